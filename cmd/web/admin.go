@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/validator"
+)
+
+type userRoleForm struct {
+	ID                   int    `schema:"id" validate:"required"`
+	Role                 string `schema:"role" validate:"required"`
+	validator.FormErrors `schema:"-"`
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := app.users.GetAll()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(w, r)
+	data.Users = users
+	data.Roles = models.Roles
+	app.render(w, http.StatusOK, "admin_users.tmpl.html", data)
+}
+
+func (app *application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	var form userRoleForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) || !models.Role(form.Role).Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// admins cannot demote themselves, so that there is always at least one admin left
+	if form.ID == app.getUserIdFromContext(w, r) {
+		err = app.setFlash(w, r, "You cannot change your own role.", FlashTypeWarning)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	_, err = app.users.Get(form.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.users.UpdateRole(form.ID, models.Role(form.Role))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.setFlash(w, r, "Role successfully updated!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
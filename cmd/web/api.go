@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"p-system.okostadinov.net/internal/models"
+)
+
+func (app *application) apiPatientList(w http.ResponseWriter, r *http.Request) {
+	var form patientListForm
+	err := app.decodeQuery(r, &form)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, "invalid query parameters")
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		app.apiFormErrors(w, app.validator.FormErrors)
+		return
+	}
+
+	patients, metadata, err := app.patients.List(form.filter())
+	if err != nil {
+		if errors.Is(err, models.ErrTooManyToMatch) {
+			app.apiError(w, http.StatusUnprocessableEntity, "too many patients to match, narrow the list down by medication, owner or therapy status")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	if patients == nil {
+		patients = []*models.Patient{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"patients": patients, "metadata": metadata}, nil)
+	if err != nil {
+		app.apiServerError(w, err)
+	}
+}
+
+func (app *application) apiPatientGet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, "invalid patient id")
+		return
+	}
+
+	patient, err := app.patients.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusNotFound, "patient not found")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"patient": patient}, nil)
+	if err != nil {
+		app.apiServerError(w, err)
+	}
+}
+
+func (app *application) apiPatientCreate(w http.ResponseWriter, r *http.Request) {
+	if !app.getActorFromContext(w, r).CanCreatePatient() {
+		app.apiError(w, http.StatusForbidden, "unauthorized action - cannot create patients")
+		return
+	}
+
+	var form patientForm
+	err := app.readJSON(w, r, &form)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		app.apiFormErrors(w, app.validator.FormErrors)
+		return
+	}
+
+	if !form.Confirmed {
+		duplicates, err := app.patients.FindDuplicates(form.UCN, form.FirstName, form.LastName, form.PhoneNumber)
+		if err != nil {
+			app.apiServerError(w, err)
+			return
+		}
+
+		if len(duplicates) > 0 {
+			app.apiDuplicates(w, duplicates)
+			return
+		}
+	}
+
+	id, err := app.patients.Insert(form.UCN, form.FirstName, form.LastName, form.PhoneNumber, form.Height, form.Weight, form.Note, app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	patient, err := app.patients.Get(id)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/patients/%d", id))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"patient": patient}, headers)
+	if err != nil {
+		app.apiServerError(w, err)
+	}
+}
+
+func (app *application) apiPatientUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, "invalid patient id")
+		return
+	}
+
+	patient, err := app.patients.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusNotFound, "patient not found")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanUpdatePatient(patient) {
+		app.apiError(w, http.StatusForbidden, "unauthorized action - cannot modify patient")
+		return
+	}
+
+	var form patientForm
+	err = app.readJSON(w, r, &form)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		app.apiFormErrors(w, app.validator.FormErrors)
+		return
+	}
+
+	userId := app.getUserIdFromContext(w, r)
+
+	err = app.patients.Update(id, form.UCN, form.FirstName, form.LastName, form.PhoneNumber, form.Height, form.Weight, userId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusNotFound, "patient not found")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	// notes are append-only, so a note other than the latest one is added to the patient's timeline
+	if note := strings.TrimSpace(form.Note); note != "" && note != patient.Note {
+		_, err = app.notes.Insert(id, models.NoteTypeGeneral, note, userId)
+		if err != nil {
+			app.apiServerError(w, err)
+			return
+		}
+	}
+
+	patient, err = app.patients.Get(id)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"patient": patient}, nil)
+	if err != nil {
+		app.apiServerError(w, err)
+	}
+}
+
+func (app *application) apiPatientDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, "invalid patient id")
+		return
+	}
+
+	patient, err := app.patients.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusNotFound, "patient not found")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanDeletePatient(patient) {
+		app.apiError(w, http.StatusForbidden, "unauthorized action - cannot delete patient")
+		return
+	}
+
+	err = app.patients.Delete(id, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusNotFound, "patient not found")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) apiMedicationList(w http.ResponseWriter, r *http.Request) {
+	medications, err := app.medications.GetAll()
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	if medications == nil {
+		medications = []*models.Medication{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"medications": medications}, nil)
+	if err != nil {
+		app.apiServerError(w, err)
+	}
+}
+
+func (app *application) apiMedicationGet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, "invalid medication id")
+		return
+	}
+
+	medication, err := app.medications.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusNotFound, "medication not found")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"medication": medication}, nil)
+	if err != nil {
+		app.apiServerError(w, err)
+	}
+}
+
+func (app *application) apiMedicationCreate(w http.ResponseWriter, r *http.Request) {
+	if !app.getActorFromContext(w, r).CanCreateMedication() {
+		app.apiError(w, http.StatusForbidden, "unauthorized action - cannot add medications")
+		return
+	}
+
+	var form medicationForm
+	err := app.readJSON(w, r, &form)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		app.apiFormErrors(w, app.validator.FormErrors)
+		return
+	}
+
+	id, err := app.medications.Insert(form.Name, form.ActiveIngredient, form.Strength, form.Unit, form.DosageForm, form.ATCCode, form.Manufacturer, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateMedication) {
+			app.apiError(w, http.StatusConflict, "medication already exists")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	medication, err := app.medications.Get(id)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/medications/%d", id))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"medication": medication}, headers)
+	if err != nil {
+		app.apiServerError(w, err)
+	}
+}
+
+func (app *application) apiMedicationUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, "invalid medication id")
+		return
+	}
+
+	medication, err := app.medications.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusNotFound, "medication not found")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanUpdateMedication(medication) {
+		app.apiError(w, http.StatusForbidden, "unauthorized action - cannot modify medication")
+		return
+	}
+
+	var form medicationForm
+	err = app.readJSON(w, r, &form)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		app.apiFormErrors(w, app.validator.FormErrors)
+		return
+	}
+
+	err = app.medications.Update(id, form.Name, form.ActiveIngredient, form.Strength, form.Unit, form.DosageForm, form.ATCCode, form.Manufacturer, app.getUserIdFromContext(w, r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.apiError(w, http.StatusNotFound, "medication not found")
+		case errors.Is(err, models.ErrDuplicateMedication):
+			app.apiError(w, http.StatusConflict, "medication already exists")
+		default:
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	medication, err = app.medications.Get(id)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"medication": medication}, nil)
+	if err != nil {
+		app.apiServerError(w, err)
+	}
+}
+
+func (app *application) apiMedicationDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, "invalid medication id")
+		return
+	}
+
+	medication, err := app.medications.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiError(w, http.StatusNotFound, "medication not found")
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanDeleteMedication(medication) {
+		app.apiError(w, http.StatusForbidden, "unauthorized action - cannot delete medication")
+		return
+	}
+
+	err = app.medications.Delete(id, app.getUserIdFromContext(w, r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.apiError(w, http.StatusNotFound, "medication not found")
+		case errors.Is(err, models.ErrExistingDependency):
+			app.apiError(w, http.StatusConflict, "medication cannot be deleted due to registered patients")
+		default:
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refuses to create a patient which probably is the same person as existing ones, listing them along with the
+// reason, unless the request is resent with confirmed set
+func (app *application) apiDuplicates(w http.ResponseWriter, duplicates []*models.Duplicate) {
+	matches := make([]envelope, len(duplicates))
+	for i, d := range duplicates {
+		matches[i] = envelope{"patient": d.Match, "reason": d.Reason}
+	}
+
+	err := app.writeJSON(w, http.StatusConflict, envelope{"error": "probable duplicate of existing patients, set confirmed to create it anyway", "duplicates": matches}, nil)
+	if err != nil {
+		app.apiServerError(w, err)
+	}
+}
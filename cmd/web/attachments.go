@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gorilla/mux"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/storage"
+	"p-system.okostadinov.net/internal/validator"
+)
+
+// the types of files which may be attached to a patient, as detected from their contents rather than their name;
+// subtypes such as HTML are not accepted as plain text
+var attachmentTypes = []string{"application/pdf", "image/jpeg", "image/png", "image/tiff", "image/webp", "text/plain"}
+
+type attachmentForm struct {
+	Category             string `schema:"category" validate:"required,oneof=lab_report imaging consent other"`
+	Description          string `schema:"description" validate:"max=500"`
+	validator.FormErrors `schema:"-"`
+}
+
+// fills in the files attached to the patient, as shown on its page
+func (app *application) addAttachmentData(data *templateData, patient *models.Patient) error {
+	attachments, err := app.attachments.GetAllByPatient(patient.ID)
+	if err != nil {
+		return err
+	}
+
+	data.Attachments = attachments
+	data.AttachmentCategories = models.AttachmentCategories
+	data.AttachmentTypes = attachmentTypes
+	data.MaxUploadSize = app.maxUploadSize
+
+	return nil
+}
+
+// keeps the name the file was uploaded with, without any directories, to be offered again once downloaded
+func attachmentName(filename string) string {
+	name := strings.TrimSpace(filepath.Base(strings.ReplaceAll(filename, `\`, "/")))
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+
+	for utf8.RuneCountInString(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	return name
+}
+
+func (app *application) patientAttachmentAdd(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanAttachFiles(patient) {
+		app.redirectToPatient(w, r, patient.ID, "Unauthorized action - cannot attach files!", FlashTypeDanger)
+		return
+	}
+
+	var form attachmentForm
+
+	r.Body = http.MaxBytesReader(w, r.Body, app.maxUploadSize+4096)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			form.FormErrors = validator.FormErrors{"file": fmt.Sprintf("file too large (maximum %s)", humanBytes(app.maxUploadSize))}
+		case errors.Is(err, http.ErrMissingFile):
+			form.FormErrors = validator.FormErrors{"file": "required field"}
+		default:
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		app.redirectToPatient(w, r, patient.ID, formErrorsMessage("attachment", form.FormErrors), FlashTypeDanger)
+		return
+	}
+	defer file.Close()
+
+	err = app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		app.redirectToPatient(w, r, patient.ID, formErrorsMessage("attachment", app.validator.FormErrors), FlashTypeDanger)
+		return
+	}
+
+	// the whole request may have been read before reaching the handler, e.g. to check its CSRF token
+	if header.Size > app.maxUploadSize {
+		form.FormErrors = validator.FormErrors{"file": fmt.Sprintf("file too large (maximum %s)", humanBytes(app.maxUploadSize))}
+		app.redirectToPatient(w, r, patient.ID, formErrorsMessage("attachment", form.FormErrors), FlashTypeDanger)
+		return
+	}
+
+	contentType, err := mimetype.DetectReader(file)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !slices.ContainsFunc(attachmentTypes, contentType.Is) {
+		form.FormErrors = validator.FormErrors{"file": fmt.Sprintf("unsupported file type %s", contentType.String())}
+		app.redirectToPatient(w, r, patient.ID, formErrorsMessage("attachment", form.FormErrors), FlashTypeDanger)
+		return
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	digest, err := storage.Key(file)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// identical contents may be losing their last reference meanwhile, in which case they must not be removed between
+	// being stored again and referenced
+	unlock := app.fileLocks.Lock(digest)
+
+	_, size, err := app.files.Put(file)
+	if err != nil {
+		unlock()
+		app.serverError(w, err)
+		return
+	}
+
+	_, err = app.attachments.Insert(patient.ID, attachmentName(header.Filename), form.Category, strings.TrimSpace(form.Description),
+		contentType.String(), size, digest, app.getUserIdFromContext(w, r))
+	unlock()
+	if err != nil {
+		app.deleteUnreferencedFile(digest)
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.redirectToPatient(w, r, patient.ID, "File successfully attached!", FlashTypeSuccess)
+}
+
+// fetches the attachment chosen in the request, responding with not found if it does not belong to the patient
+func (app *application) attachmentFromRequest(w http.ResponseWriter, patient *models.Patient, id string) (*models.Attachment, bool) {
+	attachmentId, err := strconv.Atoi(id)
+	if err != nil {
+		app.notFound(w)
+		return nil, false
+	}
+
+	attachment, err := app.attachments.Get(attachmentId)
+	if err != nil || attachment.PatientId != patient.ID {
+		if err == nil || errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	return attachment, true
+}
+
+// serves the attached file for download, never inline, so that its contents cannot run in the context of the site
+func (app *application) patientAttachmentDownload(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	attachment, ok := app.attachmentFromRequest(w, patient, mux.Vars(r)["aid"])
+	if !ok {
+		return
+	}
+
+	file, err := app.files.Open(attachment.Digest)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	http.ServeContent(w, r, attachment.Name, attachment.Created, file)
+}
+
+func (app *application) patientAttachmentDelete(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanDeleteAttachment(patient) {
+		app.redirectToPatient(w, r, patient.ID, "Unauthorized action - cannot remove attachments!", FlashTypeDanger)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	attachment, ok := app.attachmentFromRequest(w, patient, r.PostForm.Get("attachment_id"))
+	if !ok {
+		return
+	}
+
+	err = app.attachments.Delete(attachment.ID, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.deleteUnreferencedFile(attachment.Digest)
+
+	app.redirectToPatient(w, r, patient.ID, "Attachment successfully removed!", FlashTypeSuccess)
+}
+
+// removes the stored file once no attachment refers to it any longer, holding the lock on its digest so that the same
+// contents are not attached again in between; failures are only logged, as they leave nothing worse behind than an
+// orphaned file
+func (app *application) deleteUnreferencedFile(digest string) {
+	defer app.fileLocks.Lock(digest)()
+
+	referenced, err := app.attachments.IsReferenced(digest)
+	if err != nil {
+		app.errorLog.Printf("checking references to file %s: %v", digest, err)
+		return
+	}
+
+	if referenced {
+		return
+	}
+
+	err = app.files.Delete(digest)
+	if err != nil {
+		app.errorLog.Printf("deleting file %s: %v", digest, err)
+	}
+}
@@ -5,4 +5,6 @@ type contextKey string
 const (
 	isAuthenticatedContextKey = contextKey("isAuthenticated")
 	userIdContextKey          = contextKey("userId")
+	userRoleContextKey        = contextKey("userRole")
+	mustEnrollContextKey      = contextKey("mustEnroll")
 )
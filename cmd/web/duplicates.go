@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"p-system.okostadinov.net/internal/models"
+)
+
+const tooManyDuplicatesMessage = "Too many patients share a UCN or phone number to compare them, which usually means a placeholder phone number was entered for them; please correct those first."
+
+// lists every pair of patients which probably are the same person, offering to merge those the user owns
+func (app *application) patientDuplicates(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+
+	duplicates, err := app.patients.GetDuplicates()
+	if err != nil {
+		if !errors.Is(err, models.ErrTooManyToMatch) {
+			app.serverError(w, err)
+			return
+		}
+		status = http.StatusUnprocessableEntity
+	}
+
+	data := app.newTemplateData(w, r)
+	data.Duplicates = duplicates
+	if status == http.StatusUnprocessableEntity {
+		data.Flash = Flash{Content: tooManyDuplicatesMessage, Type: FlashTypeWarning}
+	}
+	app.render(w, status, "duplicates.tmpl.html", data)
+}
+
+// merges the duplicate chosen in the form into the patient, which is kept
+func (app *application) patientMerge(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	duplicateId, err := strconv.Atoi(r.FormValue("duplicate_id"))
+	if err != nil || duplicateId == patient.ID {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	duplicate, err := app.patients.Get(duplicateId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.redirectToPatient(w, r, patient.ID, "The duplicate no longer exists.", FlashTypeWarning)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanMergePatients(patient, duplicate) {
+		app.redirectToPatient(w, r, patient.ID, "Unauthorized action - cannot merge patients!", FlashTypeDanger)
+		return
+	}
+
+	err = app.patients.Merge(patient.ID, duplicate.ID, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	message := fmt.Sprintf("Patient #%d successfully merged into this one!", duplicate.ID)
+	app.redirectToPatient(w, r, patient.ID, message, FlashTypeSuccess)
+}
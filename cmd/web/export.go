@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/xlsx"
+)
+
+// a column which may be chosen for a patient list export
+type exportColumn struct {
+	Name    string
+	Header  string
+	Default bool
+	value   func(p *models.Patient) any
+}
+
+// the columns of patient list exports, in their order in the file; the note is left out unless chosen, being the
+// lengthiest and most sensitive one
+var exportColumns = []exportColumn{
+	{"id", "ID", true, func(p *models.Patient) any { return p.ID }},
+	{"ucn", "UCN", true, func(p *models.Patient) any { return p.UCN }},
+	{"first_name", "First name", true, func(p *models.Patient) any { return p.FirstName }},
+	{"last_name", "Last name", true, func(p *models.Patient) any { return p.LastName }},
+	{"phone_number", "Phone number", true, func(p *models.Patient) any { return p.PhoneNumber }},
+	{"height", "Height (cm)", true, func(p *models.Patient) any { return p.Height }},
+	{"weight", "Weight (kg)", true, func(p *models.Patient) any { return p.Weight }},
+	{"therapy_status", "Therapy", true, func(p *models.Patient) any { return p.TherapyStatus.Label() }},
+	{"therapy_expires", "Therapy expires", true, func(p *models.Patient) any {
+		if p.TherapyExpires == nil {
+			return nil
+		}
+		return *p.TherapyExpires
+	}},
+	{"medications", "Medications", true, func(p *models.Patient) any { return strings.Join(p.Medications, "; ") }},
+	{"note", "Latest note", false, func(p *models.Patient) any { return p.Note }},
+}
+
+// the chosen columns, kept in the order of exportColumns, or the default ones if none were chosen
+func (f *patientListForm) exportColumns() []exportColumn {
+	var columns []exportColumn
+
+	for _, column := range exportColumns {
+		chosen := column.Default
+		if len(f.Columns) > 0 {
+			chosen = false
+			for _, name := range f.Columns {
+				chosen = chosen || name == column.Name
+			}
+		}
+
+		if chosen {
+			columns = append(columns, column)
+		}
+	}
+
+	return columns
+}
+
+// writes the rows of an export in one of the supported formats
+type exportWriter interface {
+	WriteHeader(headers []string) error
+	Write(cells []any) error
+	Close() error
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (e *csvExportWriter) WriteHeader(headers []string) error {
+	return e.w.Write(headers)
+}
+
+func (e *csvExportWriter) Write(cells []any) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		switch v := cell.(type) {
+		case nil:
+		case time.Time:
+			record[i] = v.Format(models.DateLayout)
+		case string:
+			record[i] = neutralizeFormula(v)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExportWriter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// keeps spreadsheet applications from evaluating text as a formula when opening a CSV file, by prefixing it with an
+// apostrophe; phone numbers in international format are left as they are, since they cannot form a formula
+func neutralizeFormula(s string) string {
+	if s == "" || !strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return s
+	}
+
+	if s[0] == '+' && strings.Trim(s[1:], "0123456789") == "" {
+		return s
+	}
+
+	return "'" + s
+}
+
+// streams every patient of the list in the chosen format and columns as a download, after recording the export in
+// the audit log; errors past the first row can only be logged, the response being under way by then
+func (app *application) exportPatients(w http.ResponseWriter, r *http.Request, form *patientListForm) {
+	columns := form.exportColumns()
+
+	// an export covers every page of the list
+	filter := form.filter()
+	filter.Page, filter.PageSize = 0, 0
+
+	names := make([]string, len(columns))
+	headers := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+		headers[i] = column.Header
+	}
+
+	err := app.audit.InsertExport(&models.PatientExport{Format: form.Format, Columns: names, Filter: filter}, app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// the export takes as long as the client needs to download it, rather than the usual write timeout
+	err = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.errorLog.Printf("lifting the write deadline of an export: %v", err)
+	}
+
+	var writer exportWriter
+
+	// the headers are only sent along with the first row, so that a failing query still results in an error page
+	start := func() error {
+		filename := fmt.Sprintf("patients-%s.%s", time.Now().UTC().Format(models.DateLayout), form.Format)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		w.Header().Set("Cache-Control", "private, no-store")
+
+		switch form.Format {
+		case "xlsx":
+			w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+			xw, err := xlsx.NewWriter(w, "Patients")
+			if err != nil {
+				return err
+			}
+			writer = xw
+		default:
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			writer = &csvExportWriter{w: csv.NewWriter(w)}
+		}
+
+		return writer.WriteHeader(headers)
+	}
+
+	cells := make([]any, len(columns))
+
+	err = app.patients.Export(filter, func(p *models.Patient) error {
+		if writer == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		for i, column := range columns {
+			cells[i] = column.value(p)
+		}
+
+		return writer.Write(cells)
+	})
+	if err != nil {
+		switch {
+		case writer == nil && errors.Is(err, models.ErrTooManyToMatch):
+			err = app.setFlash(w, r, tooManyToMatchMessage, FlashTypeWarning)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+
+			// back to the list the export was requested from
+			query := r.URL.Query()
+			query.Del("format")
+			query.Del("columns")
+			http.Redirect(w, r, r.URL.Path+"?"+query.Encode(), http.StatusSeeOther)
+		case writer == nil:
+			app.serverError(w, err)
+		default:
+			app.errorLog.Printf("exporting patients: %v", err)
+		}
+		return
+	}
+
+	// an empty list still exports its header row
+	if writer == nil {
+		if err = start(); err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if err = writer.Close(); err != nil {
+		app.errorLog.Printf("exporting patients: %v", err)
+	}
+}
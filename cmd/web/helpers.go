@@ -2,14 +2,23 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"runtime/debug"
 	"time"
 
 	"github.com/gorilla/csrf"
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/policy"
+	"p-system.okostadinov.net/internal/validator"
 )
 
+// wraps every JSON response in a top level object
+type envelope map[string]any
+
 // outputs the error to the client, as well as logging it locally for debugging
 func (app *application) serverError(w http.ResponseWriter, err error) {
 	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
@@ -57,6 +66,7 @@ func (app *application) newTemplateData(w http.ResponseWriter, r *http.Request)
 		Flash:           app.popFlash(w, r),
 		IsAuthenticated: app.isAuthenticated(w, r),
 		UserId:          app.getUserIdFromContext(w, r),
+		Actor:           app.getActorFromContext(w, r),
 		CSRFField:       csrf.TemplateField(r),
 	}
 }
@@ -76,6 +86,11 @@ func (app *application) decodeForm(r *http.Request, form interface{}) error {
 	return nil
 }
 
+// decodes the request's query string parameters into a form struct
+func (app *application) decodeQuery(r *http.Request, form interface{}) error {
+	return app.decoder.Decode(form, r.URL.Query())
+}
+
 // checks whether a user is logged in based on the request context
 func (app *application) isAuthenticated(w http.ResponseWriter, r *http.Request) bool {
 	isAuthenticated, ok := r.Context().Value(isAuthenticatedContextKey).(bool)
@@ -95,6 +110,13 @@ func (app *application) getUserId(w http.ResponseWriter, r *http.Request) int {
 	return userID
 }
 
+// fetches the version of the user's password the session was logged in with, ending it once the password changes
+func (app *application) getSessionVersion(r *http.Request) int {
+	session, _ := app.store.Get(r, "session")
+	version, _ := session.Values["sessionVersion"].(int)
+	return version
+}
+
 // fetches the current authenticated user's ID from the request context
 func (app *application) getUserIdFromContext(w http.ResponseWriter, r *http.Request) int {
 	userId, ok := r.Context().Value(userIdContextKey).(int)
@@ -103,3 +125,85 @@ func (app *application) getUserIdFromContext(w http.ResponseWriter, r *http.Requ
 	}
 	return userId
 }
+
+// encodes the data as JSON and writes it to the client alongside any additional headers
+func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+
+	return nil
+}
+
+// decodes a single JSON object from the request body into dst, rejecting unknown fields and bodies over 1MB
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return err
+		}
+	}
+
+	if dec.Decode(&struct{}{}) != io.EOF {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// the JSON equivalent of clientError, outputting the message under an "error" key
+func (app *application) apiError(w http.ResponseWriter, status int, message any) {
+	err := app.writeJSON(w, status, envelope{"error": message}, nil)
+	if err != nil {
+		app.errorLog.Output(2, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// the JSON equivalent of serverError, logging the trace locally while hiding the details from the client
+func (app *application) apiServerError(w http.ResponseWriter, err error) {
+	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
+	app.errorLog.Output(2, trace)
+
+	app.apiError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+// outputs the form validation errors keyed by field name
+func (app *application) apiFormErrors(w http.ResponseWriter, formErrors validator.FormErrors) {
+	app.apiError(w, http.StatusUnprocessableEntity, formErrors)
+}
+
+// assembles the policy actor for the authenticated user from the request context
+func (app *application) getActorFromContext(w http.ResponseWriter, r *http.Request) policy.Actor {
+	role, _ := r.Context().Value(userRoleContextKey).(models.Role)
+	return policy.Actor{ID: app.getUserIdFromContext(w, r), Role: role}
+}
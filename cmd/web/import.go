@@ -0,0 +1,537 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/schema"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/policy"
+	"p-system.okostadinov.net/internal/validator"
+)
+
+const (
+	// the largest CSV file accepted for a bulk import
+	maxImportFileSize = 5 << 20
+	// the most rows a single import may hold, keeping its transaction and report reasonably sized
+	maxImportRows = 5000
+)
+
+// a kind of record which can be imported in bulk, whose fields are named as in its form
+type importKind struct {
+	Name     string
+	Fields   []string
+	Optional []string
+}
+
+var importKinds = []importKind{
+	{"patients", []string{"ucn", "first_name", "last_name", "phone_number", "height", "weight", "note"}, []string{"note"}},
+	{"medications", []string{"name", "active_ingredient", "strength", "unit", "dosage_form", "atc_code", "manufacturer"}, []string{"atc_code", "manufacturer"}},
+}
+
+// other header names a field is recognized by, e.g. those of a patient list export
+var importAliases = map[string][]string{
+	"note": {"latest_note"},
+}
+
+func findImportKind(name string) (importKind, bool) {
+	for _, kind := range importKinds {
+		if kind.Name == name {
+			return kind, true
+		}
+	}
+	return importKind{}, false
+}
+
+type importForm struct {
+	Kind                 string `schema:"kind" validate:"required,oneof=patients medications"`
+	Mapping              string `schema:"mapping" validate:"max=1000"`
+	Commit               bool   `schema:"commit"`
+	validator.FormErrors `schema:"-"`
+}
+
+// a single data row of an import, along with the values mapped to the fields of its kind and what is wrong with them
+type importRow struct {
+	Line       int
+	Values     []string
+	FormErrors validator.FormErrors
+	patient    *models.Patient
+	medication *models.Medication
+}
+
+func (r *importRow) Valid() bool {
+	return len(r.FormErrors) == 0
+}
+
+// the errors of the row in field order, formatted as in flash messages
+func (r *importRow) Errors() string {
+	return strings.TrimSuffix(strings.TrimPrefix(formErrorsMessage("row", r.FormErrors), "Invalid row - "), ".")
+}
+
+// the outcome of checking an import file, row by row; only valid rows are imported
+type importReport struct {
+	Kind    importKind
+	Columns []string
+	Ignored []string
+	Rows    []*importRow
+	Valid   int
+	Invalid int
+}
+
+// a problem with the import file as a whole, as opposed to a failure to check it
+type invalidImportError string
+
+func (e invalidImportError) Error() string {
+	return string(e)
+}
+
+func invalidImportf(format string, args ...any) error {
+	return invalidImportError(fmt.Sprintf(format, args...))
+}
+
+var headerRX = regexp.MustCompile(`[^a-z0-9]+`)
+
+// reduces a header to the form of a field name, e.g. "Height (cm)" to height, so that exported files map by themselves
+func normalizeHeader(header string) string {
+	header, _, _ = strings.Cut(strings.ToLower(header), "(")
+	return strings.Trim(headerRX.ReplaceAllString(header, "_"), "_")
+}
+
+// parses explicit column mappings such as "first_name=Given name, last_name=Surname" for the fields of the kind
+func parseImportMapping(kind importKind, mapping string) (map[string]string, error) {
+	columns := make(map[string]string)
+
+	for _, pair := range strings.Split(mapping, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		field, column, ok := strings.Cut(pair, "=")
+		field, column = strings.TrimSpace(field), strings.TrimSpace(column)
+		if !ok || field == "" || column == "" {
+			return nil, invalidImportf("invalid mapping %q (expected field=column)", strings.TrimSpace(pair))
+		}
+
+		if !slices.Contains(kind.Fields, field) {
+			return nil, invalidImportf("unknown field %s (fields: %s)", field, strings.Join(kind.Fields, ", "))
+		}
+		columns[field] = column
+	}
+
+	return columns, nil
+}
+
+// reads a CSV file of records of the kind, whose header row names the columns, mapping them to the fields by the
+// explicit mapping or else by their names, and checks every row against the rules of the kind's form as well as for
+// duplicates, within the file and of existing records; the whole file is rejected only if it cannot be read or lacks
+// a column for a required field
+func (app *application) checkImport(kind importKind, r io.Reader, mapping string) (*importReport, error) {
+	explicit, err := parseImportMapping(kind, mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, invalidImportf("the file is empty")
+		}
+		return nil, invalidImportf("invalid CSV: %v", err)
+	}
+
+	report := &importReport{Kind: kind, Columns: make([]string, len(kind.Fields))}
+	indices := make([]int, len(kind.Fields))
+	used := make(map[int]bool)
+
+	for i, field := range kind.Fields {
+		indices[i] = -1
+
+		for j, name := range header {
+			name = strings.TrimSpace(name)
+
+			var matches bool
+			if column, ok := explicit[field]; ok {
+				matches = strings.EqualFold(name, column)
+			} else {
+				normalized := normalizeHeader(name)
+				matches = normalized == field || slices.Contains(importAliases[field], normalized)
+			}
+
+			if matches {
+				indices[i], report.Columns[i] = j, name
+				used[j] = true
+				break
+			}
+		}
+
+		if indices[i] == -1 && !slices.Contains(kind.Optional, field) {
+			if column, ok := explicit[field]; ok {
+				return nil, invalidImportf("missing column %s mapped to %s", column, field)
+			}
+			return nil, invalidImportf("missing column for %s (required: %s)", field, strings.Join(requiredImportFields(kind), ", "))
+		}
+	}
+
+	for j, name := range header {
+		if !used[j] {
+			report.Ignored = append(report.Ignored, strings.TrimSpace(name))
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, invalidImportf("invalid CSV: %v", err)
+		}
+
+		if len(report.Rows) == maxImportRows {
+			return nil, invalidImportf("too many rows (maximum %d)", maxImportRows)
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := &importRow{Line: line, Values: make([]string, len(kind.Fields)), FormErrors: validator.FormErrors{}}
+
+		values := url.Values{}
+		for i, field := range kind.Fields {
+			if indices[i] != -1 && indices[i] < len(record) {
+				row.Values[i] = strings.TrimSpace(record[indices[i]])
+			}
+			values.Set(field, row.Values[i])
+		}
+
+		switch kind.Name {
+		case "patients":
+			app.checkPatientRow(row, values)
+		case "medications":
+			app.checkMedicationRow(row, values)
+		}
+
+		report.Rows = append(report.Rows, row)
+	}
+
+	if len(report.Rows) == 0 {
+		return nil, invalidImportf("the file has no rows besides its header")
+	}
+
+	switch kind.Name {
+	case "patients":
+		err = app.checkPatientDuplicates(report.Rows)
+	case "medications":
+		err = app.checkMedicationDuplicates(report.Rows)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range report.Rows {
+		if row.Valid() {
+			report.Valid++
+		} else {
+			report.Invalid++
+		}
+	}
+
+	return report, nil
+}
+
+func requiredImportFields(kind importKind) []string {
+	var required []string
+	for _, field := range kind.Fields {
+		if !slices.Contains(kind.Optional, field) {
+			required = append(required, field)
+		}
+	}
+	return required
+}
+
+// decodes the row's values into the form like a submitted one, e.g. telling apart heights which are not numbers, and
+// validates it by the same rules; returns whether the form is valid
+func (app *application) decodeImportRow(row *importRow, values url.Values, form any) bool {
+	err := app.decoder.Decode(form, values)
+	if err != nil {
+		var multiError schema.MultiError
+		if !errors.As(err, &multiError) {
+			row.FormErrors["row"] = err.Error()
+			return false
+		}
+		for field := range multiError {
+			row.FormErrors[field] = "invalid format (only numbers allowed)"
+		}
+	}
+
+	if !app.validator.ValidateForm(form) {
+		for field, message := range app.validator.FormErrors {
+			if _, ok := row.FormErrors[field]; !ok {
+				row.FormErrors[field] = message
+			}
+		}
+	}
+
+	return row.Valid()
+}
+
+func (app *application) checkPatientRow(row *importRow, values url.Values) {
+	var form patientForm
+	if !app.decodeImportRow(row, values, &form) {
+		return
+	}
+
+	row.patient = &models.Patient{
+		UCN:         form.UCN,
+		FirstName:   form.FirstName,
+		LastName:    form.LastName,
+		PhoneNumber: form.PhoneNumber,
+		Height:      form.Height,
+		Weight:      form.Weight,
+		Note:        form.Note,
+	}
+}
+
+// marks the rows of patients whose UCN repeats an earlier row or belongs to an existing patient
+func (app *application) checkPatientDuplicates(rows []*importRow) error {
+	lines := make(map[string]int)
+
+	for _, row := range rows {
+		if row.patient == nil {
+			continue
+		}
+
+		if line, ok := lines[row.patient.UCN]; ok {
+			row.FormErrors["ucn"] = fmt.Sprintf("duplicate of line %d", line)
+			continue
+		}
+		lines[row.patient.UCN] = row.Line
+
+		existing, err := app.patients.GetAllByUCN(row.patient.UCN)
+		if err != nil {
+			return err
+		}
+
+		if len(existing) > 0 {
+			row.FormErrors["ucn"] = "patient with this UCN already exists"
+		}
+	}
+
+	return nil
+}
+
+func (app *application) checkMedicationRow(row *importRow, values url.Values) {
+	var form medicationForm
+	if !app.decodeImportRow(row, values, &form) {
+		return
+	}
+
+	row.medication = &models.Medication{
+		Name:             form.Name,
+		ActiveIngredient: form.ActiveIngredient,
+		Strength:         form.Strength,
+		Unit:             form.Unit,
+		DosageForm:       form.DosageForm,
+		ATCCode:          form.ATCCode,
+		Manufacturer:     form.Manufacturer,
+	}
+}
+
+// identifies a product of the catalog the way its unique constraint does, ignoring case as MySQL does
+func medicationKey(m *models.Medication) string {
+	return strings.ToLower(strings.Join([]string{m.Name, m.Strength, m.Unit, m.DosageForm}, "\x00"))
+}
+
+// marks the rows of medications which repeat an earlier row or are already in the catalog, as the import would fail
+// on them otherwise
+func (app *application) checkMedicationDuplicates(rows []*importRow) error {
+	existing, err := app.medications.GetAll()
+	if err != nil {
+		return err
+	}
+
+	catalog := make(map[string]bool)
+	for _, m := range existing {
+		catalog[medicationKey(m)] = true
+	}
+
+	lines := make(map[string]int)
+	for _, row := range rows {
+		if row.medication == nil {
+			continue
+		}
+
+		key := medicationKey(row.medication)
+		if line, ok := lines[key]; ok {
+			row.FormErrors["name"] = fmt.Sprintf("duplicate of line %d", line)
+		} else if catalog[key] {
+			row.FormErrors["name"] = "medication with this strength and dosage form already exists"
+		} else {
+			lines[key] = row.Line
+		}
+	}
+
+	return nil
+}
+
+// imports the valid rows of the report in a single transaction owned by the user, returning how many were imported
+func (app *application) commitImport(report *importReport, userId int) (int, error) {
+	var (
+		patients    []*models.Patient
+		medications []*models.Medication
+	)
+
+	for _, row := range report.Rows {
+		if !row.Valid() {
+			continue
+		}
+		if row.patient != nil {
+			patients = append(patients, row.patient)
+		}
+		if row.medication != nil {
+			medications = append(medications, row.medication)
+		}
+	}
+
+	var (
+		ids []int
+		err error
+	)
+	switch report.Kind.Name {
+	case "patients":
+		ids, err = app.patients.Import(patients, userId)
+	case "medications":
+		ids, err = app.medications.Import(medications, userId)
+	}
+
+	return len(ids), err
+}
+
+// reports whether the actor may import records of the kind, as they may create them one by one
+func canImport(actor policy.Actor, kind string) bool {
+	switch kind {
+	case "patients":
+		return actor.CanCreatePatient()
+	case "medications":
+		return actor.CanCreateMedication()
+	default:
+		return false
+	}
+}
+
+func (app *application) importPage(w http.ResponseWriter, r *http.Request) {
+	app.renderImport(w, r, http.StatusOK, &importForm{Kind: "patients"}, nil)
+}
+
+func (app *application) renderImport(w http.ResponseWriter, r *http.Request, status int, form *importForm, report *importReport) {
+	data := app.newTemplateData(w, r)
+	data.ImportKinds = importKinds
+	data.ImportReport = report
+	data.Form = form
+	app.render(w, status, "import.tmpl.html", data)
+}
+
+// checks the uploaded file, reporting on every row, and imports its valid rows unless it is a dry run
+func (app *application) importPost(w http.ResponseWriter, r *http.Request) {
+	form := &importForm{}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportFileSize+4096)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			form.FormErrors = validator.FormErrors{"file": fmt.Sprintf("file too large (maximum %s)", humanBytes(maxImportFileSize))}
+		case errors.Is(err, http.ErrMissingFile):
+			form.FormErrors = validator.FormErrors{"file": "required field"}
+		default:
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		form.Kind = r.PostFormValue("kind")
+		form.Mapping = r.PostFormValue("mapping")
+		app.renderImport(w, r, http.StatusUnprocessableEntity, form, nil)
+		return
+	}
+	defer file.Close()
+
+	err = app.decodeForm(r, form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		form.FormErrors = app.validator.FormErrors
+		app.renderImport(w, r, http.StatusUnprocessableEntity, form, nil)
+		return
+	}
+
+	if !canImport(app.getActorFromContext(w, r), form.Kind) {
+		err := app.setFlash(w, r, fmt.Sprintf("Unauthorized action - cannot import %s!", form.Kind), FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/import/", http.StatusSeeOther)
+		return
+	}
+
+	kind, _ := findImportKind(form.Kind)
+
+	report, err := app.checkImport(kind, file, form.Mapping)
+	if err != nil {
+		var invalid invalidImportError
+		if !errors.As(err, &invalid) {
+			app.serverError(w, err)
+			return
+		}
+		form.FormErrors = validator.FormErrors{"file": invalid.Error()}
+		app.renderImport(w, r, http.StatusUnprocessableEntity, form, nil)
+		return
+	}
+
+	if !form.Commit {
+		app.renderImport(w, r, http.StatusOK, form, report)
+		return
+	}
+
+	if report.Valid == 0 {
+		form.FormErrors = validator.FormErrors{"file": "there are no valid rows to import"}
+		app.renderImport(w, r, http.StatusUnprocessableEntity, form, report)
+		return
+	}
+
+	imported, err := app.commitImport(report, app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	message := fmt.Sprintf("Imported %d %s.", imported, kind.Name)
+	if report.Invalid > 0 {
+		message = fmt.Sprintf("Imported %d %s, skipping %d invalid rows.", imported, kind.Name, report.Invalid)
+	}
+
+	err = app.setFlash(w, r, message, FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if kind.Name == "patients" {
+		http.Redirect(w, r, "/patients/user", http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, "/medications/", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/policy"
+	"p-system.okostadinov.net/internal/validator"
+)
+
+// runs the import subcommand, which checks a CSV file the same way as the import page and, if asked to, imports its
+// valid rows as owned by the given user; returns the exit code
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	driver := fs.String("driver", "mysql", "Database driver, either mysql or sqlite")
+	dsn := fs.String("dsn", "", "MySQL data source name or SQLite database file (defaults to the local p_system database)")
+	kindName := fs.String("kind", "patients", "What the file holds, either patients or medications")
+	email := fs.String("user", "", "Email of the user importing the records, who will own them")
+	mapping := fs.String("map", "", "Columns mapped to fields whose names they do not match, e.g. \"first_name=Given name,last_name=Surname\"")
+	commit := fs.Bool("commit", false, "Import the valid rows instead of only reporting on them")
+	keys := encryptionKeysFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import [flags] FILE\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Checks every row of the CSV file, whose header row names the columns, and imports the valid")
+		fmt.Fprintln(fs.Output(), "ones in a single transaction if -commit is given.")
+		fmt.Fprintln(fs.Output())
+		for _, kind := range importKinds {
+			fmt.Fprintf(fs.Output(), "  %-12s %s (optional: %s)\n", kind.Name, strings.Join(requiredImportFields(kind), ", "), strings.Join(kind.Optional, ", "))
+		}
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	kind, ok := findImportKind(*kindName)
+	if fs.NArg() != 1 || *email == "" || !ok {
+		fs.Usage()
+		return 2
+	}
+
+	keyring, err := parseEncryptionKeys(*keys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	db, dialect, err := openDB(*driver, *dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	stores := models.NewStores(db, dialect, keyring)
+
+	app := &application{
+		patients:    stores.Patients,
+		medications: stores.Medications,
+		decoder:     newDecoder(),
+		validator:   validator.NewValidator(),
+	}
+
+	user, err := stores.Users.GetByEmail(*email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			err = fmt.Errorf("no user with the email %s", *email)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if !canImport(policy.Actor{ID: user.ID, Role: user.Role}, kind.Name) {
+		fmt.Fprintf(os.Stderr, "%s (%s) may not import %s\n", user.Email, user.Role, kind.Name)
+		return 1
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	report, err := app.checkImport(kind, file, *mapping)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for i, field := range kind.Fields {
+		if column := report.Columns[i]; column != "" {
+			fmt.Printf("%s <- %s\n", field, column)
+		}
+	}
+	if len(report.Ignored) > 0 {
+		fmt.Printf("ignored columns: %s\n", strings.Join(report.Ignored, ", "))
+	}
+
+	for _, row := range report.Rows {
+		if !row.Valid() {
+			fmt.Printf("line %d: %s\n", row.Line, row.Errors())
+		}
+	}
+
+	fmt.Printf("%d valid, %d invalid rows\n", report.Valid, report.Invalid)
+
+	if !*commit {
+		fmt.Println("dry run, pass -commit to import the valid rows")
+		return 0
+	}
+
+	if report.Valid == 0 {
+		fmt.Fprintln(os.Stderr, "there are no valid rows to import")
+		return 1
+	}
+
+	imported, err := app.commitImport(report, user.ID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("imported %d %s as %s\n", imported, kind.Name, user.Email)
+	return 0
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/validator"
+)
+
+// the largest interactions file accepted for import
+const maxInteractionsFileSize = 1 << 20
+
+// the columns an interactions file has to name in its header row, in any order
+var interactionColumns = []string{"ingredient_a", "ingredient_b", "severity", "description"}
+
+type interactionImportForm struct {
+	validator.FormErrors
+}
+
+func (app *application) interactionList(w http.ResponseWriter, r *http.Request) {
+	app.renderInteractionList(w, r, http.StatusOK, &interactionImportForm{})
+}
+
+// renders the known interactions along with the form for importing more
+func (app *application) renderInteractionList(w http.ResponseWriter, r *http.Request, status int, form *interactionImportForm) {
+	interactions, err := app.interactions.GetAll()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(w, r)
+	data.Interactions = interactions
+	data.Severities = models.InteractionSeverities
+	data.Form = form
+	app.render(w, status, "interactions.tmpl.html", data)
+}
+
+func (app *application) interactionImport(w http.ResponseWriter, r *http.Request) {
+	if !app.getActorFromContext(w, r).CanManageInteractions() {
+		err := app.setFlash(w, r, "Unauthorized action - cannot import interactions!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/interactions/", http.StatusSeeOther)
+		return
+	}
+
+	form := &interactionImportForm{}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxInteractionsFileSize+4096)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			form.FormErrors = validator.FormErrors{"file": fmt.Sprintf("file too large (maximum %d KB)", maxInteractionsFileSize>>10)}
+		case errors.Is(err, http.ErrMissingFile):
+			form.FormErrors = validator.FormErrors{"file": "required field"}
+		default:
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		app.renderInteractionList(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+	defer file.Close()
+
+	interactions, err := parseInteractions(file)
+	if err != nil {
+		form.FormErrors = validator.FormErrors{"file": err.Error()}
+		app.renderInteractionList(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	inserted, updated, err := app.interactions.Import(interactions)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.setFlash(w, r, fmt.Sprintf("Interactions imported: %d added, %d replaced.", inserted, updated), FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/interactions/", http.StatusSeeOther)
+}
+
+// reads the interactions from a CSV file whose header row names the interactionColumns, rejecting the whole file
+// with the line of the first invalid row
+func parseInteractions(r io.Reader) ([]*models.Interaction, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("the file is empty")
+		}
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range interactionColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %s (required: %s)", name, strings.Join(interactionColumns, ", "))
+		}
+	}
+
+	var interactions []*models.Interaction
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			return strings.TrimSpace(record[columns[name]])
+		}
+
+		i := &models.Interaction{
+			IngredientA: field("ingredient_a"),
+			IngredientB: field("ingredient_b"),
+			Severity:    strings.ToLower(field("severity")),
+			Description: field("description"),
+		}
+
+		a, b := models.InteractionPair(i.IngredientA, i.IngredientB)
+		switch {
+		case a == "" || b == "":
+			return nil, fmt.Errorf("line %d: both ingredients are required", line)
+		case a == b:
+			return nil, fmt.Errorf("line %d: an ingredient cannot interact with itself", line)
+		case len(a) > 100 || len(b) > 100:
+			return nil, fmt.Errorf("line %d: ingredient too long (maximum 100)", line)
+		case !slices.Contains(models.InteractionSeverities, i.Severity):
+			return nil, fmt.Errorf("line %d: invalid severity (allowed: %s)", line, strings.Join(models.InteractionSeverities, " "))
+		case len(i.Description) > 500:
+			return nil, fmt.Errorf("line %d: description too long (maximum 500)", line)
+		}
+
+		interactions = append(interactions, i)
+	}
+
+	if len(interactions) == 0 {
+		return nil, errors.New("the file contains no interactions")
+	}
+
+	return interactions, nil
+}
+
+func (app *application) interactionDelete(w http.ResponseWriter, r *http.Request) {
+	if !app.getActorFromContext(w, r).CanManageInteractions() {
+		err := app.setFlash(w, r, "Unauthorized action - cannot delete interactions!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/interactions/", http.StatusSeeOther)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.interactions.Delete(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.setFlash(w, r, "Interaction deleted.", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/interactions/", http.StatusSeeOther)
+}
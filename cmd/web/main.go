@@ -5,10 +5,12 @@ import (
 	"database/sql"
 	"encoding/gob"
 	"flag"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -16,61 +18,180 @@ import (
 
 	"github.com/gorilla/schema"
 	"github.com/gorilla/sessions"
+	"p-system.okostadinov.net/internal/migrations"
 	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/notify"
+	"p-system.okostadinov.net/internal/sqlite"
+	"p-system.okostadinov.net/internal/storage"
 	"p-system.okostadinov.net/internal/validator"
 )
 
 type application struct {
-	infoLog       *log.Logger
-	errorLog      *log.Logger
-	medications   *models.MedicationModel
-	patients      *models.PatientModel
-	users         *models.UserModel
-	templateCache map[string]*template.Template
-	decoder       *schema.Decoder
-	validator     *validator.Validator
-	store         *mysqlstore.MySQLStore
+	infoLog        *log.Logger
+	errorLog       *log.Logger
+	medications    models.MedicationStore
+	patients       models.PatientStore
+	users          models.UserStore
+	tokens         models.TokenStore
+	resets         models.PasswordResetStore
+	twoFactor      models.TwoFactorStore
+	audit          models.AuditStore
+	transfers      models.TransferStore
+	prescriptions  models.PrescriptionStore
+	interactions   models.InteractionStore
+	therapy        models.TherapyStore
+	measurements   models.MeasurementStore
+	notes          models.NoteStore
+	attachments    models.AttachmentStore
+	files          storage.Store
+	fileLocks      storage.KeyLocks
+	notifier       notify.Notifier
+	baseURL        string
+	templateCache  map[string]*template.Template
+	decoder        *schema.Decoder
+	validator      *validator.Validator
+	store          sessions.Store
+	retention      time.Duration
+	autoPurge      bool
+	reminderWindow time.Duration
+	noteEditWindow time.Duration
+	rememberDevice time.Duration
+	maxUploadSize  int64
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImport(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "rotate" {
+		os.Exit(runRotate(os.Args[2:]))
+	}
+
 	addr := flag.String("addr", ":4000", "HTTP network address")
-	dsn := flag.String("dsn", "p_system_admin:p_system_admin@/p_system?parseTime=true&loc=Local", "MySQL data source name")
-	storeKey := flag.String("storekey", "secretkey", "MySQL session store key")
+	driver := flag.String("driver", "mysql", "Database driver, either mysql or sqlite")
+	dsn := flag.String("dsn", "", "MySQL data source name or SQLite database file (defaults to the local p_system database)")
+	storeKey := flag.String("storekey", "secretkey", "Session store key")
 	csrfKey := flag.String("csrfkey", "another-secret-key", "CSRF auth key")
+	encryptionKeys := encryptionKeysFlag(flag.CommandLine)
+	retention := flag.Duration("retention", 30*24*time.Hour, "How long deleted records are kept in the trash before being purged")
+	autoPurge := flag.Bool("auto-purge", false, "Purge the records kept in the trash for longer than -retention at startup and every hour, instead of only when an admin does")
+	reminderWindow := flag.Duration("reminder-window", 30*24*time.Hour, "How long before a therapy expires its owners are reminded to continue it")
+	noteEditWindow := flag.Duration("note-edit-window", 24*time.Hour, "How long the author of a clinical note may edit it before it locks")
+	attachmentsDir := flag.String("attachments-dir", "./attachments", "Directory the files attached to patients are stored in")
+	rememberDevice := flag.Duration("remember-device", 30*24*time.Hour, "How long a device a user chose to remember skips the second step of logging in")
+	maxUploadSize := flag.Int64("max-upload-size", 10<<20, "Largest file in bytes which may be attached to a patient")
+	reminderInterval := flag.Duration("reminder-interval", 24*time.Hour, "How often continuation reminders are sent")
+	baseURL := flag.String("base-url", "https://localhost:4000", "Public URL of the application, which links in emails point to")
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server address for notifications, e.g. mail.example.com:587 (emails are written to -mail-dir if empty)")
+	smtpFrom := flag.String("smtp-from", "p-system@localhost", "Sender address of notifications")
+	smtpUser := flag.String("smtp-user", "", "SMTP username, if the server requires authentication")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	mailDir := flag.String("mail-dir", "", "Directory emails are written to as .eml files instead of being sent, for development (reminders and password resets are disabled if neither this nor -smtp-addr is set)")
 	flag.Parse()
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
-	db, err := openDB(*dsn)
+	keys, err := parseEncryptionKeys(*encryptionKeys)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	if keys == nil {
+		infoLog.Print("no encryption keys configured, the personal data of patients is stored unencrypted")
+	}
+
+	db, dialect, err := openDB(*driver, *dsn)
 	if err != nil {
 		errorLog.Fatal(err)
 	}
 
 	defer db.Close()
 
-	store, err := newStore(db, *storeKey)
+	migrator, err := migrations.New(db, *driver)
 	if err != nil {
 		errorLog.Fatal(err)
 	}
 
-	defer store.Close()
+	pending, err := migrator.Pending()
+	if err != nil {
+		errorLog.Printf("checking schema migrations: %v", err)
+	} else if len(pending) > 0 {
+		errorLog.Printf("%d pending schema migrations, run the migrate subcommand to apply them", len(pending))
+	}
+
+	store, err := newStore(*driver, db, *storeKey)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
 
 	templateCache, err := newTemplateCache()
 	if err != nil {
 		errorLog.Fatal(err)
 	}
 
+	files, err := storage.NewLocal(*attachmentsDir)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	stores := models.NewStores(db, dialect, keys)
+
 	app := &application{
-		infoLog:       infoLog,
-		errorLog:      errorLog,
-		medications:   &models.MedicationModel{DB: db},
-		patients:      &models.PatientModel{DB: db},
-		users:         &models.UserModel{DB: db},
-		templateCache: templateCache,
-		decoder:       newDecoder(),
-		validator:     validator.NewValidator(),
-		store:         store,
+		infoLog:        infoLog,
+		errorLog:       errorLog,
+		medications:    stores.Medications,
+		patients:       stores.Patients,
+		users:          stores.Users,
+		tokens:         stores.Tokens,
+		resets:         stores.Resets,
+		twoFactor:      stores.TwoFactor,
+		audit:          stores.Audit,
+		transfers:      stores.Transfers,
+		prescriptions:  stores.Prescriptions,
+		interactions:   stores.Interactions,
+		therapy:        stores.Therapy,
+		measurements:   stores.Measurements,
+		notes:          stores.Notes,
+		attachments:    stores.Attachments,
+		files:          files,
+		baseURL:        strings.TrimSuffix(*baseURL, "/"),
+		templateCache:  templateCache,
+		decoder:        newDecoder(),
+		validator:      validator.NewValidator(),
+		store:          store,
+		retention:      *retention,
+		autoPurge:      *autoPurge,
+		reminderWindow: *reminderWindow,
+		noteEditWindow: *noteEditWindow,
+		rememberDevice: *rememberDevice,
+		maxUploadSize:  *maxUploadSize,
+	}
+
+	if app.autoPurge {
+		go app.purgeTrashPeriodically(time.Hour)
+	}
+
+	switch {
+	case *smtpAddr != "":
+		app.notifier = &notify.SMTPNotifier{Addr: *smtpAddr, From: *smtpFrom, Username: *smtpUser, Password: *smtpPassword}
+	case *mailDir != "":
+		app.notifier, err = notify.NewFileNotifier(*mailDir, *smtpFrom)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("no SMTP server configured, emails are written to %s", *mailDir)
+	}
+
+	if app.notifier != nil {
+		go app.remindContinuationsPeriodically(*reminderInterval)
+	} else {
+		infoLog.Print("no SMTP server or mail directory configured, continuation reminders and password resets are disabled")
 	}
 
 	tlsConfig := &tls.Config{
@@ -90,18 +211,38 @@ func main() {
 	errorLog.Fatal(srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem"))
 }
 
-// opens and tests the db connection pool before returning it
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return nil, err
-	}
+// opens and tests the db connection pool of the driver, returning it along with the driver's SQL dialect
+func openDB(driver string, dsn string) (*sql.DB, models.Dialect, error) {
+	switch driver {
+	case "mysql":
+		if dsn == "" {
+			dsn = defaultDSN
+		}
 
-	if err = db.Ping(); err != nil {
-		return nil, err
-	}
+		db, err := sql.Open("mysql", dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err = db.Ping(); err != nil {
+			return nil, nil, err
+		}
+
+		return db, models.MySQL, nil
+	case "sqlite":
+		if dsn == "" {
+			dsn = defaultSQLitePath
+		}
 
-	return db, nil
+		db, err := sqlite.Open(dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return db, sqlite.Dialect, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported driver %q", driver)
+	}
 }
 
 // initiates a new form decoder which will ignore the csrf token input
@@ -111,14 +252,9 @@ func newDecoder() *schema.Decoder {
 	return decoder
 }
 
-// initiates a mysql session store with a default cleanup interval and registered Flash struct type
-func newStore(db *sql.DB, key string) (*mysqlstore.MySQLStore, error) {
-	store, err := mysqlstore.NewMySQLStoreFromConnection(db, "sessions", "/", 3600, []byte(key))
-	if err != nil {
-		return nil, err
-	}
-
-	store.Options = &sessions.Options{
+// initiates a session store of the driver with a default cleanup interval and registered Flash struct type
+func newStore(driver string, db *sql.DB, key string) (sessions.Store, error) {
+	options := &sessions.Options{
 		Path:     "/",
 		MaxAge:   864000,
 		HttpOnly: true,
@@ -128,6 +264,21 @@ func newStore(db *sql.DB, key string) (*mysqlstore.MySQLStore, error) {
 
 	gob.Register(&Flash{})
 
+	if driver == "sqlite" {
+		store := sqlite.NewSessionStore(db, []byte(key))
+		store.Options = options
+
+		go store.Cleanup(5 * time.Minute)
+		return store, nil
+	}
+
+	store, err := mysqlstore.NewMySQLStoreFromConnection(db, "sessions", "/", 3600, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	store.Options = options
+
 	store.Cleanup(0)
 	return store, nil
 }
@@ -2,18 +2,45 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/gorilla/mux"
 	"p-system.okostadinov.net/internal/models"
 	"p-system.okostadinov.net/internal/validator"
 )
 
-type medicationAddForm struct {
-	Name                 string `schema:"name" validate:"required"`
-	validator.FormErrors `schema:"-"`
+type medicationForm struct {
+	Name                 string `schema:"name" json:"name" validate:"required,max=100"`
+	ActiveIngredient     string `schema:"active_ingredient" json:"active_ingredient" validate:"required,max=100"`
+	Strength             string `schema:"strength" json:"strength" validate:"required,max=20"`
+	Unit                 string `schema:"unit" json:"unit" validate:"required,oneof=mg g mcg ml mg/ml IU %"`
+	DosageForm           string `schema:"dosage_form" json:"dosage_form" validate:"required,oneof=tablet capsule solution suspension syrup injection infusion pen cream ointment gel inhaler patch drops suppository powder"`
+	ATCCode              string `schema:"atc_code" json:"atc_code" validate:"omitempty,atc"`
+	Manufacturer         string `schema:"manufacturer" json:"manufacturer" validate:"max=100"`
+	validator.FormErrors `schema:"-" json:"-"`
+}
+
+// fills the form with the medication's current values
+func newMedicationForm(m *models.Medication) *medicationForm {
+	return &medicationForm{
+		Name:             m.Name,
+		ActiveIngredient: m.ActiveIngredient,
+		Strength:         m.Strength,
+		Unit:             m.Unit,
+		DosageForm:       m.DosageForm,
+		ATCCode:          m.ATCCode,
+		Manufacturer:     m.Manufacturer,
+	}
 }
 
 func (app *application) medicationList(w http.ResponseWriter, r *http.Request) {
+	app.renderMedicationList(w, r, http.StatusOK, &medicationForm{})
+}
+
+// renders the catalog along with the form for adding to it
+func (app *application) renderMedicationList(w http.ResponseWriter, r *http.Request, status int, form *medicationForm) {
 	medications, err := app.medications.GetAll()
 	if err != nil {
 		app.serverError(w, err)
@@ -22,12 +49,24 @@ func (app *application) medicationList(w http.ResponseWriter, r *http.Request) {
 
 	data := app.newTemplateData(w, r)
 	data.Medications = medications
-	data.Form = &medicationAddForm{}
-	app.render(w, http.StatusOK, "medications.tmpl.html", data)
+	data.MedicationUnits = models.MedicationUnits
+	data.DosageForms = models.DosageForms
+	data.Form = form
+	app.render(w, status, "medications.tmpl.html", data)
 }
 
 func (app *application) medicationAdd(w http.ResponseWriter, r *http.Request) {
-	var form medicationAddForm
+	if !app.getActorFromContext(w, r).CanCreateMedication() {
+		err := app.setFlash(w, r, "Unauthorized action - cannot add medications!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/medications/", http.StatusSeeOther)
+		return
+	}
+
+	var form medicationForm
 	err := app.decodeForm(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
@@ -35,17 +74,8 @@ func (app *application) medicationAdd(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !app.validator.ValidateForm(form) {
-		medications, err := app.medications.GetAll()
-		if err != nil {
-			app.serverError(w, err)
-			return
-		}
-
-		data := app.newTemplateData(w, r)
 		form.FormErrors = app.validator.FormErrors
-		data.Form = form
-		data.Medications = medications
-		app.render(w, http.StatusUnprocessableEntity, "medications.tmpl.html", data)
+		app.renderMedicationList(w, r, http.StatusUnprocessableEntity, &form)
 		return
 	}
 
@@ -55,9 +85,14 @@ func (app *application) medicationAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.medications.Insert(form.Name, userId)
+	_, err = app.medications.Insert(form.Name, form.ActiveIngredient, form.Strength, form.Unit, form.DosageForm, form.ATCCode, form.Manufacturer, userId)
 	if err != nil {
-		app.serverError(w, err)
+		if errors.Is(err, models.ErrDuplicateMedication) {
+			form.FormErrors = validator.FormErrors{"name": "medication with this strength and dosage form already exists"}
+			app.renderMedicationList(w, r, http.StatusUnprocessableEntity, &form)
+		} else {
+			app.serverError(w, err)
+		}
 		return
 	}
 
@@ -69,10 +104,125 @@ func (app *application) medicationAdd(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/medications/", http.StatusSeeOther)
 }
 
+func (app *application) medicationView(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	medication, err := app.medications.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.renderMedication(w, r, http.StatusOK, medication, newMedicationForm(medication))
+}
+
+func (app *application) renderMedication(w http.ResponseWriter, r *http.Request, status int, medication *models.Medication, form *medicationForm) {
+	data := app.newTemplateData(w, r)
+	data.Medication = medication
+	data.MedicationUnits = models.MedicationUnits
+	data.DosageForms = models.DosageForms
+	data.Form = form
+	app.render(w, status, "medication.tmpl.html", data)
+}
+
+func (app *application) medicationUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	medication, err := app.medications.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanUpdateMedication(medication) {
+		err = app.setFlash(w, r, "Unauthorized action - cannot modify medication!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/medications/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	var form medicationForm
+	err = app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		form.FormErrors = app.validator.FormErrors
+		app.renderMedication(w, r, http.StatusUnprocessableEntity, medication, &form)
+		return
+	}
+
+	err = app.medications.Update(id, form.Name, form.ActiveIngredient, form.Strength, form.Unit, form.DosageForm, form.ATCCode, form.Manufacturer, app.getUserIdFromContext(w, r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		case errors.Is(err, models.ErrDuplicateMedication):
+			form.FormErrors = validator.FormErrors{"name": "medication with this strength and dosage form already exists"}
+			app.renderMedication(w, r, http.StatusUnprocessableEntity, medication, &form)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.setFlash(w, r, "Medication successfully updated!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/medications/%d", id), http.StatusSeeOther)
+}
+
 func (app *application) medicationDelete(w http.ResponseWriter, r *http.Request) {
-	name := r.FormValue("name")
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
 
-	err := app.medications.Delete(name, app.getUserIdFromContext(w, r))
+	medication, err := app.medications.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanDeleteMedication(medication) {
+		err = app.setFlash(w, r, "Unauthorized action - cannot delete medications!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/medications/", http.StatusSeeOther)
+		return
+	}
+
+	err = app.medications.Delete(id, app.getUserIdFromContext(w, r))
 	if err != nil {
 		if errors.Is(err, models.ErrExistingDependency) {
 			err = app.setFlash(w, r, "Medication cannot be deleted due to registed patients.", FlashTypeWarning)
@@ -81,20 +231,15 @@ func (app *application) medicationDelete(w http.ResponseWriter, r *http.Request)
 				return
 			}
 			http.Redirect(w, r, "/medications/", http.StatusSeeOther)
-		} else if errors.Is(err, models.ErrUnauthorizedAction) {
-			err = app.setFlash(w, r, "Unauthorized action - cannot delete medications!", FlashTypeDanger)
-			if err != nil {
-				app.serverError(w, err)
-				return
-			}
-			http.Redirect(w, r, "/medications/", http.StatusSeeOther)
+		} else if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
 		} else {
 			app.serverError(w, err)
 		}
 		return
 	}
 
-	err = app.setFlash(w, r, "Medication successfully deleted!", FlashTypeSuccess)
+	err = app.setFlash(w, r, "Medication moved to trash.", FlashTypeSuccess)
 	if err != nil {
 		app.serverError(w, err)
 		return
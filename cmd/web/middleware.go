@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/gorilla/csrf"
+	"p-system.okostadinov.net/internal/models"
 )
 
 func secureHeaders(next http.Handler) http.Handler {
@@ -54,23 +58,106 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// the API counterpart of requireAuthentication, responding with 401 instead of redirecting to the login page
+func (app *application) requireAPIAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(w, r) {
+			app.apiError(w, http.StatusUnauthorized, "you must be authenticated to access this resource")
+			return
+		}
+
+		w.Header().Add("Cache-Control", "no-store")
+		w.Header().Set("X-CSRF-Token", csrf.Token(r))
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// restricts access to users holding one of the given roles, meant to guard whole subrouters
+func (app *application) requireRole(roles ...models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !slices.Contains(roles, app.getActorFromContext(w, r).Role) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// the prefix of the API's paths, the only ones authenticated by bearer tokens
+const apiPathPrefix = "/api/v1/"
+
+// authenticates the request either via a bearer token in the Authorization header or via the session cookie;
+// tokens are accepted only by the API, so that they grant neither the administration nor the account pages and never
+// stand in for the second step of logging in; token authenticated requests are exempt from CSRF checks since they do
+// not rely on ambient browser credentials
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		if authorizationHeader := r.Header.Get("Authorization"); authorizationHeader != "" {
+			if !strings.HasPrefix(r.URL.Path, apiPathPrefix) {
+				app.apiError(w, http.StatusUnauthorized, "authentication tokens are only accepted by the API")
+				return
+			}
+
+			headerParts := strings.Split(authorizationHeader, " ")
+			if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+				app.apiError(w, http.StatusUnauthorized, "invalid or missing authentication token")
+				return
+			}
+
+			userId, err := app.tokens.GetUserId(headerParts[1])
+			if err != nil {
+				if errors.Is(err, models.ErrNoRecord) {
+					app.apiError(w, http.StatusUnauthorized, "invalid or missing authentication token")
+				} else {
+					app.apiServerError(w, err)
+				}
+				return
+			}
+
+			// a token outliving its user is no longer valid
+			user, err := app.users.Get(userId)
+			if err != nil {
+				if errors.Is(err, models.ErrNoRecord) {
+					app.apiError(w, http.StatusUnauthorized, "invalid or missing authentication token")
+				} else {
+					app.apiServerError(w, err)
+				}
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, userIdContextKey, user.ID)
+			ctx = context.WithValue(ctx, userRoleContextKey, user.Role)
+			r = csrf.UnsafeSkipCheck(r.WithContext(ctx))
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		userId := app.getUserId(w, r)
 		if userId == 0 {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		exists, err := app.users.Exists(userId)
-		if err != nil {
+		user, err := app.users.Get(userId)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
 			app.serverError(w, err)
 			return
 		}
 
-		if exists {
+		// a session logged in before the password changed is treated as logged out
+		if user != nil && user.SessionVersion == app.getSessionVersion(r) {
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
-			ctx = context.WithValue(ctx, userIdContextKey, userId)
+			ctx = context.WithValue(ctx, userIdContextKey, user.ID)
+			ctx = context.WithValue(ctx, userRoleContextKey, user.Role)
+			ctx = context.WithValue(ctx, mustEnrollContextKey, user.TwoFactorRequired && !user.TwoFactorEnabled)
 			r = r.WithContext(ctx)
 		}
 
@@ -78,6 +165,20 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// keeps users whom an admin requires to use two-factor authentication on the enrollment page until they enroll;
+// token authenticated requests, which only reach the API, are let through as scripts have no way of enrolling
+func (app *application) requireTwoFactorEnrollment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mustEnroll, _ := r.Context().Value(mustEnrollContextKey).(bool)
+		if mustEnroll && !slices.Contains(twoFactorEnrollmentPaths, r.URL.Path) && !strings.HasPrefix(r.URL.Path, "/static/") {
+			http.Redirect(w, r, "/users/account/two-factor", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // options setup for gorilla csrf middleware
 func csrfProtect(key string) func(http.Handler) http.Handler {
 	return csrf.Protect(
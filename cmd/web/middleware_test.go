@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"p-system.okostadinov.net/internal/models"
+)
+
+// accepts every token as one of the user
+type tokensOf struct {
+	models.TokenStore
+	userId int
+}
+
+func (s tokensOf) GetUserId(plaintext string) (int, error) {
+	return s.userId, nil
+}
+
+// knows of no users, as if the token's user was removed after the token was looked up
+type noUsers struct {
+	models.UserStore
+}
+
+func (noUsers) Get(id int) (*models.User, error) {
+	return nil, models.ErrNoRecord
+}
+
+func TestAuthenticateTokenOfMissingUser(t *testing.T) {
+	app := &application{
+		errorLog: log.New(io.Discard, "", 0),
+		tokens:   tokensOf{userId: 1},
+		users:    noUsers{},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the request was let through")
+	})
+
+	r := httptest.NewRequest(http.MethodGet, apiPathPrefix+"patients", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+
+	app.authenticate(next).ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"p-system.okostadinov.net/internal/migrations"
+)
+
+const (
+	defaultDSN        = "p_system_admin:p_system_admin@/p_system?parseTime=true&loc=Local"
+	defaultSQLitePath = "p_system.db"
+)
+
+// runs the migrate subcommand, returning the process exit code
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	driver := fs.String("driver", "mysql", "Database driver, either mysql or sqlite")
+	dsn := fs.String("dsn", "", "MySQL data source name, requiring privileges to alter the schema, or SQLite database file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s migrate [flags] up|down|status|to VERSION\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "  up          apply every pending migration")
+		fmt.Fprintln(fs.Output(), "  down        revert the most recently applied migration")
+		fmt.Fprintln(fs.Output(), "  status      list the migrations and whether they have been applied")
+		fmt.Fprintln(fs.Output(), "  to VERSION  migrate up or down to the given version, 0 reverting everything")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	db, _, err := openDB(*driver, *dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db, *driver)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch command := fs.Arg(0); {
+	case command == "up" && fs.NArg() == 1:
+		var run []migrations.Migration
+		run, err = migrator.Up()
+		printMigrations("applied", run)
+	case command == "down" && fs.NArg() == 1:
+		var reverted *migrations.Migration
+		reverted, err = migrator.Down()
+		if reverted != nil {
+			printMigrations("reverted", []migrations.Migration{*reverted})
+		}
+	case command == "to" && fs.NArg() == 2:
+		version, convErr := strconv.Atoi(fs.Arg(1))
+		if convErr != nil {
+			fs.Usage()
+			return 2
+		}
+
+		var run []migrations.Migration
+		run, err = migrator.To(version)
+		printMigrations("ran", run)
+	case command == "status" && fs.NArg() == 1:
+		err = printStatus(migrator)
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func printMigrations(verb string, run []migrations.Migration) {
+	if len(run) == 0 {
+		fmt.Println("nothing to do")
+		return
+	}
+
+	for _, m := range run {
+		fmt.Printf("%s %04d_%s\n", verb, m.Version, m.Name)
+	}
+}
+
+func printStatus(migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.Applied != nil {
+			applied = s.Applied.Format("02 Jan 2006 15:04")
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+
+	return tw.Flush()
+}
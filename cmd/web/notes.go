@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/validator"
+)
+
+// the form for both writing a note and editing one, which is identified by its id
+type noteForm struct {
+	ID                   int    `schema:"note_id"`
+	Type                 string `schema:"type" validate:"required,oneof=general observation assessment plan"`
+	Body                 string `schema:"body" validate:"required,max=10000"`
+	validator.FormErrors `schema:"-"`
+}
+
+// renders notes written in GitHub flavored Markdown; raw HTML is left out and links with dangerous schemes such as
+// javascript: are dropped, as goldmark does unless told otherwise, so the output is safe to embed in the page
+var markdownRenderer = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+func markdown(source string) template.HTML {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(source), &buf); err != nil {
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+	return template.HTML(buf.String())
+}
+
+// fills in the patient's timeline of notes, as shown on its page
+func (app *application) addNoteData(data *templateData, patient *models.Patient) error {
+	notes, err := app.notes.GetAllByPatient(patient.ID)
+	if err != nil {
+		return err
+	}
+
+	data.Notes = notes
+	data.NoteTypes = models.NoteTypes
+	data.NoteEditWindow = app.noteEditWindow
+
+	return nil
+}
+
+func (app *application) patientNoteAdd(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanWriteNotes(patient) {
+		app.redirectToPatient(w, r, patient.ID, "Unauthorized action - cannot write notes!", FlashTypeDanger)
+		return
+	}
+
+	var form noteForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		app.redirectToPatient(w, r, patient.ID, formErrorsMessage("note", app.validator.FormErrors), FlashTypeDanger)
+		return
+	}
+
+	_, err = app.notes.Insert(patient.ID, form.Type, form.Body, app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.redirectToPatient(w, r, patient.ID, "Note successfully added!", FlashTypeSuccess)
+}
+
+func (app *application) patientNoteUpdate(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var form noteForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	note, err := app.notes.Get(form.ID)
+	if err != nil || note.PatientId != patient.ID {
+		if err == nil || errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanEditNote(note) {
+		app.redirectToPatient(w, r, patient.ID, "Unauthorized action - only the author may edit a note!", FlashTypeDanger)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		app.redirectToPatient(w, r, patient.ID, formErrorsMessage("note", app.validator.FormErrors), FlashTypeDanger)
+		return
+	}
+
+	err = app.notes.Update(note.ID, form.Type, form.Body, time.Now().UTC().Add(-app.noteEditWindow))
+	if err != nil {
+		if errors.Is(err, models.ErrNoteLocked) {
+			app.redirectToPatient(w, r, patient.ID, "The note is locked and can no longer be edited, add a new one instead.", FlashTypeWarning)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.redirectToPatient(w, r, patient.ID, "Note successfully updated!", FlashTypeSuccess)
+}
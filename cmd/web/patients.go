@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"p-system.okostadinov.net/internal/models"
@@ -12,21 +14,33 @@ import (
 )
 
 type patientForm struct {
-	UCN                  string `schema:"ucn" validate:"required,numeric,len=10"`
-	FirstName            string `schema:"first_name" validate:"required,alphaunicode"`
-	LastName             string `schema:"last_name" validate:"required,alphaunicode"`
-	PhoneNumber          string `schema:"phone_number" validate:"required,e164"`
-	Height               int    `schema:"height" validate:"required,numeric"`
-	Weight               int    `schema:"weight" validate:"required,numeric"`
-	Medication           string `schema:"medication" validate:"required"`
-	Note                 string `schema:"note" validate:"required"`
-	Approved             bool   `schema:"approved" validate:"boolean"`
-	FirstContinuation    bool   `schema:"first_continuation" validate:"boolean"`
-	validator.FormErrors `schema:"-"`
+	UCN                  string `schema:"ucn" json:"ucn" validate:"required,ucn"`
+	FirstName            string `schema:"first_name" json:"first_name" validate:"required,alphaunicode"`
+	LastName             string `schema:"last_name" json:"last_name" validate:"required,alphaunicode"`
+	PhoneNumber          string `schema:"phone_number" json:"phone_number" validate:"required,e164"`
+	Height               int    `schema:"height" json:"height" validate:"required,numeric"`
+	Weight               int    `schema:"weight" json:"weight" validate:"required,numeric"`
+	Note                 string `schema:"note" json:"note" validate:"max=10000"`
+	Confirmed            bool   `schema:"confirmed" json:"confirmed"` // creates the patient despite probable duplicates
+	validator.FormErrors `schema:"-" json:"-"`
 }
 
-type searchByUCNForm struct {
-	UCN string `schema:"q"`
+type patientListForm struct {
+	Query                string   `schema:"q" json:"q"`
+	Page                 int      `schema:"page" json:"page" validate:"omitempty,min=1"`
+	PageSize             int      `schema:"page_size" json:"page_size" validate:"omitempty,min=1,max=100"`
+	Sort                 string   `schema:"sort" json:"sort" validate:"omitempty,oneof=id ucn first_name last_name height weight"`
+	Direction            string   `schema:"direction" json:"direction" validate:"omitempty,oneof=asc desc"`
+	Medication           int      `schema:"medication" json:"medication" validate:"omitempty,min=1"`
+	Owner                int      `schema:"owner" json:"owner" validate:"omitempty,min=1"`
+	TherapyStatus        string   `schema:"therapy_status" json:"therapy_status" validate:"omitempty,oneof=draft submitted approved rejected first_continuation continued"`
+	MinAge               int      `schema:"min_age" json:"min_age" validate:"omitempty,min=0,max=150"`
+	MaxAge               int      `schema:"max_age" json:"max_age" validate:"omitempty,min=0,max=150,gtefield=MinAge"`
+	Format               string   `schema:"format" json:"-" validate:"omitempty,oneof=csv xlsx"`
+	Columns              []string `schema:"columns" json:"-" validate:"omitempty,dive,oneof=id ucn first_name last_name phone_number height weight therapy_status therapy_expires medications note"`
+	FixedMedication      bool     `schema:"-" json:"-"`
+	FixedOwner           bool     `schema:"-" json:"-"`
+	validator.FormErrors `schema:"-" json:"-"`
 }
 
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
@@ -38,23 +52,51 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 
 	data := app.newTemplateData(w, r)
 	data.Patients = latest
+
+	if data.IsAuthenticated {
+		data.Continuations, err = app.therapy.GetDueByUser(data.UserId, app.reminderHorizon())
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		data.UnexpiringTherapies, err = app.therapy.GetWithoutExpiryByUser(data.UserId)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
 	app.render(w, http.StatusOK, "home.tmpl.html", data)
 }
 
 func (app *application) patientCreate(w http.ResponseWriter, r *http.Request) {
-	medications, err := app.medications.GetAll()
-	if err != nil {
-		app.serverError(w, err)
+	if !app.getActorFromContext(w, r).CanCreatePatient() {
+		err := app.setFlash(w, r, "Unauthorized action - cannot create patients!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/patients/", http.StatusSeeOther)
 		return
 	}
 
 	data := app.newTemplateData(w, r)
-	data.Medications = medications
 	data.Form = &patientForm{}
 	app.render(w, http.StatusOK, "create.tmpl.html", data)
 }
 
 func (app *application) patientCreatePost(w http.ResponseWriter, r *http.Request) {
+	if !app.getActorFromContext(w, r).CanCreatePatient() {
+		err := app.setFlash(w, r, "Unauthorized action - cannot create patients!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/patients/", http.StatusSeeOther)
+		return
+	}
+
 	var form patientForm
 	err := app.decodeForm(r, &form)
 	if err != nil {
@@ -63,14 +105,7 @@ func (app *application) patientCreatePost(w http.ResponseWriter, r *http.Request
 	}
 
 	if !app.validator.ValidateForm(form) {
-		medications, err := app.medications.GetAll()
-		if err != nil {
-			app.serverError(w, err)
-			return
-		}
-
 		data := app.newTemplateData(w, r)
-		data.Medications = medications
 		form.FormErrors = app.validator.FormErrors
 		data.Form = form
 		app.render(w, http.StatusUnprocessableEntity, "create.tmpl.html", data)
@@ -83,7 +118,24 @@ func (app *application) patientCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	id, err := app.patients.Insert(form.UCN, form.FirstName, form.LastName, form.PhoneNumber, form.Height, form.Weight, form.Medication, form.Note, userId)
+	// probable duplicates are pointed out first, asking to confirm the patient is indeed someone else
+	if !form.Confirmed {
+		duplicates, err := app.patients.FindDuplicates(form.UCN, form.FirstName, form.LastName, form.PhoneNumber)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		if len(duplicates) > 0 {
+			data := app.newTemplateData(w, r)
+			data.Duplicates = duplicates
+			data.Form = form
+			app.render(w, http.StatusOK, "create.tmpl.html", data)
+			return
+		}
+	}
+
+	id, err := app.patients.Insert(form.UCN, form.FirstName, form.LastName, form.PhoneNumber, form.Height, form.Weight, form.Note, userId)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -98,33 +150,81 @@ func (app *application) patientCreatePost(w http.ResponseWriter, r *http.Request
 }
 
 func (app *application) patientList(w http.ResponseWriter, r *http.Request) {
-	patients, err := app.patients.GetAll()
+	var form patientListForm
+	err := app.decodeQuery(r, &form)
 	if err != nil {
-		app.serverError(w, err)
+		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	data := app.newTemplateData(w, r)
-	data.Patients = patients
-	app.render(w, http.StatusOK, "list.tmpl.html", data)
+	app.renderPatientList(w, r, &form)
 }
 
 func (app *application) patientListFiltered(w http.ResponseWriter, r *http.Request) {
-	medication := mux.Vars(r)["name"]
-
-	patients, err := app.patients.GetAllByMedication(medication)
+	var form patientListForm
+	err := app.decodeQuery(r, &form)
 	if err != nil {
-		app.serverError(w, err)
+		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	data := app.newTemplateData(w, r)
-	data.Patients = patients
-	app.render(w, http.StatusOK, "list.tmpl.html", data)
+	form.Medication, err = strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.FixedMedication = true
+	app.renderPatientList(w, r, &form)
 }
 
 func (app *application) patientListOwn(w http.ResponseWriter, r *http.Request) {
-	patients, err := app.patients.GetAllByUserId(app.getUserIdFromContext(w, r))
+	var form patientListForm
+	err := app.decodeQuery(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Owner = app.getUserIdFromContext(w, r)
+	form.FixedOwner = true
+	app.renderPatientList(w, r, &form)
+}
+
+// explains why a list matched by the application, as it is searched or sorted by encrypted data or filtered by age,
+// is refused
+const tooManyToMatchMessage = "Too many patients to search or sort by personal data or to filter by age, please narrow the list down by medication, owner or therapy status first."
+
+// validates the list form and renders the matching page of patients, or exports all of them if a format was chosen,
+// shared by all patient list views
+func (app *application) renderPatientList(w http.ResponseWriter, r *http.Request, form *patientListForm) {
+	if !app.validator.ValidateForm(form) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if form.Format != "" {
+		app.exportPatients(w, r, form)
+		return
+	}
+
+	status := http.StatusOK
+
+	patients, metadata, err := app.patients.List(form.filter())
+	if err != nil {
+		if !errors.Is(err, models.ErrTooManyToMatch) {
+			app.serverError(w, err)
+			return
+		}
+		status = http.StatusUnprocessableEntity
+	}
+
+	medications, err := app.medications.GetAll()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	users, err := app.users.GetAll()
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -132,7 +232,16 @@ func (app *application) patientListOwn(w http.ResponseWriter, r *http.Request) {
 
 	data := app.newTemplateData(w, r)
 	data.Patients = patients
-	app.render(w, http.StatusOK, "list.tmpl.html", data)
+	data.Metadata = metadata
+	data.Medications = medications
+	data.Users = users
+	data.TherapyStatuses = models.TherapyStatuses
+	data.ExportColumns = exportColumns
+	data.Form = form
+	if status == http.StatusUnprocessableEntity {
+		data.Flash = Flash{Content: tooManyToMatchMessage, Type: FlashTypeWarning}
+	}
+	app.render(w, status, "list.tmpl.html", data)
 }
 
 func (app *application) patientView(w http.ResponseWriter, r *http.Request) {
@@ -152,17 +261,72 @@ func (app *application) patientView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	medications, err := app.medications.GetAll()
+	data := app.newTemplateData(w, r)
+	data.Patient = patient
+	data.Form = &patientForm{}
+
+	err = app.addPrescriptionData(data, patient)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
+	err = app.addTherapyData(data, patient)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.addOwnershipData(data, patient)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.addNoteData(data, patient)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.addAttachmentData(data, patient)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, http.StatusOK, "view.tmpl.html", data)
+}
+
+// shows the field-level change timeline of a patient, which remains available after the patient has been deleted
+func (app *application) patientHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := app.audit.GetAllByPatient(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	patient, err := app.patients.Get(id)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	if patient == nil && len(entries) == 0 {
+		app.notFound(w)
+		return
+	}
+
 	data := app.newTemplateData(w, r)
 	data.Patient = patient
-	data.Medications = medications
-	data.Form = &patientForm{}
-	app.render(w, http.StatusOK, "view.tmpl.html", data)
+	data.AuditEntries = entries
+	app.render(w, http.StatusOK, "history.tmpl.html", data)
 }
 
 func (app *application) patientUpdate(w http.ResponseWriter, r *http.Request) {
@@ -172,6 +336,26 @@ func (app *application) patientUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	patient, err := app.patients.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanUpdatePatient(patient) {
+		err = app.setFlash(w, r, "Unauthorized action - cannot modify patient!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/patients/%d", id), http.StatusSeeOther)
+		return
+	}
+
 	var form patientForm
 	err = app.decodeForm(r, &form)
 	if err != nil {
@@ -180,36 +364,49 @@ func (app *application) patientUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !app.validator.ValidateForm(form) {
-		medications, err := app.medications.GetAll()
+		data := app.newTemplateData(w, r)
+		form.FormErrors = app.validator.FormErrors
+		data.Form = form
+		data.Patient = patient
+
+		err = app.addPrescriptionData(data, patient)
 		if err != nil {
 			app.serverError(w, err)
 			return
 		}
 
-		patient, err := app.patients.Get(id)
+		err = app.addTherapyData(data, patient)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		err = app.addOwnershipData(data, patient)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		err = app.addNoteData(data, patient)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		err = app.addAttachmentData(data, patient)
 		if err != nil {
 			app.serverError(w, err)
 			return
 		}
 
-		data := app.newTemplateData(w, r)
-		data.Medications = medications
-		form.FormErrors = app.validator.FormErrors
-		data.Form = form
-		data.Patient = patient
 		app.render(w, http.StatusUnprocessableEntity, "view.tmpl.html", data)
 		return
 	}
 
-	err = app.patients.Update(id, form.UCN, form.FirstName, form.LastName, form.PhoneNumber, form.Height, form.Weight, form.Medication, form.Note, form.Approved, form.FirstContinuation, app.getUserIdFromContext(w, r))
+	err = app.patients.Update(id, form.UCN, form.FirstName, form.LastName, form.PhoneNumber, form.Height, form.Weight, app.getUserIdFromContext(w, r))
 	if err != nil {
-		if errors.Is(err, models.ErrUnauthorizedAction) {
-			err = app.setFlash(w, r, "Unauthorized action - cannot modify patient!", FlashTypeDanger)
-			if err != nil {
-				app.serverError(w, err)
-				return
-			}
-			http.Redirect(w, r, fmt.Sprintf("/patients/%d", id), http.StatusSeeOther)
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
 		} else {
 			app.serverError(w, err)
 		}
@@ -231,22 +428,37 @@ func (app *application) patientDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	patient, err := app.patients.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanDeletePatient(patient) {
+		err = app.setFlash(w, r, "Unauthorized action - cannot delete patient!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/patients/", http.StatusSeeOther)
+		return
+	}
+
 	err = app.patients.Delete(id, app.getUserIdFromContext(w, r))
 	if err != nil {
-		if errors.Is(err, models.ErrUnauthorizedAction) {
-			err = app.setFlash(w, r, "Unauthorized action - cannot delete patient!", FlashTypeDanger)
-			if err != nil {
-				app.serverError(w, err)
-				return
-			}
-			http.Redirect(w, r, "/patients/", http.StatusSeeOther)
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
 		} else {
 			app.serverError(w, err)
 		}
 		return
 	}
 
-	err = app.setFlash(w, r, "Patient successfully deleted!", FlashTypeSuccess)
+	err = app.setFlash(w, r, "Patient moved to trash.", FlashTypeSuccess)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -254,28 +466,132 @@ func (app *application) patientDelete(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/patients/", http.StatusSeeOther)
 }
 
-func (app *application) patientSearchByUCN(w http.ResponseWriter, r *http.Request) {
-	var form searchByUCNForm
-	err := app.decodeForm(r, &form)
+// searches patients by name, phone number, medication, note and UCN prefix, going straight to the patient on an exact UCN hit
+func (app *application) patientSearch(w http.ResponseWriter, r *http.Request) {
+	var form patientListForm
+	err := app.decodeQuery(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	patient, err := app.patients.GetByUCN(form.UCN)
-	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
-			err = app.setFlash(w, r, "No patients exists with this UCN.", FlashTypeWarning)
-			if err != nil {
-				app.serverError(w, err)
-				return
-			}
-			http.Redirect(w, r, r.Header.Get("Referer"), http.StatusSeeOther)
-		} else {
+	form.Query = strings.TrimSpace(form.Query)
+	if form.Query == "" {
+		http.Redirect(w, r, "/patients/", http.StatusSeeOther)
+		return
+	}
+
+	// an export lists the exact hit like any other result, as do UCNs registered more than once
+	if form.Format == "" {
+		patients, err := app.patients.GetAllByUCN(form.Query)
+		if err != nil {
 			app.serverError(w, err)
+			return
 		}
-		return
+
+		if len(patients) == 1 {
+			http.Redirect(w, r, fmt.Sprintf("/patients/%d", patients[0].ID), http.StatusSeeOther)
+			return
+		}
+	}
+
+	app.renderPatientList(w, r, &form)
+}
+
+// converts the submitted list parameters into a model filter
+func (f *patientListForm) filter() models.PatientFilter {
+	return models.PatientFilter{
+		Query:         strings.TrimSpace(f.Query),
+		MedicationId:  f.Medication,
+		UserId:        f.Owner,
+		TherapyStatus: models.TherapyStatus(f.TherapyStatus),
+		MinAge:        f.MinAge,
+		MaxAge:        f.MaxAge,
+		Sort:          f.Sort,
+		Direction:     f.Direction,
+		Page:          f.Page,
+		PageSize:      f.PageSize,
+	}
+}
+
+// encodes the active list parameters, leaving out those already fixed by the route
+func (f *patientListForm) values() url.Values {
+	values := url.Values{}
+
+	if f.Query != "" {
+		values.Set("q", f.Query)
+	}
+	if f.PageSize != 0 {
+		values.Set("page_size", strconv.Itoa(f.PageSize))
+	}
+	if f.Sort != "" {
+		values.Set("sort", f.Sort)
+	}
+	if f.Direction != "" {
+		values.Set("direction", f.Direction)
+	}
+	if f.Medication != 0 && !f.FixedMedication {
+		values.Set("medication", strconv.Itoa(f.Medication))
+	}
+	if f.Owner != 0 && !f.FixedOwner {
+		values.Set("owner", strconv.Itoa(f.Owner))
+	}
+	if f.TherapyStatus != "" {
+		values.Set("therapy_status", f.TherapyStatus)
+	}
+	if f.MinAge != 0 {
+		values.Set("min_age", strconv.Itoa(f.MinAge))
+	}
+	if f.MaxAge != 0 {
+		values.Set("max_age", strconv.Itoa(f.MaxAge))
+	}
+
+	return values
+}
+
+// returns the active list parameters, which an export carries over as hidden fields
+func (f *patientListForm) ExportValues() url.Values {
+	values := f.values()
+	values.Del("page_size")
+	return values
+}
+
+// returns the query string for another page of the current list
+func (f *patientListForm) PageQuery(page int) string {
+	values := f.values()
+	values.Set("page", strconv.Itoa(page))
+	return "?" + values.Encode()
+}
+
+// returns the query string sorting the current list by column, toggling the direction if it is already sorted by it
+func (f *patientListForm) SortQuery(column string) string {
+	values := f.values()
+	values.Set("sort", column)
+
+	if f.sortColumn() == column && f.Direction != "desc" {
+		values.Set("direction", "desc")
+	} else {
+		values.Set("direction", "asc")
 	}
 
-	http.Redirect(w, r, fmt.Sprintf("/patients/%d", patient.ID), http.StatusSeeOther)
+	return "?" + values.Encode()
+}
+
+// returns an arrow indicating the sort direction if the list is sorted by column
+func (f *patientListForm) SortIndicator(column string) string {
+	if f.sortColumn() != column {
+		return ""
+	}
+
+	if f.Direction == "desc" {
+		return "▼"
+	}
+	return "▲"
+}
+
+func (f *patientListForm) sortColumn() string {
+	if f.Sort == "" && f.Query == "" {
+		return "id"
+	}
+	return f.Sort
 }
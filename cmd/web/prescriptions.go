@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/validator"
+)
+
+// the form for both prescribing a medication and modifying an existing prescription, which is identified by its id;
+// it is resubmitted from the confirmation page along with the reason for overriding severe interactions
+type prescriptionForm struct {
+	ID                   int    `schema:"prescription_id"`
+	MedicationId         int    `schema:"medication_id" validate:"required_without=ID"`
+	Dose                 string `schema:"dose" validate:"required,max=50"`
+	Frequency            string `schema:"frequency" validate:"required,max=50"`
+	StartDate            string `schema:"start_date" validate:"required,datetime=2006-01-02"`
+	EndDate              string `schema:"end_date" validate:"omitempty,datetime=2006-01-02"`
+	OverrideReason       string `schema:"override_reason" validate:"max=500"`
+	Confirmed            bool   `schema:"confirmed"`
+	validator.FormErrors `schema:"-"`
+}
+
+// decodes and validates the form and parses the period of the prescription, returning a message describing what
+// is wrong with the form, if anything
+func (app *application) decodePrescriptionForm(r *http.Request, form *prescriptionForm) (time.Time, *time.Time, string, error) {
+	err := app.decodeForm(r, form)
+	if err != nil {
+		return time.Time{}, nil, "", err
+	}
+
+	if !app.validator.ValidateForm(*form) {
+		return time.Time{}, nil, formErrorsMessage("prescription", app.validator.FormErrors), nil
+	}
+
+	startDate, err := time.Parse(models.DateLayout, form.StartDate)
+	if err != nil {
+		return time.Time{}, nil, "", err
+	}
+
+	if form.EndDate == "" {
+		return startDate, nil, "", nil
+	}
+
+	endDate, err := time.Parse(models.DateLayout, form.EndDate)
+	if err != nil {
+		return time.Time{}, nil, "", err
+	}
+
+	if endDate.Before(startDate) {
+		return time.Time{}, nil, "Invalid prescription - the end date cannot precede the start date.", nil
+	}
+
+	return startDate, &endDate, "", nil
+}
+
+// sums up the form errors in a single flash message, for the forms on the patient's page which are not re-rendered
+func formErrorsMessage(subject string, formErrors validator.FormErrors) string {
+	fields := make([]string, 0, len(formErrors))
+	for field, message := range formErrors {
+		fields = append(fields, fmt.Sprintf("%s: %s", strings.ReplaceAll(field, "_", " "), message))
+	}
+	sort.Strings(fields)
+
+	return "Invalid " + subject + " - " + strings.Join(fields, ", ") + "."
+}
+
+// sums up the interactions in the rest of a flash message, e.g. "interactions with Aspirin 100 mg tablet (moderate)."
+func interactionsMessage(warnings []*models.InteractionWarning) string {
+	parts := make([]string, len(warnings))
+	for i, w := range warnings {
+		parts[i] = fmt.Sprintf("%s (%s)", w.Medication, w.Severity)
+	}
+
+	return "interactions with " + strings.Join(parts, ", ") + "."
+}
+
+// asks for a reason to prescribe the medication despite severe interactions, keeping the rest of the form as submitted
+func (app *application) renderPrescriptionConfirm(w http.ResponseWriter, r *http.Request, patient *models.Patient, form prescriptionForm, warnings []*models.InteractionWarning) {
+	medication, err := app.medications.Get(form.MedicationId)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.redirectToPatient(w, r, patient.ID, "The medication no longer exists.", FlashTypeWarning)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if form.Confirmed {
+		form.FormErrors = validator.FormErrors{"override_reason": "required field"}
+		status = http.StatusUnprocessableEntity
+	}
+
+	data := app.newTemplateData(w, r)
+	data.Patient = patient
+	data.Medication = medication
+	data.InteractionWarnings = warnings
+	data.Form = form
+	app.render(w, status, "prescription_confirm.tmpl.html", data)
+}
+
+// fills in the patient's prescriptions and the medications they may be put on, as shown on its page
+func (app *application) addPrescriptionData(data *templateData, patient *models.Patient) error {
+	prescriptions, err := app.prescriptions.GetAllByPatient(patient.ID)
+	if err != nil {
+		return err
+	}
+
+	medications, err := app.medications.GetAll()
+	if err != nil {
+		return err
+	}
+
+	data.Prescriptions = prescriptions
+	data.Medications = medications
+
+	return nil
+}
+
+// fetches the prescription chosen in the form, as long as it belongs to the patient
+func (app *application) prescriptionFromForm(id int, patient *models.Patient) (*models.Prescription, error) {
+	prescription, err := app.prescriptions.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if prescription.PatientId != patient.ID {
+		return nil, models.ErrNoRecord
+	}
+
+	return prescription, nil
+}
+
+func (app *application) patientPrescriptionAdd(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanPrescribe(patient) {
+		app.redirectToPatient(w, r, patient.ID, "Unauthorized action - cannot prescribe medications!", FlashTypeDanger)
+		return
+	}
+
+	var form prescriptionForm
+	startDate, endDate, invalid, err := app.decodePrescriptionForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if invalid != "" {
+		app.redirectToPatient(w, r, patient.ID, invalid, FlashTypeDanger)
+		return
+	}
+
+	warnings, err := app.interactions.CheckPrescription(patient.ID, form.MedicationId, startDate, endDate, 0)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.redirectToPatient(w, r, patient.ID, "The medication no longer exists.", FlashTypeWarning)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	overrideReason := strings.TrimSpace(form.OverrideReason)
+	if models.HasSevereInteraction(warnings) && overrideReason == "" {
+		app.renderPrescriptionConfirm(w, r, patient, form, warnings)
+		return
+	}
+
+	_, err = app.prescriptions.Insert(patient.ID, form.MedicationId, form.Dose, form.Frequency, startDate, endDate, overrideReason, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.redirectToPatient(w, r, patient.ID, "The medication no longer exists.", FlashTypeWarning)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if len(warnings) > 0 {
+		app.redirectToPatient(w, r, patient.ID, "Medication prescribed despite "+interactionsMessage(warnings), FlashTypeWarning)
+		return
+	}
+
+	app.redirectToPatient(w, r, patient.ID, "Medication successfully prescribed!", FlashTypeSuccess)
+}
+
+func (app *application) patientPrescriptionUpdate(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanPrescribe(patient) {
+		app.redirectToPatient(w, r, patient.ID, "Unauthorized action - cannot modify prescriptions!", FlashTypeDanger)
+		return
+	}
+
+	var form prescriptionForm
+	startDate, endDate, invalid, err := app.decodePrescriptionForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if invalid != "" {
+		app.redirectToPatient(w, r, patient.ID, invalid, FlashTypeDanger)
+		return
+	}
+
+	prescription, err := app.prescriptionFromForm(form.ID, patient)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// a reason given before still covers the prescription, as its medication cannot change
+	overrideReason := strings.TrimSpace(form.OverrideReason)
+	if overrideReason == "" {
+		overrideReason = prescription.OverrideReason
+	}
+
+	warnings, err := app.interactions.CheckPrescription(patient.ID, prescription.MedicationId, startDate, endDate, prescription.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if models.HasSevereInteraction(warnings) && overrideReason == "" {
+		form.MedicationId = prescription.MedicationId
+		app.renderPrescriptionConfirm(w, r, patient, form, warnings)
+		return
+	}
+
+	err = app.prescriptions.Update(prescription.ID, form.Dose, form.Frequency, startDate, endDate, overrideReason, app.getUserIdFromContext(w, r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrStoppedPrescription):
+			app.redirectToPatient(w, r, patient.ID, "Stopped prescriptions cannot be modified.", FlashTypeWarning)
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if len(warnings) > 0 {
+		app.redirectToPatient(w, r, patient.ID, "Prescription updated despite "+interactionsMessage(warnings), FlashTypeWarning)
+		return
+	}
+
+	app.redirectToPatient(w, r, patient.ID, "Prescription successfully updated!", FlashTypeSuccess)
+}
+
+func (app *application) patientPrescriptionStop(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanPrescribe(patient) {
+		app.redirectToPatient(w, r, patient.ID, "Unauthorized action - cannot stop prescriptions!", FlashTypeDanger)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("prescription_id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	prescription, err := app.prescriptionFromForm(id, patient)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.prescriptions.Stop(prescription.ID, app.getUserIdFromContext(w, r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrStoppedPrescription):
+			app.redirectToPatient(w, r, patient.ID, "The prescription has already been stopped.", FlashTypeWarning)
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.redirectToPatient(w, r, patient.ID, fmt.Sprintf("%s stopped.", prescription.Medication), FlashTypeSuccess)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/notify"
+)
+
+// the last date, as of today, whose expiring therapies are due for continuation
+func (app *application) reminderHorizon() time.Time {
+	return time.Now().UTC().Add(app.reminderWindow)
+}
+
+// sends each owner and co-owner a single digest of the continuations which have become upcoming or overdue since they
+// were last reminded, returning how many digests were sent; a patient is marked as reminded only once all of its
+// owners have been notified, so that a failed notification is retried on the next run
+func (app *application) sendContinuationReminders() (int, error) {
+	now := time.Now().UTC()
+
+	reminders, err := app.therapy.GetPendingReminders(now, app.reminderHorizon())
+	if err != nil {
+		return 0, err
+	}
+
+	var digests [][]*models.Reminder
+	for i, reminder := range reminders {
+		if i == 0 || reminder.UserId != reminders[i-1].UserId {
+			digests = append(digests, nil)
+		}
+		digests[len(digests)-1] = append(digests[len(digests)-1], reminder)
+	}
+
+	failed := make(map[int]bool)
+	sent := 0
+	for _, digest := range digests {
+		err := app.notifier.Notify(continuationDigest(digest))
+		if err != nil {
+			app.errorLog.Printf("notifying %s of continuations: %v", digest[0].UserEmail, err)
+			for _, reminder := range digest {
+				failed[reminder.PatientId] = true
+			}
+			continue
+		}
+		sent++
+	}
+
+	marked := make(map[int]bool)
+	for _, reminder := range reminders {
+		if failed[reminder.PatientId] || marked[reminder.PatientId] {
+			continue
+		}
+
+		err := app.therapy.MarkReminded(reminder.PatientId, reminder.Stage)
+		if err != nil {
+			return sent, err
+		}
+		marked[reminder.PatientId] = true
+	}
+
+	return sent, nil
+}
+
+// composes the message reminding a single user of their continuations, overdue ones first as they are the earliest
+func continuationDigest(reminders []*models.Reminder) notify.Message {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hello %s,\n\nthe following therapies are due for continuation:\n\n", reminders[0].UserName)
+
+	for _, reminder := range reminders {
+		state := "expires"
+		if reminder.Stage == models.ReminderStageOverdue {
+			state = "expired"
+		}
+		fmt.Fprintf(&body, "- %s (%s), %s on %s\n", reminder.PatientName, reminder.Status.Label(), state, reminder.Expires.Format("02 Jan 2006"))
+	}
+
+	body.WriteString("\nPlease review them in the P-System.\n")
+
+	return notify.Message{
+		To:      reminders[0].UserEmail,
+		Subject: fmt.Sprintf("Therapies due for continuation (%d)", len(reminders)),
+		Body:    body.String(),
+	}
+}
+
+// sends the reminders once at startup and then on every tick of the interval, so that a server restarted more often
+// than the interval still sends them; meant to be run in its own goroutine
+func (app *application) remindContinuationsPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.remindContinuations()
+		<-ticker.C
+	}
+}
+
+// sends the pending reminders, logging rather than returning any error, as nobody waits for the outcome
+func (app *application) remindContinuations() {
+	sent, err := app.sendContinuationReminders()
+	if err != nil {
+		app.errorLog.Print(err)
+		return
+	}
+
+	if sent > 0 {
+		app.infoLog.Printf("sent %d continuation reminders", sent)
+	}
+}
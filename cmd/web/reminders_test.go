@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/notify"
+	"p-system.okostadinov.net/internal/testdb"
+)
+
+// the recipients of the messages, in the order they were sent
+func recipients(messages []notify.Message) []string {
+	var to []string
+	for _, msg := range messages {
+		to = append(to, msg.To)
+	}
+	return to
+}
+
+func TestSendContinuationReminders(t *testing.T) {
+	db, dialect := testdb.Open(t, "sqlite")
+	stores := models.NewStores(db, dialect, nil)
+
+	notifier := &notify.MemoryNotifier{}
+	app := &application{
+		errorLog:       log.New(io.Discard, "", 0),
+		therapy:        stores.Therapy,
+		notifier:       notifier,
+		reminderWindow: 30 * 24 * time.Hour,
+	}
+
+	// ids 1 to 4, in order
+	for _, name := range []string{"alice", "bob", "carol", "reviewer"} {
+		err := stores.Users.Insert(name, name+"@example.com", "password123")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	const alice, bob, carol, reviewer = 1, 2, 3, 4
+
+	today := time.Now().UTC()
+
+	// inserts a patient whose therapy is approved until the date
+	approved := func(ucn string, name string, owner int, expires time.Time) int {
+		t.Helper()
+
+		id, err := stores.Patients.Insert(ucn, name, "Patient", "0888123456", 180, 80, "", owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = stores.Therapy.Transition(id, models.TherapyActionSubmit, "", nil, owner); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = stores.Therapy.Transition(id, models.TherapyActionApprove, "", &expires, reviewer); err != nil {
+			t.Fatal(err)
+		}
+
+		return id
+	}
+
+	upcoming := approved("7501020018", "Upcoming", alice, today.AddDate(0, 0, 10))
+	approved("8506150090", "Overdue", alice, today.AddDate(0, 0, -1))
+	approved("0441151237", "Distant", carol, today.AddDate(0, 0, 60))
+
+	if err := stores.Patients.AddCoOwner(upcoming, bob, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(wantSent int) []notify.Message {
+		t.Helper()
+
+		notifier.Reset()
+
+		sent, err := app.sendContinuationReminders()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if sent != wantSent {
+			t.Errorf("sent %d digests, want %d", sent, wantSent)
+		}
+
+		return notifier.Messages()
+	}
+
+	t.Run("one digest per user", func(t *testing.T) {
+		messages := run(2)
+
+		if to := recipients(messages); !slices.Equal(to, []string{"alice@example.com", "bob@example.com"}) {
+			t.Fatalf("sent to %v", to)
+		}
+
+		// the owner's digest lists both patients, the overdue one first, while the co-owner's lists the one they co-own
+		if body := messages[0].Body; !strings.Contains(body, "Overdue Patient (Approved), expired") || strings.Index(body, "Overdue") > strings.Index(body, "Upcoming") {
+			t.Errorf("owner's digest:\n%s", body)
+		}
+
+		if body := messages[1].Body; !strings.Contains(body, "Upcoming Patient (Approved), expires") || strings.Contains(body, "Overdue") {
+			t.Errorf("co-owner's digest:\n%s", body)
+		}
+
+		if subject := messages[0].Subject; subject != "Therapies due for continuation (2)" {
+			t.Errorf("owner's subject %q", subject)
+		}
+	})
+
+	t.Run("a single reminder per stage", func(t *testing.T) {
+		if messages := run(0); len(messages) != 0 {
+			t.Fatalf("reminded again of the same stage: %v", recipients(messages))
+		}
+
+		// once overdue, the owners are reminded again, of that patient alone
+		_, err := db.Exec("UPDATE patients SET therapy_expires = ? WHERE id = ?", today.AddDate(0, 0, -2).Format(models.DateLayout), upcoming)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		messages := run(2)
+		if to := recipients(messages); !slices.Equal(to, []string{"alice@example.com", "bob@example.com"}) {
+			t.Fatalf("sent to %v", to)
+		}
+
+		for _, msg := range messages {
+			if !strings.Contains(msg.Body, "Upcoming Patient (Approved), expired") || strings.Contains(msg.Body, "Overdue Patient") {
+				t.Errorf("digest of %s:\n%s", msg.To, msg.Body)
+			}
+		}
+
+		if messages := run(0); len(messages) != 0 {
+			t.Fatalf("reminded again of the overdue stage: %v", recipients(messages))
+		}
+	})
+
+	t.Run("a failed send is retried", func(t *testing.T) {
+		failing := approved("0543100090", "Failing", carol, today.AddDate(0, 0, 5))
+		if err := stores.Patients.AddCoOwner(failing, alice, carol); err != nil {
+			t.Fatal(err)
+		}
+
+		notifier.Fail = func(msg notify.Message) error {
+			if msg.To == "carol@example.com" {
+				return errors.New("mailbox unavailable")
+			}
+			return nil
+		}
+
+		// the co-owner is notified, but the patient stays pending as its owner was not
+		messages := run(1)
+		if to := recipients(messages); !slices.Equal(to, []string{"alice@example.com"}) {
+			t.Fatalf("sent to %v", to)
+		}
+
+		if messages := run(1); len(messages) != 1 {
+			t.Fatalf("the failed digest was not retried while the send keeps failing")
+		}
+
+		notifier.Fail = nil
+
+		messages = run(2)
+		if to := recipients(messages); !slices.Equal(to, []string{"alice@example.com", "carol@example.com"}) {
+			t.Fatalf("sent to %v", to)
+		}
+
+		if !strings.Contains(messages[1].Body, "Failing Patient (Approved), expires") {
+			t.Errorf("retried digest:\n%s", messages[1].Body)
+		}
+
+		if messages := run(0); len(messages) != 0 {
+			t.Fatalf("reminded again once delivered: %v", recipients(messages))
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"p-system.okostadinov.net/internal/encryption"
+	"p-system.okostadinov.net/internal/models"
+)
+
+// the environment variable the encryption keys are read from unless given by the -encryption-keys flag, which keeps
+// them out of the process list
+const encryptionKeysEnv = "P_SYSTEM_ENCRYPTION_KEYS"
+
+// registers the flag of the keys sealing the personal data of patients with the flag set
+func encryptionKeysFlag(fs *flag.FlagSet) *string {
+	return fs.String("encryption-keys", os.Getenv(encryptionKeysEnv), "Versioned keys sealing the personal data of patients and the two-factor secrets of users, e.g. \"1:<base64 key>,2:<base64 key>\", the highest version sealing new data (defaults to $"+encryptionKeysEnv+", personal data is stored unencrypted if empty)")
+}
+
+// parses the encryption keys, returning nil if there are none
+func parseEncryptionKeys(s string) (*encryption.Keyring, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return encryption.ParseKeys(s)
+}
+
+// runs the rotate subcommand, which seals every patient's personal data, its notes, the audit log and the two-factor
+// secrets of users again with the current key; returns the exit code
+func runRotate(args []string) int {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	driver := fs.String("driver", "mysql", "Database driver, either mysql or sqlite")
+	dsn := fs.String("dsn", "", "MySQL data source name or SQLite database file (defaults to the local p_system database)")
+	keys := encryptionKeysFlag(fs)
+	batchSize := fs.Int("batch-size", 500, "How many rows are sealed again in a single transaction")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s rotate [flags]\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Seals the personal data of every patient, its notes, the audit log and the two-factor secrets of users")
+		fmt.Fprintln(fs.Output(), "with the current key, the one with the highest version, replacing data sealed with older keys or")
+		fmt.Fprintln(fs.Output(), "stored before encryption was enabled. The older keys can be removed once it completes; it can safely")
+		fmt.Fprintln(fs.Output(), "be run again if interrupted.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 0 || *batchSize < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	keyring, err := parseEncryptionKeys(*keys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if keyring == nil {
+		fmt.Fprintf(os.Stderr, "no encryption keys given, set -encryption-keys or $%s\n", encryptionKeysEnv)
+		return 1
+	}
+
+	db, dialect, err := openDB(*driver, *dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	rotation := &models.KeyRotation{DB: db, Dialect: dialect, Keys: keyring, BatchSize: *batchSize}
+
+	steps := []struct {
+		name   string
+		reseal func() (int, error)
+	}{
+		{"patients", rotation.Patients},
+		{"notes", rotation.Notes},
+		{"audit log entries", rotation.AuditLog},
+		{"two-factor secrets", rotation.TwoFactorSecrets},
+	}
+
+	for _, step := range steps {
+		n, err := step.reseal()
+		if n > 0 || err == nil {
+			fmt.Printf("sealed %d %s with key %d\n", n, step.name, keyring.Current())
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	return 0
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"p-system.okostadinov.net/internal/models"
 	"p-system.okostadinov.net/ui"
 )
 
@@ -14,7 +15,7 @@ func (app *application) routes(csrfKey string) http.Handler {
 	fileServer := http.FileServer(http.FS(ui.Files))
 	mux.PathPrefix("/static/").Handler(fileServer)
 
-	mux.Use(app.recoverPanic, app.logRequest, secureHeaders, app.authenticate, csrfProtect(csrfKey))
+	mux.Use(app.recoverPanic, app.logRequest, secureHeaders, app.authenticate, app.requireTwoFactorEnrollment, csrfProtect(csrfKey))
 
 	mux.HandleFunc("/", app.home).Methods("GET")
 
@@ -22,29 +23,110 @@ func (app *application) routes(csrfKey string) http.Handler {
 	patientsRouter.Use(app.requireAuthentication)
 	patientsRouter.HandleFunc("/", app.patientList).Methods("GET")
 	patientsRouter.HandleFunc("/user", app.patientListOwn).Methods("GET")
-	patientsRouter.HandleFunc("/medication/{name}", app.patientListFiltered).Methods("GET")
+	patientsRouter.HandleFunc("/medication/{id:[0-9]+}", app.patientListFiltered).Methods("GET")
 	patientsRouter.HandleFunc("/create", app.patientCreate).Methods("GET")
 	patientsRouter.HandleFunc("/create", app.patientCreatePost).Methods("POST")
 	patientsRouter.HandleFunc("/{id:[0-9]+}", app.patientView).Methods("GET")
 	patientsRouter.HandleFunc("/{id:[0-9]+}", app.patientUpdate).Methods("POST")
-	patientsRouter.HandleFunc("/search", app.patientSearchByUCN).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/history", app.patientHistory).Methods("GET")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/vitals", app.patientVitals).Methods("GET")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/vitals", app.patientVitalsAdd).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/notes", app.patientNoteAdd).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/notes/update", app.patientNoteUpdate).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/attachments", app.patientAttachmentAdd).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/attachments/{aid:[0-9]+}", app.patientAttachmentDownload).Methods("GET")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/attachments/delete", app.patientAttachmentDelete).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/transfer", app.patientTransferPost).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/co-owners", app.patientCoOwnerAdd).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/co-owners/delete", app.patientCoOwnerRemove).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/therapy", app.patientTherapyTransition).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/prescriptions", app.patientPrescriptionAdd).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/prescriptions/update", app.patientPrescriptionUpdate).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/prescriptions/stop", app.patientPrescriptionStop).Methods("POST")
+	patientsRouter.HandleFunc("/{id:[0-9]+}/merge", app.patientMerge).Methods("POST")
+	patientsRouter.HandleFunc("/queue", app.therapyQueue).Methods("GET")
+	patientsRouter.HandleFunc("/duplicates", app.patientDuplicates).Methods("GET")
+	patientsRouter.HandleFunc("/search", app.patientSearch).Methods("GET")
 	patientsRouter.HandleFunc("/delete", app.patientDelete).Methods("POST")
 
 	medicationsRouter := mux.PathPrefix("/medications").Subrouter()
 	medicationsRouter.Use(app.requireAuthentication)
 	medicationsRouter.HandleFunc("/", app.medicationList).Methods("GET")
 	medicationsRouter.HandleFunc("/", app.medicationAdd).Methods("POST")
+	medicationsRouter.HandleFunc("/{id:[0-9]+}", app.medicationView).Methods("GET")
+	medicationsRouter.HandleFunc("/{id:[0-9]+}", app.medicationUpdate).Methods("POST")
 	medicationsRouter.HandleFunc("/delete", app.medicationDelete).Methods("POST")
 
+	interactionsRouter := mux.PathPrefix("/interactions").Subrouter()
+	interactionsRouter.Use(app.requireAuthentication)
+	interactionsRouter.HandleFunc("/", app.interactionList).Methods("GET")
+	interactionsRouter.HandleFunc("/import", app.interactionImport).Methods("POST")
+	interactionsRouter.HandleFunc("/delete", app.interactionDelete).Methods("POST")
+
+	importRouter := mux.PathPrefix("/import").Subrouter()
+	importRouter.Use(app.requireAuthentication)
+	importRouter.HandleFunc("/", app.importPage).Methods("GET")
+	importRouter.HandleFunc("/", app.importPost).Methods("POST")
+
+	trashRouter := mux.PathPrefix("/trash").Subrouter()
+	trashRouter.Use(app.requireAuthentication)
+	trashRouter.HandleFunc("/", app.trashList).Methods("GET")
+	trashRouter.HandleFunc("/patients/restore", app.trashPatientRestore).Methods("POST")
+	trashRouter.HandleFunc("/medications/restore", app.trashMedicationRestore).Methods("POST")
+
+	transfersRouter := mux.PathPrefix("/transfers").Subrouter()
+	transfersRouter.Use(app.requireAuthentication)
+	transfersRouter.HandleFunc("/", app.transferList).Methods("GET")
+	transfersRouter.HandleFunc("/accept", app.transferAccept).Methods("POST")
+	transfersRouter.HandleFunc("/decline", app.transferDecline).Methods("POST")
+	transfersRouter.HandleFunc("/cancel", app.transferCancel).Methods("POST")
+
+	adminRouter := mux.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(app.requireAuthentication, app.requireRole(models.RoleAdmin))
+	adminRouter.HandleFunc("/users", app.adminUsers).Methods("GET")
+	adminRouter.HandleFunc("/users/role", app.adminUserRolePost).Methods("POST")
+	adminRouter.HandleFunc("/users/two-factor", app.adminUserTwoFactorPost).Methods("POST")
+	adminRouter.HandleFunc("/users/two-factor/reset", app.adminUserTwoFactorReset).Methods("POST")
+	adminRouter.HandleFunc("/trash/purge", app.trashPurge).Methods("POST")
+
 	userRouter := mux.PathPrefix("/users").Subrouter()
 	userRouter.HandleFunc("/signup", app.userSignup).Methods("GET")
 	userRouter.HandleFunc("/signup", app.userSignupPost).Methods("POST")
 	userRouter.HandleFunc("/login", app.userLogin).Methods("GET")
 	userRouter.HandleFunc("/login", app.userLoginPost).Methods("POST")
+	userRouter.HandleFunc("/login/two-factor", app.userLoginTwoFactor).Methods("GET")
+	userRouter.HandleFunc("/login/two-factor", app.userLoginTwoFactorPost).Methods("POST")
+	userRouter.HandleFunc("/password/forgot", app.userPasswordForgot).Methods("GET")
+	userRouter.HandleFunc("/password/forgot", app.userPasswordForgotPost).Methods("POST")
+	userRouter.HandleFunc("/password/reset", app.userPasswordReset).Methods("GET")
+	userRouter.HandleFunc("/password/reset", app.userPasswordResetPost).Methods("POST")
 
 	userRouterProtected := mux.PathPrefix("/users").Subrouter()
 	userRouterProtected.Use(app.requireAuthentication)
 	userRouterProtected.HandleFunc("/logout", app.userLogout).Methods("POST")
+	userRouterProtected.HandleFunc("/account", app.userAccount).Methods("GET")
+	userRouterProtected.HandleFunc("/account/profile", app.userProfilePost).Methods("POST")
+	userRouterProtected.HandleFunc("/account/password", app.userPasswordPost).Methods("POST")
+	userRouterProtected.HandleFunc("/account/two-factor", app.userTwoFactor).Methods("GET")
+	userRouterProtected.HandleFunc("/account/two-factor", app.userTwoFactorPost).Methods("POST")
+	userRouterProtected.HandleFunc("/account/two-factor/recovery-codes", app.userRecoveryCodesPost).Methods("POST")
+	userRouterProtected.HandleFunc("/account/two-factor/disable", app.userTwoFactorDisablePost).Methods("POST")
+	userRouterProtected.HandleFunc("/account/two-factor/devices/forget", app.userDevicesForgetPost).Methods("POST")
+	userRouterProtected.HandleFunc("/account/tokens", app.userTokenCreatePost).Methods("POST")
+	userRouterProtected.HandleFunc("/account/tokens/delete", app.userTokenDelete).Methods("POST")
+
+	apiRouter := mux.PathPrefix("/api/v1").Subrouter()
+	apiRouter.Use(app.requireAPIAuthentication)
+	apiRouter.HandleFunc("/patients", app.apiPatientList).Methods("GET")
+	apiRouter.HandleFunc("/patients", app.apiPatientCreate).Methods("POST")
+	apiRouter.HandleFunc("/patients/{id:[0-9]+}", app.apiPatientGet).Methods("GET")
+	apiRouter.HandleFunc("/patients/{id:[0-9]+}", app.apiPatientUpdate).Methods("PUT")
+	apiRouter.HandleFunc("/patients/{id:[0-9]+}", app.apiPatientDelete).Methods("DELETE")
+	apiRouter.HandleFunc("/medications", app.apiMedicationList).Methods("GET")
+	apiRouter.HandleFunc("/medications", app.apiMedicationCreate).Methods("POST")
+	apiRouter.HandleFunc("/medications/{id:[0-9]+}", app.apiMedicationGet).Methods("GET")
+	apiRouter.HandleFunc("/medications/{id:[0-9]+}", app.apiMedicationUpdate).Methods("PUT")
+	apiRouter.HandleFunc("/medications/{id:[0-9]+}", app.apiMedicationDelete).Methods("DELETE")
 
 	return mux
 }
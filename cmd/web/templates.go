@@ -1,24 +1,202 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/policy"
 	"p-system.okostadinov.net/ui"
 )
 
 type templateData struct {
-	CurrentYear     int
-	Patient         *models.Patient
-	Patients        []*models.Patient
-	Medications     []*models.Medication
-	Form            any
-	Flash           Flash
-	IsAuthenticated bool
-	UserId          int
-	CSRFField       template.HTML
+	CurrentYear          int
+	Patient              *models.Patient
+	Patients             []*models.Patient
+	Duplicates           []*models.Duplicate
+	Metadata             models.Metadata
+	Medication           *models.Medication
+	Medications          []*models.Medication
+	MedicationUnits      []string
+	DosageForms          []string
+	Users                []*models.User
+	CoOwners             []*models.User
+	Transfers            []*models.Transfer
+	Prescriptions        []*models.Prescription
+	Interactions         []*models.Interaction
+	Severities           []string
+	InteractionWarnings  []*models.InteractionWarning
+	TherapyTransitions   []*models.TherapyTransition
+	TherapyActions       []models.TherapyAction
+	TherapyStatuses      []models.TherapyStatus
+	Continuations        []*models.Continuation
+	UnexpiringTherapies  []*models.Continuation
+	Measurements         []*models.Measurement
+	Charts               []template.HTML
+	Notes                []*models.Note
+	NoteTypes            []string
+	NoteEditWindow       time.Duration
+	Attachments          []*models.Attachment
+	AttachmentTypes      []string
+	AttachmentCategories []string
+	MaxUploadSize        int64
+	ExportColumns        []exportColumn
+	ImportKinds          []importKind
+	ImportReport         *importReport
+	Incoming             []*models.Transfer
+	Tokens               []*models.Token
+	AuditEntries         []*models.AuditEntry
+	Retention            time.Duration
+	AutoPurge            bool
+	NewToken             string
+	User                 *models.User
+	QRCode               template.HTML
+	TOTPSecret           string
+	RecoveryCodes        []string
+	RecoveryCodesLeft    int
+	Form                 any
+	Flash                Flash
+	IsAuthenticated      bool
+	UserId               int
+	Actor                policy.Actor
+	Roles                []models.Role
+	CSRFField            template.HTML
+}
+
+var functions = template.FuncMap{
+	"highlight":    highlight,
+	"excerpt":      excerpt,
+	"humanDays":    humanDays,
+	"humanBytes":   humanBytes,
+	"today":        today,
+	"monthsAhead":  monthsAhead,
+	"markdown":     markdown,
+	"therapyColor": therapyColor,
+}
+
+// picks the Bootstrap color of the badge showing a therapy status
+func therapyColor(status models.TherapyStatus) string {
+	switch status {
+	case models.TherapyStatusSubmitted:
+		return "warning"
+	case models.TherapyStatusRejected:
+		return "danger"
+	case models.TherapyStatusApproved, models.TherapyStatusFirstContinuation, models.TherapyStatusContinued:
+		return "success"
+	default:
+		return "secondary"
+	}
+}
+
+// the current date in the format expected by date inputs
+func today() string {
+	return time.Now().UTC().Format(models.DateLayout)
+}
+
+// the date the given number of months from now in the format expected by date inputs, e.g. to suggest an expiry
+func monthsAhead(months int) string {
+	return time.Now().UTC().AddDate(0, months, 0).Format(models.DateLayout)
+}
+
+// formats a duration as a whole number of days
+func humanDays(d time.Duration) string {
+	days := int(d.Hours() / 24)
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// formats a size in bytes with the largest binary unit it amounts to at least one of, e.g. 1.5 MB
+func humanBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value := float64(n) / 1024
+	units := []string{"KB", "MB", "GB"}
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if value == float64(int64(value)) {
+		return fmt.Sprintf("%.0f %s", value, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// builds a case-insensitive pattern matching any of the query's search terms, preferring the longest ones
+func searchPattern(query string) *regexp.Regexp {
+	terms := models.SearchTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	sort.Slice(terms, func(i, j int) bool { return len(terms[i]) > len(terms[j]) })
+
+	for i, term := range terms {
+		terms[i] = regexp.QuoteMeta(term)
+	}
+
+	return regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+}
+
+// escapes the text while wrapping every occurrence of the query's search terms in <mark> tags
+func highlight(text, query string) template.HTML {
+	pattern := searchPattern(query)
+	if pattern == nil {
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+
+	var b strings.Builder
+	last := 0
+
+	for _, loc := range pattern.FindAllStringIndex(text, -1) {
+		b.WriteString(template.HTMLEscapeString(text[last:loc[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[loc[0]:loc[1]]))
+		b.WriteString("</mark>")
+		last = loc[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+
+	return template.HTML(b.String())
+}
+
+// shortens the text to about width characters surrounding the first occurrence of the query's search terms
+func excerpt(text, query string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+
+	start := 0
+	if pattern := searchPattern(query); pattern != nil {
+		if loc := pattern.FindStringIndex(text); loc != nil {
+			start = len([]rune(text[:loc[0]])) - width/4
+		}
+	}
+
+	start = max(0, min(start, len(runes)-width))
+	end := start + width
+
+	result := string(runes[start:end])
+	if start > 0 {
+		result = "…" + result
+	}
+	if end < len(runes) {
+		result = result + "…"
+	}
+
+	return result
 }
 
 // prepares and stores all the html templates upon app initiation
@@ -39,7 +217,7 @@ func newTemplateCache() (map[string]*template.Template, error) {
 			page,
 		}
 
-		ts, err := template.New(name).ParseFS(ui.Files, patterns...)
+		ts, err := template.New(name).Funcs(functions).ParseFS(ui.Files, patterns...)
 		if err != nil {
 			return nil, err
 		}
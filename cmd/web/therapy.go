@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"p-system.okostadinov.net/internal/models"
+)
+
+type therapyForm struct {
+	Action  string `schema:"action" validate:"required,oneof=submit approve reject continue"`
+	Comment string `schema:"comment" validate:"required_if=Action reject,max=500"`
+	Expires string `schema:"expires" validate:"required_if=Action approve,required_if=Action continue"`
+}
+
+// parses the date an approval or continuation expires on, which has to lie in the future, returning a message
+// describing what is wrong with it, if anything; other actions do not expire
+func parseTherapyExpiry(form therapyForm) (*time.Time, string) {
+	if !models.TherapyAction(form.Action).RequiresExpiry() {
+		return nil, ""
+	}
+
+	expires, err := time.Parse(models.DateLayout, form.Expires)
+	if err != nil {
+		return nil, "Invalid therapy decision - expires: invalid date (format 2006-01-02)."
+	}
+
+	if expires.Format(models.DateLayout) <= today() {
+		return nil, "Invalid therapy decision - expires: the date has to be in the future."
+	}
+
+	return &expires, ""
+}
+
+// fills in the patient's therapy transitions and the actions the user may take on it, as shown on its page
+func (app *application) addTherapyData(data *templateData, patient *models.Patient) error {
+	transitions, err := app.therapy.GetAllByPatient(patient.ID)
+	if err != nil {
+		return err
+	}
+
+	data.TherapyTransitions = transitions
+	data.TherapyActions = nil
+	for _, action := range patient.TherapyStatus.Actions() {
+		if data.Actor.CanTransitionTherapy(patient, action) {
+			data.TherapyActions = append(data.TherapyActions, action)
+		}
+	}
+
+	return nil
+}
+
+func (app *application) patientTherapyTransition(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var form therapyForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		app.redirectToPatient(w, r, patient.ID, formErrorsMessage("therapy decision", app.validator.FormErrors), FlashTypeDanger)
+		return
+	}
+
+	action := models.TherapyAction(form.Action)
+	if !app.getActorFromContext(w, r).CanTransitionTherapy(patient, action) {
+		app.redirectToPatient(w, r, patient.ID, fmt.Sprintf("Unauthorized action - cannot %s the therapy!", action), FlashTypeDanger)
+		return
+	}
+
+	expires, invalid := parseTherapyExpiry(form)
+	if invalid != "" {
+		app.redirectToPatient(w, r, patient.ID, invalid, FlashTypeDanger)
+		return
+	}
+
+	err = app.therapy.Transition(patient.ID, action, form.Comment, expires, app.getUserIdFromContext(w, r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidTransition):
+			app.redirectToPatient(w, r, patient.ID, fmt.Sprintf("The action is not available for a therapy with status %s.", patient.TherapyStatus.Label()), FlashTypeWarning)
+		case errors.Is(err, models.ErrCommentRequired):
+			app.redirectToPatient(w, r, patient.ID, "Invalid therapy decision - comment: required field.", FlashTypeDanger)
+		case errors.Is(err, models.ErrExpiryRequired):
+			app.redirectToPatient(w, r, patient.ID, "Invalid therapy decision - expires: required field.", FlashTypeDanger)
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	next, _ := patient.TherapyStatus.Next(action)
+	app.redirectToPatient(w, r, patient.ID, fmt.Sprintf("Therapy status changed to %s.", next.Label()), FlashTypeSuccess)
+}
+
+// lists the patients submitted for approval which await the user's decision
+func (app *application) therapyQueue(w http.ResponseWriter, r *http.Request) {
+	if !app.getActorFromContext(w, r).CanReviewTherapies() {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	patients, err := app.therapy.GetQueue(app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(w, r)
+	data.Patients = patients
+	app.render(w, http.StatusOK, "queue.tmpl.html", data)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/policy"
+)
+
+// fills in the co-owners, transfer history and the users eligible to receive the patient, as shown on its page
+func (app *application) addOwnershipData(data *templateData, patient *models.Patient) error {
+	users, err := app.users.GetAll()
+	if err != nil {
+		return err
+	}
+
+	transfers, err := app.transfers.GetAllByPatient(patient.ID)
+	if err != nil {
+		return err
+	}
+
+	data.Users = nil
+	data.CoOwners = nil
+	for _, user := range users {
+		switch {
+		case slices.Contains(patient.CoOwnerIds, user.ID):
+			data.CoOwners = append(data.CoOwners, user)
+		case user.ID != patient.UserId && (policy.Actor{ID: user.ID, Role: user.Role}).CanOwnPatients():
+			data.Users = append(data.Users, user)
+		}
+	}
+	data.Transfers = transfers
+
+	return nil
+}
+
+// fetches the patient from the URL, responding with the appropriate error if it cannot be found
+func (app *application) patientFromPath(w http.ResponseWriter, r *http.Request) (*models.Patient, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+
+	patient, err := app.patients.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	return patient, true
+}
+
+// fetches the user chosen in the form, as long as they are allowed to own patients
+func (app *application) recipientFromForm(r *http.Request) (*models.User, error) {
+	id, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		return nil, models.ErrNoRecord
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !(policy.Actor{ID: user.ID, Role: user.Role}).CanOwnPatients() {
+		return nil, models.ErrNoRecord
+	}
+
+	return user, nil
+}
+
+// redirects back to the patient's page with a flash message
+func (app *application) redirectToPatient(w http.ResponseWriter, r *http.Request, id int, message string, flashType flashType) {
+	err := app.setFlash(w, r, message, flashType)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/patients/%d", id), http.StatusSeeOther)
+}
+
+func (app *application) patientTransferPost(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanTransferPatient(patient) {
+		app.redirectToPatient(w, r, patient.ID, "Unauthorized action - cannot hand over patient!", FlashTypeDanger)
+		return
+	}
+
+	recipient, err := app.recipientFromForm(r)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusBadRequest)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if recipient.ID == patient.UserId {
+		app.redirectToPatient(w, r, patient.ID, "The patient already belongs to this user.", FlashTypeWarning)
+		return
+	}
+
+	_, err = app.transfers.Insert(patient.ID, recipient.ID, app.getUserIdFromContext(w, r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrPendingTransfer):
+			app.redirectToPatient(w, r, patient.ID, "The patient already has a pending hand-over.", FlashTypeWarning)
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.redirectToPatient(w, r, patient.ID, fmt.Sprintf("Hand-over requested, waiting for %s to accept.", recipient.Name), FlashTypeSuccess)
+}
+
+func (app *application) patientCoOwnerAdd(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanManageCoOwners(patient) {
+		app.redirectToPatient(w, r, patient.ID, "Unauthorized action - cannot share patient!", FlashTypeDanger)
+		return
+	}
+
+	coOwner, err := app.recipientFromForm(r)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.clientError(w, http.StatusBadRequest)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if coOwner.ID == patient.UserId {
+		app.redirectToPatient(w, r, patient.ID, "The owner cannot be added as a co-owner.", FlashTypeWarning)
+		return
+	}
+
+	err = app.patients.AddCoOwner(patient.ID, coOwner.ID, app.getUserIdFromContext(w, r))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateCoOwner):
+			app.redirectToPatient(w, r, patient.ID, fmt.Sprintf("%s already co-owns the patient.", coOwner.Name), FlashTypeWarning)
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		default:
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.redirectToPatient(w, r, patient.ID, fmt.Sprintf("%s added as a co-owner.", coOwner.Name), FlashTypeSuccess)
+}
+
+func (app *application) patientCoOwnerRemove(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanManageCoOwners(patient) {
+		app.redirectToPatient(w, r, patient.ID, "Unauthorized action - cannot share patient!", FlashTypeDanger)
+		return
+	}
+
+	coOwnerId, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.patients.RemoveCoOwner(patient.ID, coOwnerId, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.redirectToPatient(w, r, patient.ID, "Co-owner removed.", FlashTypeSuccess)
+}
+
+func (app *application) transferList(w http.ResponseWriter, r *http.Request) {
+	userId := app.getUserIdFromContext(w, r)
+
+	incoming, err := app.transfers.GetPendingByRecipient(userId)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	outgoing, err := app.transfers.GetPendingBySender(userId)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(w, r)
+	data.Incoming = incoming
+	data.Transfers = outgoing
+	app.render(w, http.StatusOK, "transfers.tmpl.html", data)
+}
+
+// fetches the transfer named by the form, responding with the appropriate error if it cannot be found
+func (app *application) transferFromForm(w http.ResponseWriter, r *http.Request) (*models.Transfer, bool) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return nil, false
+	}
+
+	transfer, err := app.transfers.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	return transfer, true
+}
+
+func (app *application) transferAccept(w http.ResponseWriter, r *http.Request) {
+	transfer, ok := app.transferFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanAcceptTransfer(transfer) {
+		app.redirectToTransfers(w, r, "Unauthorized action - cannot accept hand-over!", FlashTypeDanger)
+		return
+	}
+
+	err := app.transfers.Accept(transfer.ID, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.redirectToTransfers(w, r, "The hand-over is no longer pending.", FlashTypeWarning)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// flashes are kept in the session store, so they leave out the patient's name, which is shown on its page anyway
+	app.redirectToPatient(w, r, transfer.PatientId, "You are now the owner of the patient.", FlashTypeSuccess)
+}
+
+func (app *application) transferDecline(w http.ResponseWriter, r *http.Request) {
+	transfer, ok := app.transferFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanAcceptTransfer(transfer) {
+		app.redirectToTransfers(w, r, "Unauthorized action - cannot decline hand-over!", FlashTypeDanger)
+		return
+	}
+
+	err := app.transfers.Decline(transfer.ID, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.redirectToTransfers(w, r, "The hand-over is no longer pending.", FlashTypeWarning)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.redirectToTransfers(w, r, "Hand-over declined.", FlashTypeSuccess)
+}
+
+func (app *application) transferCancel(w http.ResponseWriter, r *http.Request) {
+	transfer, ok := app.transferFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanCancelTransfer(transfer) {
+		app.redirectToTransfers(w, r, "Unauthorized action - cannot cancel hand-over!", FlashTypeDanger)
+		return
+	}
+
+	err := app.transfers.Cancel(transfer.ID, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.redirectToTransfers(w, r, "The hand-over is no longer pending.", FlashTypeWarning)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.redirectToTransfers(w, r, "Hand-over cancelled.", FlashTypeSuccess)
+}
+
+func (app *application) redirectToTransfers(w http.ResponseWriter, r *http.Request, message string, flashType flashType) {
+	err := app.setFlash(w, r, message, flashType)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/transfers/", http.StatusSeeOther)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"p-system.okostadinov.net/internal/models"
+)
+
+func (app *application) trashList(w http.ResponseWriter, r *http.Request) {
+	userId := app.getUserIdFromContext(w, r)
+
+	patients, err := app.patients.GetDeletedByUserId(userId)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	medications, err := app.medications.GetDeletedByUserId(userId)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(w, r)
+	data.Patients = patients
+	data.Medications = medications
+	data.Retention = app.retention
+	data.AutoPurge = app.autoPurge
+	app.render(w, http.StatusOK, "trash.tmpl.html", data)
+}
+
+func (app *application) trashPatientRestore(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	patient, err := app.patients.GetDeleted(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanRestorePatient(patient) {
+		err = app.setFlash(w, r, "Unauthorized action - cannot restore patient!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/trash/", http.StatusSeeOther)
+		return
+	}
+
+	err = app.patients.Restore(id, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrDeletedDependency) {
+			err = app.setFlash(w, r, "Patient cannot be restored while its medication is in the trash.", FlashTypeWarning)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			http.Redirect(w, r, "/trash/", http.StatusSeeOther)
+		} else if errors.Is(err, models.ErrMergedPatient) {
+			err = app.setFlash(w, r, "Patient cannot be restored as it was merged into another one.", FlashTypeWarning)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			http.Redirect(w, r, "/trash/", http.StatusSeeOther)
+		} else if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.setFlash(w, r, "Patient successfully restored!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/trash/", http.StatusSeeOther)
+}
+
+func (app *application) trashMedicationRestore(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	medication, err := app.medications.GetDeleted(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanRestoreMedication(medication) {
+		err = app.setFlash(w, r, "Unauthorized action - cannot restore medication!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/trash/", http.StatusSeeOther)
+		return
+	}
+
+	err = app.medications.Restore(id, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.setFlash(w, r, "Medication successfully restored!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/trash/", http.StatusSeeOther)
+}
+
+// lets an admin purge the expired trash immediately instead of waiting for the periodic purge
+func (app *application) trashPurge(w http.ResponseWriter, r *http.Request) {
+	patients, medications, err := app.purgeTrash(app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.setFlash(w, r, fmt.Sprintf("Purged %d patients and %d medications.", patients, medications), FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/trash/", http.StatusSeeOther)
+}
+
+// permanently removes the records which have been in the trash for longer than the retention period;
+// patients go first, so that medications only referenced by purged patients can be purged in the same run, and
+// take the files attached to them along
+func (app *application) purgeTrash(userId int) (int, int, error) {
+	cutoff := time.Now().UTC().Add(-app.retention)
+
+	digests, err := app.attachments.GetDigestsByDeletedPatients(cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	patients, err := app.patients.Purge(cutoff, userId)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, digest := range digests {
+		app.deleteUnreferencedFile(digest)
+	}
+
+	medications, err := app.medications.Purge(cutoff, userId)
+	if err != nil {
+		return patients, 0, err
+	}
+
+	return patients, medications, nil
+}
+
+// purges the trash once at startup and then on every tick of the interval, so that a server restarted more often than
+// the interval still purges it; meant to be run in its own goroutine
+func (app *application) purgeTrashPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.purgeTrashInBackground()
+		<-ticker.C
+	}
+}
+
+// purges the trash on behalf of no user, logging rather than returning any error, as nobody waits for the outcome
+func (app *application) purgeTrashInBackground() {
+	patients, medications, err := app.purgeTrash(0)
+	if err != nil {
+		app.errorLog.Print(err)
+		return
+	}
+
+	if patients > 0 || medications > 0 {
+		app.infoLog.Printf("purged %d patients and %d medications from the trash", patients, medications)
+	}
+}
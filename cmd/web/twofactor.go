@@ -0,0 +1,539 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/qr"
+	"p-system.okostadinov.net/internal/totp"
+	"p-system.okostadinov.net/internal/validator"
+)
+
+type twoFactorLoginForm struct {
+	Code                 string `schema:"code" validate:"required"`
+	Remember             bool   `schema:"remember"`
+	validator.FormErrors `schema:"-"`
+}
+
+type twoFactorEnableForm struct {
+	Code                 string `schema:"code" validate:"required"`
+	validator.FormErrors `schema:"-"`
+}
+
+// confirms regenerating the recovery codes or disabling two-factor authentication
+type twoFactorPasswordForm struct {
+	Password             string `schema:"password" validate:"required"`
+	validator.FormErrors `schema:"-"`
+}
+
+type userTwoFactorForm struct {
+	ID                   int  `schema:"id" validate:"required"`
+	Required             bool `schema:"required"`
+	validator.FormErrors `schema:"-"`
+}
+
+const (
+	// how long a user who entered their password has to enter their code
+	twoFactorLoginTimeout = 5 * time.Minute
+	// the cookie holding the token of a remembered device, only ever sent to the login page
+	rememberDeviceCookie = "remembered_device"
+	// the name authenticator apps list the application's codes under
+	totpIssuer = "P-System"
+)
+
+// the paths a user who is required to enroll in two-factor authentication may visit before they do
+var twoFactorEnrollmentPaths = []string{"/users/account/two-factor", "/users/logout"}
+
+// logs the user in, clearing any pending second step of logging in; the session lasts until their password changes
+func (app *application) logIn(w http.ResponseWriter, r *http.Request, session *sessions.Session, userId int) error {
+	user, err := app.users.Get(userId)
+	if err != nil {
+		return err
+	}
+
+	delete(session.Values, "pendingUserID")
+	delete(session.Values, "pendingSince")
+	session.Values["userID"] = userId
+	session.Values["sessionVersion"] = user.SessionVersion
+	return session.Save(r, w)
+}
+
+// returns the user who entered their password but still has to enter their code, or 0 if there is none or they took
+// too long
+func (app *application) pendingUserId(session *sessions.Session) int {
+	userId, ok := session.Values["pendingUserID"].(int)
+	if !ok {
+		return 0
+	}
+
+	since, _ := session.Values["pendingSince"].(int64)
+	if time.Since(time.Unix(since, 0)) > twoFactorLoginTimeout {
+		return 0
+	}
+
+	return userId
+}
+
+// reports whether the request comes from a device the user chose to be remembered on
+func (app *application) isDeviceRemembered(r *http.Request, userId int) (bool, error) {
+	cookie, err := r.Cookie(rememberDeviceCookie)
+	if err != nil {
+		return false, nil
+	}
+
+	return app.twoFactor.IsRemembered(userId, cookie.Value)
+}
+
+// sends the user back to the login page, as they have no second step pending
+func (app *application) twoFactorLoginExpired(w http.ResponseWriter, r *http.Request, message string) {
+	err := app.setFlash(w, r, message, FlashTypeWarning)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/users/login", http.StatusSeeOther)
+}
+
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	session, err := app.store.Get(r, "session")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if app.pendingUserId(session) == 0 {
+		app.twoFactorLoginExpired(w, r, "Please log in with your email address and password first.")
+		return
+	}
+
+	data := app.newTemplateData(w, r)
+	data.Form = &twoFactorLoginForm{}
+	app.render(w, http.StatusOK, "login_two_factor.tmpl.html", data)
+}
+
+// completes logging in with a code of the user's authenticator app or one of their recovery codes, optionally
+// remembering the device so that it skips this step until the cookie expires
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	session, err := app.store.Get(r, "session")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	userId := app.pendingUserId(session)
+	if userId == 0 {
+		app.twoFactorLoginExpired(w, r, "Your login has expired, please log in again.")
+		return
+	}
+
+	var form twoFactorLoginForm
+	err = app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		data := app.newTemplateData(w, r)
+		form.FormErrors = app.validator.FormErrors
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login_two_factor.tmpl.html", data)
+		return
+	}
+
+	recovery := models.IsRecoveryCode(form.Code)
+	if recovery {
+		err = app.twoFactor.Recover(userId, form.Code)
+	} else {
+		err = app.twoFactor.Verify(userId, form.Code)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTwoFactorLocked):
+			// the wrong codes are counted for the user rather than the session, so that logging in again does not
+			// give more tries
+			delete(session.Values, "pendingUserID")
+			delete(session.Values, "pendingSince")
+
+			err = session.Save(r, w)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+
+			app.twoFactorLoginExpired(w, r, "Too many invalid codes, please try again later.")
+			return
+		case !errors.Is(err, models.ErrInvalidCode):
+			app.serverError(w, err)
+			return
+		}
+
+		data := app.newTemplateData(w, r)
+		form.FormErrors = validator.FormErrors{"code": "invalid code"}
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login_two_factor.tmpl.html", data)
+		return
+	}
+
+	if form.Remember {
+		token, err := app.twoFactor.RememberDevice(userId, app.rememberDevice)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     rememberDeviceCookie,
+			Value:    token,
+			Path:     "/users/login",
+			MaxAge:   int(app.rememberDevice.Seconds()),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	err = app.logIn(w, r, session, userId)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	message, flashType := "Logged in successfully!", FlashTypeSuccess
+	if recovery {
+		left, err := app.twoFactor.CountRecoveryCodes(userId)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		message = fmt.Sprintf("Logged in with a recovery code, %d left. Regenerate them on your account page if you lost your authenticator.", left)
+		flashType = FlashTypeWarning
+	}
+
+	err = app.setFlash(w, r, message, flashType)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// shows the QR code of a new secret for the user to scan with their authenticator app; the secret is kept in the
+// session until the user confirms it with a code, so that reloading the page does not change it
+func (app *application) userTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		http.Redirect(w, r, "/users/account", http.StatusSeeOther)
+		return
+	}
+
+	session, err := app.store.Get(r, "session")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	secret, ok := session.Values["totpSecret"].(string)
+	if !ok {
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		session.Values["totpSecret"] = secret
+		err = session.Save(r, w)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.renderTwoFactor(w, r, http.StatusOK, user, secret, &twoFactorEnableForm{})
+}
+
+// renders the enrollment page for the secret, with its QR code
+func (app *application) renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, user *models.User, secret string, form *twoFactorEnableForm) {
+	code, err := qr.Encode([]byte(totp.URL(totpIssuer, user.Email, secret)))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(w, r)
+	data.User = user
+	// the SVG escapes its title and consists of nothing else but the modules, so its markup is safe to embed
+	data.QRCode = template.HTML(code.SVG(200, "QR code of the secret"))
+	data.TOTPSecret = groupSecret(secret)
+	data.Form = form
+	app.render(w, status, "two_factor.tmpl.html", data)
+}
+
+// splits the secret into groups of four characters, making it easier to type into an authenticator app
+func groupSecret(secret string) string {
+	var groups []string
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+	return strings.Join(append(groups, secret), " ")
+}
+
+func (app *application) userTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	session, err := app.store.Get(r, "session")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	secret, ok := session.Values["totpSecret"].(string)
+	if user.TwoFactorEnabled || !ok {
+		http.Redirect(w, r, "/users/account/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorEnableForm
+	err = app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		form.FormErrors = app.validator.FormErrors
+		app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, user, secret, &form)
+		return
+	}
+
+	codes, err := app.twoFactor.Enable(user.ID, secret, form.Code)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCode) {
+			form.FormErrors = validator.FormErrors{"code": "invalid code, check the time of your device"}
+			app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, user, secret, &form)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	delete(session.Values, "totpSecret")
+	err = session.Save(r, w)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.renderRecoveryCodes(w, r, http.StatusCreated, codes)
+}
+
+// renders the recovery codes directly instead of redirecting, since they can never be shown again
+func (app *application) renderRecoveryCodes(w http.ResponseWriter, r *http.Request, status int, codes []string) {
+	data := app.newTemplateData(w, r)
+	data.RecoveryCodes = codes
+	app.render(w, status, "recovery_codes.tmpl.html", data)
+}
+
+// decodes and checks a form confirmed by the user's password, rendering the account page with the form's errors
+// and returning false if it is not valid
+func (app *application) confirmPassword(w http.ResponseWriter, r *http.Request, forms func(*twoFactorPasswordForm) *accountForms) bool {
+	var form twoFactorPasswordForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return false
+	}
+
+	if !app.validator.ValidateForm(form) {
+		form.FormErrors = app.validator.FormErrors
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, forms(&form), "")
+		return false
+	}
+
+	err = app.users.CheckPassword(app.getUserIdFromContext(w, r), form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.FormErrors = validator.FormErrors{"password": "incorrect password"}
+			app.renderAccount(w, r, http.StatusUnprocessableEntity, forms(&form), "")
+		} else {
+			app.serverError(w, err)
+		}
+		return false
+	}
+
+	return true
+}
+
+func (app *application) userRecoveryCodesPost(w http.ResponseWriter, r *http.Request) {
+	ok := app.confirmPassword(w, r, func(form *twoFactorPasswordForm) *accountForms {
+		return &accountForms{RecoveryCodes: form}
+	})
+	if !ok {
+		return
+	}
+
+	user, err := app.users.Get(app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	codes, err := app.twoFactor.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.renderRecoveryCodes(w, r, http.StatusCreated, codes)
+}
+
+func (app *application) userTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	ok := app.confirmPassword(w, r, func(form *twoFactorPasswordForm) *accountForms {
+		return &accountForms{DisableTwoFactor: form}
+	})
+	if !ok {
+		return
+	}
+
+	user, err := app.users.Get(app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if user.TwoFactorRequired {
+		err = app.setFlash(w, r, "Two-factor authentication is required for your account and cannot be disabled.", FlashTypeWarning)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/users/account", http.StatusSeeOther)
+		return
+	}
+
+	err = app.twoFactor.Disable(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.setFlash(w, r, "Two-factor authentication successfully disabled!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/users/account", http.StatusSeeOther)
+}
+
+func (app *application) userDevicesForgetPost(w http.ResponseWriter, r *http.Request) {
+	err := app.twoFactor.ForgetDevices(app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.setFlash(w, r, "Every device will ask for a code on the next login!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/users/account", http.StatusSeeOther)
+}
+
+// requires or stops requiring a user to enroll in two-factor authentication
+func (app *application) adminUserTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	var form userTwoFactorForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	_, err = app.users.Get(form.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.twoFactor.SetRequired(form.ID, form.Required)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.setFlash(w, r, "Two-factor requirement successfully updated!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// unenrolls a user who lost both their authenticator and their recovery codes, who has to enroll again on their
+// next login if it is required of them
+func (app *application) adminUserTwoFactorReset(w http.ResponseWriter, r *http.Request) {
+	var form userTwoFactorForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	_, err = app.users.Get(form.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.twoFactor.Disable(form.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.setFlash(w, r, "Two-factor authentication successfully reset!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/notify"
 	"p-system.okostadinov.net/internal/validator"
 )
 
@@ -22,6 +27,50 @@ type userLoginForm struct {
 	validator.FormErrors `schema:"-"`
 }
 
+type tokenCreateForm struct {
+	Name                 string `schema:"name" validate:"required,max=255"`
+	validator.FormErrors `schema:"-"`
+}
+
+type userProfileForm struct {
+	Name                 string `schema:"name" validate:"required,max=255"`
+	Email                string `schema:"email" validate:"required,email,max=255"`
+	validator.FormErrors `schema:"-"`
+}
+
+type passwordChangeForm struct {
+	CurrentPassword      string `schema:"current_password" validate:"required"`
+	NewPassword          string `schema:"new_password" validate:"required,password"`
+	ConfirmPassword      string `schema:"confirm_password" validate:"required,eqfield=NewPassword"`
+	RevokeTokens         bool   `schema:"revoke_tokens"`
+	validator.FormErrors `schema:"-"`
+}
+
+type passwordForgotForm struct {
+	Email                string `schema:"email" validate:"required,email"`
+	validator.FormErrors `schema:"-"`
+}
+
+type passwordResetForm struct {
+	Token                string `schema:"token" validate:"required"`
+	Password             string `schema:"password" validate:"required,password"`
+	ConfirmPassword      string `schema:"confirm_password" validate:"required,eqfield=Password"`
+	validator.FormErrors `schema:"-"`
+}
+
+// the forms of the account page, each of which is submitted on its own; those left nil are rendered empty, apart from
+// the profile, which shows the user's current details
+type accountForms struct {
+	Profile          *userProfileForm
+	Password         *passwordChangeForm
+	Token            *tokenCreateForm
+	RecoveryCodes    *twoFactorPasswordForm
+	DisableTwoFactor *twoFactorPasswordForm
+}
+
+// how long the link mailed to a user who forgot their password stays valid
+const passwordResetTTL = time.Hour
+
 func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(w, r)
 	data.Form = &userSignupForm{}
@@ -103,14 +152,41 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	session, err := app.store.Get(r, "session")
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	session.Values["userID"] = id
-	err = session.Save(r, w)
+	// users enrolled in two-factor authentication are logged in only once they enter a code as well, unless their
+	// device is remembered
+	if user.TwoFactorEnabled {
+		remembered, err := app.isDeviceRemembered(r, id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		if !remembered {
+			session.Values["pendingUserID"] = id
+			session.Values["pendingSince"] = time.Now().Unix()
+			err = session.Save(r, w)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			http.Redirect(w, r, "/users/login/two-factor", http.StatusSeeOther)
+			return
+		}
+	}
+
+	err = app.logIn(w, r, session, id)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -145,3 +221,321 @@ func (app *application) userLogout(w http.ResponseWriter, r *http.Request) {
 	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+func (app *application) userAccount(w http.ResponseWriter, r *http.Request) {
+	app.renderAccount(w, r, http.StatusOK, &accountForms{}, "")
+}
+
+// renders the account page with the given forms, along with the user's tokens and the new token, if one was just
+// created
+func (app *application) renderAccount(w http.ResponseWriter, r *http.Request, status int, forms *accountForms, newToken string) {
+	userId := app.getUserIdFromContext(w, r)
+
+	tokens, err := app.tokens.GetAllByUserId(userId)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	user, err := app.users.Get(userId)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	recoveryCodesLeft, err := app.twoFactor.CountRecoveryCodes(userId)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if forms.Profile == nil {
+		forms.Profile = &userProfileForm{Name: user.Name, Email: user.Email}
+	}
+
+	if forms.Password == nil {
+		forms.Password = &passwordChangeForm{}
+	}
+
+	if forms.Token == nil {
+		forms.Token = &tokenCreateForm{}
+	}
+
+	if forms.RecoveryCodes == nil {
+		forms.RecoveryCodes = &twoFactorPasswordForm{}
+	}
+
+	if forms.DisableTwoFactor == nil {
+		forms.DisableTwoFactor = &twoFactorPasswordForm{}
+	}
+
+	data := app.newTemplateData(w, r)
+	data.User = user
+	data.RecoveryCodesLeft = recoveryCodesLeft
+	data.Tokens = tokens
+	data.NewToken = newToken
+	data.Form = forms
+	app.render(w, status, "account.tmpl.html", data)
+}
+
+func (app *application) userProfilePost(w http.ResponseWriter, r *http.Request) {
+	var form userProfileForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		form.FormErrors = app.validator.FormErrors
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, &accountForms{Profile: &form}, "")
+		return
+	}
+
+	err = app.users.UpdateProfile(app.getUserIdFromContext(w, r), form.Name, form.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.FormErrors = validator.FormErrors{"email": "email address already in use"}
+			app.renderAccount(w, r, http.StatusUnprocessableEntity, &accountForms{Profile: &form}, "")
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.setFlash(w, r, "Profile successfully updated!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/users/account", http.StatusSeeOther)
+}
+
+func (app *application) userPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordChangeForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		form.FormErrors = app.validator.FormErrors
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, &accountForms{Password: &form}, "")
+		return
+	}
+
+	userId := app.getUserIdFromContext(w, r)
+
+	err = app.users.ChangePassword(userId, form.CurrentPassword, form.NewPassword, form.RevokeTokens)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.FormErrors = validator.FormErrors{"current_password": "incorrect password"}
+			app.renderAccount(w, r, http.StatusUnprocessableEntity, &accountForms{Password: &form}, "")
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// every other session has ended with the change, while this one is kept logged in with the new password
+	session, err := app.store.Get(r, "session")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.logIn(w, r, session, userId)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.setFlash(w, r, "Password successfully changed!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/users/account", http.StatusSeeOther)
+}
+
+func (app *application) userTokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form tokenCreateForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		form.FormErrors = app.validator.FormErrors
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, &accountForms{Token: &form}, "")
+		return
+	}
+
+	plaintext, err := app.tokens.Insert(form.Name, app.getUserIdFromContext(w, r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// the plaintext token is rendered directly instead of redirecting, since it can never be shown again
+	app.renderAccount(w, r, http.StatusCreated, &accountForms{}, plaintext)
+}
+
+func (app *application) userTokenDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.tokens.Delete(id, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.setFlash(w, r, "Token successfully revoked!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/users/account", http.StatusSeeOther)
+}
+
+func (app *application) userPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(w, r)
+	data.Form = &passwordForgotForm{}
+	app.render(w, http.StatusOK, "password_forgot.tmpl.html", data)
+}
+
+// mails a password reset link to the user with the given email address; the response is the same whether or not such
+// a user exists, so that the form cannot be used to find out who has an account
+func (app *application) userPasswordForgotPost(w http.ResponseWriter, r *http.Request) {
+	if app.notifier == nil {
+		err := app.setFlash(w, r, "Passwords cannot be reset by email, please contact an administrator.", FlashTypeWarning)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/users/login", http.StatusSeeOther)
+		return
+	}
+
+	var form passwordForgotForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		data := app.newTemplateData(w, r)
+		form.FormErrors = app.validator.FormErrors
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "password_forgot.tmpl.html", data)
+		return
+	}
+
+	user, err := app.users.GetByEmail(form.Email)
+	if err == nil {
+		err = app.sendPasswordReset(user)
+		if err != nil {
+			app.errorLog.Printf("sending password reset to %s: %v", user.Email, err)
+		}
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.setFlash(w, r, "If an account uses this email address, a link to reset its password has been sent to it.", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/users/login", http.StatusSeeOther)
+}
+
+// issues a password reset token for the user and mails them the link to use it
+func (app *application) sendPasswordReset(user *models.User) error {
+	token, err := app.resets.Insert(user.ID, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := app.baseURL + "/users/password/reset?" + url.Values{"token": {token}}.Encode()
+
+	return app.notifier.Notify(notify.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nA password reset was requested for your account. Open the link below within %d minutes to choose a new password:\n\n%s\n\nIf you did not request it, you can ignore this email, your password stays unchanged.\n",
+			user.Name, int(passwordResetTTL.Minutes()), link),
+	})
+}
+
+func (app *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	_, err := app.resets.GetUserId(token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidResetToken) {
+			app.invalidResetToken(w, r)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(w, r)
+	data.Form = &passwordResetForm{Token: token}
+	app.render(w, http.StatusOK, "password_reset.tmpl.html", data)
+}
+
+func (app *application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordResetForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		data := app.newTemplateData(w, r)
+		form.FormErrors = app.validator.FormErrors
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "password_reset.tmpl.html", data)
+		return
+	}
+
+	_, err = app.resets.Reset(form.Token, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidResetToken) {
+			app.invalidResetToken(w, r)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.setFlash(w, r, "Password successfully reset! You may now log in.", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/users/login", http.StatusSeeOther)
+}
+
+// sends the user back to request another link, as the one they followed was used, superseded or expired
+func (app *application) invalidResetToken(w http.ResponseWriter, r *http.Request) {
+	err := app.setFlash(w, r, "The password reset link is invalid or has expired, please request a new one.", FlashTypeWarning)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/users/password/forgot", http.StatusSeeOther)
+}
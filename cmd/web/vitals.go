@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"p-system.okostadinov.net/internal/chart"
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/validator"
+)
+
+// the blood pressure is optional, but has to be given in full
+type vitalsForm struct {
+	Measured             string `schema:"measured" validate:"required,datetime=2006-01-02"`
+	Height               int    `schema:"height" validate:"required,min=30,max=300"`
+	Weight               int    `schema:"weight" validate:"required,min=1,max=500"`
+	Systolic             int    `schema:"systolic" validate:"omitempty,min=40,max=300"`
+	Diastolic            int    `schema:"diastolic" validate:"omitempty,min=20,max=200"`
+	validator.FormErrors `schema:"-"`
+}
+
+// checks what the validator tags cannot express, returning the errors along with the parsed date of the measurement
+func (form *vitalsForm) check() (time.Time, validator.FormErrors) {
+	formErrors := make(validator.FormErrors)
+
+	measured, err := time.Parse(models.DateLayout, form.Measured)
+	if err == nil && measured.Format(models.DateLayout) > today() {
+		formErrors["measured"] = "the date cannot be in the future"
+	}
+
+	switch {
+	case form.Systolic != 0 && form.Diastolic == 0:
+		formErrors["diastolic"] = "required field"
+	case form.Systolic == 0 && form.Diastolic != 0:
+		formErrors["systolic"] = "required field"
+	case form.Systolic != 0 && form.Diastolic >= form.Systolic:
+		formErrors["diastolic"] = "has to be lower than the systolic pressure"
+	}
+
+	return measured, formErrors
+}
+
+func (app *application) patientVitals(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	form := &vitalsForm{Measured: today(), Height: patient.Height}
+	app.renderVitals(w, r, http.StatusOK, patient, form)
+}
+
+// renders the patient's measurements with their charts, along with the form for recording new ones
+func (app *application) renderVitals(w http.ResponseWriter, r *http.Request, status int, patient *models.Patient, form *vitalsForm) {
+	measurements, err := app.measurements.GetAllByPatient(patient.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(w, r)
+	data.Patient = patient
+	data.Measurements = measurements
+	data.Charts = vitalsCharts(measurements)
+	data.Form = form
+	app.render(w, status, "vitals.tmpl.html", data)
+}
+
+// plots the weight trend and, if it was ever measured, the blood pressure
+func vitalsCharts(measurements []*models.Measurement) []template.HTML {
+	weight := chart.Series{Label: "Weight", Color: "#0d6efd"}
+	systolic := chart.Series{Label: "Systolic", Color: "#dc3545"}
+	diastolic := chart.Series{Label: "Diastolic", Color: "#fd7e14"}
+
+	// the measurements come most recent first, while those of the same day are plotted in the order they were taken
+	for i := len(measurements) - 1; i >= 0; i-- {
+		m := measurements[i]
+		weight.Points = append(weight.Points, chart.Point{Time: m.Measured, Value: float64(m.Weight)})
+		if m.Systolic != nil && m.Diastolic != nil {
+			systolic.Points = append(systolic.Points, chart.Point{Time: m.Measured, Value: float64(*m.Systolic)})
+			diastolic.Points = append(diastolic.Points, chart.Point{Time: m.Measured, Value: float64(*m.Diastolic)})
+		}
+	}
+
+	charts := []*chart.Line{{Title: "Weight", Unit: "kg", Width: 640, Height: 240, Series: []chart.Series{weight}}}
+	if len(systolic.Points) > 0 {
+		charts = append(charts, &chart.Line{Title: "Blood pressure", Unit: "mmHg", Width: 640, Height: 240, Series: []chart.Series{systolic, diastolic}})
+	}
+
+	var svgs []template.HTML
+	for _, c := range charts {
+		// the chart escapes every label it is given, so its markup is safe to embed
+		if svg := c.SVG(); svg != "" {
+			svgs = append(svgs, template.HTML(svg))
+		}
+	}
+
+	return svgs
+}
+
+func (app *application) patientVitalsAdd(w http.ResponseWriter, r *http.Request) {
+	patient, ok := app.patientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.getActorFromContext(w, r).CanRecordVitals(patient) {
+		err := app.setFlash(w, r, "Unauthorized action - cannot record vitals!", FlashTypeDanger)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/patients/%d/vitals", patient.ID), http.StatusSeeOther)
+		return
+	}
+
+	var form vitalsForm
+	err := app.decodeForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.validator.ValidateForm(form) {
+		form.FormErrors = app.validator.FormErrors
+		app.renderVitals(w, r, http.StatusUnprocessableEntity, patient, &form)
+		return
+	}
+
+	measured, formErrors := form.check()
+	if len(formErrors) > 0 {
+		form.FormErrors = formErrors
+		app.renderVitals(w, r, http.StatusUnprocessableEntity, patient, &form)
+		return
+	}
+
+	var systolic, diastolic *int
+	if form.Systolic != 0 {
+		systolic, diastolic = &form.Systolic, &form.Diastolic
+	}
+
+	_, err = app.measurements.Insert(patient.ID, measured, form.Height, form.Weight, systolic, diastolic, app.getUserIdFromContext(w, r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.setFlash(w, r, "Vitals successfully recorded!", FlashTypeSuccess)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/patients/%d/vitals", patient.ID), http.StatusSeeOther)
+}
@@ -0,0 +1,165 @@
+// Package chart renders simple time series line charts as standalone SVG markup, so that they can be embedded in
+// pages without any client-side scripts.
+package chart
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the plot area is inset by these margins, leaving room for the legend and the axis labels
+const (
+	marginTop    = 30
+	marginRight  = 20
+	marginBottom = 30
+	marginLeft   = 50
+	yTicks       = 5
+)
+
+// a single value at a point in time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// a named line of points, drawn in the given CSS color
+type Series struct {
+	Label  string
+	Color  string
+	Points []Point
+}
+
+// a chart plotting one or more series sharing the same unit against time
+type Line struct {
+	Title  string
+	Unit   string
+	Width  int
+	Height int
+	Series []Series
+}
+
+// renders the chart as an SVG element scaled to the width of its container, or an empty string if there is nothing to
+// plot; all labels are escaped
+func (c *Line) SVG() string {
+	minTime, maxTime, minValue, maxValue, ok := c.bounds()
+	if !ok {
+		return ""
+	}
+
+	// a single point or a flat line still needs a range to be drawn in
+	if !maxTime.After(minTime) {
+		minTime, maxTime = minTime.AddDate(0, 0, -1), maxTime.AddDate(0, 0, 1)
+	}
+	padding := (maxValue - minValue) * 0.1
+	if padding == 0 {
+		padding = math.Max(math.Abs(maxValue)*0.1, 1)
+	}
+	minValue, maxValue = minValue-padding, maxValue+padding
+
+	plotWidth := float64(c.Width - marginLeft - marginRight)
+	plotHeight := float64(c.Height - marginTop - marginBottom)
+
+	x := func(t time.Time) float64 {
+		return marginLeft + plotWidth*float64(t.Sub(minTime))/float64(maxTime.Sub(minTime))
+	}
+	y := func(v float64) float64 {
+		return marginTop + plotHeight*(maxValue-v)/(maxValue-minValue)
+	}
+
+	var b strings.Builder
+
+	title := html.EscapeString(c.Title)
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%" role="img" aria-label="%s" font-size="11" font-family="sans-serif">`, c.Width, c.Height, title)
+	fmt.Fprintf(&b, `<title>%s</title>`, title)
+
+	// horizontal grid lines with the value axis labels
+	for i := 0; i <= yTicks; i++ {
+		v := minValue + (maxValue-minValue)*float64(i)/yTicks
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#dee2e6"/>`, marginLeft, y(v), c.Width-marginRight, y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle" fill="#6c757d">%s</text>`, marginLeft-6, y(v), formatValue(v, maxValue-minValue))
+	}
+	fmt.Fprintf(&b, `<text x="%d" y="%d" fill="#6c757d">%s</text>`, 4, marginTop-12, html.EscapeString(c.Unit))
+
+	// the first, middle and last dates along the time axis, kept within its ends
+	for i, anchor := range []string{"start", "middle", "end"} {
+		t := minTime.Add(maxTime.Sub(minTime) * time.Duration(i) / 2)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="%s" fill="#6c757d">%s</text>`, x(t), c.Height-marginBottom+18, anchor, t.Format("02 Jan 2006"))
+	}
+
+	legendX := marginLeft
+	for _, s := range c.Series {
+		if len(s.Points) == 0 {
+			continue
+		}
+
+		color := html.EscapeString(s.Color)
+		points := sortedPoints(s.Points)
+
+		coords := make([]string, len(points))
+		for i, p := range points {
+			coords[i] = fmt.Sprintf("%.1f,%.1f", x(p.Time), y(p.Value))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(coords, " "), color)
+
+		for _, p := range points {
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s: %s %s</title></circle>`,
+				x(p.Time), y(p.Value), color, p.Time.Format("02 Jan 2006"), formatValue(p.Value, 0), html.EscapeString(c.Unit))
+		}
+
+		label := html.EscapeString(s.Label)
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, legendX, marginTop-22, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, legendX+14, marginTop-13, label)
+		legendX += 14 + 7*len([]rune(s.Label)) + 16
+	}
+
+	b.WriteString(`</svg>`)
+
+	return b.String()
+}
+
+// returns the range of times and values the points of every series span, and whether there are any points at all
+func (c *Line) bounds() (time.Time, time.Time, float64, float64, bool) {
+	var minTime, maxTime time.Time
+	var minValue, maxValue float64
+	found := false
+
+	for _, s := range c.Series {
+		for _, p := range s.Points {
+			if !found {
+				minTime, maxTime, minValue, maxValue = p.Time, p.Time, p.Value, p.Value
+				found = true
+				continue
+			}
+			if p.Time.Before(minTime) {
+				minTime = p.Time
+			}
+			if p.Time.After(maxTime) {
+				maxTime = p.Time
+			}
+			minValue = math.Min(minValue, p.Value)
+			maxValue = math.Max(maxValue, p.Value)
+		}
+	}
+
+	return minTime, maxTime, minValue, maxValue, found
+}
+
+// orders a copy of the points by time, so that the line is drawn from left to right
+func sortedPoints(points []Point) []Point {
+	sorted := append([]Point(nil), points...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	return sorted
+}
+
+// formats a value with a decimal place only where the span of the axis is too narrow for whole numbers to tell its
+// labels apart
+func formatValue(v float64, span float64) string {
+	if span > 0 && span < yTicks*2 || span == 0 && v != math.Trunc(v) {
+		return fmt.Sprintf("%.1f", v)
+	}
+	return fmt.Sprintf("%.0f", v)
+}
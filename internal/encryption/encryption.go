@@ -0,0 +1,230 @@
+// Package encryption seals personal data before it is stored, using AES-256-GCM under versioned keys, and derives
+// blind indexes, which let the database find a record by an exact value without ever seeing the value itself.
+//
+// Sealed values are stored as text of the form "enc:<version>:<base64 nonce and ciphertext>", so that the key they
+// were sealed with can be told apart while keys are being rotated, and so that values stored before encryption was
+// enabled are still read as they are. Values stored in plaintext which would read as sealed, such as a note typed in as
+// "enc:1:abc", are escaped as "enc:0:<value>" instead.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	prefix  = "enc:"
+	escaped = prefix + "0:"
+)
+
+var (
+	ErrMissingKey = errors.New("encryption: value sealed with a key which is not configured")
+	ErrMalformed  = errors.New("encryption: malformed sealed value")
+)
+
+type key struct {
+	aead  cipher.AEAD
+	index []byte
+}
+
+// the configured keys by version, the highest one sealing every new value; a nil Keyring leaves values as they are
+type Keyring struct {
+	keys    map[int]*key
+	current int
+}
+
+// parses a comma separated list of versioned keys, e.g. "1:<base64 key>,2:<base64 key>", every key being 32 random
+// bytes; the highest version becomes the current key, while the rest are only kept for reading values sealed with them
+func ParseKeys(s string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[int]*key)}
+
+	for _, entry := range strings.Split(s, ",") {
+		version, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("encryption: key %q lacks its version, e.g. 1:<base64 key>", entry)
+		}
+
+		v, err := strconv.Atoi(version)
+		if err != nil || v < 1 {
+			return nil, fmt.Errorf("encryption: invalid key version %q", version)
+		}
+
+		if _, exists := k.keys[v]; exists {
+			return nil, fmt.Errorf("encryption: key version %d given more than once", v)
+		}
+
+		master, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(master) != 32 {
+			return nil, fmt.Errorf("encryption: key version %d is not 32 bytes encoded in base64", v)
+		}
+
+		block, err := aes.NewCipher(derive(master, "p-system encryption"))
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		k.keys[v] = &key{aead: aead, index: derive(master, "p-system blind index")}
+		k.current = max(k.current, v)
+	}
+
+	return k, nil
+}
+
+// derives a separate key for each purpose from the master key, so that the blind indexes reveal nothing of the key
+// the values are sealed with
+func derive(master []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// the version of the key new values are sealed with, 0 if there are no keys
+func (k *Keyring) Current() int {
+	if k == nil {
+		return 0
+	}
+	return k.current
+}
+
+// the versions of every configured key, in ascending order
+func (k *Keyring) Versions() []int {
+	if k == nil {
+		return nil
+	}
+
+	versions := make([]int, 0, len(k.keys))
+	for v := range k.keys {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+
+	return versions
+}
+
+// seals the value with the current key; empty values are left empty, as they reveal nothing, while without keys the
+// value is left as it is unless it would read as sealed, in which case it is escaped
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if k == nil {
+		if strings.HasPrefix(value, prefix) {
+			return escaped + value, nil
+		}
+		return value, nil
+	}
+
+	if value == "" {
+		return value, nil
+	}
+
+	aead := k.keys[k.current].aead
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), nil)
+
+	return prefix + strconv.Itoa(k.current) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// opens a sealed value with the key it was sealed with, returning an escaped value unescaped and any other value as it is
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if strings.HasPrefix(value, escaped) {
+		return value[len(escaped):], nil
+	}
+
+	version := Version(value)
+	if version == 0 {
+		return value, nil
+	}
+
+	if k == nil || k.keys[version] == nil {
+		return "", ErrMissingKey
+	}
+
+	_, encoded, _ := strings.Cut(value[len(prefix):], ":")
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	aead := k.keys[version].aead
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrMalformed
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	return string(plaintext), nil
+}
+
+// reports whether the value has to be sealed again to be under the current key, which is the case for values sealed
+// with an older key as well as for those stored before encryption was enabled
+func (k *Keyring) Stale(value string) bool {
+	if k == nil || value == "" {
+		return false
+	}
+	return Version(value) != k.current
+}
+
+// the version of the key the value was sealed with, 0 if it is not sealed
+func Version(value string) int {
+	if !strings.HasPrefix(value, prefix) {
+		return 0
+	}
+
+	version, _, ok := strings.Cut(value[len(prefix):], ":")
+	if !ok {
+		return 0
+	}
+
+	v, err := strconv.Atoi(version)
+	if err != nil || v < 1 {
+		return 0
+	}
+
+	return v
+}
+
+// returns the blind index of the value under the current key, empty if there are no keys
+func (k *Keyring) BlindIndex(value string) string {
+	if k == nil {
+		return ""
+	}
+	return blindIndex(k.keys[k.current].index, value)
+}
+
+// returns the blind indexes of the value under every key, any of which a record may still be indexed by while the
+// keys are being rotated
+func (k *Keyring) BlindIndexes(value string) []string {
+	var indexes []string
+	for _, v := range k.Versions() {
+		indexes = append(indexes, blindIndex(k.keys[v].index, value))
+	}
+	return indexes
+}
+
+func blindIndex(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,362 @@
+// Package migrations evolves the database schema through ordered, embedded SQL files.
+//
+// Each supported database has its own directory of migrations, kept in step so that a version means the same
+// schema for all of them. Every migration consists of a pair of files named <version>_<name>.up.sql and <version>_<name>.down.sql, the
+// latter reverting the former. Statements within a file are separated by a semicolon at the end of a line. The
+// versions applied to a database are recorded in its schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed mysql/*.sql sqlite/*.sql
+var files embed.FS
+
+var (
+	ErrUnknownVersion = errors.New("unknown migration version")
+	ErrDirtyHistory   = errors.New("applied migration missing from the embedded files")
+)
+
+var (
+	fileName           = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+	statementSeparator = regexp.MustCompile(`;\s*(\n|$)`)
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// a migration along with the time it was applied, nil if it is still pending
+type Status struct {
+	Migration
+	Applied *time.Time
+}
+
+type Migrator struct {
+	DB         *sql.DB
+	migrations []Migration
+}
+
+// returns a migrator for the database, loaded with the embedded migrations of its driver, "mysql" or "sqlite"
+func New(db *sql.DB, driver string) (*Migrator, error) {
+	if driver != "mysql" && driver != "sqlite" {
+		return nil, fmt.Errorf("migrations: unsupported driver %q", driver)
+	}
+
+	migrations, err := load(files, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, migrations: migrations}, nil
+}
+
+// reads the migrations from the directory, sorted by version, making sure every version has both of its files
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations: unexpected file %q", entry.Name())
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations: version %d is used by both %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migrations: version %d is missing its up or down file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// creates the version table, which requires privileges the application's own database user may lack
+func (m *Migrator) init() error {
+	stmt := "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied DATETIME NOT NULL)"
+	_, err := m.DB.Exec(stmt)
+	return err
+}
+
+// returns the applied versions, mapped to the time they were applied at
+func (m *Migrator) applied() (map[int]time.Time, error) {
+	rows, err := m.DB.Query("SELECT version, applied FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version int
+			at      time.Time
+		)
+
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for version := range applied {
+		if _, ok := m.find(version); !ok {
+			return nil, fmt.Errorf("%w: version %d", ErrDirtyHistory, version)
+		}
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// lists every known migration in order, along with when it was applied
+func (m *Migrator) Status() ([]Status, error) {
+	err := m.init()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, migration := range m.migrations {
+		s := Status{Migration: migration}
+		if at, ok := applied[migration.Version]; ok {
+			s.Applied = &at
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, nil
+}
+
+// returns the highest applied version, 0 for an empty database
+func (m *Migrator) Version() (int, error) {
+	err := m.init()
+	if err != nil {
+		return 0, err
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	for v := range applied {
+		version = max(version, v)
+	}
+
+	return version, nil
+}
+
+// returns the migrations which have not been applied yet, failing if the database has never been migrated
+func (m *Migrator) Pending() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// applies every pending migration, returning those applied
+func (m *Migrator) Up() ([]Migration, error) {
+	if len(m.migrations) == 0 {
+		return nil, nil
+	}
+	return m.To(m.migrations[len(m.migrations)-1].Version)
+}
+
+// reverts the most recently applied migration, returning nil if there was none
+func (m *Migrator) Down() (*Migration, error) {
+	version, err := m.Version()
+	if err != nil || version == 0 {
+		return nil, err
+	}
+
+	migration, _ := m.find(version)
+
+	err = m.revert(migration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &migration, nil
+}
+
+// migrates up or down until the given version is the latest applied one, 0 reverting every migration;
+// returns the migrations applied or reverted, in the order they were run
+func (m *Migrator) To(version int) ([]Migration, error) {
+	if _, ok := m.find(version); !ok && version != 0 {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	err := m.init()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var run []Migration
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok || migration.Version <= version {
+			continue
+		}
+
+		if err = m.revert(migration); err != nil {
+			return run, err
+		}
+		run = append(run, migration)
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok || migration.Version > version {
+			continue
+		}
+
+		if err = m.apply(migration); err != nil {
+			return run, err
+		}
+		run = append(run, migration)
+	}
+
+	return run, nil
+}
+
+// MySQL commits schema changes implicitly, so the version is recorded right after the statements succeed
+// rather than in the same transaction
+func (m *Migrator) apply(migration Migration) error {
+	err := m.exec(migration.up)
+	if err != nil {
+		return fmt.Errorf("migrations: applying %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	stmt := "INSERT INTO schema_migrations (version, name, applied) VALUES (?, ?, ?)"
+	_, err = m.DB.Exec(stmt, migration.Version, migration.Name, time.Now().UTC())
+	return err
+}
+
+func (m *Migrator) revert(migration Migration) error {
+	err := m.exec(migration.down)
+	if err != nil {
+		return fmt.Errorf("migrations: reverting %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	_, err = m.DB.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+	return err
+}
+
+// runs the statements of a migration file one by one, since drivers don't execute multiple statements by default;
+// they share a single connection, so that session settings such as SQLite's pragmas hold for the whole file
+func (m *Migrator) exec(script string) error {
+	ctx := context.Background()
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, stmt := range statementSeparator.Split(script, -1) {
+		if isBlank(stmt) {
+			continue
+		}
+
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			// discards the connection instead of returning it to the pool with an open transaction or altered settings
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reports whether the statement consists of nothing but whitespace and comments
+func isBlank(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
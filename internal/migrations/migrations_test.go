@@ -0,0 +1,368 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"p-system.okostadinov.net/internal/sqlite"
+)
+
+func versions(migrations []Migration) []int {
+	v := make([]int, len(migrations))
+	for i, m := range migrations {
+		v[i] = m.Version
+	}
+	return v
+}
+
+// returns a migrator of the migrations, given by their file names within the sqlite directory, for an empty database
+func newMigrator(t *testing.T, files map[string]string) (*Migrator, *sql.DB) {
+	t.Helper()
+
+	fsys := fstest.MapFS{}
+	for name, content := range files {
+		fsys["sqlite/"+name] = &fstest.MapFile{Data: []byte(content)}
+	}
+
+	migrations, err := load(fsys, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &Migrator{DB: db, migrations: migrations}, db
+}
+
+func assertVersion(t *testing.T, m *Migrator, want int) {
+	t.Helper()
+
+	version, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != want {
+		t.Errorf("got version %d, want %d", version, want)
+	}
+}
+
+func assertRun(t *testing.T, what string, run []Migration, err error, want []int) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if got := versions(run); !slices.Equal(got, want) {
+		t.Errorf("%s ran %v, want %v", what, got, want)
+	}
+}
+
+func assertTable(t *testing.T, db *sql.DB, table string, exists bool) {
+	t.Helper()
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (count == 1) != exists {
+		t.Errorf("table %s exists: %t, want %t", table, count == 1, exists)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sqlite/0010_third.up.sql":    {Data: []byte("CREATE TABLE c (id INTEGER);")},
+		"sqlite/0010_third.down.sql":  {Data: []byte("DROP TABLE c;")},
+		"sqlite/0002_second.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER);")},
+		"sqlite/0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"sqlite/0001_first.up.sql":    {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"sqlite/0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+	}
+
+	migrations, err := load(fsys, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := versions(migrations); !slices.Equal(got, []int{1, 2, 10}) {
+		t.Errorf("got versions %v", got)
+	}
+	if migrations[2].Name != "third" || migrations[2].up != "CREATE TABLE c (id INTEGER);" || migrations[2].down != "DROP TABLE c;" {
+		t.Errorf("got %+v", migrations[2])
+	}
+
+	tests := []struct {
+		name  string
+		files []string
+	}{
+		{"unexpected file", []string{"0001_first.up.sql", "0001_first.down.sql", "README.md"}},
+		{"missing down file", []string{"0001_first.up.sql"}},
+		{"missing up file", []string{"0001_first.down.sql"}},
+		{"version used twice", []string{"0001_first.up.sql", "0001_other.down.sql"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, name := range tt.files {
+				fsys["sqlite/"+name] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+			}
+
+			if _, err := load(fsys, "sqlite"); err == nil {
+				t.Error("loaded")
+			}
+		})
+	}
+}
+
+// both drivers have the same versions, numbered without gaps, so that a version means the same schema for both
+func TestEmbeddedMigrations(t *testing.T) {
+	mysqlMigrations, err := load(files, "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqliteMigrations, err := load(files, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mysqlMigrations) != len(sqliteMigrations) {
+		t.Fatalf("got %d MySQL and %d SQLite migrations", len(mysqlMigrations), len(sqliteMigrations))
+	}
+
+	for i := range mysqlMigrations {
+		if mysqlMigrations[i].Version != i+1 || mysqlMigrations[i].Version != sqliteMigrations[i].Version || mysqlMigrations[i].Name != sqliteMigrations[i].Name {
+			t.Errorf("migration %d is %d_%s on MySQL and %d_%s on SQLite", i+1, mysqlMigrations[i].Version, mysqlMigrations[i].Name, sqliteMigrations[i].Version, sqliteMigrations[i].Name)
+		}
+	}
+
+	if _, err = New(nil, "postgres"); err == nil {
+		t.Error("accepted an unsupported driver")
+	}
+}
+
+func TestIsBlank(t *testing.T) {
+	for stmt, want := range map[string]bool{
+		"":                             true,
+		"  \n\t":                       true,
+		"-- a comment\n  -- another\n": true,
+		"-- a comment\nSELECT 1":       false,
+		"SELECT 1 -- trailing comment": false,
+	} {
+		if got := isBlank(stmt); got != want {
+			t.Errorf("%q: got %t, want %t", stmt, got, want)
+		}
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	m, db := newMigrator(t, map[string]string{
+		"0001_create_a.up.sql":   "CREATE TABLE a (id INTEGER);",
+		"0001_create_a.down.sql": "DROP TABLE a;",
+		"0002_create_b.up.sql":   "-- the second table\nCREATE TABLE b (id INTEGER);",
+		"0002_create_b.down.sql": "DROP TABLE b;",
+		"0003_fill_a.up.sql":     "INSERT INTO a VALUES (1);\nINSERT INTO a VALUES (2);\n",
+		"0003_fill_a.down.sql":   "-- nothing to revert but the rows\nDELETE FROM a;",
+	})
+
+	if _, err := m.Pending(); err == nil {
+		t.Error("listed the pending migrations of a database never migrated")
+	}
+
+	assertVersion(t, m, 0)
+
+	run, err := m.Up()
+	assertRun(t, "migrating up", run, err, []int{1, 2, 3})
+	assertVersion(t, m, 3)
+
+	var rows int
+	if err = db.QueryRow("SELECT COUNT(*) FROM a").Scan(&rows); err != nil || rows != 2 {
+		t.Errorf("got %d rows, %v", rows, err)
+	}
+
+	run, err = m.Up()
+	assertRun(t, "migrating up again", run, err, nil)
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.Applied == nil || time.Since(*s.Applied) > time.Minute {
+			t.Errorf("%d_%s applied at %v", s.Version, s.Name, s.Applied)
+		}
+	}
+
+	reverted, err := m.Down()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted == nil || reverted.Version != 3 {
+		t.Fatalf("reverted %v", reverted)
+	}
+	assertVersion(t, m, 2)
+
+	pending, err := m.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(pending); !slices.Equal(got, []int{3}) {
+		t.Errorf("got pending %v", got)
+	}
+
+	statuses, err = m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 || statuses[1].Applied == nil || statuses[2].Applied != nil {
+		t.Errorf("got %d statuses", len(statuses))
+	}
+
+	run, err = m.To(1)
+	assertRun(t, "migrating down to 1", run, err, []int{2})
+	assertTable(t, db, "b", false)
+
+	run, err = m.To(3)
+	assertRun(t, "migrating up to 3", run, err, []int{2, 3})
+	assertTable(t, db, "b", true)
+
+	_, err = m.To(4)
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("migrating to an unknown version: got %v, want %v", err, ErrUnknownVersion)
+	}
+
+	run, err = m.To(0)
+	assertRun(t, "migrating down to 0", run, err, []int{3, 2, 1})
+	assertVersion(t, m, 0)
+	assertTable(t, db, "a", false)
+
+	reverted, err = m.Down()
+	if err != nil || reverted != nil {
+		t.Errorf("reverting an empty database: got %v, %v", reverted, err)
+	}
+}
+
+// a database recording a version the embedded files no longer have is refused rather than migrated past it
+func TestMigratorDirtyHistory(t *testing.T) {
+	m, db := newMigrator(t, map[string]string{
+		"0001_create_a.up.sql":   "CREATE TABLE a (id INTEGER);",
+		"0001_create_a.down.sql": "DROP TABLE a;",
+	})
+
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := db.Exec("INSERT INTO schema_migrations (version, name, applied) VALUES (2, 'removed', ?)", time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Version()
+	assertDirty(t, "getting the version", err)
+
+	_, err = m.Status()
+	assertDirty(t, "getting the status", err)
+
+	_, err = m.Pending()
+	assertDirty(t, "listing the pending migrations", err)
+
+	_, err = m.Up()
+	assertDirty(t, "migrating up", err)
+
+	_, err = m.Down()
+	assertDirty(t, "migrating down", err)
+
+	_, err = m.To(0)
+	assertDirty(t, "migrating to 0", err)
+
+	assertTable(t, db, "a", true)
+}
+
+func assertDirty(t *testing.T, what string, err error) {
+	t.Helper()
+
+	if !errors.Is(err, ErrDirtyHistory) {
+		t.Errorf("%s: got %v, want %v", what, err, ErrDirtyHistory)
+	}
+}
+
+// a migration failing part way leaves the database at the last version which succeeded, from where migrating goes on
+// once the migration is fixed
+func TestMigratorFailingMigration(t *testing.T) {
+	m, db := newMigrator(t, map[string]string{
+		"0001_create_a.up.sql":   "CREATE TABLE a (id INTEGER);",
+		"0001_create_a.down.sql": "DROP TABLE a;",
+		"0002_broken.up.sql":     "CREATE TABLE b (id INTEGER);\nINSERT INTO missing VALUES (1);",
+		"0002_broken.down.sql":   "DROP TABLE b;",
+		"0003_atomic.up.sql":     "BEGIN;\nCREATE TABLE c (id INTEGER);\nINSERT INTO missing VALUES (1);\nCOMMIT;",
+		"0003_atomic.down.sql":   "DROP TABLE c;",
+	})
+
+	run, err := m.Up()
+	if err == nil || !strings.Contains(err.Error(), "applying 2_broken") {
+		t.Fatalf("got %v", err)
+	}
+	if got := versions(run); !slices.Equal(got, []int{1}) {
+		t.Errorf("ran %v", got)
+	}
+	assertVersion(t, m, 1)
+
+	pending, err := m.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(pending); !slices.Equal(got, []int{2, 3}) {
+		t.Errorf("got pending %v", got)
+	}
+
+	// the statements before the failing one are kept, so fixed migrations have to tolerate them
+	m.migrations[1].up = "CREATE TABLE IF NOT EXISTS b (id INTEGER);\nINSERT INTO b VALUES (1);"
+
+	run, err = m.To(2)
+	assertRun(t, "migrating the fixed migration", run, err, []int{2})
+
+	t.Run("within a transaction", func(t *testing.T) {
+		_, err := m.Up()
+		if err == nil {
+			t.Fatal("applied the failing migration")
+		}
+
+		// the connection left in the transaction is discarded, rolling the transaction back
+		assertTable(t, db, "c", false)
+		assertVersion(t, m, 2)
+
+		for i := 0; i < 3; i++ {
+			if _, err := db.Exec("INSERT INTO a VALUES (?)", i); err != nil {
+				t.Fatalf("the pool was left unusable: %v", err)
+			}
+		}
+
+		m.migrations[2].up = "BEGIN;\nCREATE TABLE c (id INTEGER);\nCOMMIT;"
+
+		run, err := m.Up()
+		assertRun(t, "migrating the fixed migration", run, err, []int{3})
+	})
+
+	t.Run("reverting", func(t *testing.T) {
+		m.migrations[2].down = "DROP TABLE missing;"
+
+		if _, err := m.Down(); err == nil || !strings.Contains(err.Error(), "reverting 3_atomic") {
+			t.Fatalf("got %v", err)
+		}
+		assertVersion(t, m, 3)
+		assertTable(t, db, "c", true)
+	})
+}
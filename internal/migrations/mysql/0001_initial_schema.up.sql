@@ -0,0 +1,107 @@
+CREATE TABLE users (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    hashed_password CHAR(60) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'doctor',
+    created DATETIME NOT NULL
+);
+
+CREATE TABLE medications (
+    name VARCHAR(30) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    deleted_at DATETIME,
+    deleted_by INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (deleted_by) REFERENCES users(id)
+);
+
+CREATE TABLE patients (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    ucn VARCHAR(10) NOT NULL,
+    first_name VARCHAR(20) NOT NULL,
+    last_name VARCHAR(20) NOT NULL,
+    phone_number VARCHAR(20) NOT NULL,
+    height VARCHAR(3) NOT NULL,
+    weight VARCHAR(3) NOT NULL,
+    medication VARCHAR(30) NOT NULL,
+    note TEXT NOT NULL,
+    approved BOOLEAN NOT NULL DEFAULT 0,
+    first_continuation BOOLEAN NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL,
+    deleted_at DATETIME,
+    deleted_by INTEGER,
+    FOREIGN KEY (medication) REFERENCES medications(name),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (deleted_by) REFERENCES users(id),
+    INDEX patients_idx_ucn (ucn),
+    FULLTEXT INDEX patients_ft_search (first_name, last_name, phone_number, medication, note)
+);
+
+CREATE TABLE patient_co_owners (
+    patient_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (patient_id, user_id),
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE patient_transfers (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    patient_id INTEGER NOT NULL,
+    from_user_id INTEGER NOT NULL,
+    to_user_id INTEGER NOT NULL,
+    requested_by INTEGER NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    created DATETIME NOT NULL,
+    resolved DATETIME,
+    resolved_by INTEGER,
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (from_user_id) REFERENCES users(id),
+    FOREIGN KEY (to_user_id) REFERENCES users(id),
+    FOREIGN KEY (requested_by) REFERENCES users(id),
+    FOREIGN KEY (resolved_by) REFERENCES users(id),
+    INDEX patient_transfers_idx_status (status, to_user_id)
+);
+
+CREATE TABLE audit_log (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER,
+    action VARCHAR(10) NOT NULL,
+    entity VARCHAR(30) NOT NULL,
+    entity_id VARCHAR(30) NOT NULL,
+    before_values JSON,
+    after_values JSON,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    INDEX audit_log_idx_entity (entity, entity_id)
+);
+
+CREATE TABLE tokens (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    hash CHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE sessions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    session_data LONGBLOB,
+    created_on TIMESTAMP DEFAULT NOW(),
+    modified_on TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_on TIMESTAMP DEFAULT NOW()
+);
+
+ALTER TABLE
+    users
+ADD
+    CONSTRAINT users_uc_email UNIQUE (email);
+
+ALTER TABLE
+    tokens
+ADD
+    CONSTRAINT tokens_uc_hash UNIQUE (hash);
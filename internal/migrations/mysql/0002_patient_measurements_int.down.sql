@@ -0,0 +1,6 @@
+ALTER TABLE
+    patients
+MODIFY
+    height VARCHAR(3) NOT NULL,
+MODIFY
+    weight VARCHAR(3) NOT NULL;
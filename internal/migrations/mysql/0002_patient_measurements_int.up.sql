@@ -0,0 +1,6 @@
+ALTER TABLE
+    patients
+MODIFY
+    height SMALLINT UNSIGNED NOT NULL,
+MODIFY
+    weight SMALLINT UNSIGNED NOT NULL;
@@ -0,0 +1,50 @@
+-- fails if several products share a name, since medications are keyed by their name again
+ALTER TABLE patients DROP FOREIGN KEY patients_fk_medication, DROP INDEX patients_ft_search;
+
+ALTER TABLE
+    patients
+ADD
+    COLUMN medication VARCHAR(30) AFTER weight;
+
+UPDATE patients SET medication = (SELECT name FROM medications WHERE medications.id = patients.medication_id);
+
+UPDATE
+    audit_log
+SET
+    entity_id = (SELECT name FROM medications WHERE CAST(medications.id AS CHAR) = audit_log.entity_id)
+WHERE
+    entity = 'medication'
+    AND entity_id IN (SELECT CAST(id AS CHAR) FROM medications);
+
+ALTER TABLE
+    patients
+MODIFY
+    medication VARCHAR(30) NOT NULL,
+DROP COLUMN medication_id;
+
+ALTER TABLE medications DROP INDEX medications_ft_search;
+
+ALTER TABLE
+    medications
+DROP INDEX medications_uc_product,
+DROP COLUMN id,
+DROP COLUMN active_ingredient,
+DROP COLUMN strength,
+DROP COLUMN unit,
+DROP COLUMN dosage_form,
+DROP COLUMN atc_code,
+DROP COLUMN manufacturer,
+MODIFY
+    name VARCHAR(30) NOT NULL,
+ADD
+    PRIMARY KEY (name);
+
+ALTER TABLE
+    patients
+ADD
+    CONSTRAINT patients_ibfk_1 FOREIGN KEY (medication) REFERENCES medications(name);
+
+ALTER TABLE
+    patients
+ADD
+    FULLTEXT INDEX patients_ft_search (first_name, last_name, phone_number, medication, note);
@@ -0,0 +1,58 @@
+-- the initial schema left the foreign key on patients.medication unnamed, so InnoDB generated this name for it
+ALTER TABLE patients DROP FOREIGN KEY patients_ibfk_1, DROP INDEX patients_ft_search;
+
+-- the existing medications keep their name, the catalog details being filled in afterwards
+ALTER TABLE
+    medications
+DROP PRIMARY KEY,
+ADD
+    COLUMN id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT FIRST,
+MODIFY
+    name VARCHAR(100) NOT NULL,
+ADD
+    COLUMN active_ingredient VARCHAR(100) NOT NULL DEFAULT '' AFTER name,
+ADD
+    COLUMN strength VARCHAR(20) NOT NULL DEFAULT '' AFTER active_ingredient,
+ADD
+    COLUMN unit VARCHAR(10) NOT NULL DEFAULT '' AFTER strength,
+ADD
+    COLUMN dosage_form VARCHAR(30) NOT NULL DEFAULT '' AFTER unit,
+ADD
+    COLUMN atc_code VARCHAR(7) NOT NULL DEFAULT '' AFTER dosage_form,
+ADD
+    COLUMN manufacturer VARCHAR(100) NOT NULL DEFAULT '' AFTER atc_code,
+ADD
+    CONSTRAINT medications_uc_product UNIQUE (name, strength, unit, dosage_form);
+
+ALTER TABLE
+    medications
+ADD
+    FULLTEXT INDEX medications_ft_search (name, active_ingredient);
+
+ALTER TABLE
+    patients
+ADD
+    COLUMN medication_id INTEGER AFTER weight;
+
+UPDATE patients SET medication_id = (SELECT id FROM medications WHERE medications.name = patients.medication);
+
+UPDATE
+    audit_log
+SET
+    entity_id = (SELECT CAST(id AS CHAR) FROM medications WHERE medications.name = audit_log.entity_id)
+WHERE
+    entity = 'medication'
+    AND entity_id IN (SELECT name FROM medications);
+
+ALTER TABLE
+    patients
+MODIFY
+    medication_id INTEGER NOT NULL,
+DROP COLUMN medication,
+ADD
+    CONSTRAINT patients_fk_medication FOREIGN KEY (medication_id) REFERENCES medications(id);
+
+ALTER TABLE
+    patients
+ADD
+    FULLTEXT INDEX patients_ft_search (first_name, last_name, phone_number, note);
@@ -0,0 +1,32 @@
+-- every patient is put back on the medication of their latest prescription; fails for patients who have none
+ALTER TABLE
+    patients
+ADD
+    COLUMN medication_id INTEGER AFTER weight;
+
+UPDATE
+    patients
+SET
+    medication_id = (
+        SELECT
+            medication_id
+        FROM
+            prescriptions
+        WHERE
+            prescriptions.patient_id = patients.id
+        ORDER BY
+            status = 'active' DESC,
+            start_date DESC,
+            id DESC
+        LIMIT
+            1
+    );
+
+ALTER TABLE
+    patients
+MODIFY
+    medication_id INTEGER NOT NULL,
+ADD
+    CONSTRAINT patients_fk_medication FOREIGN KEY (medication_id) REFERENCES medications(id);
+
+DROP TABLE prescriptions;
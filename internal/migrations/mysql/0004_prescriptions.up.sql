@@ -0,0 +1,35 @@
+CREATE TABLE prescriptions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    patient_id INTEGER NOT NULL,
+    medication_id INTEGER NOT NULL,
+    dose VARCHAR(50) NOT NULL,
+    frequency VARCHAR(50) NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE,
+    prescribed_by INTEGER NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'active',
+    created DATETIME NOT NULL,
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (medication_id) REFERENCES medications(id),
+    FOREIGN KEY (prescribed_by) REFERENCES users(id)
+);
+
+CREATE INDEX prescriptions_idx_medication ON prescriptions (medication_id, status);
+
+-- every patient keeps their medication as a prescription by their owner, the dosage being filled in afterwards;
+-- its start date is the day of the migration, as the original one was never recorded
+INSERT INTO
+    prescriptions (patient_id, medication_id, dose, frequency, start_date, prescribed_by, status, created)
+SELECT
+    id,
+    medication_id,
+    '',
+    '',
+    UTC_DATE(),
+    user_id,
+    'active',
+    UTC_TIMESTAMP()
+FROM
+    patients;
+
+ALTER TABLE patients DROP FOREIGN KEY patients_fk_medication, DROP COLUMN medication_id;
@@ -0,0 +1,18 @@
+DROP TABLE therapy_transitions;
+
+ALTER TABLE
+    patients
+ADD
+    COLUMN approved BOOLEAN NOT NULL DEFAULT 0 AFTER note,
+ADD
+    COLUMN first_continuation BOOLEAN NOT NULL DEFAULT 0 AFTER approved;
+
+UPDATE
+    patients
+SET
+    approved = therapy_status IN ('approved', 'first_continuation', 'continued'),
+    first_continuation = therapy_status IN ('first_continuation', 'continued');
+
+ALTER TABLE patients DROP INDEX patients_idx_therapy_status;
+
+ALTER TABLE patients DROP COLUMN therapy_status, DROP COLUMN therapy_changed;
@@ -0,0 +1,5 @@
+ALTER TABLE therapy_transitions DROP COLUMN expires;
+
+ALTER TABLE patients DROP INDEX patients_idx_therapy_expires;
+
+ALTER TABLE patients DROP COLUMN therapy_expires, DROP COLUMN therapy_reminder;
@@ -0,0 +1,21 @@
+ALTER TABLE
+    patients
+ADD
+    COLUMN therapy_expires DATE AFTER therapy_changed,
+ADD
+    -- the last reminder stage the owners were notified of, either upcoming or overdue, reset on every transition
+    COLUMN therapy_reminder VARCHAR(10) NOT NULL DEFAULT '' AFTER therapy_expires;
+
+-- the therapies granted before expiry dates were tracked are taken to expire a year after they were last changed;
+-- as that was not tracked either for those migrated from the former flags, they expire on the day of the upgrade
+-- instead, so that their owners are reminded to review and continue them
+UPDATE
+    patients
+SET
+    therapy_expires = COALESCE(DATE(therapy_changed) + INTERVAL 1 YEAR, UTC_DATE())
+WHERE
+    therapy_status IN ('approved', 'first_continuation', 'continued');
+
+CREATE INDEX patients_idx_therapy_expires ON patients (therapy_expires);
+
+ALTER TABLE therapy_transitions ADD COLUMN expires DATE AFTER comment;
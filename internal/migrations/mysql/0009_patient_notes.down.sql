@@ -0,0 +1,33 @@
+ALTER TABLE patients DROP INDEX patients_ft_search;
+
+ALTER TABLE
+    patients
+ADD
+    COLUMN note TEXT NOT NULL AFTER weight;
+
+-- only the most recent note of every patient survives
+UPDATE
+    patients
+SET
+    note = COALESCE(
+        (
+            SELECT
+                n.body
+            FROM
+                patient_notes n
+            WHERE
+                n.patient_id = patients.id
+            ORDER BY
+                n.created DESC,
+                n.id DESC
+            LIMIT
+                1
+        ), ''
+    );
+
+ALTER TABLE
+    patients
+ADD
+    FULLTEXT INDEX patients_ft_search (first_name, last_name, phone_number, note);
+
+DROP TABLE patient_notes;
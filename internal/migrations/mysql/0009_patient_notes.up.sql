@@ -0,0 +1,35 @@
+CREATE TABLE patient_notes (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    patient_id INTEGER NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    body TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    updated DATETIME,
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FULLTEXT INDEX patient_notes_ft_search (body)
+);
+
+CREATE INDEX patient_notes_idx_patient ON patient_notes (patient_id, created);
+
+-- every patient's note starts its timeline as a general note by its owner, written on the day of the migration
+INSERT INTO
+    patient_notes (patient_id, type, body, user_id, created)
+SELECT
+    id,
+    'general',
+    note,
+    user_id,
+    UTC_TIMESTAMP()
+FROM
+    patients
+WHERE
+    TRIM(note) <> '';
+
+ALTER TABLE patients DROP INDEX patients_ft_search, DROP COLUMN note;
+
+ALTER TABLE
+    patients
+ADD
+    FULLTEXT INDEX patients_ft_search (first_name, last_name, phone_number);
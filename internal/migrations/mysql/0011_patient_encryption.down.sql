@@ -0,0 +1,15 @@
+-- fails on sealed values which no longer fit, which have to be decrypted first
+ALTER TABLE
+    patients
+DROP
+    INDEX patients_idx_ucn_index,
+DROP
+    COLUMN ucn_index,
+MODIFY
+    ucn VARCHAR(10) NOT NULL,
+MODIFY
+    first_name VARCHAR(20) NOT NULL,
+MODIFY
+    last_name VARCHAR(20) NOT NULL,
+MODIFY
+    phone_number VARCHAR(20) NOT NULL;
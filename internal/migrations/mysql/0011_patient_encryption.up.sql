@@ -0,0 +1,15 @@
+-- sealed values take considerably more room than the plaintext they replace
+ALTER TABLE
+    patients
+MODIFY
+    ucn VARCHAR(255) NOT NULL,
+MODIFY
+    first_name VARCHAR(255) NOT NULL,
+MODIFY
+    last_name VARCHAR(255) NOT NULL,
+MODIFY
+    phone_number VARCHAR(255) NOT NULL,
+ADD
+    ucn_index CHAR(64) NOT NULL DEFAULT '',
+ADD
+    INDEX patients_idx_ucn_index (ucn_index);
@@ -0,0 +1 @@
+ALTER TABLE patients DROP COLUMN merged_into;
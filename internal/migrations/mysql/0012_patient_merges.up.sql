@@ -0,0 +1,5 @@
+ALTER TABLE
+    patients
+ADD
+    -- the patient a duplicate was merged into, which took over all of its records
+    COLUMN merged_into INTEGER AFTER deleted_by;
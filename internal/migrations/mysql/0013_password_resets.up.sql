@@ -0,0 +1,10 @@
+-- single-use tokens mailed to users who forgot their password, only the hash of which is stored
+CREATE TABLE password_resets (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    hash CHAR(64) NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT password_resets_uc_hash UNIQUE (hash)
+);
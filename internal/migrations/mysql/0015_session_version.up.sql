@@ -0,0 +1,5 @@
+ALTER TABLE
+    users
+ADD
+    -- incremented whenever the password changes, ending the sessions logged in with an older version
+    COLUMN session_version INTEGER NOT NULL DEFAULT 0 AFTER hashed_password;
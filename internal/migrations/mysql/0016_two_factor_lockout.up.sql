@@ -0,0 +1,8 @@
+ALTER TABLE
+    users
+ADD
+    -- the codes entered wrong in a row, counted until one is accepted
+    COLUMN totp_failures INTEGER NOT NULL DEFAULT 0 AFTER totp_last_step,
+ADD
+    -- set once too many codes were entered wrong, no code being accepted until it passes
+    COLUMN totp_locked_until DATETIME AFTER totp_failures;
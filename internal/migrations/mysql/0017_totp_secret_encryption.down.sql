@@ -0,0 +1,5 @@
+-- fails on sealed secrets which no longer fit, which have to be decrypted first
+ALTER TABLE
+    users
+MODIFY
+    totp_secret VARCHAR(64);
@@ -0,0 +1,5 @@
+-- the secret is sealed once encryption keys are configured, taking considerably more room
+ALTER TABLE
+    users
+MODIFY
+    totp_secret VARCHAR(255);
@@ -0,0 +1 @@
+-- the emails keep being compared case-insensitively by the default collation of MySQL
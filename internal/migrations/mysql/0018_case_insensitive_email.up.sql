@@ -0,0 +1 @@
+-- MySQL already compares the emails case-insensitively by the default collation, which SQLite is brought in line with
@@ -0,0 +1,9 @@
+-- the databases which went past migration 7 before it filled in the expiry of the therapies granted until then get it
+-- filled in likewise
+UPDATE
+    patients
+SET
+    therapy_expires = COALESCE(DATE(therapy_changed) + INTERVAL 1 YEAR, UTC_DATE())
+WHERE
+    therapy_status IN ('approved', 'first_continuation', 'continued')
+    AND therapy_expires IS NULL;
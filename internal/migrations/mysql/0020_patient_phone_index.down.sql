@@ -0,0 +1,6 @@
+ALTER TABLE
+    patients
+DROP
+    INDEX patients_idx_phone_index,
+DROP
+    COLUMN phone_index;
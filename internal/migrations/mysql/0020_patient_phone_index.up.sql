@@ -0,0 +1,15 @@
+-- the blind index of the digits of the phone number, or the digits themselves where encryption is not enabled, which
+-- narrows down the probable duplicates of a patient; sealed phone numbers are indexed by the key rotation
+ALTER TABLE
+    patients
+ADD
+    phone_index CHAR(64) NOT NULL DEFAULT '',
+ADD
+    INDEX patients_idx_phone_index (phone_index);
+
+UPDATE
+    patients
+SET
+    phone_index = REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(phone_number, ' ', ''), '-', ''), '+', ''), '(', ''), ')', ''), '/', ''), '.', '')
+WHERE
+    phone_number NOT LIKE 'enc:%';
@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS sessions;
+
+DROP TABLE IF EXISTS tokens;
+
+DROP TABLE IF EXISTS audit_log;
+
+DROP TABLE IF EXISTS patient_transfers;
+
+DROP TABLE IF EXISTS patient_co_owners;
+
+DROP TABLE IF EXISTS patients;
+
+DROP TABLE IF EXISTS medications;
+
+DROP TABLE IF EXISTS users;
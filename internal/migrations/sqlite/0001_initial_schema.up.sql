@@ -0,0 +1,101 @@
+CREATE TABLE users (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    hashed_password CHAR(60) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'doctor',
+    created DATETIME NOT NULL,
+    CONSTRAINT users_uc_email UNIQUE (email)
+);
+
+CREATE TABLE medications (
+    name VARCHAR(30) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    deleted_at DATETIME,
+    deleted_by INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (deleted_by) REFERENCES users(id)
+);
+
+CREATE TABLE patients (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    ucn VARCHAR(10) NOT NULL,
+    first_name VARCHAR(20) NOT NULL,
+    last_name VARCHAR(20) NOT NULL,
+    phone_number VARCHAR(20) NOT NULL,
+    height INTEGER NOT NULL,
+    weight INTEGER NOT NULL,
+    medication VARCHAR(30) NOT NULL,
+    note TEXT NOT NULL,
+    approved BOOLEAN NOT NULL DEFAULT 0,
+    first_continuation BOOLEAN NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL,
+    deleted_at DATETIME,
+    deleted_by INTEGER,
+    FOREIGN KEY (medication) REFERENCES medications(name),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (deleted_by) REFERENCES users(id)
+);
+
+CREATE INDEX patients_idx_ucn ON patients (ucn);
+
+CREATE TABLE patient_co_owners (
+    patient_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (patient_id, user_id),
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE patient_transfers (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    patient_id INTEGER NOT NULL,
+    from_user_id INTEGER NOT NULL,
+    to_user_id INTEGER NOT NULL,
+    requested_by INTEGER NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    created DATETIME NOT NULL,
+    resolved DATETIME,
+    resolved_by INTEGER,
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (from_user_id) REFERENCES users(id),
+    FOREIGN KEY (to_user_id) REFERENCES users(id),
+    FOREIGN KEY (requested_by) REFERENCES users(id),
+    FOREIGN KEY (resolved_by) REFERENCES users(id)
+);
+
+CREATE INDEX patient_transfers_idx_status ON patient_transfers (status, to_user_id);
+
+CREATE TABLE audit_log (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    action VARCHAR(10) NOT NULL,
+    entity VARCHAR(30) NOT NULL,
+    entity_id VARCHAR(30) NOT NULL,
+    before_values TEXT,
+    after_values TEXT,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX audit_log_idx_entity ON audit_log (entity, entity_id);
+
+CREATE TABLE tokens (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    hash CHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT tokens_uc_hash UNIQUE (hash)
+);
+
+CREATE TABLE sessions (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    session_data BLOB,
+    created_on DATETIME,
+    modified_on DATETIME,
+    expires_on DATETIME
+);
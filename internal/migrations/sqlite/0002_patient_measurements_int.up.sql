@@ -0,0 +1 @@
+-- height and weight have been created as integers by the initial schema already
@@ -0,0 +1,87 @@
+-- rebuilds both tables like the up migration; fails if several products share a name, since medications are
+-- keyed by their name again
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE medications_old (
+    name VARCHAR(30) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    deleted_at DATETIME,
+    deleted_by INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (deleted_by) REFERENCES users(id)
+);
+
+INSERT INTO
+    medications_old
+SELECT
+    name,
+    user_id,
+    deleted_at,
+    deleted_by
+FROM
+    medications;
+
+CREATE TABLE patients_old (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    ucn VARCHAR(10) NOT NULL,
+    first_name VARCHAR(20) NOT NULL,
+    last_name VARCHAR(20) NOT NULL,
+    phone_number VARCHAR(20) NOT NULL,
+    height INTEGER NOT NULL,
+    weight INTEGER NOT NULL,
+    medication VARCHAR(30) NOT NULL,
+    note TEXT NOT NULL,
+    approved BOOLEAN NOT NULL DEFAULT 0,
+    first_continuation BOOLEAN NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL,
+    deleted_at DATETIME,
+    deleted_by INTEGER,
+    FOREIGN KEY (medication) REFERENCES medications(name),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (deleted_by) REFERENCES users(id)
+);
+
+INSERT INTO
+    patients_old
+SELECT
+    p.id,
+    p.ucn,
+    p.first_name,
+    p.last_name,
+    p.phone_number,
+    p.height,
+    p.weight,
+    m.name,
+    p.note,
+    p.approved,
+    p.first_continuation,
+    p.user_id,
+    p.deleted_at,
+    p.deleted_by
+FROM
+    patients p
+    JOIN medications m ON m.id = p.medication_id;
+
+UPDATE
+    audit_log
+SET
+    entity_id = (SELECT name FROM medications WHERE CAST(medications.id AS TEXT) = audit_log.entity_id)
+WHERE
+    entity = 'medication'
+    AND entity_id IN (SELECT CAST(id AS TEXT) FROM medications);
+
+DROP TABLE patients;
+
+DROP TABLE medications;
+
+ALTER TABLE medications_old RENAME TO medications;
+
+ALTER TABLE patients_old RENAME TO patients;
+
+CREATE INDEX patients_idx_ucn ON patients (ucn);
+
+COMMIT;
+
+PRAGMA foreign_keys = ON;
@@ -0,0 +1,100 @@
+-- SQLite can neither change a primary key nor drop a column under a foreign key, so both tables are rebuilt
+-- as described in https://www.sqlite.org/lang_altertable.html#otheralter, with the foreign keys turned off
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE medications_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL,
+    active_ingredient VARCHAR(100) NOT NULL DEFAULT '',
+    strength VARCHAR(20) NOT NULL DEFAULT '',
+    unit VARCHAR(10) NOT NULL DEFAULT '',
+    dosage_form VARCHAR(30) NOT NULL DEFAULT '',
+    atc_code VARCHAR(7) NOT NULL DEFAULT '',
+    manufacturer VARCHAR(100) NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL,
+    deleted_at DATETIME,
+    deleted_by INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (deleted_by) REFERENCES users(id),
+    CONSTRAINT medications_uc_product UNIQUE (name, strength, unit, dosage_form)
+);
+
+-- the existing medications keep their name, the catalog details being filled in afterwards
+INSERT INTO
+    medications_new (name, user_id, deleted_at, deleted_by)
+SELECT
+    name,
+    user_id,
+    deleted_at,
+    deleted_by
+FROM
+    medications
+ORDER BY
+    name;
+
+CREATE TABLE patients_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    ucn VARCHAR(10) NOT NULL,
+    first_name VARCHAR(20) NOT NULL,
+    last_name VARCHAR(20) NOT NULL,
+    phone_number VARCHAR(20) NOT NULL,
+    height INTEGER NOT NULL,
+    weight INTEGER NOT NULL,
+    medication_id INTEGER NOT NULL,
+    note TEXT NOT NULL,
+    approved BOOLEAN NOT NULL DEFAULT 0,
+    first_continuation BOOLEAN NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL,
+    deleted_at DATETIME,
+    deleted_by INTEGER,
+    FOREIGN KEY (medication_id) REFERENCES medications(id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (deleted_by) REFERENCES users(id)
+);
+
+INSERT INTO
+    patients_new
+SELECT
+    p.id,
+    p.ucn,
+    p.first_name,
+    p.last_name,
+    p.phone_number,
+    p.height,
+    p.weight,
+    m.id,
+    p.note,
+    p.approved,
+    p.first_continuation,
+    p.user_id,
+    p.deleted_at,
+    p.deleted_by
+FROM
+    patients p
+    JOIN medications_new m ON m.name = p.medication;
+
+UPDATE
+    audit_log
+SET
+    entity_id = (SELECT CAST(id AS TEXT) FROM medications_new WHERE medications_new.name = audit_log.entity_id)
+WHERE
+    entity = 'medication'
+    AND entity_id IN (SELECT name FROM medications_new);
+
+DROP TABLE patients;
+
+DROP TABLE medications;
+
+ALTER TABLE medications_new RENAME TO medications;
+
+ALTER TABLE patients_new RENAME TO patients;
+
+CREATE INDEX patients_idx_ucn ON patients (ucn);
+
+CREATE INDEX patients_idx_medication ON patients (medication_id);
+
+COMMIT;
+
+PRAGMA foreign_keys = ON;
@@ -0,0 +1,72 @@
+-- rebuilds patients like the up migration, putting every patient back on the medication of their latest
+-- prescription; fails for patients who have none
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE patients_old (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    ucn VARCHAR(10) NOT NULL,
+    first_name VARCHAR(20) NOT NULL,
+    last_name VARCHAR(20) NOT NULL,
+    phone_number VARCHAR(20) NOT NULL,
+    height INTEGER NOT NULL,
+    weight INTEGER NOT NULL,
+    medication_id INTEGER NOT NULL,
+    note TEXT NOT NULL,
+    approved BOOLEAN NOT NULL DEFAULT 0,
+    first_continuation BOOLEAN NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL,
+    deleted_at DATETIME,
+    deleted_by INTEGER,
+    FOREIGN KEY (medication_id) REFERENCES medications(id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (deleted_by) REFERENCES users(id)
+);
+
+INSERT INTO
+    patients_old
+SELECT
+    p.id,
+    p.ucn,
+    p.first_name,
+    p.last_name,
+    p.phone_number,
+    p.height,
+    p.weight,
+    (
+        SELECT
+            pr.medication_id
+        FROM
+            prescriptions pr
+        WHERE
+            pr.patient_id = p.id
+        ORDER BY
+            pr.status = 'active' DESC,
+            pr.start_date DESC,
+            pr.id DESC
+        LIMIT
+            1
+    ),
+    p.note,
+    p.approved,
+    p.first_continuation,
+    p.user_id,
+    p.deleted_at,
+    p.deleted_by
+FROM
+    patients p;
+
+DROP TABLE prescriptions;
+
+DROP TABLE patients;
+
+ALTER TABLE patients_old RENAME TO patients;
+
+CREATE INDEX patients_idx_ucn ON patients (ucn);
+
+CREATE INDEX patients_idx_medication ON patients (medication_id);
+
+COMMIT;
+
+PRAGMA foreign_keys = ON;
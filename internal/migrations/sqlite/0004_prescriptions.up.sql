@@ -0,0 +1,87 @@
+-- SQLite cannot drop a column under a foreign key, so patients is rebuilt as in 0003, with the foreign keys turned off
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE prescriptions (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    patient_id INTEGER NOT NULL,
+    medication_id INTEGER NOT NULL,
+    dose VARCHAR(50) NOT NULL,
+    frequency VARCHAR(50) NOT NULL,
+    start_date DATE NOT NULL,
+    end_date DATE,
+    prescribed_by INTEGER NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'active',
+    created DATETIME NOT NULL,
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (medication_id) REFERENCES medications(id),
+    FOREIGN KEY (prescribed_by) REFERENCES users(id)
+);
+
+CREATE INDEX prescriptions_idx_patient ON prescriptions (patient_id);
+
+CREATE INDEX prescriptions_idx_medication ON prescriptions (medication_id, status);
+
+-- every patient keeps their medication as a prescription by their owner, the dosage being filled in afterwards;
+-- its start date is the day of the migration, as the original one was never recorded
+INSERT INTO
+    prescriptions (patient_id, medication_id, dose, frequency, start_date, prescribed_by, status, created)
+SELECT
+    id,
+    medication_id,
+    '',
+    '',
+    date('now'),
+    user_id,
+    'active',
+    strftime('%Y-%m-%d %H:%M:%S', 'now')
+FROM
+    patients;
+
+CREATE TABLE patients_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    ucn VARCHAR(10) NOT NULL,
+    first_name VARCHAR(20) NOT NULL,
+    last_name VARCHAR(20) NOT NULL,
+    phone_number VARCHAR(20) NOT NULL,
+    height INTEGER NOT NULL,
+    weight INTEGER NOT NULL,
+    note TEXT NOT NULL,
+    approved BOOLEAN NOT NULL DEFAULT 0,
+    first_continuation BOOLEAN NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL,
+    deleted_at DATETIME,
+    deleted_by INTEGER,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (deleted_by) REFERENCES users(id)
+);
+
+INSERT INTO
+    patients_new
+SELECT
+    id,
+    ucn,
+    first_name,
+    last_name,
+    phone_number,
+    height,
+    weight,
+    note,
+    approved,
+    first_continuation,
+    user_id,
+    deleted_at,
+    deleted_by
+FROM
+    patients;
+
+DROP TABLE patients;
+
+ALTER TABLE patients_new RENAME TO patients;
+
+CREATE INDEX patients_idx_ucn ON patients (ucn);
+
+COMMIT;
+
+PRAGMA foreign_keys = ON;
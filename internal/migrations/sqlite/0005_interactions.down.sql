@@ -0,0 +1,3 @@
+ALTER TABLE prescriptions DROP COLUMN override_reason;
+
+DROP TABLE interactions;
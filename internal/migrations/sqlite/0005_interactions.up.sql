@@ -0,0 +1,14 @@
+CREATE TABLE interactions (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    ingredient_a VARCHAR(100) NOT NULL,
+    ingredient_b VARCHAR(100) NOT NULL,
+    severity VARCHAR(10) NOT NULL,
+    description VARCHAR(500) NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT interactions_uc_pair UNIQUE (ingredient_a, ingredient_b)
+);
+
+CREATE INDEX interactions_idx_ingredient_b ON interactions (ingredient_b);
+
+-- the reason a prescription was made despite severe interactions, empty if there were none
+ALTER TABLE prescriptions ADD COLUMN override_reason VARCHAR(500) NOT NULL DEFAULT '';
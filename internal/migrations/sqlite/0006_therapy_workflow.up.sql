@@ -0,0 +1,34 @@
+ALTER TABLE patients ADD COLUMN therapy_status VARCHAR(20) NOT NULL DEFAULT 'draft';
+
+ALTER TABLE patients ADD COLUMN therapy_changed DATETIME;
+
+-- the former flags map onto the furthest status they imply, while unapproved patients start over as drafts
+UPDATE
+    patients
+SET
+    therapy_status = CASE
+        WHEN first_continuation THEN 'first_continuation'
+        WHEN approved THEN 'approved'
+        ELSE 'draft'
+    END;
+
+ALTER TABLE patients DROP COLUMN approved;
+
+ALTER TABLE patients DROP COLUMN first_continuation;
+
+CREATE INDEX patients_idx_therapy_status ON patients (therapy_status);
+
+CREATE TABLE therapy_transitions (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    patient_id INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    comment VARCHAR(500) NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX therapy_transitions_idx_patient ON therapy_transitions (patient_id);
@@ -0,0 +1,7 @@
+ALTER TABLE therapy_transitions DROP COLUMN expires;
+
+DROP INDEX patients_idx_therapy_expires;
+
+ALTER TABLE patients DROP COLUMN therapy_reminder;
+
+ALTER TABLE patients DROP COLUMN therapy_expires;
@@ -0,0 +1,18 @@
+ALTER TABLE patients ADD COLUMN therapy_expires DATE;
+
+-- the last reminder stage the owners were notified of, either upcoming or overdue, reset on every transition
+ALTER TABLE patients ADD COLUMN therapy_reminder VARCHAR(10) NOT NULL DEFAULT '';
+
+-- the therapies granted before expiry dates were tracked are taken to expire a year after they were last changed;
+-- as that was not tracked either for those migrated from the former flags, they expire on the day of the upgrade
+-- instead, so that their owners are reminded to review and continue them
+UPDATE
+    patients
+SET
+    therapy_expires = COALESCE(date(therapy_changed, '+1 year'), date('now'))
+WHERE
+    therapy_status IN ('approved', 'first_continuation', 'continued');
+
+CREATE INDEX patients_idx_therapy_expires ON patients (therapy_expires);
+
+ALTER TABLE therapy_transitions ADD COLUMN expires DATE;
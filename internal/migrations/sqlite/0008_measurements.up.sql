@@ -0,0 +1,29 @@
+CREATE TABLE measurements (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    patient_id INTEGER NOT NULL,
+    measured DATE NOT NULL,
+    height INTEGER NOT NULL,
+    weight INTEGER NOT NULL,
+    systolic INTEGER,
+    diastolic INTEGER,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX measurements_idx_patient ON measurements (patient_id, measured);
+
+-- the current height and weight of every patient start its history, as recorded by its owner on the day of the
+-- migration, since the earlier values were overwritten
+INSERT INTO
+    measurements (patient_id, measured, height, weight, user_id, created)
+SELECT
+    id,
+    date('now'),
+    height,
+    weight,
+    user_id,
+    strftime('%Y-%m-%d %H:%M:%S', 'now')
+FROM
+    patients;
@@ -0,0 +1,23 @@
+ALTER TABLE patients ADD COLUMN note TEXT NOT NULL DEFAULT '';
+
+-- only the most recent note of every patient survives
+UPDATE
+    patients
+SET
+    note = COALESCE(
+        (
+            SELECT
+                n.body
+            FROM
+                patient_notes n
+            WHERE
+                n.patient_id = patients.id
+            ORDER BY
+                n.created DESC,
+                n.id DESC
+            LIMIT
+                1
+        ), ''
+    );
+
+DROP TABLE patient_notes;
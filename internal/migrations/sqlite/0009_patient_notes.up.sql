@@ -0,0 +1,29 @@
+CREATE TABLE patient_notes (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    patient_id INTEGER NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    body TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    updated DATETIME,
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX patient_notes_idx_patient ON patient_notes (patient_id, created);
+
+-- every patient's note starts its timeline as a general note by its owner, written on the day of the migration
+INSERT INTO
+    patient_notes (patient_id, type, body, user_id, created)
+SELECT
+    id,
+    'general',
+    note,
+    user_id,
+    strftime('%Y-%m-%d %H:%M:%S', 'now')
+FROM
+    patients
+WHERE
+    TRIM(note) <> '';
+
+ALTER TABLE patients DROP COLUMN note;
@@ -0,0 +1,18 @@
+CREATE TABLE attachments (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    patient_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    category VARCHAR(20) NOT NULL,
+    description VARCHAR(500) NOT NULL DEFAULT '',
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    digest CHAR(64) NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX attachments_idx_patient ON attachments (patient_id, created);
+
+CREATE INDEX attachments_idx_digest ON attachments (digest);
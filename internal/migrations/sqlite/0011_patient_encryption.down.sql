@@ -0,0 +1,3 @@
+DROP INDEX patients_idx_ucn_index;
+
+ALTER TABLE patients DROP COLUMN ucn_index;
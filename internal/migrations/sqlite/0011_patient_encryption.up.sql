@@ -0,0 +1,4 @@
+-- SQLite does not enforce the length of VARCHAR columns, so only the blind index of the UCN has to be added
+ALTER TABLE patients ADD COLUMN ucn_index CHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX patients_idx_ucn_index ON patients (ucn_index);
@@ -0,0 +1,2 @@
+-- the patient a duplicate was merged into, which took over all of its records
+ALTER TABLE patients ADD COLUMN merged_into INTEGER;
@@ -0,0 +1,9 @@
+DROP TABLE remembered_devices;
+
+DROP TABLE recovery_codes;
+
+ALTER TABLE users DROP COLUMN totp_required;
+
+ALTER TABLE users DROP COLUMN totp_last_step;
+
+ALTER TABLE users DROP COLUMN totp_secret;
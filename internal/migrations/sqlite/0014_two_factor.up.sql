@@ -0,0 +1,29 @@
+-- the shared secret of the user's authenticator app, set once they enroll in two-factor authentication
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64);
+
+-- the step of the last code accepted, so that no code is accepted twice
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- set by an admin for users who may not work without two-factor authentication
+ALTER TABLE users ADD COLUMN totp_required BOOLEAN NOT NULL DEFAULT 0;
+
+-- single-use codes letting users who lost their authenticator log in, only the hash of which is stored
+CREATE TABLE recovery_codes (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    hash CHAR(64) NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT recovery_codes_uc_hash UNIQUE (hash)
+);
+
+-- browsers which skip the second step of logging in until they expire, identified by the hash of a cookie's token
+CREATE TABLE remembered_devices (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    hash CHAR(64) NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT remembered_devices_uc_hash UNIQUE (hash)
+);
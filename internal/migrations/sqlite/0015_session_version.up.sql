@@ -0,0 +1,2 @@
+-- incremented whenever the password changes, ending the sessions logged in with an older version
+ALTER TABLE users ADD COLUMN session_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN totp_locked_until;
+
+ALTER TABLE users DROP COLUMN totp_failures;
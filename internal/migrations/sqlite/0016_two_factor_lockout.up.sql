@@ -0,0 +1,5 @@
+-- the codes entered wrong in a row, counted until one is accepted
+ALTER TABLE users ADD COLUMN totp_failures INTEGER NOT NULL DEFAULT 0;
+
+-- set once too many codes were entered wrong, no code being accepted until it passes
+ALTER TABLE users ADD COLUMN totp_locked_until DATETIME;
@@ -0,0 +1,46 @@
+-- rebuilds the table like the up migration, comparing the emails byte by byte again
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE users_old (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    hashed_password CHAR(60) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'doctor',
+    created DATETIME NOT NULL,
+    totp_secret VARCHAR(64),
+    totp_last_step BIGINT NOT NULL DEFAULT 0,
+    totp_required BOOLEAN NOT NULL DEFAULT 0,
+    session_version INTEGER NOT NULL DEFAULT 0,
+    totp_failures INTEGER NOT NULL DEFAULT 0,
+    totp_locked_until DATETIME,
+    CONSTRAINT users_uc_email UNIQUE (email)
+);
+
+INSERT INTO
+    users_old (id, name, email, hashed_password, role, created, totp_secret, totp_last_step, totp_required, session_version, totp_failures, totp_locked_until)
+SELECT
+    id,
+    name,
+    email,
+    hashed_password,
+    role,
+    created,
+    totp_secret,
+    totp_last_step,
+    totp_required,
+    session_version,
+    totp_failures,
+    totp_locked_until
+FROM
+    users;
+
+DROP TABLE users;
+
+ALTER TABLE users_old RENAME TO users;
+
+COMMIT;
+
+PRAGMA foreign_keys = ON;
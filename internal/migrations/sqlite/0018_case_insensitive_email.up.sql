@@ -0,0 +1,48 @@
+-- MySQL compares the emails case-insensitively by the default collation, while SQLite compares them byte by byte, so
+-- the table is rebuilt with the email collated likewise, as described in
+-- https://www.sqlite.org/lang_altertable.html#otheralter; fails if several users' emails differ in case only
+PRAGMA foreign_keys = OFF;
+
+BEGIN;
+
+CREATE TABLE users_new (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL COLLATE NOCASE,
+    hashed_password CHAR(60) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'doctor',
+    created DATETIME NOT NULL,
+    totp_secret VARCHAR(64),
+    totp_last_step BIGINT NOT NULL DEFAULT 0,
+    totp_required BOOLEAN NOT NULL DEFAULT 0,
+    session_version INTEGER NOT NULL DEFAULT 0,
+    totp_failures INTEGER NOT NULL DEFAULT 0,
+    totp_locked_until DATETIME,
+    CONSTRAINT users_uc_email UNIQUE (email)
+);
+
+INSERT INTO
+    users_new (id, name, email, hashed_password, role, created, totp_secret, totp_last_step, totp_required, session_version, totp_failures, totp_locked_until)
+SELECT
+    id,
+    name,
+    email,
+    hashed_password,
+    role,
+    created,
+    totp_secret,
+    totp_last_step,
+    totp_required,
+    session_version,
+    totp_failures,
+    totp_locked_until
+FROM
+    users;
+
+DROP TABLE users;
+
+ALTER TABLE users_new RENAME TO users;
+
+COMMIT;
+
+PRAGMA foreign_keys = ON;
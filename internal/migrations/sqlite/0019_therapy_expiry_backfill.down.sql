@@ -0,0 +1 @@
+-- the filled in expiry dates cannot be told apart from those chosen since, so they are kept
@@ -0,0 +1,3 @@
+DROP INDEX patients_idx_phone_index;
+
+ALTER TABLE patients DROP COLUMN phone_index;
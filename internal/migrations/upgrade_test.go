@@ -0,0 +1,316 @@
+package migrations_test
+
+import (
+	"database/sql"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"p-system.okostadinov.net/internal/migrations"
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/testdb"
+)
+
+var statementSeparator = regexp.MustCompile(`;\s*(\n|$)`)
+
+// runs the statements of an SQL script on a single connection, skipping the USE statements selecting the database,
+// which the tests create under a name of their own
+func execScript(t *testing.T, db *sql.DB, path string) {
+	t.Helper()
+
+	script, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(script), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	for _, stmt := range statementSeparator.Split(strings.Join(lines, "\n"), -1) {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" || strings.HasPrefix(stmt, "USE ") {
+			continue
+		}
+
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+}
+
+// the tables of the database, sorted by name
+func tables(t *testing.T, db *sql.DB, driver string) []string {
+	t.Helper()
+
+	stmt := "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'"
+	if driver == "mysql" {
+		stmt = "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()"
+	}
+
+	rows, err := db.Query(stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+func latestVersion(t *testing.T, migrator *migrations.Migrator) int {
+	t.Helper()
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return statuses[len(statuses)-1].Version
+}
+
+// every down migration reverts its up migration, so that the schema can be built again from nothing
+func TestRoundTrip(t *testing.T) {
+	for _, driver := range testdb.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			db, _ := testdb.OpenEmpty(t, driver)
+
+			migrator, err := migrations.New(db, driver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			latest := latestVersion(t, migrator)
+
+			applied, err := migrator.Up()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(applied) != latest {
+				t.Fatalf("applied %d of %d migrations", len(applied), latest)
+			}
+			schema := tables(t, db, driver)
+
+			reverted, err := migrator.To(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reverted) != latest {
+				t.Fatalf("reverted %d of %d migrations", len(reverted), latest)
+			}
+			if got := tables(t, db, driver); !slices.Equal(got, []string{"schema_migrations"}) {
+				t.Errorf("left the tables %v", got)
+			}
+
+			if _, err = migrator.Up(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tables(t, db, driver); !slices.Equal(got, schema) {
+				t.Errorf("got the tables %v, want %v", got, schema)
+			}
+
+			version, err := migrator.Version()
+			if err != nil || version != latest {
+				t.Errorf("got version %d, %v", version, err)
+			}
+		})
+	}
+}
+
+// the data stored at the latest version survives reverting the migrations since patient notes were introduced and
+// applying them again
+func TestDataSurvivesDownAndUp(t *testing.T) {
+	for _, driver := range testdb.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			db, dialect := testdb.Open(t, driver)
+			stores := models.NewStores(db, dialect, nil)
+
+			if err := stores.Users.Insert("alice", "alice@example.com", "password123"); err != nil {
+				t.Fatal(err)
+			}
+			const alice = 1
+
+			token, err := stores.Tokens.Insert("ci", alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id, err := stores.Patients.Insert("7501020018", "Ivan", "Petrov", "0888 123 456", 180, 80, "Allergic to penicillin", alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			duplicateId, err := stores.Patients.Insert("8506150090", "Ivan", "Petrova", "0888123456", 165, 60, "", alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			medicationId, err := stores.Medications.Insert("Aspirin", "acetylsalicylic acid", "100", "mg", "tablet", "", "", alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = stores.Prescriptions.Insert(id, medicationId, "1 tablet", "daily", time.Now().UTC(), nil, "", alice); err != nil {
+				t.Fatal(err)
+			}
+
+			expires, err := time.Parse(models.DateLayout, time.Now().UTC().AddDate(0, 0, 10).Format(models.DateLayout))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = stores.Therapy.Transition(id, models.TherapyActionSubmit, "", nil, alice); err != nil {
+				t.Fatal(err)
+			}
+			if err = stores.Therapy.Transition(id, models.TherapyActionApprove, "", &expires, alice); err != nil {
+				t.Fatal(err)
+			}
+
+			migrator, err := migrations.New(db, driver)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the version which replaced the patients' note with the notes timeline
+			if _, err = migrator.To(9); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = migrator.Up(); err != nil {
+				t.Fatal(err)
+			}
+
+			if userId, err := stores.Users.Authenticate("alice@example.com", "password123"); err != nil || userId != alice {
+				t.Errorf("authenticating got %d, %v", userId, err)
+			}
+
+			if userId, err := stores.Tokens.GetUserId(token); err != nil || userId != alice {
+				t.Errorf("looking the token up got %d, %v", userId, err)
+			}
+
+			p, err := stores.Patients.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.UCN != "7501020018" || p.FirstName != "Ivan" || p.LastName != "Petrov" || p.PhoneNumber != "0888 123 456" ||
+				p.Height != 180 || p.Note != "Allergic to penicillin" || p.TherapyStatus != models.TherapyStatusApproved {
+				t.Errorf("got %+v", p)
+			}
+			if len(p.Medications) != 1 || !strings.HasPrefix(p.Medications[0], "Aspirin") {
+				t.Errorf("got medications %v", p.Medications)
+			}
+
+			due, err := stores.Therapy.GetDueByUser(alice, expires)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(due) != 1 || due[0].PatientId != id || !due[0].Expires.Equal(expires) {
+				t.Errorf("got %d continuations", len(due))
+			}
+
+			// the phone numbers are indexed again by the migration adding their index
+			duplicates, err := stores.Patients.GetDuplicates()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(duplicates) != 1 || duplicates[0].Patient.ID != id || duplicates[0].Match.ID != duplicateId {
+				t.Errorf("got %d duplicates", len(duplicates))
+			}
+		})
+	}
+}
+
+// the columns of every table of the database, as "<table>.<column> <type> <nullable> <default>", sorted
+func mysqlColumns(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+
+	rows, err := db.Query(`SELECT table_name, column_name, column_type, is_nullable, COALESCE(column_default, 'NULL')
+	FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name <> 'schema_migrations'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var table, column, columnType, nullable, def string
+		if err = rows.Scan(&table, &column, &columnType, &nullable, &def); err != nil {
+			t.Fatal(err)
+		}
+		columns = append(columns, table+"."+column+" "+columnType+" "+nullable+" "+def)
+	}
+
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(columns)
+	return columns
+}
+
+// a database created by the setup script of the releases before schema migrations is brought up to migration 1 by
+// the upgrade script, after which it migrates like any other, keeping its data and ending up with the same schema
+func TestUpgradeBaseline(t *testing.T) {
+	db, _ := testdb.OpenEmpty(t, "mysql")
+
+	execScript(t, db, "testdata/pre_migration_schema.sql")
+
+	for _, stmt := range []string{
+		"INSERT INTO users (name, email, hashed_password, created) VALUES ('alice', 'alice@example.com', '', UTC_TIMESTAMP())",
+		"INSERT INTO medications (name, user_id) VALUES ('Aspirin', 1)",
+		"INSERT INTO patients (ucn, first_name, last_name, phone_number, height, weight, medication, note, approved, first_continuation, user_id) VALUES ('7501020018', 'Ivan', 'Petrov', '0888123456', '180', '80', 'Aspirin', 'Allergic to penicillin', 1, 0, 1)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	execScript(t, db, "../../scripts/upgrade_baseline.sql")
+
+	migrator, err := migrations.New(db, "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := migrator.Version()
+	if err != nil || version != 1 {
+		t.Fatalf("got version %d, %v", version, err)
+	}
+
+	if _, err = migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	stores := models.NewStores(db, models.MySQL, nil)
+
+	p, err := stores.Patients.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.LastName != "Petrov" || p.Height != 180 || p.Note != "Allergic to penicillin" || p.TherapyStatus != models.TherapyStatusApproved {
+		t.Errorf("got %+v", p)
+	}
+	if len(p.Medications) != 1 || !strings.HasPrefix(p.Medications[0], "Aspirin") {
+		t.Errorf("got medications %v", p.Medications)
+	}
+
+	fresh, _ := testdb.Open(t, "mysql")
+	if got, want := mysqlColumns(t, db), mysqlColumns(t, fresh); !slices.Equal(got, want) {
+		t.Errorf("the upgraded schema differs from a migrated one:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	AttachmentCategoryLabReport = "lab_report"
+	AttachmentCategoryImaging   = "imaging"
+	AttachmentCategoryConsent   = "consent"
+	AttachmentCategoryOther     = "other"
+)
+
+var AttachmentCategories = []string{AttachmentCategoryLabReport, AttachmentCategoryImaging, AttachmentCategoryConsent, AttachmentCategoryOther}
+
+// a file attached to a patient, whose contents are kept in the file storage under their digest
+type Attachment struct {
+	ID          int       `json:"id"`
+	PatientId   int       `json:"patient_id"`
+	Name        string    `json:"name"`
+	Category    string    `json:"category"`
+	Description string    `json:"description"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Digest      string    `json:"digest"`
+	UserId      int       `json:"user_id"`
+	UserName    string    `json:"-"`
+	Created     time.Time `json:"created"`
+}
+
+type AttachmentModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+// attachments are audited under their patient, e.g. "12/3", so that they show up in its history
+func attachmentAuditId(patientId int, id int) string {
+	return fmt.Sprintf("%d/%d", patientId, id)
+}
+
+// records a file stored under the digest as attached to the patient, as long as the patient is not deleted
+func (m *AttachmentModel) Insert(patientId int, name string, category string, description string, contentType string, size int64, digest string, userId int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = getPatientForUpdate(tx, m.Dialect, patientId, false)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO attachments (patient_id, name, category, description, content_type, size, digest, user_id, created)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := tx.Exec(stmt, patientId, name, category, description, contentType, size, digest, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	after, err := getAttachment(tx, int(id))
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionInsert, AuditEntityAttachment, attachmentAuditId(patientId, int(id)), nil, after)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), tx.Commit()
+}
+
+const attachmentSelect = `SELECT a.id, a.patient_id, a.name, a.category, a.description, a.content_type, a.size, a.digest, a.user_id, u.name, a.created
+	FROM attachments a JOIN users u ON u.id = a.user_id`
+
+func scanAttachment(row rowScanner) (*Attachment, error) {
+	var a Attachment
+
+	err := row.Scan(&a.ID, &a.PatientId, &a.Name, &a.Category, &a.Description, &a.ContentType, &a.Size, &a.Digest, &a.UserId, &a.UserName, &a.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// satisfied by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func getAttachment(q rowQuerier, id int) (*Attachment, error) {
+	a, err := scanAttachment(q.QueryRow(attachmentSelect+" WHERE a.id = ?", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+func (m *AttachmentModel) Get(id int) (*Attachment, error) {
+	return getAttachment(m.DB, id)
+}
+
+// returns the patient's attachments, the most recent first
+func (m *AttachmentModel) GetAllByPatient(patientId int) ([]*Attachment, error) {
+	var attachments []*Attachment
+
+	rows, err := m.DB.Query(attachmentSelect+" WHERE a.patient_id = ? ORDER BY a.created DESC, a.id DESC", patientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+// removes the attachment, leaving its file to the caller, who should delete it once no other attachment refers to it
+func (m *AttachmentModel) Delete(id int, userId int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getAttachment(tx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM attachments WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionDelete, AuditEntityAttachment, attachmentAuditId(before.PatientId, id), before, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// reports whether any attachment still refers to the file stored under the digest
+func (m *AttachmentModel) IsReferenced(digest string) (bool, error) {
+	var exists bool
+
+	err := m.DB.QueryRow("SELECT EXISTS(SELECT true FROM attachments WHERE digest = ?)", digest).Scan(&exists)
+
+	return exists, err
+}
+
+// returns the digests of the files attached to the patients soft deleted before the cutoff, which go along with them
+// once they are purged
+func (m *AttachmentModel) GetDigestsByDeletedPatients(cutoff time.Time) ([]string, error) {
+	var digests []string
+
+	stmt := "SELECT DISTINCT a.digest FROM attachments a JOIN patients p ON p.id = a.patient_id WHERE p.deleted_at < ?"
+
+	rows, err := m.DB.Query(stmt, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var digest string
+		if err = rows.Scan(&digest); err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return digests, nil
+}
+
+// audits the purge of the patient's attachments, which are deleted along with it
+func purgeAttachments(tx *sql.Tx, patientId int, userId int) error {
+	rows, err := tx.Query(attachmentSelect+" WHERE a.patient_id = ?", patientId)
+	if err != nil {
+		return err
+	}
+
+	var attachments []*Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		attachments = append(attachments, a)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, a := range attachments {
+		err = insertAuditEntry(tx, userId, AuditActionPurge, AuditEntityAttachment, attachmentAuditId(patientId, a.ID), a, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
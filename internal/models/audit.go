@@ -0,0 +1,267 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"p-system.okostadinov.net/internal/encryption"
+)
+
+const (
+	AuditActionInsert  = "insert"
+	AuditActionUpdate  = "update"
+	AuditActionDelete  = "delete"
+	AuditActionRestore = "restore"
+	AuditActionPurge   = "purge"
+	AuditActionExport  = "export"
+	AuditActionMerge   = "merge"
+)
+
+const (
+	AuditEntityPatient    = "patient"
+	AuditEntityMedication = "medication"
+	AuditEntityAttachment = "attachment"
+	AuditEntityPatients   = "patients"
+)
+
+type AuditEntry struct {
+	ID       int
+	UserId   int
+	UserName string
+	Action   string
+	Entity   string
+	EntityId string
+	Before   map[string]any
+	After    map[string]any
+	Created  time.Time
+}
+
+// a single field's value before and after an audited change, formatted for display
+type FieldChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// reads the audit log, opening the personal data sealed in the snapshots of patients with the keys
+type AuditModel struct {
+	DB   *sql.DB
+	Keys *encryption.Keyring
+}
+
+// records an audit entry as part of the caller's transaction, so that the change and its trace are committed together;
+// before and after are snapshots of the record, nil for inserts and deletes respectively, while a zero userId marks
+// changes made by the system itself
+func insertAuditEntry(tx *sql.Tx, userId int, action string, entity string, entityId any, before any, after any) error {
+	var actor any
+	if userId != 0 {
+		actor = userId
+	}
+
+	beforeJSON, err := marshalSnapshot(before)
+	if err != nil {
+		return err
+	}
+
+	afterJSON, err := marshalSnapshot(after)
+	if err != nil {
+		return err
+	}
+
+	stmt := "INSERT INTO audit_log (user_id, action, entity, entity_id, before_values, after_values, created) VALUES (?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())"
+	_, err = tx.Exec(stmt, actor, action, entity, fmt.Sprint(entityId), beforeJSON, afterJSON)
+	return err
+}
+
+// an export of a patient list, as recorded in the audit log since it discloses personal data
+type PatientExport struct {
+	Format  string        `json:"format"`
+	Columns []string      `json:"columns"`
+	Filter  PatientFilter `json:"filter"`
+}
+
+// records that the user is exporting the patients matching the filter; exports are logged against the patients as a
+// whole, identified by the format, as they are taken before the first row is written, with the search query, which
+// may well hold personal data, sealed
+func (m *AuditModel) InsertExport(export *PatientExport, userId int) error {
+	query, err := m.Keys.Encrypt(export.Filter.Query)
+	if err != nil {
+		return err
+	}
+
+	sealed := *export
+	sealed.Filter.Query = query
+	export = &sealed
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertAuditEntry(tx, userId, AuditActionExport, AuditEntityPatients, export.Format, nil, export)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func marshalSnapshot(snapshot any) (any, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+
+	if v := reflect.ValueOf(snapshot); v.Kind() == reflect.Pointer && v.IsNil() {
+		return nil, nil
+	}
+
+	js, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(js), nil
+}
+
+// returns the audit trail of a single record, most recent first
+func (m *AuditModel) GetAllByEntity(entity string, entityId any) ([]*AuditEntry, error) {
+	stmt := auditSelect + " WHERE a.entity = ? AND a.entity_id = ? ORDER BY a.created DESC, a.id DESC"
+
+	return m.query(stmt, entity, fmt.Sprint(entityId))
+}
+
+const auditSelect = `SELECT a.id, COALESCE(a.user_id, 0), COALESCE(u.name, 'System'), a.action, a.entity, a.entity_id, a.before_values, a.after_values, a.created
+	FROM audit_log a LEFT JOIN users u ON u.id = a.user_id`
+
+func (m *AuditModel) query(stmt string, args ...any) ([]*AuditEntry, error) {
+	var entries []*AuditEntry
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			e             AuditEntry
+			before, after []byte
+		)
+
+		err := rows.Scan(&e.ID, &e.UserId, &e.UserName, &e.Action, &e.Entity, &e.EntityId, &before, &after, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		if before != nil {
+			if err = json.Unmarshal(before, &e.Before); err != nil {
+				return nil, err
+			}
+		}
+
+		if after != nil {
+			if err = json.Unmarshal(after, &e.After); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, field := range sealedSnapshotFields[e.Entity] {
+			path := strings.Split(field, ".")
+
+			if err = snapshotField(e.Before, path, m.Keys.Decrypt); err != nil {
+				return nil, err
+			}
+
+			if err = snapshotField(e.After, path, m.Keys.Decrypt); err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, &e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// the fields of the audit log snapshots holding personal data, by entity, nested fields being separated by dots; the
+// snapshots of patients are taken as they are stored, so their personal data stays sealed in the audit log as well,
+// while the rest of the fields are never sealed, whatever they read like
+var sealedSnapshotFields = map[string][]string{
+	AuditEntityPatient:  {"ucn", "first_name", "last_name", "phone_number"},
+	AuditEntityPatients: {"filter.query"},
+}
+
+// replaces the value of the snapshot's field, given by its path within nested objects, with the value returned by
+// transform, e.g. sealing or opening it; snapshots lacking the field are left as they are
+func snapshotField(snapshot map[string]any, path []string, transform func(string) (string, error)) error {
+	if len(path) > 1 {
+		nested, ok := snapshot[path[0]].(map[string]any)
+		if !ok {
+			return nil
+		}
+		return snapshotField(nested, path[1:], transform)
+	}
+
+	value, ok := snapshot[path[0]].(string)
+	if !ok {
+		return nil
+	}
+
+	transformed, err := transform(value)
+	if err != nil {
+		return err
+	}
+
+	snapshot[path[0]] = transformed
+	return nil
+}
+
+// returns the audit trail of the patient along with that of its attachments, most recent first
+func (m *AuditModel) GetAllByPatient(patientId int) ([]*AuditEntry, error) {
+	stmt := auditSelect + ` WHERE (a.entity = ? AND a.entity_id = ?) OR (a.entity = ? AND a.entity_id LIKE ?)
+	ORDER BY a.created DESC, a.id DESC`
+
+	return m.query(stmt, AuditEntityPatient, fmt.Sprint(patientId), AuditEntityAttachment, fmt.Sprintf("%d/%%", patientId))
+}
+
+// lists the fields whose values differ between the before and after snapshots, sorted by field name
+func (e *AuditEntry) Changes() []FieldChange {
+	var changes []FieldChange
+
+	fields := make(map[string]bool)
+	for field := range e.Before {
+		fields[field] = true
+	}
+	for field := range e.After {
+		fields[field] = true
+	}
+
+	for field := range fields {
+		before, after := e.Before[field], e.After[field]
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: field, Before: formatAuditValue(before), After: formatAuditValue(after)})
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+
+	return changes
+}
+
+func formatAuditValue(value any) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	ReminderStageUpcoming = "upcoming"
+	ReminderStageOverdue  = "overdue"
+)
+
+// a granted therapy which expires and therefore has to be continued
+type Continuation struct {
+	PatientId   int
+	PatientName string
+	Status      TherapyStatus
+	Expires     time.Time
+}
+
+// reports whether the therapy has already expired as of today
+func (c *Continuation) Overdue() bool {
+	return c.Expires.Format(DateLayout) < time.Now().UTC().Format(DateLayout)
+}
+
+// the number of days until the therapy expires, negative once it has
+func (c *Continuation) DaysLeft() int {
+	today, _ := time.Parse(DateLayout, time.Now().UTC().Format(DateLayout))
+	return int(c.Expires.Sub(today).Hours() / 24)
+}
+
+// a continuation to remind one of the patient's owners or co-owners of, at the stage it has reached
+type Reminder struct {
+	Continuation
+	UserId    int
+	UserName  string
+	UserEmail string
+	Stage     string
+}
+
+// the condition a patient aliased p has to meet to have a granted therapy, which has to be continued once it expires
+const grantedTherapy = "p.deleted_at IS NULL AND p.therapy_status IN ('approved', 'first_continuation', 'continued')"
+
+// the condition a patient aliased p has to meet to be due for continuation by the horizon date
+const dueContinuation = grantedTherapy + " AND p.therapy_expires <= ?"
+
+// the condition a patient aliased p has to meet to be owned or co-owned by the user, given twice
+const ownedOrCoOwned = "(p.user_id = ? OR EXISTS(SELECT true FROM patient_co_owners c WHERE c.patient_id = p.id AND c.user_id = ?))"
+
+// returns the continuations of the patients the user owns or co-owns which expire by the horizon date, including
+// those already overdue, earliest first
+func (m *TherapyModel) GetDueByUser(userId int, horizon time.Time) ([]*Continuation, error) {
+	stmt := `SELECT p.id, p.first_name, p.last_name, p.therapy_status, p.therapy_expires FROM patients p
+	WHERE ` + dueContinuation + ` AND ` + ownedOrCoOwned + `
+	ORDER BY p.therapy_expires, p.id`
+
+	return m.queryContinuations(stmt, horizon.Format(DateLayout), userId, userId)
+}
+
+// returns the granted therapies of the patients the user owns or co-owns which lack an expiry, e.g. those granted
+// before expiries were tracked, which are never due and have to be continued with one; their Expires is left zero
+func (m *TherapyModel) GetWithoutExpiryByUser(userId int) ([]*Continuation, error) {
+	stmt := `SELECT p.id, p.first_name, p.last_name, p.therapy_status, p.therapy_expires FROM patients p
+	WHERE ` + grantedTherapy + ` AND p.therapy_expires IS NULL AND ` + ownedOrCoOwned + `
+	ORDER BY p.id`
+
+	return m.queryContinuations(stmt, userId, userId)
+}
+
+func (m *TherapyModel) queryContinuations(stmt string, args ...any) ([]*Continuation, error) {
+	var continuations []*Continuation
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			c                   Continuation
+			firstName, lastName string
+			expires             sql.NullTime
+		)
+
+		err := rows.Scan(&c.PatientId, &firstName, &lastName, &c.Status, &expires)
+		if err != nil {
+			return nil, err
+		}
+		c.Expires = expires.Time
+
+		c.PatientName, err = decryptName(m.Keys, firstName, lastName)
+		if err != nil {
+			return nil, err
+		}
+		continuations = append(continuations, &c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return continuations, nil
+}
+
+// returns a reminder for every owner and co-owner of the patients due for continuation by the horizon date, which
+// have not yet been reminded of at their current stage, grouped by user
+func (m *TherapyModel) GetPendingReminders(today time.Time, horizon time.Time) ([]*Reminder, error) {
+	var reminders []*Reminder
+
+	stmt := `SELECT p.id, p.first_name, p.last_name, p.therapy_status, p.therapy_expires, u.id, u.name, u.email, r.stage
+	FROM (SELECT p.id, CASE WHEN p.therapy_expires < ? THEN 'overdue' ELSE 'upcoming' END AS stage FROM patients p WHERE ` + dueContinuation + `) r
+	JOIN patients p ON p.id = r.id
+	JOIN users u ON u.id = p.user_id OR EXISTS(SELECT true FROM patient_co_owners c WHERE c.patient_id = p.id AND c.user_id = u.id)
+	WHERE p.therapy_reminder <> r.stage
+	ORDER BY u.id, p.therapy_expires, p.id`
+
+	rows, err := m.DB.Query(stmt, today.Format(DateLayout), horizon.Format(DateLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			r                   Reminder
+			firstName, lastName string
+		)
+
+		err := rows.Scan(&r.PatientId, &firstName, &lastName, &r.Status, &r.Expires, &r.UserId, &r.UserName, &r.UserEmail, &r.Stage)
+		if err != nil {
+			return nil, err
+		}
+
+		r.PatientName, err = decryptName(m.Keys, firstName, lastName)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, &r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reminders, nil
+}
+
+// records that the patient's owners have been reminded of its continuation at the stage, so that they are not
+// reminded again until it becomes overdue or the therapy is continued
+func (m *TherapyModel) MarkReminded(patientId int, stage string) error {
+	_, err := m.DB.Exec("UPDATE patients SET therapy_reminder = ? WHERE id = ?", stage, patientId)
+	return err
+}
@@ -0,0 +1,125 @@
+package models_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"p-system.okostadinov.net/internal/migrations"
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/testdb"
+)
+
+func continuationIds(continuations []*models.Continuation) []int {
+	ids := make([]int, len(continuations))
+	for i, c := range continuations {
+		ids[i] = c.PatientId
+	}
+	return ids
+}
+
+// the therapies granted before their expiry was tracked become due once migrated, rather than never
+func TestContinuationsOfLegacyTherapies(t *testing.T) {
+	for _, driver := range testdb.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			db, dialect := testdb.OpenEmpty(t, driver)
+
+			migrator, err := migrations.New(db, driver)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the last version with the approval flags
+			if _, err = migrator.To(5); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = db.Exec("INSERT INTO users (name, email, hashed_password, created) VALUES ('alice', 'alice@example.com', '', '2024-01-02 03:04:05')")
+			if err != nil {
+				t.Fatal(err)
+			}
+			const alice = 1
+
+			stmt := "INSERT INTO patients (ucn, first_name, last_name, phone_number, height, weight, note, approved, first_continuation, user_id) VALUES ('7501020018', 'Ivan', ?, '0888123456', 180, 80, '', ?, ?, 1)"
+			for _, p := range []struct {
+				name                        string
+				approved, firstContinuation bool
+			}{
+				{"Approved", true, false},
+				{"Continued", true, true},
+				{"Unapproved", false, false},
+			} {
+				if _, err = db.Exec(stmt, p.name, p.approved, p.firstContinuation); err != nil {
+					t.Fatal(err)
+				}
+			}
+			const approved, continued = 1, 2
+
+			if _, err = migrator.Up(); err != nil {
+				t.Fatal(err)
+			}
+
+			stores := models.NewStores(db, dialect, nil)
+			today := time.Now().UTC()
+
+			due, err := stores.Therapy.GetDueByUser(alice, today)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := continuationIds(due); !slices.Equal(ids, []int{approved, continued}) {
+				t.Fatalf("due %v", ids)
+			}
+			for _, c := range due {
+				if c.DaysLeft() != 0 {
+					t.Errorf("patient %d expires in %d days", c.PatientId, c.DaysLeft())
+				}
+			}
+
+			reminders, err := stores.Therapy.GetPendingReminders(today, today)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reminders) != 2 || reminders[0].UserId != alice || reminders[0].Stage != models.ReminderStageUpcoming {
+				t.Errorf("got %d reminders", len(reminders))
+			}
+
+			unexpiring, err := stores.Therapy.GetWithoutExpiryByUser(alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(unexpiring) != 0 {
+				t.Errorf("without expiry %v", continuationIds(unexpiring))
+			}
+
+			t.Run("databases migrated before the expiry was filled in", func(t *testing.T) {
+				if _, err := migrator.To(18); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := db.Exec("UPDATE patients SET therapy_expires = NULL"); err != nil {
+					t.Fatal(err)
+				}
+
+				unexpiring, err := stores.Therapy.GetWithoutExpiryByUser(alice)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ids := continuationIds(unexpiring); !slices.Equal(ids, []int{approved, continued}) {
+					t.Errorf("without expiry %v", ids)
+				}
+
+				if _, err = migrator.Up(); err != nil {
+					t.Fatal(err)
+				}
+
+				due, err := stores.Therapy.GetDueByUser(alice, today)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ids := continuationIds(due); !slices.Equal(ids, []int{approved, continued}) {
+					t.Errorf("due %v", ids)
+				}
+			})
+		})
+	}
+}
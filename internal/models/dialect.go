@@ -0,0 +1,84 @@
+package models
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// the parts of the SQL which differ between the supported databases, the rest of the statements being shared
+type Dialect interface {
+	// clause appended to a SELECT to lock the rows for the remainder of the transaction
+	LockClause() string
+	// reports whether the error was caused by a unique or primary key violation
+	IsDuplicate(err error) bool
+	// returns the condition matching patients against a search query, along with its arguments
+	SearchCondition(query string) (string, []any)
+	// returns the ORDER BY expressions ranking the patients matching a search query, best first
+	SearchOrder(query string) (string, []any)
+}
+
+type mysqlDialect struct{}
+
+// the dialect of MySQL, relying on its InnoDB full-text index for searching patients
+var MySQL Dialect = mysqlDialect{}
+
+func (mysqlDialect) LockClause() string {
+	return " FOR UPDATE"
+}
+
+func (mysqlDialect) IsDuplicate(err error) bool {
+	var mySQLError *mysql.MySQLError
+	return errors.As(err, &mySQLError) && mySQLError.Number == 1062
+}
+
+// every search term has to prefix a word of the patient, of one of its notes or of a medication it has been prescribed,
+// or the query has to prefix the UCN
+func (mysqlDialect) SearchCondition(query string) (string, []any) {
+	var (
+		conditions []string
+		args       []any
+	)
+
+	for _, term := range prefixTerms(query) {
+		conditions = append(conditions, "(MATCH(first_name, last_name, phone_number) AGAINST (? IN BOOLEAN MODE) OR EXISTS(SELECT true FROM patient_notes n WHERE n.patient_id = patients.id AND MATCH(n.body) AGAINST (? IN BOOLEAN MODE)) OR EXISTS(SELECT true FROM prescriptions pr JOIN medications m ON m.id = pr.medication_id WHERE pr.patient_id = patients.id AND MATCH(m.name, m.active_ingredient) AGAINST (? IN BOOLEAN MODE)))")
+		args = append(args, term, term, term)
+	}
+
+	condition := "ucn LIKE ?"
+	if len(conditions) > 0 {
+		condition = "((" + strings.Join(conditions, " AND ") + ") OR " + condition + ")"
+	}
+
+	return condition, append(args, UCNPrefix(query))
+}
+
+func (mysqlDialect) SearchOrder(query string) (string, []any) {
+	return "ucn LIKE ? DESC, MATCH(first_name, last_name, phone_number) AGAINST (? IN BOOLEAN MODE) DESC", []any{UCNPrefix(query), strings.Join(prefixTerms(query), " ")}
+}
+
+// turns the search terms into word prefixes, e.g. "ivan*"
+func prefixTerms(query string) []string {
+	var terms []string
+
+	for _, term := range SearchTerms(query) {
+		// InnoDB ignores words shorter than its minimum token size, which would otherwise fail every required term
+		if len([]rune(term)) < 3 {
+			continue
+		}
+		terms = append(terms, term+"*")
+	}
+
+	return terms
+}
+
+// matches UCNs starting with the query, escaping any LIKE wildcards with a backslash
+func UCNPrefix(query string) string {
+	return EscapeLike(strings.TrimSpace(query)) + "%"
+}
+
+func EscapeLike(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return replacer.Replace(s)
+}
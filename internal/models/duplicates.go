@@ -0,0 +1,322 @@
+package models
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"p-system.okostadinov.net/internal/encryption"
+)
+
+const (
+	DuplicateReasonUCN  = "same UCN"
+	DuplicateReasonName = "similar name and same phone number"
+)
+
+// how many single letter edits apart two full names may be for patients sharing a phone number to be probable
+// duplicates, catching typos and transliteration differences
+const maxNameDistance = 2
+
+// a pair of patients which probably are the same person
+type Duplicate struct {
+	Patient *Patient
+	Match   *Patient
+	Reason  string
+}
+
+// returns the patients which probably are the same person as the one described, either sharing its UCN or having a
+// similar name and the same phone number, so that creating it can be reconsidered; only the patients sharing either
+// are compared, which the database finds by their blind indexes
+func (m *PatientModel) FindDuplicates(ucn string, firstName string, lastName string, phone string) ([]*Duplicate, error) {
+	condition, args := ucnCondition(m.Keys, ucn)
+	for _, index := range phoneIndexes(m.Keys, phone) {
+		condition += " OR phone_index = ?"
+		args = append(args, index)
+	}
+
+	patients, err := m.getCandidates(condition, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	candidate := &Patient{UCN: ucn, FirstName: firstName, LastName: lastName, PhoneNumber: phone}
+
+	var (
+		duplicates []*Duplicate
+		matches    []*Patient
+	)
+	for _, p := range patients {
+		if reason := duplicateReason(candidate, p); reason != "" {
+			duplicates = append(duplicates, &Duplicate{Patient: candidate, Match: p, Reason: reason})
+			matches = append(matches, p)
+		}
+	}
+
+	if err = fillPatients(m.DB, m.Keys, matches); err != nil {
+		return nil, err
+	}
+
+	return duplicates, nil
+}
+
+// the UCN patients are grouped by to find those sharing it: its blind index, or the UCN itself if it was stored before
+// encryption was enabled; patients indexed under different keys are only grouped together once the keys are rotated
+const sharedUCN = "CASE WHEN ucn_index = '' THEN ucn ELSE ucn_index END"
+
+// the condition matching the patients which share their UCN or phone number with another patient, which are the only
+// ones which may be duplicates
+const sharingPatients = sharedUCN + " IN (SELECT " + sharedUCN + " FROM patients WHERE deleted_at IS NULL GROUP BY " +
+	sharedUCN + " HAVING COUNT(*) > 1) OR phone_index IN (SELECT phone_index FROM patients WHERE deleted_at IS NULL " +
+	"AND phone_index <> '' GROUP BY phone_index HAVING COUNT(*) > 1)"
+
+// returns every pair of patients which probably are the same person, the older patient of each pair first, ordered
+// by it; patients sharing a UCN are paired whatever their names, while those sharing a phone number are paired only
+// if their names are similar
+func (m *PatientModel) GetDuplicates() ([]*Duplicate, error) {
+	patients, err := m.getCandidates(sharingPatients)
+	if err != nil {
+		return nil, err
+	}
+
+	byUCN := make(map[string][]*Patient)
+	byPhone := make(map[string][]*Patient)
+	for _, p := range patients {
+		byUCN[p.UCN] = append(byUCN[p.UCN], p)
+		if phone := normalizePhone(p.PhoneNumber); phone != "" {
+			byPhone[phone] = append(byPhone[phone], p)
+		}
+	}
+
+	type pair struct{ a, b int }
+	seen := make(map[pair]bool)
+
+	var duplicates []*Duplicate
+	for _, groups := range []map[string][]*Patient{byUCN, byPhone} {
+		for _, group := range groups {
+			for i, a := range group {
+				for _, b := range group[i+1:] {
+					if seen[pair{a.ID, b.ID}] {
+						continue
+					}
+
+					if reason := duplicateReason(a, b); reason != "" {
+						seen[pair{a.ID, b.ID}] = true
+						duplicates = append(duplicates, &Duplicate{Patient: a, Match: b, Reason: reason})
+					}
+				}
+			}
+		}
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].Patient.ID != duplicates[j].Patient.ID {
+			return duplicates[i].Patient.ID < duplicates[j].Patient.ID
+		}
+		return duplicates[i].Match.ID < duplicates[j].Match.ID
+	})
+
+	return duplicates, nil
+}
+
+// returns the patients which are not deleted and match the condition with their personal data opened, ordered by id,
+// for the application to compare them; returns ErrTooManyToMatch rather than opening more than MaxApplicationMatches
+func (m *PatientModel) getCandidates(condition string, args ...any) ([]*Patient, error) {
+	where := " FROM patients WHERE (" + condition + ") AND deleted_at IS NULL"
+
+	var candidates int
+	if err := m.DB.QueryRow("SELECT COUNT(*)"+where, args...).Scan(&candidates); err != nil {
+		return nil, err
+	}
+
+	if candidates > MaxApplicationMatches {
+		return nil, ErrTooManyToMatch
+	}
+
+	rows, err := m.DB.Query("SELECT "+patientColumns+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var patients []*Patient
+	for rows.Next() {
+		p, err := scanPatient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = decryptPatient(m.Keys, p); err != nil {
+			return nil, err
+		}
+		patients = append(patients, p)
+	}
+
+	return patients, rows.Err()
+}
+
+// reports why the two patients probably are the same person, empty if they probably are not
+func duplicateReason(a *Patient, b *Patient) string {
+	if a.UCN == b.UCN {
+		return DuplicateReasonUCN
+	}
+
+	phone := normalizePhone(a.PhoneNumber)
+	if phone == "" || phone != normalizePhone(b.PhoneNumber) {
+		return ""
+	}
+
+	name := normalizeName(a.FirstName + " " + a.LastName)
+	// the first and last names are often swapped when a patient is registered in a hurry
+	if levenshtein(name, normalizeName(b.FirstName+" "+b.LastName)) <= maxNameDistance ||
+		levenshtein(name, normalizeName(b.LastName+" "+b.FirstName)) <= maxNameDistance {
+		return DuplicateReasonName
+	}
+
+	return ""
+}
+
+// keeps only the digits of the phone number, so that differently formatted numbers compare equal
+func normalizePhone(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, phone)
+}
+
+// the index of the phone number which patients sharing it are found by: the blind index of its digits, or the digits
+// themselves if encryption is not enabled, the phone number being stored in plaintext then anyway
+func phoneIndex(keys *encryption.Keyring, phone string) string {
+	digits := normalizePhone(phone)
+	if keys == nil || digits == "" {
+		return digits
+	}
+	return keys.BlindIndex(digits)
+}
+
+// returns every index a patient with the phone number may be found by, under any of the keys as well as from before
+// encryption was enabled, none if the phone number has no digits
+func phoneIndexes(keys *encryption.Keyring, phone string) []string {
+	digits := normalizePhone(phone)
+	if digits == "" {
+		return nil
+	}
+	return append([]string{digits}, keys.BlindIndexes(digits)...)
+}
+
+// lowers the case of the name and collapses its whitespace
+func normalizeName(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), unicode.IsSpace), " ")
+}
+
+// the number of single letter insertions, deletions and substitutions turning a into b
+func levenshtein(a string, b string) int {
+	s, t := []rune(a), []rune(b)
+
+	previous := make([]int, len(t)+1)
+	current := make([]int, len(t)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := range s {
+		current[0] = i + 1
+		for j := range t {
+			cost := 1
+			if s[i] == t[j] {
+				cost = 0
+			}
+			current[j+1] = min(previous[j+1]+1, current[j]+1, previous[j]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(t)]
+}
+
+// the tables whose rows belong to a patient, all of which are handed over to the patient a duplicate is merged into
+var patientTables = []string{"prescriptions", "measurements", "patient_notes", "attachments", "therapy_transitions", "patient_transfers"}
+
+// merges the duplicate into the patient, which keeps its own details and therapy status while taking over the
+// duplicate's prescriptions, measurements, notes, attachments, therapy and transfer history; the duplicate's owner and
+// co-owners become co-owners of the patient so that none of them loses access, its pending transfers are cancelled
+// and it is moved to the trash, from where it cannot be restored
+func (m *PatientModel) Merge(id int, duplicateId int, userId int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	patient, err := getPatientForUpdate(tx, m.Dialect, id, false)
+	if err != nil {
+		return err
+	}
+
+	duplicate, err := getPatientForUpdate(tx, m.Dialect, duplicateId, false)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE patient_transfers SET status = ?, resolved = UTC_TIMESTAMP(), resolved_by = ? WHERE patient_id = ? AND status = ?"
+	_, err = tx.Exec(stmt, TransferStatusCancelled, userId, duplicateId, TransferStatusPending)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range patientTables {
+		_, err = tx.Exec("UPDATE "+table+" SET patient_id = ? WHERE patient_id = ?", id, duplicateId)
+		if err != nil {
+			return err
+		}
+	}
+
+	before, err := getCoOwnerIds(tx, id)
+	if err != nil {
+		return err
+	}
+
+	duplicateCoOwners, err := getCoOwnerIds(tx, duplicateId)
+	if err != nil {
+		return err
+	}
+
+	for _, coOwnerId := range append(duplicateCoOwners, duplicate.UserId) {
+		if coOwnerId == patient.UserId || slices.Contains(before, coOwnerId) {
+			continue
+		}
+
+		_, err = tx.Exec("INSERT INTO patient_co_owners (patient_id, user_id, created) VALUES (?, ?, UTC_TIMESTAMP())", id, coOwnerId)
+		if err != nil {
+			return err
+		}
+	}
+
+	after, err := getCoOwnerIds(tx, id)
+	if err != nil {
+		return err
+	}
+
+	stmt = "UPDATE patients SET deleted_at = UTC_TIMESTAMP(), deleted_by = ?, merged_into = ? WHERE id = ?"
+	_, err = tx.Exec(stmt, userId, id, duplicateId)
+	if err != nil {
+		return err
+	}
+
+	merged := coOwnerSnapshot(after)
+	merged["merged_patient_id"] = duplicateId
+
+	err = insertAuditEntry(tx, userId, AuditActionMerge, AuditEntityPatient, id, coOwnerSnapshot(before), merged)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionMerge, AuditEntityPatient, duplicateId, duplicate, map[string]any{"merged_into": id})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
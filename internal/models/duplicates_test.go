@@ -0,0 +1,162 @@
+package models_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"p-system.okostadinov.net/internal/encryption"
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/sqlite"
+	"p-system.okostadinov.net/internal/testdb"
+)
+
+type duplicatePair struct {
+	patient, match int
+	reason         string
+}
+
+func duplicatePairs(duplicates []*models.Duplicate) []duplicatePair {
+	pairs := make([]duplicatePair, len(duplicates))
+	for i, d := range duplicates {
+		pairs[i] = duplicatePair{d.Patient.ID, d.Match.ID, d.Reason}
+	}
+	return pairs
+}
+
+func TestDuplicates(t *testing.T) {
+	for _, driver := range testdb.Drivers() {
+		for name, keys := range map[string]*encryption.Keyring{"plaintext": nil, "sealed": newKeyring(t, 1)} {
+			t.Run(driver+"/"+name, func(t *testing.T) {
+				db, dialect := testdb.Open(t, driver)
+				stores := models.NewStores(db, dialect, keys)
+
+				insertUsers(t, stores, "alice")
+				const alice = 1
+
+				for _, p := range []struct{ ucn, first, last, phone string }{
+					{"7501020018", "Ivan", "Petrov", "0888 123 456"},
+					{"8506150090", "Petrov", "Ivan", "0888-123-456"},
+					{"9001010006", "Ivan", "Petrova", "0888123456"},
+					{"7501020018", "Maria", "Georgieva", "0899111222"},
+					{"6201010003", "Ivan", "Petrov", "0877654321"},
+					{"9001010006", "Georgi", "Ivanov", ""},
+				} {
+					if _, err := stores.Patients.Insert(p.ucn, p.first, p.last, p.phone, 180, 80, "", alice); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				want := []duplicatePair{
+					{1, 2, models.DuplicateReasonName},
+					{1, 3, models.DuplicateReasonName},
+					{1, 4, models.DuplicateReasonUCN},
+					{2, 3, models.DuplicateReasonName},
+					{3, 6, models.DuplicateReasonUCN},
+				}
+
+				duplicates, err := stores.Patients.GetDuplicates()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := duplicatePairs(duplicates); !slices.Equal(got, want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+
+				// the new patient shares the phone number, formatted otherwise, of the first three and the UCN of the last
+				duplicates, err = stores.Patients.FindDuplicates("6201010003", "ivan", "petrov", "+0888 12 34 56")
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := duplicatePairs(duplicates)
+				want = []duplicatePair{
+					{0, 1, models.DuplicateReasonName},
+					{0, 2, models.DuplicateReasonName},
+					{0, 3, models.DuplicateReasonName},
+					{0, 5, models.DuplicateReasonUCN},
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("got %v, want %v", got, want)
+				}
+
+				duplicates, err = stores.Patients.FindDuplicates("5001010000", "Georgi", "Ivanov", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(duplicates) != 0 {
+					t.Errorf("a patient without a phone number got %v", duplicatePairs(duplicates))
+				}
+			})
+		}
+	}
+}
+
+// the patients sealed before their phone number was indexed are compared by it once the keys are rotated
+func TestDuplicatesOfUnindexedPhoneNumbers(t *testing.T) {
+	db, _ := testdb.Open(t, "sqlite")
+	keys := newKeyring(t, 1)
+	stores := models.NewStores(db, sqlite.Dialect, keys)
+
+	insertUsers(t, stores, "alice")
+	insertPatient(t, stores, "7501020018", "Petrov", 180, 1)
+	insertPatient(t, stores, "8506150090", "Petrova", 180, 1)
+
+	if _, err := db.Exec("UPDATE patients SET phone_index = ''"); err != nil {
+		t.Fatal(err)
+	}
+
+	duplicates, err := stores.Patients.GetDuplicates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(duplicates) != 0 {
+		t.Fatalf("got %v before the phone numbers were indexed", duplicatePairs(duplicates))
+	}
+
+	rotation := &models.KeyRotation{DB: db, Dialect: sqlite.Dialect, Keys: keys, BatchSize: 10}
+	if n, err := rotation.Patients(); err != nil || n != 2 {
+		t.Fatalf("indexed %d patients, %v", n, err)
+	}
+
+	duplicates, err = stores.Patients.GetDuplicates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := duplicatePairs(duplicates), []duplicatePair{{1, 2, models.DuplicateReasonName}}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDuplicatesMatchLimit(t *testing.T) {
+	db, _ := testdb.Open(t, "sqlite")
+	stores := models.NewStores(db, sqlite.Dialect, nil)
+
+	insertUsers(t, stores, "alice")
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a placeholder phone number entered for every patient
+	for i := 0; i <= models.MaxApplicationMatches; i++ {
+		_, err = tx.Exec("INSERT INTO patients (ucn, first_name, last_name, phone_number, phone_index, height, weight, user_id) VALUES (?, '', '', '0', '0', 180, 80, 1)", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = stores.Patients.GetDuplicates()
+	if !errors.Is(err, models.ErrTooManyToMatch) {
+		t.Errorf("listing got %v, want %v", err, models.ErrTooManyToMatch)
+	}
+
+	_, err = stores.Patients.FindDuplicates("7501020018", "Ivan", "Petrov", "0")
+	if !errors.Is(err, models.ErrTooManyToMatch) {
+		t.Errorf("finding got %v, want %v", err, models.ErrTooManyToMatch)
+	}
+}
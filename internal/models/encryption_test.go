@@ -0,0 +1,353 @@
+package models_test
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"p-system.okostadinov.net/internal/encryption"
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/sqlite"
+	"p-system.okostadinov.net/internal/testdb"
+)
+
+// returns a keyring of the given versions, each with a distinct key
+func newKeyring(t *testing.T, versions ...int) *encryption.Keyring {
+	t.Helper()
+
+	var entries []string
+	for _, v := range versions {
+		key := strings.Repeat(string(rune('a'+v)), 32)
+		entries = append(entries, string(rune('0'+v))+":"+base64.StdEncoding.EncodeToString([]byte(key)))
+	}
+
+	keys, err := encryption.ParseKeys(strings.Join(entries, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// fails the test if any of the rows of the query contain any of the plaintexts in any column
+func assertSealed(t *testing.T, db *sql.DB, query string, plaintexts ...string) {
+	t.Helper()
+
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if err = rows.Scan(dest...); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, value := range values {
+			for _, plaintext := range plaintexts {
+				if strings.Contains(strings.ToLower(value.String), strings.ToLower(plaintext)) {
+					t.Errorf("%s: column %s stores %q in plaintext: %s", query, columns[i], plaintext, value.String)
+				}
+			}
+		}
+		count++
+	}
+
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count == 0 {
+		t.Errorf("%s: no rows", query)
+	}
+}
+
+func TestPatientEncryption(t *testing.T) {
+	db, _ := testdb.Open(t, "sqlite")
+	keys := newKeyring(t, 1)
+	stores := models.NewStores(db, sqlite.Dialect, keys)
+
+	if err := stores.Users.Insert("Alice", "alice@example.com", "password123"); err != nil {
+		t.Fatal(err)
+	}
+	const userId = 1
+
+	const (
+		ucn       = "7501020018"
+		firstName = "Ivan"
+		lastName  = "Petrov"
+		phone     = "0888123456"
+		note      = "Allergic to penicillin"
+	)
+
+	id, err := stores.Patients.Insert(ucn, firstName, lastName, phone, 180, 80, note, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an update leaves both the former and the new values in the audit log
+	const updatedPhone = "0877654321"
+	if err = stores.Patients.Update(id, ucn, firstName, lastName, updatedPhone, 181, 80, userId); err != nil {
+		t.Fatal(err)
+	}
+
+	const followUp = "Follow-up with cardiology"
+	if _, err = stores.Notes.Insert(id, models.NoteTypeGeneral, followUp, userId); err != nil {
+		t.Fatal(err)
+	}
+
+	err = stores.Audit.InsertExport(&models.PatientExport{Format: "csv", Columns: []string{"ucn"}, Filter: models.PatientFilter{Query: lastName}}, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("rows hold no plaintext", func(t *testing.T) {
+		assertSealed(t, db, "SELECT ucn, first_name, last_name, phone_number, ucn_index FROM patients", ucn, firstName, lastName, phone, updatedPhone)
+		assertSealed(t, db, "SELECT body FROM patient_notes", note, followUp, "penicillin", "cardiology")
+		assertSealed(t, db, "SELECT before_values, after_values FROM audit_log", ucn, firstName, lastName, phone, updatedPhone, note, followUp)
+	})
+
+	t.Run("values open again", func(t *testing.T) {
+		p, err := stores.Patients.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.UCN != ucn || p.FirstName != firstName || p.LastName != lastName || p.PhoneNumber != updatedPhone || p.Note != followUp {
+			t.Errorf("got %s %s %s %s %q", p.UCN, p.FirstName, p.LastName, p.PhoneNumber, p.Note)
+		}
+	})
+
+	t.Run("lookup by UCN through the blind index", func(t *testing.T) {
+		var index string
+		if err := db.QueryRow("SELECT ucn_index FROM patients WHERE id = ?", id).Scan(&index); err != nil {
+			t.Fatal(err)
+		}
+
+		if index != keys.BlindIndex(ucn) {
+			t.Errorf("got blind index %q, want %q", index, keys.BlindIndex(ucn))
+		}
+
+		patients, err := stores.Patients.GetAllByUCN(ucn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(patients) != 1 || patients[0].ID != id || patients[0].UCN != ucn {
+			t.Fatalf("got %d patients", len(patients))
+		}
+
+		patients, err = stores.Patients.GetAllByUCN("7501020023")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(patients) != 0 {
+			t.Errorf("found %d patients by another UCN", len(patients))
+		}
+	})
+
+	t.Run("search by sealed data", func(t *testing.T) {
+		for _, query := range []string{"petrov", "Ivan Petrov", "0877", "7501", "penicillin", "cardiology"} {
+			patients, metadata, err := stores.Patients.List(models.PatientFilter{Query: query})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(patients) != 1 || patients[0].ID != id || metadata.TotalRecords != 1 {
+				t.Errorf("%q found %d patients", query, len(patients))
+			}
+		}
+
+		patients, _, err := stores.Patients.List(models.PatientFilter{Query: "ivanov"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(patients) != 0 {
+			t.Errorf("found %d patients by a name they do not have", len(patients))
+		}
+	})
+
+	t.Run("lookup after the keys rotate", func(t *testing.T) {
+		// the patient keeps the blind index of the first key until it is sealed again with the second
+		rotated := models.NewStores(db, sqlite.Dialect, newKeyring(t, 1, 2))
+
+		patients, err := rotated.Patients.GetAllByUCN(ucn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(patients) != 1 || patients[0].ID != id {
+			t.Fatalf("got %d patients", len(patients))
+		}
+	})
+
+	t.Run("lookup of data stored before encryption", func(t *testing.T) {
+		plain := models.NewStores(db, sqlite.Dialect, nil)
+
+		const legacyUCN = "8506150090"
+		legacyId, err := plain.Patients.Insert(legacyUCN, "Maria", "Georgieva", "0899111222", 165, 60, "", userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		patients, err := stores.Patients.GetAllByUCN(legacyUCN)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(patients) != 1 || patients[0].ID != legacyId || patients[0].FirstName != "Maria" {
+			t.Fatalf("got %d patients", len(patients))
+		}
+	})
+}
+
+func TestPatientEncryptionMatchLimit(t *testing.T) {
+	db, _ := testdb.Open(t, "sqlite")
+	stores := models.NewStores(db, sqlite.Dialect, newKeyring(t, 1))
+
+	if err := stores.Users.Insert("Alice", "alice@example.com", "password123"); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i <= models.MaxApplicationMatches; i++ {
+		_, err = tx.Exec("INSERT INTO patients (ucn, first_name, last_name, phone_number, height, weight, user_id) VALUES ('', '', '', '', 180, 80, 1)")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = stores.Patients.List(models.PatientFilter{Query: "petrov"})
+	if !errors.Is(err, models.ErrTooManyToMatch) {
+		t.Errorf("searching got %v, want %v", err, models.ErrTooManyToMatch)
+	}
+
+	err = stores.Patients.Export(models.PatientFilter{Sort: "last_name"}, func(*models.Patient) error { return nil })
+	if !errors.Is(err, models.ErrTooManyToMatch) {
+		t.Errorf("exporting got %v, want %v", err, models.ErrTooManyToMatch)
+	}
+
+	// lists the database matches by itself are not limited
+	_, metadata, err := stores.Patients.List(models.PatientFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.TotalRecords != models.MaxApplicationMatches+1 {
+		t.Errorf("got %d patients, want %d", metadata.TotalRecords, models.MaxApplicationMatches+1)
+	}
+}
+
+// values typed in which read like sealed ones are stored and shown as they were typed, whether encryption is enabled or
+// not, and are sealed like any other value once it is
+func TestValuesReadingAsSealed(t *testing.T) {
+	const typed = "enc:1:abc"
+
+	for _, driver := range testdb.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			db, dialect := testdb.Open(t, driver)
+			stores := models.NewStores(db, dialect, nil)
+
+			insertUsers(t, stores, "alice")
+			const alice = 1
+
+			medicationId, err := stores.Medications.Insert(typed, "ingredient", "10", "mg", "tablet", "", "", alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id, err := stores.Patients.Insert("7501020018", typed, "Petrov", "0888123456", 180, 80, typed, alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = stores.Prescriptions.Insert(id, medicationId, "1 tablet", "daily", time.Now().UTC(), nil, "", alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertTyped := func(t *testing.T, stores *models.Stores) {
+				t.Helper()
+
+				p, err := stores.Patients.Get(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.FirstName != typed || p.Note != typed {
+					t.Errorf("got first name %q, note %q", p.FirstName, p.Note)
+				}
+
+				medication, err := stores.Medications.Get(medicationId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if medication.Name != typed {
+					t.Errorf("got medication %q", medication.Name)
+				}
+
+				entries, err := stores.Audit.GetAllByEntity(models.AuditEntityMedication, medicationId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(entries) != 1 || entries[0].After["name"] != typed {
+					t.Errorf("got %d medication audit entries", len(entries))
+				}
+
+				entries, err = stores.Audit.GetAllByPatient(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if inserted := entries[len(entries)-1]; inserted.After["first_name"] != typed {
+					t.Errorf("got first name %v in the audit log", inserted.After["first_name"])
+				}
+			}
+
+			assertTyped(t, stores)
+
+			t.Run("once encryption is enabled", func(t *testing.T) {
+				keys := newKeyring(t, 1)
+				rotation := &models.KeyRotation{DB: db, Dialect: dialect, Keys: keys, BatchSize: 10}
+				for _, rotate := range []func() (int, error){rotation.Patients, rotation.Notes, rotation.AuditLog} {
+					if _, err := rotate(); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				var firstName string
+				if err := db.QueryRow("SELECT first_name FROM patients WHERE id = ?", id).Scan(&firstName); err != nil {
+					t.Fatal(err)
+				}
+				if encryption.Version(firstName) != 1 {
+					t.Errorf("got first name %q stored", firstName)
+				}
+
+				assertTyped(t, models.NewStores(db, dialect, keys))
+			})
+		})
+	}
+}
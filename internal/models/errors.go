@@ -3,9 +3,22 @@ package models
 import "errors"
 
 var (
-	ErrNoRecord           = errors.New("no record")
-	ErrDuplicateEmail     = errors.New("duplicate email")
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrUnauthorizedAction = errors.New("authorized action")
-	ErrExistingDependency = errors.New("existing dependency")
+	ErrNoRecord            = errors.New("no record")
+	ErrDuplicateEmail      = errors.New("duplicate email")
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrExistingDependency  = errors.New("existing dependency")
+	ErrDuplicateMedication = errors.New("duplicate medication")
+	ErrDeletedDependency   = errors.New("deleted dependency")
+	ErrDuplicateCoOwner    = errors.New("duplicate co-owner")
+	ErrPendingTransfer     = errors.New("pending transfer")
+	ErrStoppedPrescription = errors.New("stopped prescription")
+	ErrInvalidTransition   = errors.New("invalid transition")
+	ErrCommentRequired     = errors.New("comment required")
+	ErrExpiryRequired      = errors.New("expiry required")
+	ErrNoteLocked          = errors.New("note locked")
+	ErrMergedPatient       = errors.New("merged patient")
+	ErrInvalidResetToken   = errors.New("invalid reset token")
+	ErrInvalidCode         = errors.New("invalid code")
+	ErrTwoFactorLocked     = errors.New("two-factor locked")
+	ErrTooManyToMatch      = errors.New("too many to match")
 )
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"p-system.okostadinov.net/internal/encryption"
+)
+
+// columns the patient lists may be sorted by, mapped to their database column
+var PatientSortColumns = map[string]string{
+	"id":         "id",
+	"ucn":        "ucn",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"height":     "height",
+	"weight":     "weight",
+}
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// combinable criteria for listing patients; zero values disable the respective filter
+type PatientFilter struct {
+	Query         string        `json:"query,omitempty"`
+	MedicationId  int           `json:"medication_id,omitempty"`
+	UserId        int           `json:"user_id,omitempty"`
+	TherapyStatus TherapyStatus `json:"therapy_status,omitempty"`
+	MinAge        int           `json:"min_age,omitempty"`
+	MaxAge        int           `json:"max_age,omitempty"`
+	Sort          string        `json:"sort,omitempty"`
+	Direction     string        `json:"direction,omitempty"`
+	Page          int           `json:"page,omitempty"`
+	PageSize      int           `json:"page_size,omitempty"`
+}
+
+// pagination details accompanying a filtered list
+type Metadata struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	FirstPage    int `json:"first_page"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+// builds the WHERE clause and its positional arguments from the enabled filters
+func (f PatientFilter) where(d Dialect) (string, []any) {
+	var (
+		conditions = []string{"deleted_at IS NULL"}
+		args       []any
+	)
+
+	if f.Query != "" {
+		condition, searchArgs := d.SearchCondition(f.Query)
+		conditions = append(conditions, condition)
+		args = append(args, searchArgs...)
+	}
+
+	if f.MedicationId != 0 {
+		conditions = append(conditions, "EXISTS(SELECT true FROM prescriptions pr WHERE pr.patient_id = patients.id AND pr.medication_id = ? AND "+activePrescription+")")
+		args = append(args, f.MedicationId)
+	}
+
+	if f.UserId != 0 {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, f.UserId)
+	}
+
+	if f.TherapyStatus != "" {
+		conditions = append(conditions, "therapy_status = ?")
+		args = append(args, f.TherapyStatus)
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// builds the ORDER BY clause, ranking search results by relevance unless an explicit sort column was requested
+func (f PatientFilter) orderBy(d Dialect) (string, []any) {
+	if f.Query != "" && f.Sort == "" {
+		order, args := d.SearchOrder(f.Query)
+		return " ORDER BY " + order + ", id DESC", args
+	}
+
+	direction := f.sortDirection()
+	return fmt.Sprintf(" ORDER BY %s %s, id %s", f.sortColumn(), direction, direction), nil
+}
+
+// the sort columns holding personal data, which the database can no longer order by once it is sealed
+var sealedSortColumns = map[string]bool{"ucn": true, "first_name": true, "last_name": true}
+
+// reports whether the patients have to be matched by the application rather than the database, which is the case for
+// lists filtered by age, as it is encoded in the UCN, and for those searched or sorted by personal data sealed by the
+// keys, which are then searched and sorted by matches and sort
+func (f PatientFilter) inApplication(keys *encryption.Keyring) bool {
+	return f.MinAge > 0 || f.MaxAge > 0 || keys != nil && (f.Query != "" || sealedSortColumns[f.Sort])
+}
+
+// reports whether the patient's age as of today is within the bounds of the filter; patients whose UCN does not
+// encode their date of birth only match lists which are not filtered by age
+func (f PatientFilter) matchesAge(p *Patient, today time.Time) bool {
+	if f.MinAge == 0 && f.MaxAge == 0 {
+		return true
+	}
+
+	info := p.UCNInfo()
+	if info == nil {
+		return false
+	}
+
+	age := info.Age(today)
+	return age >= f.MinAge && (f.MaxAge == 0 || age <= f.MaxAge)
+}
+
+// reports whether the patient matches the search query like the SQLite dialect's search condition does: every term has
+// to appear in its name, its phone number or one of the texts it is searched by, or the query has to prefix its UCN
+func (f PatientFilter) matches(p *Patient, texts []string) bool {
+	if strings.HasPrefix(p.UCN, strings.TrimSpace(f.Query)) {
+		return true
+	}
+
+	terms := SearchTerms(f.Query)
+	if len(terms) == 0 {
+		return false
+	}
+
+	fields := append([]string{p.FirstName, p.LastName, p.PhoneNumber}, texts...)
+	for i := range fields {
+		fields[i] = strings.ToLower(fields[i])
+	}
+
+	for _, term := range terms {
+		term = strings.ToLower(term)
+
+		found := false
+		for _, field := range fields {
+			if strings.Contains(field, term) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// orders the patients as orderBy would, UCN matches coming first in search results
+func (f PatientFilter) sort(patients []*Patient) {
+	if f.Query != "" && f.Sort == "" {
+		prefix := strings.TrimSpace(f.Query)
+		sort.SliceStable(patients, func(i, j int) bool {
+			a, b := strings.HasPrefix(patients[i].UCN, prefix), strings.HasPrefix(patients[j].UCN, prefix)
+			if a != b {
+				return a
+			}
+			return patients[i].ID > patients[j].ID
+		})
+		return
+	}
+
+	column, desc := f.sortColumn(), f.sortDirection() == "DESC"
+	sort.SliceStable(patients, func(i, j int) bool {
+		c := comparePatients(column, patients[i], patients[j])
+		if c == 0 {
+			c = cmp.Compare(patients[i].ID, patients[j].ID)
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+func comparePatients(column string, a *Patient, b *Patient) int {
+	switch column {
+	case "ucn":
+		return strings.Compare(a.UCN, b.UCN)
+	case "first_name":
+		return strings.Compare(strings.ToLower(a.FirstName), strings.ToLower(b.FirstName))
+	case "last_name":
+		return strings.Compare(strings.ToLower(a.LastName), strings.ToLower(b.LastName))
+	case "height":
+		return cmp.Compare(a.Height, b.Height)
+	case "weight":
+		return cmp.Compare(a.Weight, b.Weight)
+	default:
+		return 0
+	}
+}
+
+// splits a search query into words, dropping the characters that carry meaning in MySQL's boolean full-text mode
+func SearchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// whitelists the sort column, since it cannot be passed as a placeholder argument
+func (f PatientFilter) sortColumn() string {
+	if column, ok := PatientSortColumns[f.Sort]; ok {
+		return column
+	}
+	return "id"
+}
+
+func (f PatientFilter) sortDirection() string {
+	if strings.ToLower(f.Direction) == "desc" {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+func (f PatientFilter) limit() int {
+	if f.PageSize < 1 || f.PageSize > MaxPageSize {
+		return DefaultPageSize
+	}
+	return f.PageSize
+}
+
+func (f PatientFilter) page() int {
+	if f.Page < 1 {
+		return 1
+	}
+	return f.Page
+}
+
+func (f PatientFilter) offset() int {
+	return (f.page() - 1) * f.limit()
+}
+
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{CurrentPage: page, PageSize: pageSize}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
+
+func (m Metadata) HasPrevious() bool {
+	return m.CurrentPage > m.FirstPage && m.FirstPage != 0
+}
+
+func (m Metadata) HasNext() bool {
+	return m.CurrentPage < m.LastPage
+}
+
+func (m Metadata) PreviousPage() int {
+	return m.CurrentPage - 1
+}
+
+func (m Metadata) NextPage() int {
+	return m.CurrentPage + 1
+}
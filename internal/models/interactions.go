@@ -0,0 +1,198 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	InteractionSeverityMinor    = "minor"
+	InteractionSeverityModerate = "moderate"
+	InteractionSeveritySevere   = "severe"
+)
+
+var InteractionSeverities = []string{InteractionSeverityMinor, InteractionSeverityModerate, InteractionSeveritySevere}
+
+// a known interaction between two active ingredients, stored in lower case with the ingredients in alphabetical order
+// so that each pair is recorded only once
+type Interaction struct {
+	ID          int
+	IngredientA string
+	IngredientB string
+	Severity    string
+	Description string
+	Created     time.Time
+}
+
+// an interaction between a medication about to be prescribed and one the patient is already on
+type InteractionWarning struct {
+	Interaction
+	Medication string
+}
+
+type InteractionModel struct {
+	DB *sql.DB
+}
+
+// normalizes an ingredient pair into the form interactions are stored and looked up in
+func InteractionPair(a, b string) (string, string) {
+	a, b = normalizeIngredient(a), normalizeIngredient(b)
+	if b < a {
+		return b, a
+	}
+	return a, b
+}
+
+func normalizeIngredient(ingredient string) string {
+	return strings.ToLower(strings.Join(strings.Fields(ingredient), " "))
+}
+
+// ranks the severities for sorting, the most severe first
+const interactionSeverityRank = "CASE i.severity WHEN 'severe' THEN 0 WHEN 'moderate' THEN 1 ELSE 2 END"
+
+// returns all known interactions, the most severe first
+func (m *InteractionModel) GetAll() ([]*Interaction, error) {
+	var interactions []*Interaction
+
+	stmt := "SELECT i.id, i.ingredient_a, i.ingredient_b, i.severity, i.description, i.created FROM interactions i ORDER BY " + interactionSeverityRank + ", i.ingredient_a, i.ingredient_b"
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i Interaction
+
+		err := rows.Scan(&i.ID, &i.IngredientA, &i.IngredientB, &i.Severity, &i.Description, &i.Created)
+		if err != nil {
+			return nil, err
+		}
+		interactions = append(interactions, &i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return interactions, nil
+}
+
+// adds the interactions in a single transaction, replacing the severity and description of the pairs already known,
+// and returns how many were added and how many replaced
+func (m *InteractionModel) Import(interactions []*Interaction) (int, int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var inserted, updated int
+	for _, i := range interactions {
+		a, b := InteractionPair(i.IngredientA, i.IngredientB)
+
+		var id int
+		err = tx.QueryRow("SELECT id FROM interactions WHERE ingredient_a = ? AND ingredient_b = ?", a, b).Scan(&id)
+
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			stmt := "INSERT INTO interactions (ingredient_a, ingredient_b, severity, description, created) VALUES (?, ?, ?, ?, UTC_TIMESTAMP())"
+			_, err = tx.Exec(stmt, a, b, i.Severity, i.Description)
+			inserted++
+		case err == nil:
+			_, err = tx.Exec("UPDATE interactions SET severity = ?, description = ? WHERE id = ?", i.Severity, i.Description, id)
+			updated++
+		}
+
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return inserted, updated, tx.Commit()
+}
+
+func (m *InteractionModel) Delete(id int) error {
+	result, err := m.DB.Exec("DELETE FROM interactions WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// checks the medication against the patient's other prescriptions which have not been stopped and overlap the
+// given period, returning the interactions found, the most severe first; excludeId leaves out the prescription
+// being modified, if any
+func (m *InteractionModel) CheckPrescription(patientId int, medicationId int, startDate time.Time, endDate *time.Time, excludeId int) ([]*InteractionWarning, error) {
+	var warnings []*InteractionWarning
+
+	var ingredient string
+	err := m.DB.QueryRow("SELECT active_ingredient FROM medications WHERE id = ?", medicationId).Scan(&ingredient)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	ingredient = normalizeIngredient(ingredient)
+	if ingredient == "" {
+		return nil, nil
+	}
+
+	stmt := `SELECT i.id, i.ingredient_a, i.ingredient_b, i.severity, i.description, i.created, ` + medicationLabel + `
+	FROM prescriptions pr
+	JOIN medications m ON m.id = pr.medication_id
+	JOIN interactions i ON (i.ingredient_a = ? AND i.ingredient_b = LOWER(m.active_ingredient)) OR (i.ingredient_b = ? AND i.ingredient_a = LOWER(m.active_ingredient))
+	WHERE pr.patient_id = ? AND pr.id <> ? AND pr.status = 'active'
+	AND (pr.end_date IS NULL OR pr.end_date >= ?) AND (? IS NULL OR pr.start_date <= ?)
+	ORDER BY ` + interactionSeverityRank + `, pr.start_date, pr.id`
+
+	end := formatDate(endDate)
+
+	rows, err := m.DB.Query(stmt, ingredient, ingredient, patientId, excludeId, startDate.Format(DateLayout), end, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var w InteractionWarning
+
+		err := rows.Scan(&w.ID, &w.IngredientA, &w.IngredientB, &w.Severity, &w.Description, &w.Created, &w.Medication)
+		if err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, &w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return warnings, nil
+}
+
+// reports whether any of the interactions is severe enough to require a reason for prescribing anyway
+func HasSevereInteraction(warnings []*InteractionWarning) bool {
+	for _, w := range warnings {
+		if w.Severity == InteractionSeveritySevere {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// a patient's vitals as measured on a given date, height in centimeters, weight in kilograms and the optional blood
+// pressure in mmHg
+type Measurement struct {
+	ID        int
+	PatientId int
+	Measured  time.Time
+	Height    int
+	Weight    int
+	Systolic  *int
+	Diastolic *int
+	UserId    int
+	UserName  string
+	Created   time.Time
+}
+
+// the body mass index in kg/m²
+func (m *Measurement) BMI() float64 {
+	if m.Height <= 0 {
+		return 0
+	}
+	meters := float64(m.Height) / 100
+	return float64(m.Weight) / (meters * meters)
+}
+
+// the body surface area in m², as estimated by the Mosteller formula which dosing is commonly based on
+func (m *Measurement) BSA() float64 {
+	return math.Sqrt(float64(m.Height*m.Weight) / 3600)
+}
+
+// the WHO weight category of the body mass index
+func (m *Measurement) BMICategory() string {
+	switch bmi := m.BMI(); {
+	case bmi < 18.5:
+		return "Underweight"
+	case bmi < 25:
+		return "Normal"
+	case bmi < 30:
+		return "Overweight"
+	default:
+		return "Obese"
+	}
+}
+
+// the blood pressure as usually written, e.g. "120/80", or an empty string if it was not measured
+func (m *Measurement) BloodPressure() string {
+	if m.Systolic == nil || m.Diastolic == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", *m.Systolic, *m.Diastolic)
+}
+
+type MeasurementModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+// records the patient's vitals; unless the measurement is older than the latest one, the height and weight it
+// reports become the patient's current ones, which is audited as a change to the patient
+func (m *MeasurementModel) Insert(patientId int, measured time.Time, height int, weight int, systolic *int, diastolic *int, userId int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	before, err := getPatientForUpdate(tx, m.Dialect, patientId, false)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := insertMeasurement(tx, patientId, measured, height, weight, systolic, diastolic, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	var later bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT true FROM measurements WHERE patient_id = ? AND measured > ?)", patientId, measured.Format(DateLayout)).Scan(&later)
+	if err != nil {
+		return 0, err
+	}
+
+	if !later && (before.Height != height || before.Weight != weight) {
+		_, err = tx.Exec("UPDATE patients SET height = ?, weight = ? WHERE id = ?", height, weight, patientId)
+		if err != nil {
+			return 0, err
+		}
+
+		after, err := getPatientForUpdate(tx, m.Dialect, patientId, false)
+		if err != nil {
+			return 0, err
+		}
+
+		err = insertAuditEntry(tx, userId, AuditActionUpdate, AuditEntityPatient, patientId, before, after)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+// records a measurement as part of a transaction which also keeps the patient's current height and weight up to date
+func insertMeasurement(tx *sql.Tx, patientId int, measured time.Time, height int, weight int, systolic *int, diastolic *int, userId int) (int, error) {
+	stmt := "INSERT INTO measurements (patient_id, measured, height, weight, systolic, diastolic, user_id, created) VALUES (?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())"
+
+	result, err := tx.Exec(stmt, patientId, measured.Format(DateLayout), height, weight, systolic, diastolic, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// returns the patient's measurements, the most recent first
+func (m *MeasurementModel) GetAllByPatient(patientId int) ([]*Measurement, error) {
+	var measurements []*Measurement
+
+	stmt := `SELECT m.id, m.patient_id, m.measured, m.height, m.weight, m.systolic, m.diastolic, m.user_id, u.name, m.created
+	FROM measurements m JOIN users u ON u.id = m.user_id
+	WHERE m.patient_id = ? ORDER BY m.measured DESC, m.id DESC`
+
+	rows, err := m.DB.Query(stmt, patientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ms Measurement
+
+		err := rows.Scan(&ms.ID, &ms.PatientId, &ms.Measured, &ms.Height, &ms.Weight, &ms.Systolic, &ms.Diastolic, &ms.UserId, &ms.UserName, &ms.Created)
+		if err != nil {
+			return nil, err
+		}
+		measurements = append(measurements, &ms)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return measurements, nil
+}
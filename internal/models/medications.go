@@ -2,45 +2,211 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
 )
 
+// a product of the medication catalog, e.g. Glucophage 500 mg tablet containing metformin
 type Medication struct {
-	Name   string
-	UserId int
+	ID               int        `json:"id"`
+	Name             string     `json:"name"`
+	ActiveIngredient string     `json:"active_ingredient"`
+	Strength         string     `json:"strength"`
+	Unit             string     `json:"unit"`
+	DosageForm       string     `json:"dosage_form"`
+	ATCCode          string     `json:"atc_code"`
+	Manufacturer     string     `json:"manufacturer"`
+	UserId           int        `json:"user_id"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+}
+
+// the units a medication's strength may be given in
+var MedicationUnits = []string{"mg", "g", "mcg", "ml", "mg/ml", "IU", "%"}
+
+// the dosage forms a medication may come in
+var DosageForms = []string{"tablet", "capsule", "solution", "suspension", "syrup", "injection", "infusion", "pen", "cream", "ointment", "gel", "inhaler", "patch", "drops", "suppository", "powder"}
+
+// returns the name distinguishing the product from others of the same name, e.g. "Glucophage 500 mg tablet"
+func (m *Medication) Label() string {
+	var parts []string
+	for _, part := range []string{m.Name, m.Strength, m.Unit, m.DosageForm} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
 }
 
 type MedicationModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect Dialect
 }
 
-func (m *MedicationModel) Insert(name string, userId int) error {
-	stmt := "INSERT INTO medications (name, user_id) VALUES (?, ?)"
-	_, err := m.DB.Exec(stmt, name, userId)
+// labels a medication aliased m the same way as Medication.Label
+const medicationLabel = "CONCAT_WS(' ', m.name, NULLIF(m.strength, ''), NULLIF(m.unit, ''), NULLIF(m.dosage_form, ''))"
+
+// the columns scanned by scanMedication, in order
+const medicationColumns = "id, name, active_ingredient, strength, unit, dosage_form, atc_code, manufacturer, user_id, deleted_at"
+
+func scanMedication(row rowScanner) (*Medication, error) {
+	var med Medication
+
+	err := row.Scan(&med.ID, &med.Name, &med.ActiveIngredient, &med.Strength, &med.Unit, &med.DosageForm, &med.ATCCode, &med.Manufacturer, &med.UserId, &med.DeletedAt)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return &med, nil
+}
+
+func (m *MedicationModel) Insert(name string, activeIngredient string, strength string, unit string, dosageForm string, atcCode string, manufacturer string, userId int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	return nil
+	id, err := insertMedication(tx, m.Dialect, name, activeIngredient, strength, unit, dosageForm, atcCode, manufacturer, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
 }
 
+// adds all the medications, owned by the user, to the catalog in a single transaction, so that either every one of
+// them is added or none is, e.g. when one of them is already in the catalog; returns their ids in order
+func (m *MedicationModel) Import(medications []*Medication, userId int) ([]int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(medications))
+	for i, med := range medications {
+		ids[i], err = insertMedication(tx, m.Dialect, med.Name, med.ActiveIngredient, med.Strength, med.Unit, med.DosageForm, med.ATCCode, med.Manufacturer, userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, tx.Commit()
+}
+
+func insertMedication(tx *sql.Tx, d Dialect, name string, activeIngredient string, strength string, unit string, dosageForm string, atcCode string, manufacturer string, userId int) (int, error) {
+	stmt := "INSERT INTO medications (name, active_ingredient, strength, unit, dosage_form, atc_code, manufacturer, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	result, err := tx.Exec(stmt, name, activeIngredient, strength, unit, dosageForm, atcCode, manufacturer, userId)
+	if err != nil {
+		if d.IsDuplicate(err) {
+			return 0, ErrDuplicateMedication
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	after, err := getMedicationForUpdate(tx, d, int(id), false)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionInsert, AuditEntityMedication, id, nil, after)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// fetches and locks a medication row for the remainder of the transaction, soft deleted rows only being found if requested
+func getMedicationForUpdate(tx *sql.Tx, d Dialect, id int, deleted bool) (*Medication, error) {
+	stmt := "SELECT " + medicationColumns + " FROM medications WHERE id = ? AND deleted_at IS NULL" + d.LockClause()
+	if deleted {
+		stmt = "SELECT " + medicationColumns + " FROM medications WHERE id = ? AND deleted_at IS NOT NULL" + d.LockClause()
+	}
+
+	med, err := scanMedication(tx.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return med, nil
+}
+
+// checks whether any patients not in the trash are currently on the medication
+func hasActivePatients(tx *sql.Tx, id int) (bool, error) {
+	var exists bool
+	stmt := "SELECT EXISTS(SELECT true FROM prescriptions pr JOIN patients p ON p.id = pr.patient_id WHERE pr.medication_id = ? AND p.deleted_at IS NULL AND " + activePrescription + ")"
+
+	err := tx.QueryRow(stmt, id).Scan(&exists)
+	return exists, err
+}
+
+func (m *MedicationModel) Get(id int) (*Medication, error) {
+	stmt := "SELECT " + medicationColumns + " FROM medications WHERE id = ? AND deleted_at IS NULL"
+	med, err := scanMedication(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return med, nil
+}
+
+func (m *MedicationModel) GetDeleted(id int) (*Medication, error) {
+	stmt := "SELECT " + medicationColumns + " FROM medications WHERE id = ? AND deleted_at IS NOT NULL"
+	med, err := scanMedication(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return med, nil
+}
+
+// returns the catalog ordered by name, the products of the same name being ordered by id
 func (m *MedicationModel) GetAll() ([]*Medication, error) {
+	stmt := "SELECT " + medicationColumns + " FROM medications WHERE deleted_at IS NULL ORDER BY name, id"
+	return m.query(stmt)
+}
+
+// returns the soft deleted medications owned by the user, most recently deleted first
+func (m *MedicationModel) GetDeletedByUserId(userId int) ([]*Medication, error) {
+	stmt := "SELECT " + medicationColumns + " FROM medications WHERE user_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC"
+	return m.query(stmt, userId)
+}
+
+func (m *MedicationModel) query(stmt string, args ...any) ([]*Medication, error) {
 	var medications []*Medication
 
-	stmt := "SELECT * FROM medications"
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var med Medication
-
-		err = rows.Scan(&med.Name, &med.UserId)
+		med, err := scanMedication(rows)
 		if err != nil {
 			return nil, err
 		}
-		medications = append(medications, &med)
+		medications = append(medications, med)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -50,11 +216,53 @@ func (m *MedicationModel) GetAll() ([]*Medication, error) {
 	return medications, nil
 }
 
-func (m *MedicationModel) Delete(name string, userId int) error {
-	var exists bool
-	stmt := "SELECT EXISTS(SELECT true FROM patients WHERE medication = ?)"
+// updates the catalog entry, which the prescriptions follow since they reference it by id
+func (m *MedicationModel) Update(id int, name string, activeIngredient string, strength string, unit string, dosageForm string, atcCode string, manufacturer string, userId int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getMedicationForUpdate(tx, m.Dialect, id, false)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE medications SET name = ?, active_ingredient = ?, strength = ?, unit = ?, dosage_form = ?, atc_code = ?, manufacturer = ? WHERE id = ?"
+
+	_, err = tx.Exec(stmt, name, activeIngredient, strength, unit, dosageForm, atcCode, manufacturer, id)
+	if err != nil {
+		if m.Dialect.IsDuplicate(err) {
+			return ErrDuplicateMedication
+		}
+		return err
+	}
 
-	err := m.DB.QueryRow(stmt, name).Scan(&exists)
+	after, err := getMedicationForUpdate(tx, m.Dialect, id, false)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		err = insertAuditEntry(tx, userId, AuditActionUpdate, AuditEntityMedication, id, before, after)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// moves the medication to the trash, which is only allowed as long as no active patients are on it
+func (m *MedicationModel) Delete(id int, userId int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exists, err := hasActivePatients(tx, id)
 	if err != nil {
 		return err
 	}
@@ -63,21 +271,99 @@ func (m *MedicationModel) Delete(name string, userId int) error {
 		return ErrExistingDependency
 	}
 
-	stmt = "DELETE FROM medications WHERE name = ? && user_id = ?"
+	before, err := getMedicationForUpdate(tx, m.Dialect, id, false)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE medications SET deleted_at = UTC_TIMESTAMP(), deleted_by = ? WHERE id = ?"
 
-	res, err := m.DB.Exec(stmt, name, userId)
+	_, err = tx.Exec(stmt, userId, id)
 	if err != nil {
 		return err
 	}
 
-	rows, err := res.RowsAffected()
+	err = insertAuditEntry(tx, userId, AuditActionDelete, AuditEntityMedication, id, before, nil)
 	if err != nil {
 		return err
 	}
 
-	if rows == 0 {
-		return ErrUnauthorizedAction
+	return tx.Commit()
+}
+
+func (m *MedicationModel) Restore(id int, userId int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = getMedicationForUpdate(tx, m.Dialect, id, true)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE medications SET deleted_at = NULL, deleted_by = NULL WHERE id = ?"
+
+	_, err = tx.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	after, err := getMedicationForUpdate(tx, m.Dialect, id, false)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionRestore, AuditEntityMedication, id, nil, after)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// permanently removes the medications soft deleted before the cutoff, skipping those still referenced by prescriptions
+func (m *MedicationModel) Purge(cutoff time.Time, userId int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := "SELECT " + medicationColumns + " FROM medications WHERE deleted_at < ? AND NOT EXISTS(SELECT true FROM prescriptions WHERE medication_id = medications.id)" + m.Dialect.LockClause()
+
+	rows, err := tx.Query(stmt, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	var purged []*Medication
+	for rows.Next() {
+		med, err := scanMedication(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		purged = append(purged, med)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, med := range purged {
+		_, err = tx.Exec("DELETE FROM medications WHERE id = ?", med.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		err = insertAuditEntry(tx, userId, AuditActionPurge, AuditEntityMedication, med.ID, med, nil)
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	return nil
+	return len(purged), tx.Commit()
 }
@@ -0,0 +1,207 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"p-system.okostadinov.net/internal/encryption"
+)
+
+const (
+	NoteTypeGeneral     = "general"
+	NoteTypeObservation = "observation"
+	NoteTypeAssessment  = "assessment"
+	NoteTypePlan        = "plan"
+)
+
+var NoteTypes = []string{NoteTypeGeneral, NoteTypeObservation, NoteTypeAssessment, NoteTypePlan}
+
+// a clinical note on a patient's timeline, written in Markdown; notes are never removed, and may only be edited by
+// their author until they lock
+type Note struct {
+	ID        int
+	PatientId int
+	Type      string
+	Body      string
+	UserId    int
+	UserName  string
+	Created   time.Time
+	Updated   *time.Time
+}
+
+// the moment the note locks, given how long notes stay editable
+func (n *Note) LocksAt(window time.Duration) time.Time {
+	return n.Created.Add(window)
+}
+
+// reports whether the note can no longer be edited, given how long notes stay editable
+func (n *Note) Locked(window time.Duration) bool {
+	return !time.Now().UTC().Before(n.LocksAt(window))
+}
+
+// stores notes with their bodies sealed by the keys, if any
+type NoteModel struct {
+	DB   *sql.DB
+	Keys *encryption.Keyring
+}
+
+func (m *NoteModel) Insert(patientId int, noteType string, body string, userId int) (int, error) {
+	return insertNote(m.DB, m.Keys, patientId, noteType, body, userId)
+}
+
+// satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// appends a note to the patient's timeline, also as part of the transaction creating the patient
+func insertNote(e execer, keys *encryption.Keyring, patientId int, noteType string, body string, userId int) (int, error) {
+	sealed, err := keys.Encrypt(strings.TrimSpace(body))
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := "INSERT INTO patient_notes (patient_id, type, body, user_id, created) VALUES (?, ?, ?, ?, UTC_TIMESTAMP())"
+
+	result, err := e.Exec(stmt, patientId, noteType, sealed, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+const noteSelect = `SELECT n.id, n.patient_id, n.type, n.body, n.user_id, u.name, n.created, n.updated
+	FROM patient_notes n JOIN users u ON u.id = n.user_id`
+
+func scanNote(row rowScanner, keys *encryption.Keyring) (*Note, error) {
+	var n Note
+
+	err := row.Scan(&n.ID, &n.PatientId, &n.Type, &n.Body, &n.UserId, &n.UserName, &n.Created, &n.Updated)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Body, err = keys.Decrypt(n.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+func (m *NoteModel) Get(id int) (*Note, error) {
+	n, err := scanNote(m.DB.QueryRow(noteSelect+" WHERE n.id = ?", id), m.Keys)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+// returns the patient's timeline, the most recent note first
+func (m *NoteModel) GetAllByPatient(patientId int) ([]*Note, error) {
+	var notes []*Note
+
+	rows, err := m.DB.Query(noteSelect+" WHERE n.patient_id = ? ORDER BY n.created DESC, n.id DESC", patientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		n, err := scanNote(rows, m.Keys)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
+
+// replaces the type and body of the note, as long as it was written after the cutoff, past which notes are locked
+func (m *NoteModel) Update(id int, noteType string, body string, cutoff time.Time) error {
+	sealed, err := m.Keys.Encrypt(strings.TrimSpace(body))
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE patient_notes SET type = ?, body = ?, updated = UTC_TIMESTAMP() WHERE id = ? AND created > ?"
+
+	result, err := m.DB.Exec(stmt, noteType, sealed, id, cutoff)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoteLocked
+	}
+
+	return nil
+}
+
+// returns the body of the most recent note of every patient which has any
+func getLatestNotes(q querier, keys *encryption.Keyring, patientIds []int) (map[int]string, error) {
+	notes := make(map[int]string)
+	if len(patientIds) == 0 {
+		return notes, nil
+	}
+
+	placeholders := strings.Repeat(", ?", len(patientIds))[2:]
+	args := make([]any, len(patientIds))
+	for i, id := range patientIds {
+		args[i] = id
+	}
+
+	stmt := `SELECT n.patient_id, n.body FROM patient_notes n
+	WHERE n.patient_id IN (` + placeholders + `)
+	AND NOT EXISTS(SELECT true FROM patient_notes l WHERE l.patient_id = n.patient_id AND (l.created > n.created OR l.created = n.created AND l.id > n.id))`
+
+	rows, err := q.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			patientId int
+			body      string
+		)
+		if err = rows.Scan(&patientId, &body); err != nil {
+			return nil, err
+		}
+
+		notes[patientId], err = keys.Decrypt(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
@@ -3,31 +3,191 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"p-system.okostadinov.net/internal/encryption"
+	"p-system.okostadinov.net/internal/ucn"
 )
 
 type Patient struct {
-	ID                int
-	UCN               string
-	FirstName         string
-	LastName          string
-	PhoneNumber       string
-	Height            int
-	Weight            int
-	Medication        string
-	Note              string
-	Approved          bool
-	FirstContinuation bool
-	UserId            int
+	ID             int           `json:"id"`
+	UCN            string        `json:"ucn"`
+	FirstName      string        `json:"first_name"`
+	LastName       string        `json:"last_name"`
+	PhoneNumber    string        `json:"phone_number"`
+	Height         int           `json:"height"`
+	Weight         int           `json:"weight"`
+	Note           string        `json:"note,omitempty"` // the body of the most recent clinical note
+	TherapyStatus  TherapyStatus `json:"therapy_status"`
+	TherapyChanged *time.Time    `json:"therapy_changed,omitempty"`
+	TherapyExpires *time.Time    `json:"therapy_expires,omitempty"`
+	UserId         int           `json:"user_id"`
+	CoOwnerIds     []int         `json:"co_owner_ids,omitempty"`
+	SubmittedBy    int           `json:"submitted_by,omitempty"` // the user who submitted the therapy awaiting approval
+	Medications    []string      `json:"medications"`
+	DeletedAt      *time.Time    `json:"deleted_at,omitempty"`
 }
 
+// stores patients with their UCN, names and phone number sealed by the keys, if any, along with a blind index of
+// the UCN to look them up by
 type PatientModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect Dialect
+	Keys    *encryption.Keyring
+}
+
+// the columns scanned by scanPatient, in order
+const patientColumns = "id, ucn, first_name, last_name, phone_number, height, weight, therapy_status, therapy_changed, therapy_expires, user_id, deleted_at"
+
+// satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func scanPatient(row rowScanner) (*Patient, error) {
+	var p Patient
+
+	err := row.Scan(&p.ID, &p.UCN, &p.FirstName, &p.LastName, &p.PhoneNumber, &p.Height, &p.Weight, &p.TherapyStatus, &p.TherapyChanged, &p.TherapyExpires, &p.UserId, &p.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// the date of birth and sex encoded in the patient's UCN, nil if it does not encode them, as may be the case for
+// patients created before UCNs were validated
+func (p *Patient) UCNInfo() *ucn.Info {
+	info, err := ucn.Parse(p.UCN)
+	if err != nil {
+		return nil
+	}
+	return &info
+}
+
+// the patient's age in full years as of today, -1 if its UCN does not encode its date of birth
+func (p *Patient) Age() int {
+	info := p.UCNInfo()
+	if info == nil {
+		return -1
+	}
+	return info.Age(time.Now().UTC())
+}
+
+// opens the sealed personal data of a scanned patient in place
+func decryptPatient(keys *encryption.Keyring, p *Patient) error {
+	for _, field := range []*string{&p.UCN, &p.FirstName, &p.LastName, &p.PhoneNumber} {
+		value, err := keys.Decrypt(*field)
+		if err != nil {
+			return err
+		}
+		*field = value
+	}
+
+	return nil
+}
+
+// returns the values of the ucn, first_name, last_name and phone_number columns sealed for storing, followed by the
+// blind index of the UCN and the index of the phone number; the stored values of an existing patient are kept where they are unchanged and already
+// sealed with the current key, so that they do not show up as changed in the audit log
+func sealPatient(keys *encryption.Keyring, stored *Patient, ucn string, firstName string, lastName string, phone string) ([]any, error) {
+	values := []string{ucn, firstName, lastName, phone}
+
+	var current []string
+	if stored != nil {
+		current = []string{stored.UCN, stored.FirstName, stored.LastName, stored.PhoneNumber}
+	}
+
+	sealed := make([]any, 0, len(values)+1)
+	for i, value := range values {
+		if current != nil && !keys.Stale(current[i]) {
+			plaintext, err := keys.Decrypt(current[i])
+			if err != nil {
+				return nil, err
+			}
+			if plaintext == value {
+				sealed = append(sealed, current[i])
+				continue
+			}
+		}
+
+		v, err := keys.Encrypt(value)
+		if err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, v)
+	}
+
+	return append(sealed, keys.BlindIndex(ucn), phoneIndex(keys, phone)), nil
+}
+
+// joins the sealed first and last name of a patient into its full name
+func decryptName(keys *encryption.Keyring, firstName string, lastName string) (string, error) {
+	first, err := keys.Decrypt(firstName)
+	if err != nil {
+		return "", err
+	}
+
+	last, err := keys.Decrypt(lastName)
+	if err != nil {
+		return "", err
+	}
+
+	return first + " " + last, nil
+}
+
+// creates the patient, starting its timeline with the note, if any
+func (m *PatientModel) Insert(ucn string, firstName string, lastName string, phone string, height int, weight int, note string, userId int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := insertPatient(tx, m.Dialect, m.Keys, ucn, firstName, lastName, phone, height, weight, note, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// creates all the patients, owned by the user, in a single transaction, so that either every one of them is created
+// or none is; returns their ids in order
+func (m *PatientModel) Import(patients []*Patient, userId int) ([]int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(patients))
+	for i, p := range patients {
+		ids[i], err = insertPatient(tx, m.Dialect, m.Keys, p.UCN, p.FirstName, p.LastName, p.PhoneNumber, p.Height, p.Weight, p.Note, userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, tx.Commit()
 }
 
-func (m *PatientModel) Insert(ucn string, firstName string, lastName string, phone string, height int, weight int, medication string, note string, userId int) (int, error) {
-	stmt := "INSERT INTO patients (ucn, first_name, last_name, phone_number, height, weight, medication, note, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+func insertPatient(tx *sql.Tx, d Dialect, keys *encryption.Keyring, ucn string, firstName string, lastName string, phone string, height int, weight int, note string, userId int) (int, error) {
+	sealed, err := sealPatient(keys, nil, ucn, firstName, lastName, phone)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := "INSERT INTO patients (ucn, first_name, last_name, phone_number, ucn_index, phone_index, height, weight, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
 
-	result, err := m.DB.Exec(stmt, ucn, firstName, lastName, phone, height, weight, medication, note, userId)
+	result, err := tx.Exec(stmt, append(sealed, height, weight, userId)...)
 	if err != nil {
 		return 0, err
 	}
@@ -37,14 +197,41 @@ func (m *PatientModel) Insert(ucn string, firstName string, lastName string, pho
 		return 0, err
 	}
 
+	// the vitals the patient is created with start its measurements history
+	_, err = insertMeasurement(tx, int(id), time.Now().UTC(), height, weight, nil, nil, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	if strings.TrimSpace(note) != "" {
+		_, err = insertNote(tx, keys, int(id), NoteTypeGeneral, note, userId)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	after, err := getPatientForUpdate(tx, d, int(id), false)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionInsert, AuditEntityPatient, id, nil, after)
+	if err != nil {
+		return 0, err
+	}
+
 	return int(id), nil
 }
 
-func (m *PatientModel) Get(id int) (*Patient, error) {
-	var p Patient
+// fetches and locks a patient row for the remainder of the transaction, soft deleted rows only being found if requested;
+// its personal data is left sealed, as it is stored, for the audit log
+func getPatientForUpdate(tx *sql.Tx, d Dialect, id int, deleted bool) (*Patient, error) {
+	stmt := "SELECT " + patientColumns + " FROM patients WHERE id = ? AND deleted_at IS NULL" + d.LockClause()
+	if deleted {
+		stmt = "SELECT " + patientColumns + " FROM patients WHERE id = ? AND deleted_at IS NOT NULL" + d.LockClause()
+	}
 
-	stmt := "SELECT * FROM patients WHERE id = ?"
-	err := m.DB.QueryRow(stmt, id).Scan(&p.ID, &p.UCN, &p.FirstName, &p.LastName, &p.PhoneNumber, &p.Height, &p.Weight, &p.Medication, &p.Note, &p.Approved, &p.FirstContinuation, &p.UserId)
+	p, err := scanPatient(tx.QueryRow(stmt, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -53,14 +240,13 @@ func (m *PatientModel) Get(id int) (*Patient, error) {
 		}
 	}
 
-	return &p, nil
+	return p, nil
 }
 
-func (m *PatientModel) GetByUCN(ucn string) (*Patient, error) {
-	var p Patient
-
-	stmt := "SELECT * FROM patients WHERE ucn = ?"
-	err := m.DB.QueryRow(stmt, ucn).Scan(&p.ID, &p.UCN, &p.FirstName, &p.LastName, &p.PhoneNumber, &p.Height, &p.Weight, &p.Medication, &p.Note, &p.Approved, &p.FirstContinuation, &p.UserId)
+// returns the patient along with its co-owners, which the authorization checks depend on, and its current medications
+func (m *PatientModel) Get(id int) (*Patient, error) {
+	stmt := "SELECT " + patientColumns + " FROM patients WHERE id = ? AND deleted_at IS NULL"
+	p, err := scanPatient(m.DB.QueryRow(stmt, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -69,153 +255,688 @@ func (m *PatientModel) GetByUCN(ucn string) (*Patient, error) {
 		}
 	}
 
-	return &p, nil
+	if err = decryptPatient(m.Keys, p); err != nil {
+		return nil, err
+	}
+
+	p.CoOwnerIds, err = getCoOwnerIds(m.DB, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.TherapyStatus == TherapyStatusSubmitted {
+		p.SubmittedBy, err = getSubmitterId(m.DB, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = fillPatients(m.DB, m.Keys, []*Patient{p})
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// looks the patients up by their blind index under any of the keys, as well as by their UCN in case they were stored
+// before encryption was enabled; more than one patient is found if the UCN was registered twice, oldest first
+func (m *PatientModel) GetAllByUCN(ucn string) ([]*Patient, error) {
+	condition, args := ucnCondition(m.Keys, ucn)
+
+	stmt := "SELECT " + patientColumns + " FROM patients WHERE (" + condition + ") AND deleted_at IS NULL ORDER BY id"
+	return m.query(stmt, args...)
+}
+
+// the condition matching the patients with the UCN, either by their blind index under any of the keys or by the UCN
+// itself, and its arguments
+func ucnCondition(keys *encryption.Keyring, ucn string) (string, []any) {
+	condition := "ucn = ?"
+	args := []any{ucn}
+	for _, index := range keys.BlindIndexes(ucn) {
+		condition += " OR ucn_index = ?"
+		args = append(args, index)
+	}
+
+	return condition, args
 }
 
 func (m *PatientModel) Latest() ([]*Patient, error) {
+	stmt := "SELECT " + patientColumns + " FROM patients WHERE deleted_at IS NULL ORDER BY ID DESC LIMIT 10"
+	return m.query(stmt)
+}
+
+func (m *PatientModel) GetDeleted(id int) (*Patient, error) {
+	stmt := "SELECT " + patientColumns + " FROM patients WHERE id = ? AND deleted_at IS NOT NULL"
+	p, err := scanPatient(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	if err = decryptPatient(m.Keys, p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// returns the soft deleted patients owned by the user, most recently deleted first
+func (m *PatientModel) GetDeletedByUserId(userId int) ([]*Patient, error) {
+	stmt := "SELECT " + patientColumns + " FROM patients WHERE user_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC"
+	return m.query(stmt, userId)
+}
+
+func (m *PatientModel) query(stmt string, args ...any) ([]*Patient, error) {
 	var patients []*Patient
 
-	stmt := "SELECT * FROM patients ORDER BY ID DESC LIMIT 10"
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var p Patient
-
-		err := rows.Scan(&p.ID, &p.UCN, &p.FirstName, &p.LastName, &p.PhoneNumber, &p.Height, &p.Weight, &p.Medication, &p.Note, &p.Approved, &p.FirstContinuation, &p.UserId)
+		p, err := scanPatient(rows)
 		if err != nil {
 			return nil, err
 		}
-		patients = append(patients, &p)
+
+		if err = decryptPatient(m.Keys, p); err != nil {
+			return nil, err
+		}
+		patients = append(patients, p)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
+	err = fillPatients(m.DB, m.Keys, patients)
+	if err != nil {
+		return nil, err
+	}
+
 	return patients, nil
 }
 
-func (m *PatientModel) GetAll() ([]*Patient, error) {
-	var patients []*Patient
+// sets the current medications and the latest note of the patients
+func fillPatients(q querier, keys *encryption.Keyring, patients []*Patient) error {
+	ids := make([]int, len(patients))
+	for i, p := range patients {
+		ids[i] = p.ID
+	}
+
+	medications, err := getActiveMedications(q, ids)
+	if err != nil {
+		return err
+	}
 
-	stmt := "SELECT * FROM patients"
-	rows, err := m.DB.Query(stmt)
+	notes, err := getLatestNotes(q, keys, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range patients {
+		p.Medications = medications[p.ID]
+		p.Note = notes[p.ID]
+	}
+
+	return nil
+}
+
+// returns a single page of patients matching the filter, along with the pagination metadata for all matches
+func (m *PatientModel) List(filter PatientFilter) ([]*Patient, Metadata, error) {
+	if filter.inApplication(m.Keys) {
+		matches, err := m.matchInApplication(filter)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		start := min(filter.offset(), len(matches))
+		patients := matches[start:min(start+filter.limit(), len(matches))]
+
+		if err = fillPatients(m.DB, m.Keys, patients); err != nil {
+			return nil, Metadata{}, err
+		}
+
+		return patients, calculateMetadata(len(matches), filter.page(), filter.limit()), nil
+	}
+
+	var totalRecords int
+
+	where, args := filter.where(m.Dialect)
+
+	stmt := "SELECT COUNT(*) FROM patients" + where
+	err := m.DB.QueryRow(stmt, args...).Scan(&totalRecords)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	orderBy, orderArgs := filter.orderBy(m.Dialect)
+	stmt = "SELECT " + patientColumns + " FROM patients" + where + orderBy + " LIMIT ? OFFSET ?"
+
+	args = append(args, orderArgs...)
+	patients, err := m.query(stmt, append(args, filter.limit(), filter.offset())...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return patients, calculateMetadata(totalRecords, filter.page(), filter.limit()), nil
+}
+
+// the most patients the application matches a list against at once; the database's indexes being of no use for the
+// sealed data, each of them is read, opened and held in memory, so larger lists have to be narrowed down by the
+// criteria the database does match first, i.e. medication, owner and therapy status
+const MaxApplicationMatches = 5000
+
+// returns every patient matching the filter in the order of the list, for the lists the database cannot match by
+// itself: those filtered by age, which is encoded in the UCN, and those searched or sorted by sealed personal data,
+// which are searched and sorted once it is opened; returns ErrTooManyToMatch if more than MaxApplicationMatches
+// patients would have to be read
+func (m *PatientModel) matchInApplication(filter PatientFilter) ([]*Patient, error) {
+	sealed := m.Keys != nil
+
+	sqlFilter := filter
+	if sealed {
+		sqlFilter.Query = ""
+	}
+
+	where, args := sqlFilter.where(m.Dialect)
+
+	var candidates int
+	err := m.DB.QueryRow("SELECT COUNT(*) FROM patients"+where, args...).Scan(&candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	if candidates > MaxApplicationMatches {
+		return nil, ErrTooManyToMatch
+	}
+
+	stmt := "SELECT " + patientColumns + " FROM patients" + where
+	if !sealed {
+		orderBy, orderArgs := filter.orderBy(m.Dialect)
+		stmt += orderBy
+		args = append(args, orderArgs...)
+	}
+
+	rows, err := m.DB.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	for rows.Next() {
-		var p Patient
+	today := time.Now().UTC()
 
-		err := rows.Scan(&p.ID, &p.UCN, &p.FirstName, &p.LastName, &p.PhoneNumber, &p.Height, &p.Weight, &p.Medication, &p.Note, &p.Approved, &p.FirstContinuation, &p.UserId)
+	var patients []*Patient
+	for rows.Next() {
+		p, err := scanPatient(rows)
 		if err != nil {
 			return nil, err
 		}
-		patients = append(patients, &p)
+
+		if err = decryptPatient(m.Keys, p); err != nil {
+			return nil, err
+		}
+
+		if filter.matchesAge(p, today) {
+			patients = append(patients, p)
+		}
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
+	if !sealed {
+		return patients, nil
+	}
+
+	if filter.Query != "" {
+		texts, err := getSearchTexts(m.DB, m.Keys, patients)
+		if err != nil {
+			return nil, err
+		}
+
+		matches := patients[:0]
+		for _, p := range patients {
+			if filter.matches(p, texts[p.ID]) {
+				matches = append(matches, p)
+			}
+		}
+		patients = matches
+	}
+
+	filter.sort(patients)
+
 	return patients, nil
 }
 
-func (m *PatientModel) GetAllByMedication(medication string) ([]*Patient, error) {
-	var patients []*Patient
+// returns the texts the patients are searched by besides their personal data, namely the bodies of their notes and the
+// names and active ingredients of the medications they have been prescribed; they are read in batches, keeping the
+// number of query parameters within the limits of the databases
+func getSearchTexts(q querier, keys *encryption.Keyring, patients []*Patient) (map[int][]string, error) {
+	texts := make(map[int][]string)
+
+	for start := 0; start < len(patients); start += exportBatchSize {
+		batch := patients[start:min(start+exportBatchSize, len(patients))]
+
+		ids := make([]any, len(batch))
+		for i, p := range batch {
+			ids[i] = p.ID
+		}
+
+		err := addSearchTexts(q, keys, ids, texts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return texts, nil
+}
 
-	stmt := "SELECT * FROM patients WHERE medication = ?"
-	rows, err := m.DB.Query(stmt, medication)
+// adds the texts the patients of the ids are searched by to those of the map
+func addSearchTexts(q querier, keys *encryption.Keyring, ids []any, texts map[int][]string) error {
+	placeholders := strings.Repeat(", ?", len(ids))[2:]
+
+	// only the notes are sealed, the medications being no personal data
+	queries := []struct {
+		stmt   string
+		sealed bool
+	}{
+		{"SELECT n.patient_id, n.body FROM patient_notes n WHERE n.patient_id IN (" + placeholders + ")", true},
+		{`SELECT pr.patient_id, CONCAT(m.name, ' ', m.active_ingredient) FROM prescriptions pr
+		JOIN medications m ON m.id = pr.medication_id
+		WHERE pr.patient_id IN (` + placeholders + ")", false},
+	}
+
+	for _, query := range queries {
+		rows, err := q.Query(query.stmt, ids...)
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var (
+				patientId int
+				text      string
+			)
+			if err = rows.Scan(&patientId, &text); err != nil {
+				rows.Close()
+				return err
+			}
+
+			if query.sealed {
+				text, err = keys.Decrypt(text)
+				if err != nil {
+					rows.Close()
+					return err
+				}
+			}
+			texts[patientId] = append(texts[patientId], text)
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// how many exported patients are read before their medications and notes are fetched at once
+const exportBatchSize = 500
+
+// calls fn with every patient matching the filter in the order of the list, ignoring its pagination; the patients are
+// read from a single query in batches, so that an export of any size is never held in memory at once, unless they
+// have to be matched by the application, which holds at most MaxApplicationMatches of them
+func (m *PatientModel) Export(filter PatientFilter, fn func(*Patient) error) error {
+	if filter.inApplication(m.Keys) {
+		matches, err := m.matchInApplication(filter)
+		if err != nil {
+			return err
+		}
+
+		for start := 0; start < len(matches); start += exportBatchSize {
+			batch := matches[start:min(start+exportBatchSize, len(matches))]
+
+			if err = fillPatients(m.DB, m.Keys, batch); err != nil {
+				return err
+			}
+
+			for _, p := range batch {
+				if err = fn(p); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	where, args := filter.where(m.Dialect)
+	orderBy, orderArgs := filter.orderBy(m.Dialect)
+
+	rows, err := m.DB.Query("SELECT "+patientColumns+" FROM patients"+where+orderBy, append(args, orderArgs...)...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
+	batch := make([]*Patient, 0, exportBatchSize)
+
+	flush := func() error {
+		err := fillPatients(m.DB, m.Keys, batch)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range batch {
+			if err = fn(p); err != nil {
+				return err
+			}
+		}
+
+		batch = batch[:0]
+		return nil
+	}
+
 	for rows.Next() {
-		var p Patient
+		p, err := scanPatient(rows)
+		if err != nil {
+			return err
+		}
+
+		if err = decryptPatient(m.Keys, p); err != nil {
+			return err
+		}
+
+		batch = append(batch, p)
+		if len(batch) == exportBatchSize {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+func (m *PatientModel) Update(id int, ucn string, firstName string, lastName string, phone string, height int, weight int, userId int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getPatientForUpdate(tx, m.Dialect, id, false)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := sealPatient(m.Keys, before, ucn, firstName, lastName, phone)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE patients SET ucn = ?, first_name = ?, last_name = ?, phone_number = ?, ucn_index = ?, phone_index = ?, height = ?, weight = ? WHERE id = ?"
 
-		err := rows.Scan(&p.ID, &p.UCN, &p.FirstName, &p.LastName, &p.PhoneNumber, &p.Height, &p.Weight, &p.Medication, &p.Note, &p.Approved, &p.FirstContinuation, &p.UserId)
+	_, err = tx.Exec(stmt, append(sealed, height, weight, id)...)
+	if err != nil {
+		return err
+	}
+
+	// corrected vitals are kept as a measurement of the day, so that the trend is not lost
+	if before.Height != height || before.Weight != weight {
+		_, err = insertMeasurement(tx, id, time.Now().UTC(), height, weight, nil, nil, userId)
 		if err != nil {
-			return nil, err
+			return err
+		}
+	}
+
+	after, err := getPatientForUpdate(tx, m.Dialect, id, false)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		err = insertAuditEntry(tx, userId, AuditActionUpdate, AuditEntityPatient, id, before, after)
+		if err != nil {
+			return err
 		}
-		patients = append(patients, &p)
 	}
 
+	return tx.Commit()
+}
+
+// moves the patient to the trash, from where it can be restored until it is purged
+func (m *PatientModel) Delete(id int, userId int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getPatientForUpdate(tx, m.Dialect, id, false)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE patients SET deleted_at = UTC_TIMESTAMP(), deleted_by = ? WHERE id = ?"
+
+	_, err = tx.Exec(stmt, userId, id)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionDelete, AuditEntityPatient, id, before, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// brings a soft deleted patient back, as long as it was not merged into another one and none of its active
+// prescriptions' medications have been deleted in the meantime
+func (m *PatientModel) Restore(id int, userId int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = getPatientForUpdate(tx, m.Dialect, id, true)
+	if err != nil {
+		return err
+	}
+
+	// a merged patient's records now belong to the one it was merged into, leaving nothing worth restoring
+	var merged bool
+	err = tx.QueryRow("SELECT merged_into IS NOT NULL FROM patients WHERE id = ?", id).Scan(&merged)
+	if err != nil {
+		return err
+	}
+
+	if merged {
+		return ErrMergedPatient
+	}
+
+	var exists bool
+	stmt := "SELECT EXISTS(SELECT true FROM prescriptions pr JOIN medications m ON m.id = pr.medication_id WHERE pr.patient_id = ? AND m.deleted_at IS NOT NULL AND " + activePrescription + ")"
+
+	err = tx.QueryRow(stmt, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return ErrDeletedDependency
+	}
+
+	stmt = "UPDATE patients SET deleted_at = NULL, deleted_by = NULL WHERE id = ?"
+
+	_, err = tx.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	after, err := getPatientForUpdate(tx, m.Dialect, id, false)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionRestore, AuditEntityPatient, id, nil, after)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// permanently removes the patients soft deleted before the cutoff, returning how many were purged
+func (m *PatientModel) Purge(cutoff time.Time, userId int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := "SELECT " + patientColumns + " FROM patients WHERE deleted_at < ?" + m.Dialect.LockClause()
+
+	rows, err := tx.Query(stmt, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	var purged []*Patient
+	for rows.Next() {
+		p, err := scanPatient(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		purged = append(purged, p)
+	}
+	rows.Close()
+
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	return patients, nil
+	for _, p := range purged {
+		err = purgeAttachments(tx, p.ID, userId)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.Exec("DELETE FROM patients WHERE id = ?", p.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		err = insertAuditEntry(tx, userId, AuditActionPurge, AuditEntityPatient, p.ID, p, nil)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(purged), tx.Commit()
 }
 
-func (m *PatientModel) GetAllByUserId(userId int) ([]*Patient, error) {
-	var patients []*Patient
+func getCoOwnerIds(q querier, patientId int) ([]int, error) {
+	var ids []int
 
-	stmt := "SELECT * FROM patients WHERE user_id = ?"
-	rows, err := m.DB.Query(stmt, userId)
+	rows, err := q.Query("SELECT user_id FROM patient_co_owners WHERE patient_id = ? ORDER BY user_id", patientId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var p Patient
-
-		err := rows.Scan(&p.ID, &p.UCN, &p.FirstName, &p.LastName, &p.PhoneNumber, &p.Height, &p.Weight, &p.Medication, &p.Note, &p.Approved, &p.FirstContinuation, &p.UserId)
-		if err != nil {
+		var id int
+		if err = rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		patients = append(patients, &p)
+		ids = append(ids, id)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return patients, nil
+	return ids, nil
+}
+
+// shares the patient with another user, who may then update it alongside the owner
+func (m *PatientModel) AddCoOwner(patientId int, coOwnerId int, userId int) error {
+	return m.changeCoOwners(patientId, userId, "INSERT INTO patient_co_owners (patient_id, user_id, created) VALUES (?, ?, UTC_TIMESTAMP())", coOwnerId)
 }
 
-func (m *PatientModel) Update(id int, ucn string, firstName string, lastName string, phone string, height int, weight int, medication string, note string, approved bool, firstCont bool, userId int) error {
-	stmt := "UPDATE patients SET ucn = ?, first_name = ?, last_name = ?, phone_number = ?, height = ?, weight = ?, medication = ?, note = ?, approved = ?, first_continuation = ? WHERE id = ? && user_id = ?"
+func (m *PatientModel) RemoveCoOwner(patientId int, coOwnerId int, userId int) error {
+	return m.changeCoOwners(patientId, userId, "DELETE FROM patient_co_owners WHERE patient_id = ? AND user_id = ?", coOwnerId)
+}
 
-	res, err := m.DB.Exec(stmt, ucn, firstName, lastName, phone, height, weight, medication, note, approved, firstCont, id, userId)
+// runs a statement adding or removing a co-owner, auditing the co-owner list before and after it
+func (m *PatientModel) changeCoOwners(patientId int, userId int, stmt string, coOwnerId int) error {
+	tx, err := m.DB.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rows, err := res.RowsAffected()
+	_, err = getPatientForUpdate(tx, m.Dialect, patientId, false)
 	if err != nil {
 		return err
 	}
 
-	if rows == 0 {
-		return ErrUnauthorizedAction
+	before, err := getCoOwnerIds(tx, patientId)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
-
-func (m *PatientModel) Delete(id int, userId int) error {
-	stmt := "DELETE FROM patients WHERE id = ? && user_id = ?"
-
-	res, err := m.DB.Exec(stmt, id, userId)
+	result, err := tx.Exec(stmt, patientId, coOwnerId)
 	if err != nil {
+		if m.Dialect.IsDuplicate(err) {
+			return ErrDuplicateCoOwner
+		}
 		return err
 	}
 
-	rows, err := res.RowsAffected()
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
 	if rows == 0 {
-		return ErrUnauthorizedAction
+		return ErrNoRecord
 	}
 
-	return nil
+	after, err := getCoOwnerIds(tx, patientId)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionUpdate, AuditEntityPatient, patientId, coOwnerSnapshot(before), coOwnerSnapshot(after))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func coOwnerSnapshot(ids []int) map[string]any {
+	if ids == nil {
+		ids = []int{}
+	}
+	return map[string]any{"co_owner_ids": ids}
 }
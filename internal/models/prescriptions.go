@@ -0,0 +1,300 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	PrescriptionStatusActive  = "active"
+	PrescriptionStatusStopped = "stopped"
+)
+
+// the layout prescription dates are passed to the database in, which SQLite stores as is
+const DateLayout = "2006-01-02"
+
+// a medication a patient is put on, from its start date until it is stopped or its end date has passed
+type Prescription struct {
+	ID               int
+	PatientId        int
+	MedicationId     int
+	Medication       string
+	Dose             string
+	Frequency        string
+	StartDate        time.Time
+	EndDate          *time.Time
+	PrescribedBy     int
+	PrescribedByName string
+	Status           string
+	OverrideReason   string
+	Created          time.Time
+}
+
+type PrescriptionModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+// the condition a prescription aliased pr has to meet to be in effect today
+const activePrescription = "pr.status = 'active' AND pr.start_date <= UTC_DATE() AND (pr.end_date IS NULL OR pr.end_date >= UTC_DATE())"
+
+// the columns scanned by scanPrescription, in order, joined with the medication label and the prescribing user's name
+const prescriptionSelect = `SELECT pr.id, pr.patient_id, pr.medication_id, ` + medicationLabel + `, pr.dose, pr.frequency, pr.start_date, pr.end_date, pr.prescribed_by, u.name, pr.status, pr.override_reason, pr.created
+	FROM prescriptions pr
+	JOIN medications m ON m.id = pr.medication_id
+	JOIN users u ON u.id = pr.prescribed_by`
+
+func scanPrescription(row rowScanner) (*Prescription, error) {
+	var p Prescription
+
+	err := row.Scan(&p.ID, &p.PatientId, &p.MedicationId, &p.Medication, &p.Dose, &p.Frequency, &p.StartDate, &p.EndDate, &p.PrescribedBy, &p.PrescribedByName, &p.Status, &p.OverrideReason, &p.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// reports whether the prescription is scheduled, active, ended or stopped as of today
+func (p *Prescription) State() string {
+	today := time.Now().UTC().Format(DateLayout)
+
+	switch {
+	case p.Status == PrescriptionStatusStopped:
+		return PrescriptionStatusStopped
+	case p.StartDate.Format(DateLayout) > today:
+		return "scheduled"
+	case p.EndDate != nil && p.EndDate.Format(DateLayout) < today:
+		return "ended"
+	default:
+		return PrescriptionStatusActive
+	}
+}
+
+// summarizes the prescription on a single line, e.g. "Glucophage 500 mg tablet, 1 tablet twice daily, 2024-01-01 - 2024-06-30, active"
+func (p *Prescription) String() string {
+	var b strings.Builder
+
+	b.WriteString(p.Medication)
+	if dosage := strings.TrimSpace(p.Dose + " " + p.Frequency); dosage != "" {
+		b.WriteString(", " + dosage)
+	}
+
+	b.WriteString(", " + p.StartDate.Format(DateLayout) + " - ")
+	if p.EndDate != nil {
+		b.WriteString(p.EndDate.Format(DateLayout))
+	}
+
+	b.WriteString(", " + p.Status)
+	if p.OverrideReason != "" {
+		b.WriteString(", interactions overridden: " + p.OverrideReason)
+	}
+	return b.String()
+}
+
+// prescriptions are audited as changes of their patient, so that they show up in the patient's history
+func prescriptionSnapshot(p *Prescription) map[string]any {
+	return map[string]any{fmt.Sprintf("prescription #%d", p.ID): p.String()}
+}
+
+func formatDate(date *time.Time) any {
+	if date == nil {
+		return nil
+	}
+	return date.Format(DateLayout)
+}
+
+// puts the patient on the medication, prescribed by the user, along with the reason for prescribing it despite
+// severe interactions, if there are any
+func (m *PrescriptionModel) Insert(patientId int, medicationId int, dose string, frequency string, startDate time.Time, endDate *time.Time, overrideReason string, userId int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = getPatientForUpdate(tx, m.Dialect, patientId, false)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = getMedicationForUpdate(tx, m.Dialect, medicationId, false)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := "INSERT INTO prescriptions (patient_id, medication_id, dose, frequency, start_date, end_date, prescribed_by, status, override_reason, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())"
+
+	result, err := tx.Exec(stmt, patientId, medicationId, dose, frequency, startDate.Format(DateLayout), formatDate(endDate), userId, PrescriptionStatusActive, overrideReason)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	after, err := getPrescriptionForUpdate(tx, m.Dialect, int(id))
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionUpdate, AuditEntityPatient, patientId, nil, prescriptionSnapshot(after))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), tx.Commit()
+}
+
+// fetches and locks a prescription row for the remainder of the transaction
+func getPrescriptionForUpdate(tx *sql.Tx, d Dialect, id int) (*Prescription, error) {
+	p, err := scanPrescription(tx.QueryRow(prescriptionSelect+" WHERE pr.id = ?"+d.LockClause(), id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (m *PrescriptionModel) Get(id int) (*Prescription, error) {
+	p, err := scanPrescription(m.DB.QueryRow(prescriptionSelect+" WHERE pr.id = ?", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// returns the patient's prescriptions, the ones still active first and then by start date, latest first
+func (m *PrescriptionModel) GetAllByPatient(patientId int) ([]*Prescription, error) {
+	var prescriptions []*Prescription
+
+	stmt := prescriptionSelect + " WHERE pr.patient_id = ? ORDER BY pr.status = 'active' DESC, pr.start_date DESC, pr.id DESC"
+
+	rows, err := m.DB.Query(stmt, patientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPrescription(rows)
+		if err != nil {
+			return nil, err
+		}
+		prescriptions = append(prescriptions, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return prescriptions, nil
+}
+
+// changes the dosage or the period of a prescription which has not been stopped yet
+func (m *PrescriptionModel) Update(id int, dose string, frequency string, startDate time.Time, endDate *time.Time, overrideReason string, userId int) error {
+	stmt := "UPDATE prescriptions SET dose = ?, frequency = ?, start_date = ?, end_date = ?, override_reason = ? WHERE id = ?"
+	return m.change(id, userId, stmt, dose, frequency, startDate.Format(DateLayout), formatDate(endDate), overrideReason, id)
+}
+
+// takes the patient off the medication as of today, keeping an earlier end date if it has one
+func (m *PrescriptionModel) Stop(id int, userId int) error {
+	stmt := "UPDATE prescriptions SET status = ?, end_date = CASE WHEN end_date IS NULL OR end_date > UTC_DATE() THEN UTC_DATE() ELSE end_date END WHERE id = ?"
+	return m.change(id, userId, stmt, PrescriptionStatusStopped, id)
+}
+
+// runs a statement changing a prescription which has not been stopped yet, auditing it before and after
+func (m *PrescriptionModel) change(id int, userId int, stmt string, args ...any) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getPrescriptionForUpdate(tx, m.Dialect, id)
+	if err != nil {
+		return err
+	}
+
+	if before.Status == PrescriptionStatusStopped {
+		return ErrStoppedPrescription
+	}
+
+	_, err = getPatientForUpdate(tx, m.Dialect, before.PatientId, false)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	after, err := getPrescriptionForUpdate(tx, m.Dialect, id)
+	if err != nil {
+		return err
+	}
+
+	if before.String() != after.String() {
+		err = insertAuditEntry(tx, userId, AuditActionUpdate, AuditEntityPatient, before.PatientId, prescriptionSnapshot(before), prescriptionSnapshot(after))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// returns the labels of the medications the patients are currently on, keyed by patient id
+func getActiveMedications(q querier, patientIds []int) (map[int][]string, error) {
+	medications := make(map[int][]string)
+	if len(patientIds) == 0 {
+		return medications, nil
+	}
+
+	placeholders := strings.Repeat(", ?", len(patientIds))[2:]
+	args := make([]any, len(patientIds))
+	for i, id := range patientIds {
+		args[i] = id
+	}
+
+	stmt := `SELECT pr.patient_id, ` + medicationLabel + ` FROM prescriptions pr JOIN medications m ON m.id = pr.medication_id
+	WHERE pr.patient_id IN (` + placeholders + `) AND ` + activePrescription + ` ORDER BY pr.start_date, pr.id`
+
+	rows, err := q.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			patientId int
+			label     string
+		)
+		if err = rows.Scan(&patientId, &label); err != nil {
+			return nil, err
+		}
+		medications[patientId] = append(medications[patientId], label)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return medications, nil
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// issues the tokens which let users who forgot their password set a new one; like personal access tokens, only their
+// hash is stored, while each is valid only until it expires and for a single use
+type PasswordResetModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+// generates a reset token for the user, valid for the given duration, and returns its plaintext value to be mailed to
+// them; any token issued to the user before stops being valid, and expired tokens of every user are removed
+func (m *PasswordResetModel) Insert(userId int, ttl time.Duration) (string, error) {
+	plaintext, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	_, err = tx.Exec("DELETE FROM password_resets WHERE user_id = ? OR expires <= ?", userId, now)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := "INSERT INTO password_resets (hash, user_id, created, expires) VALUES (?, ?, UTC_TIMESTAMP(), ?)"
+	_, err = tx.Exec(stmt, hashToken(plaintext), userId, now.Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, tx.Commit()
+}
+
+// returns the user the token was issued to, as long as it is still valid
+func (m *PasswordResetModel) GetUserId(plaintext string) (int, error) {
+	var userId int
+
+	stmt := "SELECT user_id FROM password_resets WHERE hash = ? AND expires > ?"
+	err := m.DB.QueryRow(stmt, hashToken(plaintext), time.Now().UTC()).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidResetToken
+		} else {
+			return 0, err
+		}
+	}
+
+	return userId, nil
+}
+
+// sets the password of the user the token was issued to, consuming the token; as whoever asked for it may be locking
+// an intruder out, every session, remembered device and access token of the user is ended as well; returns the user's
+// id
+func (m *PasswordResetModel) Reset(plaintext string, password string) (int, error) {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userId int
+
+	// the token is locked so that it cannot be used twice by concurrent requests
+	stmt := "SELECT user_id FROM password_resets WHERE hash = ? AND expires > ?" + m.Dialect.LockClause()
+	err = tx.QueryRow(stmt, hashToken(plaintext), time.Now().UTC()).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidResetToken
+		} else {
+			return 0, err
+		}
+	}
+
+	err = setPassword(tx, userId, hashedPassword, true)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("DELETE FROM password_resets WHERE user_id = ?", userId)
+	if err != nil {
+		return 0, err
+	}
+
+	return userId, tx.Commit()
+}
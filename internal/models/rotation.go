@@ -0,0 +1,219 @@
+package models
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"p-system.okostadinov.net/internal/encryption"
+)
+
+// seals the stored personal data again with the current key, replacing values sealed with older keys as well as those
+// stored before encryption was enabled, so that the older keys can be retired
+type KeyRotation struct {
+	DB        *sql.DB
+	Dialect   Dialect
+	Keys      *encryption.Keyring
+	BatchSize int
+}
+
+// seals the UCN, names and phone number of every patient, including deleted ones, and indexes it by the blind indexes
+// of its UCN and phone number under the current key; returns how many patients were sealed again
+func (r *KeyRotation) Patients() (int, error) {
+	columns := []string{"ucn", "first_name", "last_name", "phone_number", "ucn_index", "phone_index"}
+
+	return r.reseal("patients", columns, func(values []string) error {
+		ucn, err := r.Keys.Decrypt(values[0])
+		if err != nil {
+			return err
+		}
+
+		phone, err := r.Keys.Decrypt(values[3])
+		if err != nil {
+			return err
+		}
+
+		for i := range values[:4] {
+			if values[i], err = r.seal(values[i]); err != nil {
+				return err
+			}
+		}
+
+		values[4] = r.Keys.BlindIndex(ucn)
+		values[5] = phoneIndex(r.Keys, phone)
+		return nil
+	})
+}
+
+// seals the body of every note; returns how many notes were sealed again
+func (r *KeyRotation) Notes() (int, error) {
+	return r.reseal("patient_notes", []string{"body"}, func(values []string) error {
+		var err error
+		values[0], err = r.seal(values[0])
+		return err
+	})
+}
+
+// seals the TOTP secret of every user enrolled in two-factor authentication; returns how many secrets were sealed again
+func (r *KeyRotation) TwoFactorSecrets() (int, error) {
+	return r.reseal("users", []string{"totp_secret"}, func(values []string) error {
+		var err error
+		values[0], err = r.seal(values[0])
+		return err
+	})
+}
+
+// seals the personal data in the snapshots of the audit log, which keeps the values as they were stored when the
+// entry was made; returns how many entries were sealed again
+func (r *KeyRotation) AuditLog() (int, error) {
+	return r.reseal("audit_log", []string{"entity", "before_values", "after_values"}, func(values []string) error {
+		for i := 1; i < len(values); i++ {
+			if values[i] == "" {
+				continue
+			}
+
+			var snapshot map[string]any
+
+			decoder := json.NewDecoder(strings.NewReader(values[i]))
+			decoder.UseNumber()
+			if err := decoder.Decode(&snapshot); err != nil {
+				return err
+			}
+
+			// the snapshot is only rewritten if sealing changed it, keeping the rest of the entries as they are
+			original, err := json.Marshal(snapshot)
+			if err != nil {
+				return err
+			}
+
+			for _, field := range sealedSnapshotFields[values[0]] {
+				if err := snapshotField(snapshot, strings.Split(field, "."), r.seal); err != nil {
+					return err
+				}
+			}
+
+			js, err := json.Marshal(snapshot)
+			if err != nil {
+				return err
+			}
+
+			if !bytes.Equal(original, js) {
+				values[i] = string(js)
+			}
+		}
+
+		return nil
+	})
+}
+
+// returns the value sealed with the current key, as it is if it already is
+func (r *KeyRotation) seal(value string) (string, error) {
+	if !r.Keys.Stale(value) {
+		return value, nil
+	}
+
+	plaintext, err := r.Keys.Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+
+	return r.Keys.Encrypt(plaintext)
+}
+
+// walks the table by id in batches, each of which is locked and committed on its own so that the application can keep
+// running meanwhile, passing the values of the columns of every row to seal, NULL ones as empty, which replaces them in
+// place; the columns whose values were changed are written back, returning how many rows were
+func (r *KeyRotation) reseal(table string, columns []string, seal func(values []string) error) (int, error) {
+	var resealed, lastId int
+
+	for {
+		tx, err := r.DB.Begin()
+		if err != nil {
+			return resealed, err
+		}
+
+		stmt := "SELECT id, " + strings.Join(columns, ", ") + " FROM " + table + " WHERE id > ? ORDER BY id LIMIT ?" + r.Dialect.LockClause()
+
+		rows, err := tx.Query(stmt, lastId, r.BatchSize)
+		if err != nil {
+			tx.Rollback()
+			return resealed, err
+		}
+
+		var (
+			ids   []int
+			batch [][]string
+		)
+		for rows.Next() {
+			var id int
+			nullable := make([]sql.NullString, len(columns))
+
+			dest := []any{&id}
+			for i := range nullable {
+				dest = append(dest, &nullable[i])
+			}
+
+			if err = rows.Scan(dest...); err != nil {
+				rows.Close()
+				tx.Rollback()
+				return resealed, err
+			}
+
+			values := make([]string, len(columns))
+			for i, value := range nullable {
+				values[i] = value.String
+			}
+
+			ids = append(ids, id)
+			batch = append(batch, values)
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			tx.Rollback()
+			return resealed, err
+		}
+
+		if len(ids) == 0 {
+			tx.Rollback()
+			return resealed, nil
+		}
+
+		for i, values := range batch {
+			sealed := append([]string(nil), values...)
+			if err = seal(sealed); err != nil {
+				tx.Rollback()
+				return resealed, err
+			}
+
+			var (
+				assignments []string
+				args        []any
+			)
+			for j, column := range columns {
+				if sealed[j] != values[j] {
+					assignments = append(assignments, column+" = ?")
+					args = append(args, sealed[j])
+				}
+			}
+
+			if len(assignments) == 0 {
+				continue
+			}
+
+			_, err = tx.Exec("UPDATE "+table+" SET "+strings.Join(assignments, ", ")+" WHERE id = ?", append(args, ids[i])...)
+			if err != nil {
+				tx.Rollback()
+				return resealed, err
+			}
+			resealed++
+		}
+
+		if err = tx.Commit(); err != nil {
+			return resealed, err
+		}
+
+		lastId = ids[len(ids)-1]
+	}
+}
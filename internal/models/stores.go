@@ -0,0 +1,200 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"p-system.okostadinov.net/internal/encryption"
+)
+
+// the storage the application depends on, satisfied by the SQL models below for every supported dialect
+type PatientStore interface {
+	Insert(ucn string, firstName string, lastName string, phone string, height int, weight int, note string, userId int) (int, error)
+	Get(id int) (*Patient, error)
+	GetAllByUCN(ucn string) ([]*Patient, error)
+	FindDuplicates(ucn string, firstName string, lastName string, phone string) ([]*Duplicate, error)
+	GetDuplicates() ([]*Duplicate, error)
+	Latest() ([]*Patient, error)
+	GetDeleted(id int) (*Patient, error)
+	GetDeletedByUserId(userId int) ([]*Patient, error)
+	List(filter PatientFilter) ([]*Patient, Metadata, error)
+	Export(filter PatientFilter, fn func(*Patient) error) error
+	Import(patients []*Patient, userId int) ([]int, error)
+	Update(id int, ucn string, firstName string, lastName string, phone string, height int, weight int, userId int) error
+	Delete(id int, userId int) error
+	Restore(id int, userId int) error
+	Merge(id int, duplicateId int, userId int) error
+	Purge(cutoff time.Time, userId int) (int, error)
+	AddCoOwner(patientId int, coOwnerId int, userId int) error
+	RemoveCoOwner(patientId int, coOwnerId int, userId int) error
+}
+
+type MedicationStore interface {
+	Insert(name string, activeIngredient string, strength string, unit string, dosageForm string, atcCode string, manufacturer string, userId int) (int, error)
+	Get(id int) (*Medication, error)
+	GetDeleted(id int) (*Medication, error)
+	GetAll() ([]*Medication, error)
+	Import(medications []*Medication, userId int) ([]int, error)
+	GetDeletedByUserId(userId int) ([]*Medication, error)
+	Update(id int, name string, activeIngredient string, strength string, unit string, dosageForm string, atcCode string, manufacturer string, userId int) error
+	Delete(id int, userId int) error
+	Restore(id int, userId int) error
+	Purge(cutoff time.Time, userId int) (int, error)
+}
+
+type UserStore interface {
+	Insert(name, email, password string) error
+	Authenticate(email, password string) (int, error)
+	Get(id int) (*User, error)
+	GetByEmail(email string) (*User, error)
+	GetAll() ([]*User, error)
+	UpdateRole(id int, role Role) error
+	UpdateProfile(id int, name, email string) error
+	CheckPassword(id int, password string) error
+	ChangePassword(id int, currentPassword, newPassword string, revokeTokens bool) error
+}
+
+type TwoFactorStore interface {
+	Enable(userId int, secret string, code string) ([]string, error)
+	Disable(userId int) error
+	SetRequired(userId int, required bool) error
+	Verify(userId int, code string) error
+	Recover(userId int, code string) error
+	RegenerateRecoveryCodes(userId int) ([]string, error)
+	CountRecoveryCodes(userId int) (int, error)
+	RememberDevice(userId int, ttl time.Duration) (string, error)
+	IsRemembered(userId int, plaintext string) (bool, error)
+	ForgetDevices(userId int) error
+}
+
+type PasswordResetStore interface {
+	Insert(userId int, ttl time.Duration) (string, error)
+	GetUserId(plaintext string) (int, error)
+	Reset(plaintext string, password string) (int, error)
+}
+
+type TokenStore interface {
+	Insert(name string, userId int) (string, error)
+	GetAllByUserId(userId int) ([]*Token, error)
+	GetUserId(plaintext string) (int, error)
+	Delete(id int, userId int) error
+}
+
+type AuditStore interface {
+	GetAllByEntity(entity string, entityId any) ([]*AuditEntry, error)
+	GetAllByPatient(patientId int) ([]*AuditEntry, error)
+	InsertExport(export *PatientExport, userId int) error
+}
+
+type TransferStore interface {
+	Insert(patientId int, toUserId int, userId int) (int, error)
+	Get(id int) (*Transfer, error)
+	GetPendingByRecipient(userId int) ([]*Transfer, error)
+	GetPendingBySender(userId int) ([]*Transfer, error)
+	GetAllByPatient(patientId int) ([]*Transfer, error)
+	Accept(id int, userId int) error
+	Decline(id int, userId int) error
+	Cancel(id int, userId int) error
+}
+
+type PrescriptionStore interface {
+	Insert(patientId int, medicationId int, dose string, frequency string, startDate time.Time, endDate *time.Time, overrideReason string, userId int) (int, error)
+	Get(id int) (*Prescription, error)
+	GetAllByPatient(patientId int) ([]*Prescription, error)
+	Update(id int, dose string, frequency string, startDate time.Time, endDate *time.Time, overrideReason string, userId int) error
+	Stop(id int, userId int) error
+}
+
+type TherapyStore interface {
+	Transition(patientId int, action TherapyAction, comment string, expires *time.Time, userId int) error
+	GetAllByPatient(patientId int) ([]*TherapyTransition, error)
+	GetQueue(userId int) ([]*Patient, error)
+	GetDueByUser(userId int, horizon time.Time) ([]*Continuation, error)
+	GetWithoutExpiryByUser(userId int) ([]*Continuation, error)
+	GetPendingReminders(today time.Time, horizon time.Time) ([]*Reminder, error)
+	MarkReminded(patientId int, stage string) error
+}
+
+type InteractionStore interface {
+	GetAll() ([]*Interaction, error)
+	Import(interactions []*Interaction) (int, int, error)
+	Delete(id int) error
+	CheckPrescription(patientId int, medicationId int, startDate time.Time, endDate *time.Time, excludeId int) ([]*InteractionWarning, error)
+}
+
+type MeasurementStore interface {
+	Insert(patientId int, measured time.Time, height int, weight int, systolic *int, diastolic *int, userId int) (int, error)
+	GetAllByPatient(patientId int) ([]*Measurement, error)
+}
+
+type NoteStore interface {
+	Insert(patientId int, noteType string, body string, userId int) (int, error)
+	Get(id int) (*Note, error)
+	GetAllByPatient(patientId int) ([]*Note, error)
+	Update(id int, noteType string, body string, cutoff time.Time) error
+}
+
+type AttachmentStore interface {
+	Insert(patientId int, name string, category string, description string, contentType string, size int64, digest string, userId int) (int, error)
+	Get(id int) (*Attachment, error)
+	GetAllByPatient(patientId int) ([]*Attachment, error)
+	Delete(id int, userId int) error
+	IsReferenced(digest string) (bool, error)
+	GetDigestsByDeletedPatients(cutoff time.Time) ([]string, error)
+}
+
+var (
+	_ PatientStore       = (*PatientModel)(nil)
+	_ MedicationStore    = (*MedicationModel)(nil)
+	_ UserStore          = (*UserModel)(nil)
+	_ TokenStore         = (*TokenModel)(nil)
+	_ PasswordResetStore = (*PasswordResetModel)(nil)
+	_ TwoFactorStore     = (*TwoFactorModel)(nil)
+	_ AuditStore         = (*AuditModel)(nil)
+	_ TransferStore      = (*TransferModel)(nil)
+	_ PrescriptionStore  = (*PrescriptionModel)(nil)
+	_ InteractionStore   = (*InteractionModel)(nil)
+	_ TherapyStore       = (*TherapyModel)(nil)
+	_ MeasurementStore   = (*MeasurementModel)(nil)
+	_ NoteStore          = (*NoteModel)(nil)
+	_ AttachmentStore    = (*AttachmentModel)(nil)
+)
+
+// every store of the application, sharing a single connection pool
+type Stores struct {
+	Patients      PatientStore
+	Medications   MedicationStore
+	Users         UserStore
+	Tokens        TokenStore
+	Resets        PasswordResetStore
+	TwoFactor     TwoFactorStore
+	Audit         AuditStore
+	Transfers     TransferStore
+	Prescriptions PrescriptionStore
+	Interactions  InteractionStore
+	Therapy       TherapyStore
+	Measurements  MeasurementStore
+	Notes         NoteStore
+	Attachments   AttachmentStore
+}
+
+// returns the SQL stores for the database, speaking the given dialect and sealing the personal data of patients with
+// the keys, if any
+func NewStores(db *sql.DB, dialect Dialect, keys *encryption.Keyring) *Stores {
+	return &Stores{
+		Patients:      &PatientModel{DB: db, Dialect: dialect, Keys: keys},
+		Medications:   &MedicationModel{DB: db, Dialect: dialect},
+		Users:         &UserModel{DB: db, Dialect: dialect},
+		Tokens:        &TokenModel{DB: db},
+		Resets:        &PasswordResetModel{DB: db, Dialect: dialect},
+		TwoFactor:     &TwoFactorModel{DB: db, Dialect: dialect, Keys: keys},
+		Audit:         &AuditModel{DB: db, Keys: keys},
+		Transfers:     &TransferModel{DB: db, Dialect: dialect, Keys: keys},
+		Prescriptions: &PrescriptionModel{DB: db, Dialect: dialect},
+		Interactions:  &InteractionModel{DB: db},
+		Therapy:       &TherapyModel{DB: db, Dialect: dialect, Keys: keys},
+		Measurements:  &MeasurementModel{DB: db, Dialect: dialect},
+		Notes:         &NoteModel{DB: db, Keys: keys},
+		Attachments:   &AttachmentModel{DB: db, Dialect: dialect},
+	}
+}
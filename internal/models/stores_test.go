@@ -0,0 +1,969 @@
+package models_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/testdb"
+)
+
+// the contracts of the stores every backend has to keep, run against each of them by forEachBackend
+
+// inserts a user per name, whose ids follow in order starting from 1
+func insertUsers(t *testing.T, stores *models.Stores, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		err := stores.Users.Insert(name, name+"@example.com", "password123")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func insertPatient(t *testing.T, stores *models.Stores, ucn string, lastName string, height int, userId int) int {
+	t.Helper()
+
+	id, err := stores.Patients.Insert(ucn, "Ivan", lastName, "0888123456", height, 80, "", userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func assertErr(t *testing.T, what string, err error, want error) {
+	t.Helper()
+
+	if !errors.Is(err, want) {
+		t.Errorf("%s: got %v, want %v", what, err, want)
+	}
+}
+
+func patientIds(patients []*models.Patient) []int {
+	ids := make([]int, len(patients))
+	for i, p := range patients {
+		ids[i] = p.ID
+	}
+	return ids
+}
+
+func TestPatientStoreSoftDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *sql.DB, stores *models.Stores) {
+		insertUsers(t, stores, "alice", "bob")
+		const alice, bob = 1, 2
+
+		id := insertPatient(t, stores, "7501020018", "Petrov", 180, alice)
+		kept := insertPatient(t, stores, "8506150090", "Georgieva", 165, alice)
+
+		if err := stores.Patients.Delete(id, bob); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run("deleted patients are in the trash only", func(t *testing.T) {
+			_, err := stores.Patients.Get(id)
+			assertErr(t, "getting", err, models.ErrNoRecord)
+
+			p, err := stores.Patients.GetDeleted(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.DeletedAt == nil {
+				t.Error("no deletion time")
+			}
+
+			_, err = stores.Patients.GetDeleted(kept)
+			assertErr(t, "getting a patient not deleted from the trash", err, models.ErrNoRecord)
+
+			patients, metadata, err := stores.Patients.List(models.PatientFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := patientIds(patients); !slices.Equal(ids, []int{kept}) || metadata.TotalRecords != 1 {
+				t.Errorf("listed %v of %d", ids, metadata.TotalRecords)
+			}
+
+			patients, err = stores.Patients.GetAllByUCN("7501020018")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(patients) != 0 {
+				t.Errorf("found %d deleted patients by UCN", len(patients))
+			}
+		})
+
+		t.Run("the trash is the owner's", func(t *testing.T) {
+			patients, err := stores.Patients.GetDeletedByUserId(alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := patientIds(patients); !slices.Equal(ids, []int{id}) {
+				t.Errorf("owner's trash holds %v", ids)
+			}
+
+			// the user who deleted the patient does not own it
+			patients, err = stores.Patients.GetDeletedByUserId(bob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(patients) != 0 {
+				t.Errorf("deleting user's trash holds %v", patientIds(patients))
+			}
+		})
+
+		t.Run("deleted patients cannot be changed", func(t *testing.T) {
+			err := stores.Patients.Update(id, "7501020018", "Ivan", "Petrov", "0888123456", 181, 80, alice)
+			assertErr(t, "updating", err, models.ErrNoRecord)
+
+			assertErr(t, "deleting again", stores.Patients.Delete(id, alice), models.ErrNoRecord)
+			assertErr(t, "adding a co-owner", stores.Patients.AddCoOwner(id, bob, alice), models.ErrNoRecord)
+			assertErr(t, "restoring a patient not deleted", stores.Patients.Restore(kept, alice), models.ErrNoRecord)
+		})
+
+		t.Run("restore", func(t *testing.T) {
+			if err := stores.Patients.Restore(id, alice); err != nil {
+				t.Fatal(err)
+			}
+
+			p, err := stores.Patients.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.DeletedAt != nil || p.LastName != "Petrov" {
+				t.Errorf("restored %+v", p)
+			}
+
+			assertErr(t, "restoring again", stores.Patients.Restore(id, alice), models.ErrNoRecord)
+		})
+
+		t.Run("purge", func(t *testing.T) {
+			if err := stores.Patients.Delete(id, alice); err != nil {
+				t.Fatal(err)
+			}
+
+			// the patient was deleted after the cutoff of the retention period
+			purged, err := stores.Patients.Purge(time.Now().UTC().Add(-time.Hour), alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if purged != 0 {
+				t.Errorf("purged %d patients deleted after the cutoff", purged)
+			}
+
+			purged, err = stores.Patients.Purge(time.Now().UTC().Add(time.Minute), alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if purged != 1 {
+				t.Errorf("purged %d patients, want 1", purged)
+			}
+
+			_, err = stores.Patients.GetDeleted(id)
+			assertErr(t, "getting a purged patient", err, models.ErrNoRecord)
+			assertErr(t, "restoring a purged patient", stores.Patients.Restore(id, alice), models.ErrNoRecord)
+
+			if _, err = stores.Patients.Get(kept); err != nil {
+				t.Errorf("getting the patient not deleted: %v", err)
+			}
+		})
+	})
+}
+
+func TestPatientStoreOwnership(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *sql.DB, stores *models.Stores) {
+		insertUsers(t, stores, "alice", "bob", "carol")
+		const alice, bob, carol = 1, 2, 3
+
+		id := insertPatient(t, stores, "7501020018", "Petrov", 180, alice)
+
+		owned := func(userId int) []int {
+			t.Helper()
+
+			patients, _, err := stores.Patients.List(models.PatientFilter{UserId: userId})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return patientIds(patients)
+		}
+
+		t.Run("the inserting user owns the patient", func(t *testing.T) {
+			p, err := stores.Patients.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.UserId != alice {
+				t.Errorf("owned by %d", p.UserId)
+			}
+
+			if ids := owned(alice); !slices.Equal(ids, []int{id}) {
+				t.Errorf("owner's list holds %v", ids)
+			}
+			if ids := owned(bob); len(ids) != 0 {
+				t.Errorf("other user's list holds %v", ids)
+			}
+		})
+
+		t.Run("co-owners", func(t *testing.T) {
+			if err := stores.Patients.AddCoOwner(id, bob, alice); err != nil {
+				t.Fatal(err)
+			}
+			assertErr(t, "adding the co-owner twice", stores.Patients.AddCoOwner(id, bob, alice), models.ErrDuplicateCoOwner)
+			assertErr(t, "adding a co-owner to a missing patient", stores.Patients.AddCoOwner(id+100, bob, alice), models.ErrNoRecord)
+			assertErr(t, "removing a user who is no co-owner", stores.Patients.RemoveCoOwner(id, carol, alice), models.ErrNoRecord)
+
+			p, err := stores.Patients.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(p.CoOwnerIds, []int{bob}) || p.UserId != alice {
+				t.Errorf("owned by %d, co-owned by %v", p.UserId, p.CoOwnerIds)
+			}
+
+			// co-owned patients are not the co-owner's own
+			if ids := owned(bob); len(ids) != 0 {
+				t.Errorf("co-owner's list holds %v", ids)
+			}
+		})
+
+		t.Run("hand-over", func(t *testing.T) {
+			transferId, err := stores.Transfers.Insert(id, bob, alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = stores.Transfers.Insert(id, carol, alice)
+			assertErr(t, "requesting a second hand-over", err, models.ErrPendingTransfer)
+
+			if err = stores.Transfers.Accept(transferId, bob); err != nil {
+				t.Fatal(err)
+			}
+			assertErr(t, "accepting again", stores.Transfers.Accept(transferId, bob), models.ErrNoRecord)
+
+			// the recipient stops being a co-owner as they become the owner
+			p, err := stores.Patients.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.UserId != bob || len(p.CoOwnerIds) != 0 {
+				t.Errorf("owned by %d, co-owned by %v", p.UserId, p.CoOwnerIds)
+			}
+
+			if ids := owned(alice); len(ids) != 0 {
+				t.Errorf("former owner's list holds %v", ids)
+			}
+			if ids := owned(bob); !slices.Equal(ids, []int{id}) {
+				t.Errorf("new owner's list holds %v", ids)
+			}
+		})
+	})
+}
+
+func TestPatientStorePagination(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *sql.DB, stores *models.Stores) {
+		insertUsers(t, stores, "alice")
+		const alice = 1
+
+		// 45 patients, the height descending as the id ascends, and one in the trash
+		var ids []int
+		for i := 0; i < 45; i++ {
+			ids = append(ids, insertPatient(t, stores, "7501020018", fmt.Sprintf("Patient %02d", i), 200-i, alice))
+		}
+
+		deleted := insertPatient(t, stores, "8506150090", "Deleted", 150, alice)
+		if err := stores.Patients.Delete(deleted, alice); err != nil {
+			t.Fatal(err)
+		}
+
+		reversed := slices.Clone(ids)
+		slices.Reverse(reversed)
+
+		tests := []struct {
+			name     string
+			filter   models.PatientFilter
+			ids      []int
+			metadata models.Metadata
+		}{
+			{"first page", models.PatientFilter{Page: 1, PageSize: 10}, ids[:10], models.Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 5, TotalRecords: 45}},
+			{"middle page", models.PatientFilter{Page: 3, PageSize: 10}, ids[20:30], models.Metadata{CurrentPage: 3, PageSize: 10, FirstPage: 1, LastPage: 5, TotalRecords: 45}},
+			{"last page", models.PatientFilter{Page: 5, PageSize: 10}, ids[40:], models.Metadata{CurrentPage: 5, PageSize: 10, FirstPage: 1, LastPage: 5, TotalRecords: 45}},
+			{"past the last page", models.PatientFilter{Page: 6, PageSize: 10}, []int{}, models.Metadata{CurrentPage: 6, PageSize: 10, FirstPage: 1, LastPage: 5, TotalRecords: 45}},
+			{"defaults", models.PatientFilter{}, ids[:models.DefaultPageSize], models.Metadata{CurrentPage: 1, PageSize: models.DefaultPageSize, FirstPage: 1, LastPage: 3, TotalRecords: 45}},
+			{"page size above the maximum", models.PatientFilter{PageSize: models.MaxPageSize + 1}, ids[:models.DefaultPageSize], models.Metadata{CurrentPage: 1, PageSize: models.DefaultPageSize, FirstPage: 1, LastPage: 3, TotalRecords: 45}},
+			{"maximum page size", models.PatientFilter{PageSize: models.MaxPageSize}, ids, models.Metadata{CurrentPage: 1, PageSize: models.MaxPageSize, FirstPage: 1, LastPage: 1, TotalRecords: 45}},
+			{"sorted descending", models.PatientFilter{Page: 2, PageSize: 10, Direction: "desc"}, reversed[10:20], models.Metadata{CurrentPage: 2, PageSize: 10, FirstPage: 1, LastPage: 5, TotalRecords: 45}},
+			{"sorted by a column", models.PatientFilter{PageSize: 10, Sort: "height"}, reversed[:10], models.Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 5, TotalRecords: 45}},
+			{"sorted by name", models.PatientFilter{Page: 5, PageSize: 10, Sort: "last_name", Direction: "desc"}, reversed[40:], models.Metadata{CurrentPage: 5, PageSize: 10, FirstPage: 1, LastPage: 5, TotalRecords: 45}},
+			{"no matches", models.PatientFilter{UserId: alice + 1}, []int{}, models.Metadata{CurrentPage: 1, PageSize: models.DefaultPageSize}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				patients, metadata, err := stores.Patients.List(tt.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got := patientIds(patients); !slices.Equal(got, tt.ids) {
+					t.Errorf("got %v, want %v", got, tt.ids)
+				}
+
+				if metadata != tt.metadata {
+					t.Errorf("got %+v, want %+v", metadata, tt.metadata)
+				}
+			})
+		}
+
+		t.Run("exports ignore the pagination", func(t *testing.T) {
+			var exported []int
+			err := stores.Patients.Export(models.PatientFilter{Page: 2, PageSize: 10}, func(p *models.Patient) error {
+				exported = append(exported, p.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(exported, ids) {
+				t.Errorf("exported %v", exported)
+			}
+		})
+	})
+}
+
+func TestMedicationStoreSoftDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *sql.DB, stores *models.Stores) {
+		insertUsers(t, stores, "alice", "bob")
+		const alice, bob = 1, 2
+
+		insertMedication := func(name string, userId int) int {
+			t.Helper()
+
+			id, err := stores.Medications.Insert(name, "ingredient", "10", "mg", "tablet", "", "", userId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+
+		id := insertMedication("Aspirin", alice)
+		unused := insertMedication("Paracetamol", bob)
+
+		patientId := insertPatient(t, stores, "7501020018", "Petrov", 180, alice)
+		_, err := stores.Prescriptions.Insert(patientId, id, "1 tablet", "daily", time.Now().UTC(), nil, "", alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run("medications of active patients are kept", func(t *testing.T) {
+			assertErr(t, "deleting", stores.Medications.Delete(id, alice), models.ErrExistingDependency)
+
+			if _, err := stores.Medications.Get(id); err != nil {
+				t.Errorf("getting: %v", err)
+			}
+		})
+
+		t.Run("deleted medications are in the owner's trash only", func(t *testing.T) {
+			if err := stores.Medications.Delete(unused, alice); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := stores.Medications.Get(unused)
+			assertErr(t, "getting", err, models.ErrNoRecord)
+
+			if _, err = stores.Medications.GetDeleted(unused); err != nil {
+				t.Errorf("getting from the trash: %v", err)
+			}
+
+			medications, err := stores.Medications.GetAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(medications) != 1 || medications[0].ID != id {
+				t.Errorf("listed %d medications", len(medications))
+			}
+
+			for userId, want := range map[int]int{alice: 0, bob: 1} {
+				medications, err = stores.Medications.GetDeletedByUserId(userId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(medications) != want {
+					t.Errorf("the trash of user %d holds %d medications, want %d", userId, len(medications), want)
+				}
+			}
+
+			err = stores.Medications.Update(unused, "Paracetamol", "ingredient", "20", "mg", "tablet", "", "", bob)
+			assertErr(t, "updating", err, models.ErrNoRecord)
+			assertErr(t, "deleting again", stores.Medications.Delete(unused, bob), models.ErrNoRecord)
+		})
+
+		t.Run("patients on deleted medications are kept in the trash", func(t *testing.T) {
+			if err := stores.Patients.Delete(patientId, alice); err != nil {
+				t.Fatal(err)
+			}
+
+			// the medication is free to go once its only patient is in the trash
+			if err := stores.Medications.Delete(id, alice); err != nil {
+				t.Fatal(err)
+			}
+
+			assertErr(t, "restoring the patient", stores.Patients.Restore(patientId, alice), models.ErrDeletedDependency)
+
+			if err := stores.Medications.Restore(id, alice); err != nil {
+				t.Fatal(err)
+			}
+			assertErr(t, "restoring the medication again", stores.Medications.Restore(id, alice), models.ErrNoRecord)
+
+			if err := stores.Patients.Restore(patientId, alice); err != nil {
+				t.Errorf("restoring the patient: %v", err)
+			}
+		})
+
+		t.Run("duplicate products", func(t *testing.T) {
+			_, err := stores.Medications.Insert("Aspirin", "other", "10", "mg", "tablet", "", "", alice)
+			assertErr(t, "inserting", err, models.ErrDuplicateMedication)
+		})
+	})
+}
+
+func TestUserStoreDuplicateEmail(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *sql.DB, stores *models.Stores) {
+		insertUsers(t, stores, "alice", "bob")
+		const alice, bob = 1, 2
+
+		err := stores.Users.Insert("Alice", "alice@example.com", "password456")
+		assertErr(t, "inserting", err, models.ErrDuplicateEmail)
+
+		// emails are compared case-insensitively
+		err = stores.Users.Insert("Alice", "Alice@Example.com", "password456")
+		assertErr(t, "inserting in another case", err, models.ErrDuplicateEmail)
+
+		assertErr(t, "taking another user's email", stores.Users.UpdateProfile(bob, "Bob", "alice@example.com"), models.ErrDuplicateEmail)
+		assertErr(t, "taking it in another case", stores.Users.UpdateProfile(bob, "Bob", "ALICE@example.com"), models.ErrDuplicateEmail)
+
+		if err = stores.Users.UpdateProfile(alice, "Alice Smith", "alice@example.com"); err != nil {
+			t.Errorf("keeping the own email: %v", err)
+		}
+
+		users, err := stores.Users.GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 2 {
+			t.Errorf("got %d users, want 2", len(users))
+		}
+
+		id, err := stores.Users.Authenticate("ALICE@example.com", "password123")
+		if err != nil || id != alice {
+			t.Errorf("authenticating in another case: got %d, %v", id, err)
+		}
+
+		_, err = stores.Users.Authenticate("alice@example.com", "password456")
+		assertErr(t, "authenticating with the wrong password", err, models.ErrInvalidCredentials)
+
+		_, err = stores.Users.Authenticate("carol@example.com", "password123")
+		assertErr(t, "authenticating an unknown email", err, models.ErrInvalidCredentials)
+
+		u, err := stores.Users.GetByEmail("Bob@example.com")
+		if err != nil || u.ID != bob {
+			t.Errorf("getting by email in another case: %v", err)
+		}
+
+		_, err = stores.Users.Get(bob + 1)
+		assertErr(t, "getting a missing user", err, models.ErrNoRecord)
+	})
+}
+
+func TestTokenStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *sql.DB, stores *models.Stores) {
+		insertUsers(t, stores, "alice", "bob")
+		const alice, bob = 1, 2
+
+		used, err := stores.Tokens.Insert("ci", alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		unused, err := stores.Tokens.Insert("laptop", alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if used == "" || used == unused {
+			t.Fatalf("got tokens %q and %q", used, unused)
+		}
+
+		var stored int
+		if err = db.QueryRow("SELECT COUNT(*) FROM tokens WHERE hash IN (?, ?)", used, unused).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if stored != 0 {
+			t.Errorf("%d tokens stored in plaintext", stored)
+		}
+
+		userId, err := stores.Tokens.GetUserId(used)
+		if err != nil || userId != alice {
+			t.Errorf("got user %d, %v", userId, err)
+		}
+
+		_, err = stores.Tokens.GetUserId("unknown")
+		assertErr(t, "looking up an unknown token", err, models.ErrNoRecord)
+
+		tokens, err := stores.Tokens.GetAllByUserId(alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// newest first, only the token looked up being marked as used
+		if len(tokens) != 2 || tokens[0].Name != "laptop" || tokens[1].Name != "ci" {
+			t.Fatalf("got %d tokens", len(tokens))
+		}
+		if tokens[0].LastUsed != nil || tokens[1].LastUsed == nil {
+			t.Errorf("got last used %v and %v", tokens[0].LastUsed, tokens[1].LastUsed)
+		}
+
+		tokens, err = stores.Tokens.GetAllByUserId(bob)
+		if err != nil || len(tokens) != 0 {
+			t.Errorf("bob got %d tokens, %v", len(tokens), err)
+		}
+
+		assertErr(t, "deleting another user's token", stores.Tokens.Delete(1, bob), models.ErrNoRecord)
+
+		if err = stores.Tokens.Delete(1, alice); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = stores.Tokens.GetUserId(used)
+		assertErr(t, "looking up a deleted token", err, models.ErrNoRecord)
+
+		assertErr(t, "deleting a deleted token", stores.Tokens.Delete(1, alice), models.ErrNoRecord)
+
+		t.Run("revoked along with a changed password", func(t *testing.T) {
+			if err := stores.Users.ChangePassword(alice, "password123", "password456", true); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := stores.Tokens.GetUserId(unused)
+			assertErr(t, "looking up a revoked token", err, models.ErrNoRecord)
+		})
+	})
+}
+
+func TestTransferStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *sql.DB, stores *models.Stores) {
+		insertUsers(t, stores, "alice", "bob", "carol")
+		const alice, bob, carol = 1, 2, 3
+
+		id := insertPatient(t, stores, "7501020018", "Petrov", 180, alice)
+		if err := stores.Patients.AddCoOwner(id, bob, alice); err != nil {
+			t.Fatal(err)
+		}
+
+		transferIds := func(transfers []*models.Transfer) []int {
+			ids := make([]int, len(transfers))
+			for i, tr := range transfers {
+				ids[i] = tr.ID
+			}
+			return ids
+		}
+
+		assertPending := func(t *testing.T, recipient int, sender int, want []int) {
+			t.Helper()
+
+			received, err := stores.Transfers.GetPendingByRecipient(recipient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := transferIds(received); !slices.Equal(got, want) {
+				t.Errorf("user %d received %v, want %v", recipient, got, want)
+			}
+
+			sent, err := stores.Transfers.GetPendingBySender(sender)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := transferIds(sent); !slices.Equal(got, want) {
+				t.Errorf("user %d sent %v, want %v", sender, got, want)
+			}
+		}
+
+		accepted, err := stores.Transfers.Insert(id, bob, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = stores.Transfers.Insert(id, carol, alice)
+		assertErr(t, "requesting a second transfer", err, models.ErrPendingTransfer)
+
+		_, err = stores.Transfers.Insert(id+1, bob, alice)
+		assertErr(t, "transferring a missing patient", err, models.ErrNoRecord)
+
+		transfer, err := stores.Transfers.Get(accepted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transfer.PatientName != "Ivan Petrov" || transfer.FromUserId != alice || transfer.ToUserId != bob || transfer.RequestedByName != "alice" ||
+			transfer.Status != models.TransferStatusPending || transfer.Resolved != nil {
+			t.Errorf("got %+v", transfer)
+		}
+
+		assertPending(t, bob, alice, []int{accepted})
+		assertPending(t, carol, carol, []int{})
+
+		if err = stores.Transfers.Accept(accepted, bob); err != nil {
+			t.Fatal(err)
+		}
+		assertErr(t, "accepting twice", stores.Transfers.Accept(accepted, bob), models.ErrNoRecord)
+
+		// the recipient takes the patient over, ceasing to be its co-owner
+		p, err := stores.Patients.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.UserId != bob || len(p.CoOwnerIds) != 0 {
+			t.Errorf("owned by %d, co-owned by %v", p.UserId, p.CoOwnerIds)
+		}
+
+		transfer, err = stores.Transfers.Get(accepted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transfer.Status != models.TransferStatusAccepted || transfer.Resolved == nil {
+			t.Errorf("got status %s, resolved %v", transfer.Status, transfer.Resolved)
+		}
+
+		assertPending(t, bob, alice, []int{})
+
+		declined, err := stores.Transfers.Insert(id, carol, bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = stores.Transfers.Decline(declined, carol); err != nil {
+			t.Fatal(err)
+		}
+		assertErr(t, "cancelling a declined transfer", stores.Transfers.Cancel(declined, bob), models.ErrNoRecord)
+
+		cancelled, err := stores.Transfers.Insert(id, carol, bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = stores.Transfers.Cancel(cancelled, bob); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err = stores.Patients.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.UserId != bob {
+			t.Errorf("declining or cancelling handed the patient over to %d", p.UserId)
+		}
+
+		transfers, err := stores.Transfers.GetAllByPatient(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := transferIds(transfers), []int{cancelled, declined, accepted}; !slices.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		for i, status := range []string{models.TransferStatusCancelled, models.TransferStatusDeclined, models.TransferStatusAccepted} {
+			if transfers[i].Status != status {
+				t.Errorf("transfer %d: got %s, want %s", transfers[i].ID, transfers[i].Status, status)
+			}
+		}
+
+		_, err = stores.Transfers.Get(cancelled + 1)
+		assertErr(t, "getting a missing transfer", err, models.ErrNoRecord)
+
+		t.Run("of deleted patients", func(t *testing.T) {
+			pending, err := stores.Transfers.Insert(id, carol, bob)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = stores.Patients.Delete(id, bob); err != nil {
+				t.Fatal(err)
+			}
+
+			assertPending(t, carol, bob, []int{})
+
+			if _, err = stores.Transfers.Get(pending); err != nil {
+				t.Error(err)
+			}
+		})
+	})
+}
+
+func TestPatientStoreSearch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *sql.DB, stores *models.Stores) {
+		insertUsers(t, stores, "alice")
+		const alice = 1
+
+		for _, p := range []struct{ ucn, first, last, phone, note string }{
+			{"7501020018", "Ivan", "Petrov", "0888123456", "Allergic to penicillin"},
+			{"8506150090", "Maria", "Georgieva", "0899111222", ""},
+			{"6201010003", "Georgi", "Ivanov", "7501999999", ""},
+		} {
+			if _, err := stores.Patients.Insert(p.ucn, p.first, p.last, p.phone, 180, 80, p.note, alice); err != nil {
+				t.Fatal(err)
+			}
+		}
+		const petrov, georgieva, ivanov = 1, 2, 3
+
+		medicationId, err := stores.Medications.Insert("Aspirin", "acetylsalicylic acid", "100", "mg", "tablet", "", "", alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = stores.Prescriptions.Insert(georgieva, medicationId, "1 tablet", "daily", time.Now().UTC(), nil, "", alice); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			query string
+			ids   []int
+		}{
+			{"petrov", []int{petrov}},
+			{"PETROV", []int{petrov}},
+			{"Ivan Petrov", []int{petrov}},
+			{"petrov georgieva", []int{}},
+			{"0888", []int{petrov}},
+			{"penicillin", []int{petrov}},
+			{"aspirin", []int{georgieva}},
+			{"acetylsalicylic", []int{georgieva}},
+			{"8506", []int{georgieva}},
+			// the patient whose UCN starts with the query ranks above the one whose phone number does
+			{"7501", []int{petrov, ivanov}},
+			// a LIKE wildcard is matched literally rather than prefixing every UCN
+			{"%", []int{}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.query, func(t *testing.T) {
+				patients, metadata, err := stores.Patients.List(models.PatientFilter{Query: tt.query})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got := patientIds(patients); !slices.Equal(got, tt.ids) {
+					t.Errorf("got %v, want %v", got, tt.ids)
+				}
+
+				if metadata.TotalRecords != len(tt.ids) {
+					t.Errorf("got %d records", metadata.TotalRecords)
+				}
+			})
+		}
+	})
+}
+
+func TestTherapyStoreContinuations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *sql.DB, stores *models.Stores) {
+		insertUsers(t, stores, "alice", "bob")
+		const alice, bob = 1, 2
+
+		today, err := time.Parse(models.DateLayout, time.Now().UTC().Format(models.DateLayout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		days := func(n int) time.Time { return today.AddDate(0, 0, n) }
+
+		grant := func(t *testing.T, ownerId int, expires time.Time) int {
+			t.Helper()
+
+			id := insertPatient(t, stores, "7501020018", "Petrov", 180, ownerId)
+			if err := stores.Therapy.Transition(id, models.TherapyActionSubmit, "", nil, ownerId); err != nil {
+				t.Fatal(err)
+			}
+			if err := stores.Therapy.Transition(id, models.TherapyActionApprove, "", &expires, ownerId); err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+
+		overdue := grant(t, alice, days(-1))
+		upcoming := grant(t, alice, days(10))
+		later := grant(t, bob, days(40))
+		deleted := grant(t, alice, days(5))
+		if err = stores.Patients.AddCoOwner(overdue, bob, alice); err != nil {
+			t.Fatal(err)
+		}
+		if err = stores.Patients.Delete(deleted, alice); err != nil {
+			t.Fatal(err)
+		}
+
+		draft := insertPatient(t, stores, "8506150090", "Georgieva", 165, alice)
+		assertErr(t, "approving a draft", stores.Therapy.Transition(draft, models.TherapyActionApprove, "", &today, alice), models.ErrInvalidTransition)
+		assertErr(t, "approving without an expiry", stores.Therapy.Transition(upcoming, models.TherapyActionContinue, "", nil, alice), models.ErrExpiryRequired)
+
+		tests := []struct {
+			name    string
+			userId  int
+			horizon time.Time
+			ids     []int
+		}{
+			{"owned and within the horizon, earliest first", alice, days(30), []int{overdue, upcoming}},
+			{"co-owned", bob, days(30), []int{overdue}},
+			{"further horizon", bob, days(60), []int{overdue, later}},
+			{"overdue only", alice, today, []int{overdue}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				due, err := stores.Therapy.GetDueByUser(tt.userId, tt.horizon)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ids := continuationIds(due); !slices.Equal(ids, tt.ids) {
+					t.Errorf("got %v, want %v", ids, tt.ids)
+				}
+			})
+		}
+
+		due, err := stores.Therapy.GetDueByUser(alice, days(30))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 2 || !due[0].Overdue() || due[1].Overdue() || due[1].DaysLeft() != 10 || due[1].PatientName != "Ivan Petrov" ||
+			due[1].Status != models.TherapyStatusApproved {
+			t.Errorf("got %+v", due)
+		}
+
+		type reminder struct {
+			userId, patientId int
+			stage             string
+		}
+		assertReminders := func(t *testing.T, want []reminder) {
+			t.Helper()
+
+			reminders, err := stores.Therapy.GetPendingReminders(today, days(30))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]reminder, len(reminders))
+			for i, r := range reminders {
+				got[i] = reminder{r.UserId, r.PatientId, r.Stage}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+
+		assertReminders(t, []reminder{
+			{alice, overdue, models.ReminderStageOverdue},
+			{alice, upcoming, models.ReminderStageUpcoming},
+			{bob, overdue, models.ReminderStageOverdue},
+		})
+
+		if err = stores.Therapy.MarkReminded(overdue, models.ReminderStageOverdue); err != nil {
+			t.Fatal(err)
+		}
+		if err = stores.Therapy.MarkReminded(upcoming, models.ReminderStageUpcoming); err != nil {
+			t.Fatal(err)
+		}
+		assertReminders(t, []reminder{})
+
+		// continuing the therapy makes it due again only once it nears its new expiry
+		next := days(365)
+		if err = stores.Therapy.Transition(overdue, models.TherapyActionContinue, "", &next, alice); err != nil {
+			t.Fatal(err)
+		}
+
+		due, err = stores.Therapy.GetDueByUser(alice, days(30))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := continuationIds(due); !slices.Equal(ids, []int{upcoming}) {
+			t.Errorf("got %v after continuing", ids)
+		}
+
+		unexpiring, err := stores.Therapy.GetWithoutExpiryByUser(alice)
+		if err != nil || len(unexpiring) != 0 {
+			t.Errorf("got %d without expiry, %v", len(unexpiring), err)
+		}
+	})
+}
+
+// the few statements which differ between the backends, run against each of them
+func TestDialect(t *testing.T) {
+	for _, driver := range testdb.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			db, dialect := testdb.Open(t, driver)
+			stores := models.NewStores(db, dialect, nil)
+
+			insertUsers(t, stores, "alice")
+			insertPatient(t, stores, "7501020018", "Petrov", 180, 1)
+			insertPatient(t, stores, "6201010003", "Petrova", 180, 1)
+
+			t.Run("LockClause", func(t *testing.T) {
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer tx.Rollback()
+
+				var name string
+				if err = tx.QueryRow("SELECT name FROM users WHERE id = ?"+dialect.LockClause(), 1).Scan(&name); err != nil {
+					t.Fatal(err)
+				}
+				if name != "alice" {
+					t.Errorf("got %q", name)
+				}
+			})
+
+			t.Run("IsDuplicate", func(t *testing.T) {
+				_, err := db.Exec("INSERT INTO users (name, email, hashed_password, created) VALUES ('Alice', 'alice@example.com', '', UTC_TIMESTAMP())")
+				if err == nil || !dialect.IsDuplicate(err) {
+					t.Errorf("a duplicate email: got %v", err)
+				}
+
+				_, err = db.Exec("INSERT INTO users (id, name, email, hashed_password, created) VALUES (1, 'Alice', 'other@example.com', '', UTC_TIMESTAMP())")
+				if err == nil || !dialect.IsDuplicate(err) {
+					t.Errorf("a duplicate primary key: got %v", err)
+				}
+
+				_, err = db.Exec("INSERT INTO tokens (hash, name, user_id, created) VALUES ('hash', 'ci', 99, UTC_TIMESTAMP())")
+				if err == nil || dialect.IsDuplicate(err) {
+					t.Errorf("a missing foreign key: got %v", err)
+				}
+
+				if dialect.IsDuplicate(nil) || dialect.IsDuplicate(errors.New("duplicate")) {
+					t.Error("reported an error of no database as a duplicate")
+				}
+			})
+
+			t.Run("SearchCondition and SearchOrder", func(t *testing.T) {
+				for query, want := range map[string][]int{"petrov": {1, 2}, "petrova": {2}, "6201": {2}, "petrov 6201": {}} {
+					condition, args := dialect.SearchCondition(query)
+					order, orderArgs := dialect.SearchOrder(query)
+
+					rows, err := db.Query("SELECT id FROM patients WHERE "+condition+" ORDER BY "+order+", id", append(args, orderArgs...)...)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					ids := []int{}
+					for rows.Next() {
+						var id int
+						if err = rows.Scan(&id); err != nil {
+							t.Fatal(err)
+						}
+						ids = append(ids, id)
+					}
+					rows.Close()
+
+					if err = rows.Err(); err != nil {
+						t.Fatal(err)
+					}
+					if !slices.Equal(ids, want) {
+						t.Errorf("%q: got %v, want %v", query, ids, want)
+					}
+				}
+			})
+		})
+	}
+}
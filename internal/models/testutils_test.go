@@ -0,0 +1,19 @@
+package models_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/testdb"
+)
+
+// runs the test against the stores of every driver, each on an empty database of its own
+func forEachBackend(t *testing.T, test func(t *testing.T, db *sql.DB, stores *models.Stores)) {
+	for _, driver := range testdb.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			db, dialect := testdb.Open(t, driver)
+			test(t, db, models.NewStores(db, dialect, nil))
+		})
+	}
+}
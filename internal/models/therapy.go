@@ -0,0 +1,254 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"p-system.okostadinov.net/internal/encryption"
+)
+
+// the stage of a patient's therapy in its approval lifecycle
+type TherapyStatus string
+
+const (
+	TherapyStatusDraft             TherapyStatus = "draft"
+	TherapyStatusSubmitted         TherapyStatus = "submitted"
+	TherapyStatusApproved          TherapyStatus = "approved"
+	TherapyStatusRejected          TherapyStatus = "rejected"
+	TherapyStatusFirstContinuation TherapyStatus = "first_continuation"
+	TherapyStatusContinued         TherapyStatus = "continued"
+)
+
+// all statuses, in the order a therapy goes through them
+var TherapyStatuses = []TherapyStatus{TherapyStatusDraft, TherapyStatusSubmitted, TherapyStatusApproved, TherapyStatusRejected, TherapyStatusFirstContinuation, TherapyStatusContinued}
+
+func (s TherapyStatus) Valid() bool {
+	for _, status := range TherapyStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// the status as displayed, e.g. "First continuation"
+func (s TherapyStatus) Label() string {
+	label := strings.ReplaceAll(string(s), "_", " ")
+	if label == "" {
+		return ""
+	}
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+// an explicit step taken on a therapy, moving it from one status to another
+type TherapyAction string
+
+const (
+	TherapyActionSubmit   TherapyAction = "submit"
+	TherapyActionApprove  TherapyAction = "approve"
+	TherapyActionReject   TherapyAction = "reject"
+	TherapyActionContinue TherapyAction = "continue"
+)
+
+// the allowed transitions: the status each action leads to from every status it may be taken in; a rejected therapy
+// may be resubmitted, while continuations follow one another indefinitely
+var therapyTransitions = map[TherapyAction]map[TherapyStatus]TherapyStatus{
+	TherapyActionSubmit: {
+		TherapyStatusDraft:    TherapyStatusSubmitted,
+		TherapyStatusRejected: TherapyStatusSubmitted,
+	},
+	TherapyActionApprove: {
+		TherapyStatusSubmitted: TherapyStatusApproved,
+	},
+	TherapyActionReject: {
+		TherapyStatusSubmitted: TherapyStatusRejected,
+	},
+	TherapyActionContinue: {
+		TherapyStatusApproved:          TherapyStatusFirstContinuation,
+		TherapyStatusFirstContinuation: TherapyStatusContinued,
+		TherapyStatusContinued:         TherapyStatusContinued,
+	},
+}
+
+// the actions in the order they are offered
+var therapyActions = []TherapyAction{TherapyActionSubmit, TherapyActionApprove, TherapyActionReject, TherapyActionContinue}
+
+// returns the status the action leads to from the current one, and whether it may be taken at all
+func (s TherapyStatus) Next(action TherapyAction) (TherapyStatus, bool) {
+	next, ok := therapyTransitions[action][s]
+	return next, ok
+}
+
+// returns the actions which may be taken from the status, regardless of who takes them
+func (s TherapyStatus) Actions() []TherapyAction {
+	var actions []TherapyAction
+	for _, action := range therapyActions {
+		if _, ok := s.Next(action); ok {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+// the action as displayed on its button, e.g. "Submit for approval"
+func (a TherapyAction) Label() string {
+	switch a {
+	case TherapyActionSubmit:
+		return "Submit for approval"
+	case TherapyActionApprove:
+		return "Approve"
+	case TherapyActionReject:
+		return "Reject"
+	case TherapyActionContinue:
+		return "Continue"
+	default:
+		return string(a)
+	}
+}
+
+// whether the action has to be explained in a comment
+func (a TherapyAction) RequiresComment() bool {
+	return a == TherapyActionReject
+}
+
+// whether the action grants the therapy for a limited period, after which it has to be continued
+func (a TherapyAction) RequiresExpiry() bool {
+	return a == TherapyActionApprove || a == TherapyActionContinue
+}
+
+// a single step in a patient's therapy lifecycle, recording when each status was entered and by whom
+type TherapyTransition struct {
+	ID         int
+	PatientId  int
+	Action     TherapyAction
+	FromStatus TherapyStatus
+	ToStatus   TherapyStatus
+	Comment    string
+	Expires    *time.Time
+	UserId     int
+	UserName   string
+	Created    time.Time
+}
+
+type TherapyModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Keys    *encryption.Keyring
+}
+
+// takes the action on the patient's therapy, as long as its current status allows it, recording the transition along
+// with the comment and auditing the patient's change of status; approvals and continuations expire on the given date,
+// which is ignored for the other actions
+func (m *TherapyModel) Transition(patientId int, action TherapyAction, comment string, expires *time.Time, userId int) error {
+	if action.RequiresComment() && strings.TrimSpace(comment) == "" {
+		return ErrCommentRequired
+	}
+
+	if !action.RequiresExpiry() {
+		expires = nil
+	} else if expires == nil {
+		return ErrExpiryRequired
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := getPatientForUpdate(tx, m.Dialect, patientId, false)
+	if err != nil {
+		return err
+	}
+
+	next, ok := before.TherapyStatus.Next(action)
+	if !ok {
+		return ErrInvalidTransition
+	}
+
+	// a new status calls for new reminders once it is due for continuation
+	stmt := "UPDATE patients SET therapy_status = ?, therapy_changed = UTC_TIMESTAMP(), therapy_expires = ?, therapy_reminder = '' WHERE id = ?"
+
+	_, err = tx.Exec(stmt, next, formatDate(expires), patientId)
+	if err != nil {
+		return err
+	}
+
+	stmt = "INSERT INTO therapy_transitions (patient_id, action, from_status, to_status, comment, expires, user_id, created) VALUES (?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())"
+
+	_, err = tx.Exec(stmt, patientId, action, before.TherapyStatus, next, strings.TrimSpace(comment), formatDate(expires), userId)
+	if err != nil {
+		return err
+	}
+
+	after, err := getPatientForUpdate(tx, m.Dialect, patientId, false)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionUpdate, AuditEntityPatient, patientId, before, after)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// returns the patient's therapy transitions, most recent first
+func (m *TherapyModel) GetAllByPatient(patientId int) ([]*TherapyTransition, error) {
+	var transitions []*TherapyTransition
+
+	stmt := `SELECT t.id, t.patient_id, t.action, t.from_status, t.to_status, t.comment, t.expires, t.user_id, u.name, t.created
+	FROM therapy_transitions t JOIN users u ON u.id = t.user_id
+	WHERE t.patient_id = ? ORDER BY t.created DESC, t.id DESC`
+
+	rows, err := m.DB.Query(stmt, patientId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t TherapyTransition
+
+		err := rows.Scan(&t.ID, &t.PatientId, &t.Action, &t.FromStatus, &t.ToStatus, &t.Comment, &t.Expires, &t.UserId, &t.UserName, &t.Created)
+		if err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, &t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return transitions, nil
+}
+
+// returns the patients submitted for approval which the user may decide on, that is the ones they neither own nor
+// co-own nor submitted themselves, longest waiting first
+func (m *TherapyModel) GetQueue(userId int) ([]*Patient, error) {
+	stmt := "SELECT " + patientColumns + ` FROM patients
+	WHERE therapy_status = ? AND deleted_at IS NULL AND user_id <> ?
+	AND NOT EXISTS(SELECT true FROM patient_co_owners c WHERE c.patient_id = patients.id AND c.user_id = ?)
+	AND COALESCE((SELECT t.user_id FROM therapy_transitions t WHERE t.patient_id = patients.id AND t.action = ? ORDER BY t.id DESC LIMIT 1), 0) <> ?
+	ORDER BY therapy_changed, id`
+
+	patients := &PatientModel{DB: m.DB, Dialect: m.Dialect, Keys: m.Keys}
+	return patients.query(stmt, TherapyStatusSubmitted, userId, userId, TherapyActionSubmit, userId)
+}
+
+// returns the user who last submitted the patient's therapy for approval, or 0 if it never was
+func getSubmitterId(db *sql.DB, patientId int) (int, error) {
+	var userId int
+
+	stmt := "SELECT user_id FROM therapy_transitions WHERE patient_id = ? AND action = ? ORDER BY id DESC LIMIT 1"
+	err := db.QueryRow(stmt, patientId, TherapyActionSubmit).Scan(&userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	return userId, nil
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+type Token struct {
+	ID       int
+	Name     string
+	UserId   int
+	Created  time.Time
+	LastUsed *time.Time
+}
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// hashes the plaintext token, as only the hash is ever persisted
+func hashToken(plaintext string) string {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(hash[:])
+}
+
+// generates a random plaintext token, long enough not to be guessed
+func generateToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+// generates a new personal access token for the user and returns its plaintext value, which cannot be retrieved afterwards
+func (m *TokenModel) Insert(name string, userId int) (string, error) {
+	plaintext, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := "INSERT INTO tokens (hash, name, user_id, created) VALUES (?, ?, ?, UTC_TIMESTAMP())"
+	_, err = m.DB.Exec(stmt, hashToken(plaintext), name, userId)
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+func (m *TokenModel) GetAllByUserId(userId int) ([]*Token, error) {
+	var tokens []*Token
+
+	stmt := "SELECT id, name, user_id, created, last_used FROM tokens WHERE user_id = ? ORDER BY id DESC"
+	rows, err := m.DB.Query(stmt, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t Token
+
+		err := rows.Scan(&t.ID, &t.Name, &t.UserId, &t.Created, &t.LastUsed)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// looks up the owner of a plaintext token and marks the token as used
+func (m *TokenModel) GetUserId(plaintext string) (int, error) {
+	var userId int
+	hash := hashToken(plaintext)
+
+	stmt := "SELECT user_id FROM tokens WHERE hash = ?"
+	err := m.DB.QueryRow(stmt, hash).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	stmt = "UPDATE tokens SET last_used = UTC_TIMESTAMP() WHERE hash = ?"
+	_, err = m.DB.Exec(stmt, hash)
+	if err != nil {
+		return 0, err
+	}
+
+	return userId, nil
+}
+
+func (m *TokenModel) Delete(id int, userId int) error {
+	stmt := "DELETE FROM tokens WHERE id = ? AND user_id = ?"
+
+	res, err := m.DB.Exec(stmt, id, userId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"p-system.okostadinov.net/internal/encryption"
+)
+
+const (
+	TransferStatusPending   = "pending"
+	TransferStatusAccepted  = "accepted"
+	TransferStatusDeclined  = "declined"
+	TransferStatusCancelled = "cancelled"
+)
+
+// a request to hand a patient over to another user, which only takes effect once the recipient accepts it
+type Transfer struct {
+	ID              int
+	PatientId       int
+	PatientName     string
+	FromUserId      int
+	FromUserName    string
+	ToUserId        int
+	ToUserName      string
+	RequestedBy     int
+	RequestedByName string
+	Status          string
+	Created         time.Time
+	Resolved        *time.Time
+}
+
+type TransferModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Keys    *encryption.Keyring
+}
+
+// the columns scanned by scanTransfer, in order, joined with the names of the patient and users involved
+const transferSelect = `SELECT t.id, t.patient_id, p.first_name, p.last_name, t.from_user_id, f.name, t.to_user_id, u.name, t.requested_by, r.name, t.status, t.created, t.resolved
+	FROM patient_transfers t
+	JOIN patients p ON p.id = t.patient_id
+	JOIN users f ON f.id = t.from_user_id
+	JOIN users u ON u.id = t.to_user_id
+	JOIN users r ON r.id = t.requested_by`
+
+func scanTransfer(row rowScanner, keys *encryption.Keyring) (*Transfer, error) {
+	var (
+		t                   Transfer
+		firstName, lastName string
+	)
+
+	err := row.Scan(&t.ID, &t.PatientId, &firstName, &lastName, &t.FromUserId, &t.FromUserName, &t.ToUserId, &t.ToUserName, &t.RequestedBy, &t.RequestedByName, &t.Status, &t.Created, &t.Resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	t.PatientName, err = decryptName(keys, firstName, lastName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// requests the patient to be handed over from its current owner, allowing only a single pending transfer per patient
+func (m *TransferModel) Insert(patientId int, toUserId int, userId int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	patient, err := getPatientForUpdate(tx, m.Dialect, patientId, false)
+	if err != nil {
+		return 0, err
+	}
+
+	var exists bool
+	stmt := "SELECT EXISTS(SELECT true FROM patient_transfers WHERE patient_id = ? AND status = ?)"
+
+	err = tx.QueryRow(stmt, patientId, TransferStatusPending).Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+
+	if exists {
+		return 0, ErrPendingTransfer
+	}
+
+	stmt = "INSERT INTO patient_transfers (patient_id, from_user_id, to_user_id, requested_by, status, created) VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())"
+
+	result, err := tx.Exec(stmt, patientId, patient.UserId, toUserId, userId, TransferStatusPending)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), tx.Commit()
+}
+
+func (m *TransferModel) Get(id int) (*Transfer, error) {
+	t, err := scanTransfer(m.DB.QueryRow(transferSelect+" WHERE t.id = ?", id), m.Keys)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// returns the pending transfers the user has been asked to accept, oldest first
+func (m *TransferModel) GetPendingByRecipient(userId int) ([]*Transfer, error) {
+	stmt := transferSelect + " WHERE t.to_user_id = ? AND t.status = ? AND p.deleted_at IS NULL ORDER BY t.created"
+	return m.query(stmt, userId, TransferStatusPending)
+}
+
+// returns the pending transfers of the user's own patients, as well as those the user requested
+func (m *TransferModel) GetPendingBySender(userId int) ([]*Transfer, error) {
+	stmt := transferSelect + " WHERE (t.from_user_id = ? OR t.requested_by = ?) AND t.status = ? AND p.deleted_at IS NULL ORDER BY t.created"
+	return m.query(stmt, userId, userId, TransferStatusPending)
+}
+
+// returns every transfer of the patient, most recent first
+func (m *TransferModel) GetAllByPatient(patientId int) ([]*Transfer, error) {
+	stmt := transferSelect + " WHERE t.patient_id = ? ORDER BY t.created DESC, t.id DESC"
+	return m.query(stmt, patientId)
+}
+
+func (m *TransferModel) query(stmt string, args ...any) ([]*Transfer, error) {
+	var transfers []*Transfer
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTransfer(rows, m.Keys)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+// fetches and locks a pending transfer for the remainder of the transaction
+func getPendingTransferForUpdate(tx *sql.Tx, d Dialect, id int) (*Transfer, error) {
+	stmt := "SELECT id, patient_id, from_user_id, to_user_id, requested_by FROM patient_transfers WHERE id = ? AND status = ?" + d.LockClause()
+
+	var t Transfer
+	err := tx.QueryRow(stmt, id, TransferStatusPending).Scan(&t.ID, &t.PatientId, &t.FromUserId, &t.ToUserId, &t.RequestedBy)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+func resolveTransfer(tx *sql.Tx, id int, status string, userId int) error {
+	stmt := "UPDATE patient_transfers SET status = ?, resolved = UTC_TIMESTAMP(), resolved_by = ? WHERE id = ?"
+	_, err := tx.Exec(stmt, status, userId, id)
+	return err
+}
+
+// hands the patient over to the recipient, who stops being a co-owner as they become the owner
+func (m *TransferModel) Accept(id int, userId int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	t, err := getPendingTransferForUpdate(tx, m.Dialect, id)
+	if err != nil {
+		return err
+	}
+
+	before, err := getPatientForUpdate(tx, m.Dialect, t.PatientId, false)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE patients SET user_id = ? WHERE id = ?", t.ToUserId, t.PatientId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM patient_co_owners WHERE patient_id = ? AND user_id = ?", t.PatientId, t.ToUserId)
+	if err != nil {
+		return err
+	}
+
+	err = resolveTransfer(tx, id, TransferStatusAccepted, userId)
+	if err != nil {
+		return err
+	}
+
+	after, err := getPatientForUpdate(tx, m.Dialect, t.PatientId, false)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEntry(tx, userId, AuditActionUpdate, AuditEntityPatient, t.PatientId, before, after)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *TransferModel) Decline(id int, userId int) error {
+	return m.close(id, TransferStatusDeclined, userId)
+}
+
+func (m *TransferModel) Cancel(id int, userId int) error {
+	return m.close(id, TransferStatusCancelled, userId)
+}
+
+// resolves a pending transfer without handing the patient over
+func (m *TransferModel) close(id int, status string, userId int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = getPendingTransferForUpdate(tx, m.Dialect, id)
+	if err != nil {
+		return err
+	}
+
+	err = resolveTransfer(tx, id, status, userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,348 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"p-system.okostadinov.net/internal/encryption"
+	"p-system.okostadinov.net/internal/totp"
+)
+
+// how many recovery codes a user is given on enrolling or regenerating them
+const RecoveryCodeCount = 10
+
+const (
+	// how many codes in a row a user may enter wrong before no code is accepted for a while, so that the codes cannot
+	// be guessed however often the password is entered again
+	MaxTwoFactorFailures = 5
+	// how long the first lockout lasts, doubling with every further wrong code up to the maximum
+	twoFactorLockout    = time.Minute
+	maxTwoFactorLockout = time.Hour
+)
+
+// the second factor of logging in: the TOTP secret of the user's authenticator app, sealed by the keys if any, along
+// with the recovery codes and remembered devices standing in for it; like other tokens, only the hashes of the latter
+// two are stored
+type TwoFactorModel struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Keys    *encryption.Keyring
+}
+
+// enrolls the user with the secret, provided the code shows their authenticator app generates the same codes;
+// returns their new recovery codes, which cannot be retrieved afterwards, replacing any issued before along with their
+// remembered devices
+func (m *TwoFactorModel) Enable(userId int, secret string, code string) ([]string, error) {
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	sealed, err := m.Keys.Encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := "UPDATE users SET totp_secret = ?, totp_last_step = ?, totp_failures = 0, totp_locked_until = NULL WHERE id = ?"
+	res, err := tx.Exec(stmt, sealed, step, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rows == 0 {
+		return nil, ErrNoRecord
+	}
+
+	_, err = tx.Exec("DELETE FROM remembered_devices WHERE user_id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// unenrolls the user, removing their secret, recovery codes and remembered devices along with the count of wrong codes,
+// so that enrolling again starts over
+func (m *TwoFactorModel) Disable(userId int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE users SET totp_secret = NULL, totp_last_step = 0, totp_failures = 0, totp_locked_until = NULL WHERE id = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"recovery_codes", "remembered_devices"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// sets whether the user has to enroll before they may use the application
+func (m *TwoFactorModel) SetRequired(userId int, required bool) error {
+	_, err := m.DB.Exec("UPDATE users SET totp_required = ? WHERE id = ?", required, userId)
+	return err
+}
+
+// checks the code against the user's authenticator app; a code is accepted only once, so that one seen over the
+// user's shoulder cannot be used again while it is still valid
+func (m *TwoFactorModel) Verify(userId int, code string) error {
+	return m.attempt(userId, func(tx *sql.Tx, f secondFactor) (bool, error) {
+		if !f.secret.Valid {
+			return false, nil
+		}
+
+		step, ok := totp.Validate(f.secret.String, code, time.Now())
+		if !ok || step <= f.lastStep {
+			return false, nil
+		}
+
+		_, err := tx.Exec("UPDATE users SET totp_last_step = ? WHERE id = ?", step, userId)
+		return err == nil, err
+	})
+}
+
+// consumes one of the user's recovery codes
+func (m *TwoFactorModel) Recover(userId int, code string) error {
+	return m.attempt(userId, func(tx *sql.Tx, _ secondFactor) (bool, error) {
+		stmt := "DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?"
+		res, err := tx.Exec(stmt, userId, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return false, err
+		}
+
+		rows, err := res.RowsAffected()
+		return rows > 0, err
+	})
+}
+
+// the user's enrollment as read by attempt
+type secondFactor struct {
+	secret   sql.NullString
+	lastStep int64
+}
+
+// runs the check of a code with the user locked, so that concurrent requests can neither use the same code nor get
+// around the count of wrong ones; the wrong codes are counted until one is accepted, the user being locked out once
+// there are too many, after which no code is checked until the lockout passes
+func (m *TwoFactorModel) attempt(userId int, check func(*sql.Tx, secondFactor) (bool, error)) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		f           secondFactor
+		failures    int
+		lockedUntil *time.Time
+	)
+
+	stmt := "SELECT totp_secret, totp_last_step, totp_failures, totp_locked_until FROM users WHERE id = ?" + m.Dialect.LockClause()
+	err = tx.QueryRow(stmt, userId).Scan(&f.secret, &f.lastStep, &failures, &lockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		} else {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	if lockedUntil != nil && now.Before(*lockedUntil) {
+		return ErrTwoFactorLocked
+	}
+
+	if f.secret.Valid {
+		f.secret.String, err = m.Keys.Decrypt(f.secret.String)
+		if err != nil {
+			return err
+		}
+	}
+
+	ok, err := check(tx, f)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		_, err = tx.Exec("UPDATE users SET totp_failures = 0, totp_locked_until = NULL WHERE id = ?", userId)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}
+
+	failures++
+	lockedUntil = nil
+	if failures >= MaxTwoFactorFailures {
+		until := now.Add(twoFactorLockoutAfter(failures))
+		lockedUntil = &until
+	}
+
+	_, err = tx.Exec("UPDATE users SET totp_failures = ?, totp_locked_until = ? WHERE id = ?", failures, lockedUntil, userId)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if lockedUntil != nil {
+		return ErrTwoFactorLocked
+	}
+	return ErrInvalidCode
+}
+
+// returns how long a user who entered the given number of wrong codes in a row is locked out for
+func twoFactorLockoutAfter(failures int) time.Duration {
+	lockout := twoFactorLockout
+	for i := MaxTwoFactorFailures; i < failures && lockout < maxTwoFactorLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, maxTwoFactorLockout)
+}
+
+// replaces the user's recovery codes with new ones, returned in plaintext as they cannot be retrieved afterwards
+func (m *TwoFactorModel) RegenerateRecoveryCodes(userId int) ([]string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// returns how many of the user's recovery codes are left unused
+func (m *TwoFactorModel) CountRecoveryCodes(userId int) (int, error) {
+	var count int
+	err := m.DB.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?", userId).Scan(&count)
+	return count, err
+}
+
+// remembers a device of the user for the given duration, returning the plaintext token to be kept in its cookie;
+// expired devices of every user are removed
+func (m *TwoFactorModel) RememberDevice(userId int, ttl time.Duration) (string, error) {
+	plaintext, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+
+	_, err = m.DB.Exec("DELETE FROM remembered_devices WHERE expires <= ?", now)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := "INSERT INTO remembered_devices (hash, user_id, created, expires) VALUES (?, ?, UTC_TIMESTAMP(), ?)"
+	_, err = m.DB.Exec(stmt, hashToken(plaintext), userId, now.Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// reports whether the token of a device's cookie remembers it for the user, as long as it has not expired
+func (m *TwoFactorModel) IsRemembered(userId int, plaintext string) (bool, error) {
+	var exists bool
+
+	stmt := "SELECT EXISTS(SELECT 1 FROM remembered_devices WHERE user_id = ? AND hash = ? AND expires > ?)"
+	err := m.DB.QueryRow(stmt, userId, hashToken(plaintext), time.Now().UTC()).Scan(&exists)
+	return exists, err
+}
+
+// forgets every device of the user, each of which has to go through the second step of logging in again
+func (m *TwoFactorModel) ForgetDevices(userId int) error {
+	_, err := m.DB.Exec("DELETE FROM remembered_devices WHERE user_id = ?", userId)
+	return err
+}
+
+// deletes the user's recovery codes and inserts new ones within the transaction, returning their plaintext values
+func replaceRecoveryCodes(tx *sql.Tx, userId int) ([]string, error) {
+	_, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		stmt := "INSERT INTO recovery_codes (hash, user_id, created) VALUES (?, ?, UTC_TIMESTAMP())"
+		_, err = tx.Exec(stmt, hashToken(normalizeRecoveryCode(codes[i])), userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// generates a random recovery code of 80 bits, grouped by dashes to be easier to copy down, e.g. abcd-efgh-ijkl-mnop
+func generateRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 10)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))
+
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// reduces a recovery code to its letters and digits, so that it matches however its case and grouping are typed
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+// tells recovery codes apart from the shorter, digits only codes of authenticator apps
+func IsRecoveryCode(code string) bool {
+	return len(normalizeRecoveryCode(code)) == 16
+}
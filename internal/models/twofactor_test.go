@@ -0,0 +1,69 @@
+package models_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/totp"
+)
+
+// enrolls the user with a new secret, returning a code of a step long past, which is never accepted
+func enrollTwoFactor(t *testing.T, stores *models.Stores, userId int) string {
+	t.Helper()
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step := totp.Step(time.Now())
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = stores.TwoFactor.Enable(userId, secret, code); err != nil {
+		t.Fatal(err)
+	}
+
+	wrong, err := totp.Code(secret, step-10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wrong
+}
+
+func TestTwoFactorDisableResetsLockout(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db *sql.DB, stores *models.Stores) {
+		insertUsers(t, stores, "alice")
+		const alice = 1
+
+		wrong := enrollTwoFactor(t, stores, alice)
+
+		for i := 1; i < models.MaxTwoFactorFailures; i++ {
+			assertErr(t, "entering a wrong code", stores.TwoFactor.Verify(alice, wrong), models.ErrInvalidCode)
+		}
+		assertErr(t, "entering the last wrong code", stores.TwoFactor.Verify(alice, wrong), models.ErrTwoFactorLocked)
+
+		// an admin resets the locked out user, who starts over without the wrong codes entered before
+		if err := stores.TwoFactor.Disable(alice); err != nil {
+			t.Fatal(err)
+		}
+
+		var failures int
+		var lockedUntil sql.NullTime
+		err := db.QueryRow("SELECT totp_failures, totp_locked_until FROM users WHERE id = ?", alice).Scan(&failures, &lockedUntil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failures != 0 || lockedUntil.Valid {
+			t.Errorf("got %d failures, locked until %v", failures, lockedUntil)
+		}
+
+		wrong = enrollTwoFactor(t, stores, alice)
+		assertErr(t, "entering a wrong code after enrolling again", stores.TwoFactor.Verify(alice, wrong), models.ErrInvalidCode)
+	})
+}
@@ -3,39 +3,79 @@ package models
 import (
 	"database/sql"
 	"errors"
-	"strings"
 	"time"
 
-	"github.com/go-sql-driver/mysql"
 	"golang.org/x/crypto/bcrypt"
 )
 
+type Role string
+
+const (
+	RoleAdmin      Role = "admin"
+	RoleDoctor     Role = "doctor"
+	RolePharmacist Role = "pharmacist"
+	RoleReadOnly   Role = "readonly"
+)
+
+// all assignable roles, in the order they are offered in the admin UI
+var Roles = []Role{RoleAdmin, RoleDoctor, RolePharmacist, RoleReadOnly}
+
+func (r Role) Valid() bool {
+	for _, role := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 type User struct {
 	ID             int
 	Name           string
 	Email          string
 	HashedPassword []byte
+	Role           Role
 	Created        time.Time
+	// incremented whenever the password changes, so that sessions logged in before are ended
+	SessionVersion int
+	// whether the user enrolled an authenticator app, and whether an admin requires them to
+	TwoFactorEnabled  bool
+	TwoFactorRequired bool
+}
+
+// the columns of a user scanned by scanUser, leaving out the password hash and the TOTP secret
+const userColumns = "id, name, email, role, created, session_version, totp_secret IS NOT NULL, totp_required"
+
+func scanUser(row rowScanner) (*User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Role, &u.Created, &u.SessionVersion, &u.TwoFactorEnabled, &u.TwoFactorRequired)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect Dialect
+}
+
+func hashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), 12)
 }
 
 func (m *UserModel) Insert(name, email, password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := hashPassword(password)
 	if err != nil {
 		return err
 	}
 
 	stmt := "INSERT INTO users (name, email, hashed_password, created) VALUES (?, ?, ?, UTC_TIMESTAMP())"
 
+	// the email is the only unique column besides the generated id
 	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
-	var mySQLError *mysql.MySQLError
-	if errors.As(err, &mySQLError) {
-		if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-			return ErrDuplicateEmail
-		}
+	if m.Dialect.IsDuplicate(err) {
+		return ErrDuplicateEmail
 	}
 	return err
 }
@@ -60,10 +100,153 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 	return u.ID, nil
 }
 
-func (m *UserModel) Exists(id int) (bool, error) {
-	var exists bool
-	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
-	err := m.DB.QueryRow(stmt, id).Scan(&exists)
+func (m *UserModel) Get(id int) (*User, error) {
+	stmt := "SELECT " + userColumns + " FROM users WHERE id = ?"
+	u, err := scanUser(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
+
+func (m *UserModel) GetByEmail(email string) (*User, error) {
+	stmt := "SELECT " + userColumns + " FROM users WHERE email = ?"
+	u, err := scanUser(m.DB.QueryRow(stmt, email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
+
+func (m *UserModel) GetAll() ([]*User, error) {
+	var users []*User
+
+	stmt := "SELECT " + userColumns + " FROM users ORDER BY name"
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (m *UserModel) UpdateRole(id int, role Role) error {
+	stmt := "UPDATE users SET role = ? WHERE id = ?"
+	_, err := m.DB.Exec(stmt, role, id)
+	return err
+}
+
+// changes the user's name and email address, which has to remain unique
+func (m *UserModel) UpdateProfile(id int, name, email string) error {
+	stmt := "UPDATE users SET name = ?, email = ? WHERE id = ?"
+	_, err := m.DB.Exec(stmt, name, email, id)
+	if m.Dialect.IsDuplicate(err) {
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+// checks the password of the user, for actions which have to be confirmed by it
+func (m *UserModel) CheckPassword(id int, password string) error {
+	var hashedPassword []byte
+	stmt := "SELECT hashed_password FROM users WHERE id = ?"
+	err := m.DB.QueryRow(stmt, id).Scan(&hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		} else {
+			return err
+		}
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err != nil {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// replaces the user's password, provided the current one is given, ending their other sessions and forgetting their
+// remembered devices; their access tokens are revoked as well if asked to
+func (m *UserModel) ChangePassword(id int, currentPassword, newPassword string, revokeTokens bool) error {
+	err := m.CheckPassword(id, currentPassword)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = setPassword(tx, id, hashedPassword, revokeTokens)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sets the user's password within the transaction, bumping their session version so that every session logged in
+// with the old one ends, and forgetting their remembered devices, which would otherwise skip the second step of
+// logging in with the new one; their access tokens are deleted as well if asked to
+func setPassword(tx *sql.Tx, userId int, hashedPassword []byte, revokeTokens bool) error {
+	stmt := "UPDATE users SET hashed_password = ?, session_version = session_version + 1 WHERE id = ?"
+	res, err := tx.Exec(stmt, string(hashedPassword), userId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	tables := []string{"remembered_devices"}
+	if revokeTokens {
+		tables = append(tables, "tokens")
+	}
+
+	for _, table := range tables {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", userId)
+		if err != nil {
+			return err
+		}
+	}
 
-	return exists, err
+	return nil
 }
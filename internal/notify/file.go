@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// writes every message as an .eml file to a directory instead of sending it, so that emails can be read during
+// development without an SMTP server
+type FileNotifier struct {
+	dir  string
+	from string
+	seq  atomic.Int64
+}
+
+// returns a notifier writing to the directory, creating it if it does not exist
+func NewFileNotifier(dir string, from string) (*FileNotifier, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileNotifier{dir: dir, from: from}, nil
+}
+
+// names the file by the time it was written, a sequence number and its recipient, so that the files list in the order
+// the messages were sent
+func (n *FileNotifier) Notify(msg Message) error {
+	recipient := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, msg.To)
+
+	name := fmt.Sprintf("%s-%04d-%s.eml", time.Now().UTC().Format("20060102T150405"), n.seq.Add(1), recipient)
+
+	return os.WriteFile(filepath.Join(n.dir, name), compose(n.from, msg), 0o600)
+}
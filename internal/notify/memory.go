@@ -0,0 +1,42 @@
+package notify
+
+import "sync"
+
+// keeps the messages in memory instead of delivering them, standing in for a real notifier in tests
+type MemoryNotifier struct {
+	// if set, returns the error a message fails with, failed messages not being kept
+	Fail func(msg Message) error
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (n *MemoryNotifier) Notify(msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.Fail != nil {
+		if err := n.Fail(msg); err != nil {
+			return err
+		}
+	}
+
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+// returns a copy of the messages received so far, in the order they were sent
+func (n *MemoryNotifier) Messages() []Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]Message(nil), n.messages...)
+}
+
+// forgets the messages received so far
+func (n *MemoryNotifier) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.messages = nil
+}
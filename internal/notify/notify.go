@@ -0,0 +1,14 @@
+// Package notify delivers notifications to the application's users through a pluggable Notifier.
+package notify
+
+// a plain text notification addressed to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// delivers messages to their recipients, e.g. by email
+type Notifier interface {
+	Notify(msg Message) error
+}
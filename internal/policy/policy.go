@@ -0,0 +1,172 @@
+package policy
+
+import (
+	"slices"
+
+	"p-system.okostadinov.net/internal/models"
+)
+
+// the authenticated user on whose behalf an action is performed; the zero value is an anonymous user who may do nothing
+type Actor struct {
+	ID   int
+	Role models.Role
+}
+
+func (a Actor) IsAdmin() bool {
+	return a.Role == models.RoleAdmin
+}
+
+// every authenticated user may browse patients and medications
+func (a Actor) CanRead() bool {
+	return a.ID != 0
+}
+
+func (a Actor) CanCreatePatient() bool {
+	return a.Role == models.RoleAdmin || a.Role == models.RoleDoctor
+}
+
+// users who may own patients, and thereby receive transfers or be made co-owners
+func (a Actor) CanOwnPatients() bool {
+	return a.CanCreatePatient()
+}
+
+// admins may edit any patient, while doctors are limited to the patients they own or co-own
+func (a Actor) CanUpdatePatient(p *models.Patient) bool {
+	switch a.Role {
+	case models.RoleAdmin:
+		return true
+	case models.RoleDoctor:
+		return p.UserId == a.ID || slices.Contains(p.CoOwnerIds, a.ID)
+	default:
+		return false
+	}
+}
+
+// deleting, restoring and handing over a patient are reserved to its owner, co-owners aside
+func (a Actor) isPatientOwner(p *models.Patient) bool {
+	switch a.Role {
+	case models.RoleAdmin:
+		return true
+	case models.RoleDoctor:
+		return p.UserId == a.ID
+	default:
+		return false
+	}
+}
+
+func (a Actor) CanDeletePatient(p *models.Patient) bool {
+	return a.isPatientOwner(p)
+}
+
+// whoever may update a patient may also put them on or take them off a medication
+func (a Actor) CanPrescribe(p *models.Patient) bool {
+	return a.CanUpdatePatient(p)
+}
+
+// notes are written by those who may update the patient
+func (a Actor) CanWriteNotes(p *models.Patient) bool {
+	return a.CanUpdatePatient(p)
+}
+
+// only the author may edit a note, as long as it is not locked
+func (a Actor) CanEditNote(n *models.Note) bool {
+	return a.ID == n.UserId
+}
+
+// files are attached to the patient by those who may update it
+func (a Actor) CanAttachFiles(p *models.Patient) bool {
+	return a.CanUpdatePatient(p)
+}
+
+// attachments are removed by those who may update the patient, whoever attached them
+func (a Actor) CanDeleteAttachment(p *models.Patient) bool {
+	return a.CanUpdatePatient(p)
+}
+
+// vitals are recorded by those who may update the patient
+func (a Actor) CanRecordVitals(p *models.Patient) bool {
+	return a.CanUpdatePatient(p)
+}
+
+// therapies are decided on by admins and doctors
+func (a Actor) CanReviewTherapies() bool {
+	return a.Role == models.RoleAdmin || a.Role == models.RoleDoctor
+}
+
+// a reviewer may not decide on the therapy of a patient they are in charge of or whose therapy they submitted, so that
+// no one approves their own submission
+func (a Actor) CanReviewTherapy(p *models.Patient) bool {
+	return a.CanReviewTherapies() && p.UserId != a.ID && !slices.Contains(p.CoOwnerIds, a.ID) && p.SubmittedBy != a.ID
+}
+
+// whoever may update the patient submits its therapy for approval, while reviewers approve, reject and continue it
+func (a Actor) CanTransitionTherapy(p *models.Patient, action models.TherapyAction) bool {
+	if action == models.TherapyActionSubmit {
+		return a.CanUpdatePatient(p)
+	}
+	return a.CanReviewTherapy(p)
+}
+
+func (a Actor) CanRestorePatient(p *models.Patient) bool {
+	return a.isPatientOwner(p)
+}
+
+// merging a duplicate removes it, so it is reserved to whoever owns both patients
+func (a Actor) CanMergePatients(p *models.Patient, duplicate *models.Patient) bool {
+	return a.isPatientOwner(p) && a.isPatientOwner(duplicate)
+}
+
+func (a Actor) CanTransferPatient(p *models.Patient) bool {
+	return a.isPatientOwner(p)
+}
+
+func (a Actor) CanManageCoOwners(p *models.Patient) bool {
+	return a.isPatientOwner(p)
+}
+
+// only the recipient may accept or decline a transfer, provided their role still allows owning patients
+func (a Actor) CanAcceptTransfer(t *models.Transfer) bool {
+	return t.ToUserId == a.ID && a.CanOwnPatients()
+}
+
+// a pending transfer may be withdrawn by whoever requested it, the patient's owner or an admin
+func (a Actor) CanCancelTransfer(t *models.Transfer) bool {
+	return t.RequestedBy == a.ID || t.FromUserId == a.ID || a.IsAdmin()
+}
+
+func (a Actor) CanCreateMedication() bool {
+	return a.Role == models.RoleAdmin || a.Role == models.RolePharmacist || a.Role == models.RoleDoctor
+}
+
+// admins and pharmacists manage the whole medication list, while doctors are limited to the medications they added
+func (a Actor) CanUpdateMedication(m *models.Medication) bool {
+	switch a.Role {
+	case models.RoleAdmin, models.RolePharmacist:
+		return true
+	case models.RoleDoctor:
+		return m.UserId == a.ID
+	default:
+		return false
+	}
+}
+
+func (a Actor) CanDeleteMedication(m *models.Medication) bool {
+	return a.CanUpdateMedication(m)
+}
+
+func (a Actor) CanRestoreMedication(m *models.Medication) bool {
+	return a.CanUpdateMedication(m)
+}
+
+// the interactions list is reference data, maintained by the same users who manage the whole medication list
+func (a Actor) CanManageInteractions() bool {
+	return a.Role == models.RoleAdmin || a.Role == models.RolePharmacist
+}
+
+func (a Actor) CanPurgeTrash() bool {
+	return a.IsAdmin()
+}
+
+func (a Actor) CanManageUsers() bool {
+	return a.IsAdmin()
+}
@@ -0,0 +1,370 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// the worked example of ISO/IEC 18004 and most QR code tutorials: "HELLO WORLD" in alphanumeric mode at version 1-M,
+// the error correction codewords being independent of the mode the data codewords were encoded in
+func TestReedSolomonRemainder(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	got := reedSolomonRemainder(data, reedSolomonDivisor(len(want)))
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// the format information of the medium level, from table C.1 of ISO/IEC 18004
+func TestFormatBits(t *testing.T) {
+	tests := []string{
+		"101010000010010",
+		"101000100100101",
+		"101111001111100",
+		"101101101001011",
+		"100010111111001",
+		"100000011001110",
+		"100111110010111",
+		"100101010100000",
+	}
+
+	for mask, want := range tests {
+		if got := fmt.Sprintf("%015b", formatBits(mask)); got != want {
+			t.Errorf("mask %d: got %s, want %s", mask, got, want)
+		}
+	}
+}
+
+// the version information, from table D.1 of ISO/IEC 18004
+func TestVersionBits(t *testing.T) {
+	tests := []struct {
+		version int
+		want    int
+	}{
+		{7, 0x07c94},
+		{8, 0x085bc},
+		{20, 0x149a6},
+		{40, 0x28c69},
+	}
+
+	for _, tt := range tests {
+		if got := versionBits(tt.version); got != tt.want {
+			t.Errorf("version %d: got %05x, want %05x", tt.version, got, tt.want)
+		}
+	}
+}
+
+// the centres of the alignment patterns, from table E.1 of ISO/IEC 18004
+func TestAlignmentPositions(t *testing.T) {
+	tests := []struct {
+		version int
+		want    []int
+	}{
+		{1, nil},
+		{2, []int{6, 18}},
+		{7, []int{6, 22, 38}},
+		{10, []int{6, 28, 50}},
+		{22, []int{6, 26, 50, 74, 98}},
+		{32, []int{6, 34, 60, 86, 112, 138}},
+		{36, []int{6, 24, 50, 76, 102, 128, 154}},
+		{40, []int{6, 30, 58, 86, 114, 142, 170}},
+	}
+
+	for _, tt := range tests {
+		if got := alignmentPositions(tt.version); !slices.Equal(got, tt.want) {
+			t.Errorf("version %d: got %v, want %v", tt.version, got, tt.want)
+		}
+	}
+}
+
+// the data codewords at the medium level, from table 7 of ISO/IEC 18004
+func TestDataCodewords(t *testing.T) {
+	tests := []struct {
+		version int
+		want    int
+	}{
+		{1, 16},
+		{2, 28},
+		{5, 86},
+		{7, 124},
+		{10, 216},
+		{20, 669},
+		{27, 1128},
+		{40, 2334},
+	}
+
+	for _, tt := range tests {
+		if got := dataCodewords(tt.version); got != tt.want {
+			t.Errorf("version %d: got %d, want %d", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		version int
+	}{
+		{"empty", "", 1},
+		{"version 1 full", strings.Repeat("a", 14), 1},
+		{"version 2", strings.Repeat("a", 15), 2},
+		{"version 9 full", strings.Repeat("b", 180), 9},
+		{"version 10, wider count", strings.Repeat("b", 181), 10},
+		{"otpauth URL", "otpauth://totp/P-System:alice%40example.com?algorithm=SHA1&digits=6&issuer=P-System&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP", 8},
+		{"binary", "\x00\x01\xfe\xff", 1},
+		{"version 40 full", strings.Repeat("c", 2331), 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Encode([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := tt.version*4 + 17; c.Size != want {
+				t.Fatalf("got size %d, want %d of version %d", c.Size, want, tt.version)
+			}
+
+			got, err := decode(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.data {
+				t.Errorf("decoded %q, want %q", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	_, err := Encode(bytes.Repeat([]byte("c"), 2332))
+	if !errors.Is(err, ErrTooLong) {
+		t.Errorf("got %v, want %v", err, ErrTooLong)
+	}
+}
+
+func TestSVG(t *testing.T) {
+	c, err := Encode([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svg := c.SVG(200, `Scan "me"`)
+
+	for _, want := range []string{`viewBox="0 0 29 29"`, `width="200"`, `aria-label="Scan &#34;me&#34;"`, `<path fill="#000" d="M4 4h1v1h-1z`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("missing %s in %s", want, svg)
+		}
+	}
+}
+
+// reads the data back from the modules the way a reader does: the format bits give the mask, the modules outside the
+// function patterns give the codewords, whose blocks have to be free of errors, and the codewords give the segment
+func decode(c *Code) ([]byte, error) {
+	version := (c.Size - 17) / 4
+
+	// the first copy of the format bits, around the top left finder pattern
+	format := 0
+	positions := [15][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}}
+	for i, p := range positions {
+		if c.Dark(p[0], p[1]) {
+			format |= 1 << i
+		}
+	}
+
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if formatBits(m) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		return nil, fmt.Errorf("unknown format bits %015b", format)
+	}
+
+	if !c.Dark(8, c.Size-8) {
+		return nil, errors.New("missing dark module")
+	}
+
+	// which modules belong to the function patterns is worked out from an empty code of the same version
+	function := newCode(version)
+	function.drawFunctionPatterns(version)
+
+	// the codewords zigzag in two module wide columns from the bottom right, upwards first, skipping over the
+	// vertical timing pattern
+	var bits []bool
+	for pair, right := 0, c.Size-1; right >= 1; pair, right = pair+1, right-2 {
+		if right == 6 {
+			right--
+		}
+		upward := pair%2 == 0
+		for i := 0; i < c.Size; i++ {
+			y := i
+			if upward {
+				y = c.Size - 1 - i
+			}
+			for _, x := range []int{right, right - 1} {
+				if function.isFunction[y][x] {
+					continue
+				}
+				bits = append(bits, c.Dark(x, y) != masked(mask, x, y))
+			}
+		}
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i := range codewords {
+		for _, b := range bits[i*8 : i*8+8] {
+			codewords[i] <<= 1
+			if b {
+				codewords[i] |= 1
+			}
+		}
+	}
+
+	data, err := deinterleave(codewords, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// a single segment in byte mode
+	if data[0]>>4 != 0b0100 {
+		return nil, fmt.Errorf("mode %04b instead of bytes", data[0]>>4)
+	}
+
+	var stream bitBuffer
+	for _, b := range data {
+		stream.append(int(b), 8)
+	}
+	read := func(from int, length int) int {
+		value := 0
+		for _, b := range stream[from : from+length] {
+			value <<= 1
+			if b {
+				value |= 1
+			}
+		}
+		return value
+	}
+
+	count := read(4, countBits(version))
+	start := 4 + countBits(version)
+	if start+count*8 > len(stream) {
+		return nil, fmt.Errorf("count %d exceeds the data", count)
+	}
+
+	result := make([]byte, count)
+	for i := range result {
+		result[i] = byte(read(start+i*8, 8))
+	}
+	return result, nil
+}
+
+// reports whether the mask inverts the module, following table 10 of ISO/IEC 18004 with i the row and j the column
+func masked(mask int, j int, i int) bool {
+	switch mask {
+	case 0:
+		return (i+j)%2 == 0
+	case 1:
+		return i%2 == 0
+	case 2:
+		return j%3 == 0
+	case 3:
+		return (i+j)%3 == 0
+	case 4:
+		return (i/2+j/3)%2 == 0
+	case 5:
+		return (i*j)%2+(i*j)%3 == 0
+	case 6:
+		return ((i*j)%2+(i*j)%3)%2 == 0
+	default:
+		return ((i*j)%3+(i+j)%2)%2 == 0
+	}
+}
+
+// splits the interleaved codewords into their blocks, checking that every block is a codeword of the Reed-Solomon
+// code, and returns the data codewords of the blocks in order
+func deinterleave(codewords []byte, version int) ([]byte, error) {
+	numBlocks := eccBlocks[version]
+	eccLen := eccPerBlock[version]
+	total := rawDataModules(version) / 8
+	if len(codewords) < total {
+		return nil, fmt.Errorf("read %d codewords, want %d", len(codewords), total)
+	}
+	codewords = codewords[:total]
+
+	// the last blocks are one data codeword longer than the first ones
+	numLong := total % numBlocks
+	shortData := total/numBlocks - eccLen
+
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < shortData+1; i++ {
+		for j := range blocks {
+			if i == shortData && j < numBlocks-numLong {
+				continue
+			}
+			blocks[j] = append(blocks[j], codewords[k])
+			k++
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for j := range blocks {
+			blocks[j] = append(blocks[j], codewords[k])
+			k++
+		}
+	}
+
+	var data []byte
+	for j, block := range blocks {
+		for i := 0; i < eccLen; i++ {
+			if s := syndrome(block, i); s != 0 {
+				return nil, fmt.Errorf("block %d has syndrome %d of %d", j, s, i)
+			}
+		}
+		data = append(data, block[:len(block)-eccLen]...)
+	}
+	return data, nil
+}
+
+// evaluates the block as a polynomial, highest coefficient first, at the i-th power of the generator α = 2, which is
+// zero for every root of the generator polynomial if the block is free of errors
+func syndrome(block []byte, i int) byte {
+	alpha := 1
+	for ; i > 0; i-- {
+		alpha <<= 1
+		if alpha&0x100 != 0 {
+			alpha ^= 0x11d
+		}
+	}
+
+	var result byte
+	for _, b := range block {
+		result = multiply(result, byte(alpha)) ^ b
+	}
+	return result
+}
+
+// multiplies in GF(2^8) by shifting and adding, independently of gfMultiply
+func multiply(x byte, y byte) byte {
+	var result byte
+	for a, b := int(x), y; b != 0; b >>= 1 {
+		if b&1 != 0 {
+			result ^= byte(a)
+		}
+		a <<= 1
+		if a&0x100 != 0 {
+			a ^= 0x11d
+		}
+	}
+	return result
+}
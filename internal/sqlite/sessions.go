@@ -0,0 +1,133 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// a gorilla session store keeping the session values in the sessions table, the cookie only holding the signed id
+type SessionStore struct {
+	DB      *sql.DB
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+func NewSessionStore(db *sql.DB, keyPairs ...[]byte) *SessionStore {
+	return &SessionStore{
+		DB:      db,
+		Codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{Path: "/", MaxAge: 86400 * 30},
+	}
+}
+
+// returns the session cached for the request, loading it on first use
+func (s *SessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// returns the session referenced by the request's cookie, or a new one if there is no valid session
+func (s *SessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	err = securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+
+	err = s.load(session)
+	if err == nil {
+		session.IsNew = false
+	} else if errors.Is(err, sql.ErrNoRows) {
+		// an expired or deleted session is replaced by a new one
+		session.ID = ""
+		err = nil
+	}
+
+	return session, err
+}
+
+// persists the session, deleting it once its max age turns negative
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if _, err := s.DB.Exec("DELETE FROM sessions WHERE id = ?", session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	expires := now.Add(time.Duration(session.Options.MaxAge) * time.Second)
+
+	if session.ID == "" {
+		stmt := "INSERT INTO sessions (session_data, created_on, modified_on, expires_on) VALUES (?, ?, ?, ?)"
+		result, err := s.DB.Exec(stmt, encoded, now, now, expires)
+		if err != nil {
+			return err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		session.ID = strconv.FormatInt(id, 10)
+	} else {
+		stmt := "UPDATE sessions SET session_data = ?, modified_on = ?, expires_on = ? WHERE id = ?"
+		_, err = s.DB.Exec(stmt, encoded, now, expires, session.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	cookie, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), cookie, session.Options))
+	return nil
+}
+
+func (s *SessionStore) load(session *sessions.Session) error {
+	var data string
+
+	stmt := "SELECT session_data FROM sessions WHERE id = ? AND expires_on > ?"
+	err := s.DB.QueryRow(stmt, session.ID, time.Now().UTC()).Scan(&data)
+	if err != nil {
+		return err
+	}
+
+	return securecookie.DecodeMulti(session.Name(), data, &session.Values, s.Codecs...)
+}
+
+// deletes the expired sessions on every tick of the interval, meant to be run in its own goroutine;
+// failures are simply retried on the next tick
+func (s *SessionStore) Cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.DB.Exec("DELETE FROM sessions WHERE expires_on <= ?", time.Now().UTC())
+	}
+}
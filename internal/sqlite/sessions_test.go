@@ -0,0 +1,178 @@
+package sqlite_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"p-system.okostadinov.net/internal/sqlite"
+	"p-system.okostadinov.net/internal/testdb"
+)
+
+const sessionName = "session"
+
+var sessionKey = []byte("0123456789abcdef0123456789abcdef")
+
+// a request carrying the cookies set by the response, as the browser would send it next
+func requestWithCookies(w *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestSessionStore(t *testing.T) {
+	db, _ := testdb.Open(t, "sqlite")
+	store := sqlite.NewSessionStore(db, sessionKey)
+
+	session, err := store.New(httptest.NewRequest(http.MethodGet, "/", nil), sessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.IsNew || session.ID != "" {
+		t.Fatalf("got session %q, new %t", session.ID, session.IsNew)
+	}
+
+	session.Values["userId"] = 1
+
+	w := httptest.NewRecorder()
+	if err = store.Save(nil, w, session); err != nil {
+		t.Fatal(err)
+	}
+	id := session.ID
+
+	var data string
+	if err = db.QueryRow("SELECT session_data FROM sessions WHERE id = ?", id).Scan(&data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cookie only holds the signed id, the values being kept in the database
+	cookie := w.Result().Cookies()[0]
+	if cookie.Value == data || cookie.MaxAge != 86400*30 {
+		t.Errorf("got cookie %+v", cookie)
+	}
+
+	t.Run("loads", func(t *testing.T) {
+		loaded, err := store.New(requestWithCookies(w), sessionName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.IsNew || loaded.ID != id || loaded.Values["userId"] != 1 {
+			t.Errorf("got session %q, new %t, values %v", loaded.ID, loaded.IsNew, loaded.Values)
+		}
+
+		// saving it again updates the same row
+		loaded.Values["userId"] = 2
+		if err = store.Save(nil, httptest.NewRecorder(), loaded); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded, err := store.New(requestWithCookies(w), sessionName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reloaded.ID != id || reloaded.Values["userId"] != 2 {
+			t.Errorf("got session %q, values %v", reloaded.ID, reloaded.Values)
+		}
+	})
+
+	t.Run("rejects a tampered cookie", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(&http.Cookie{Name: sessionName, Value: cookie.Value + "x"})
+
+		tampered, err := store.New(r, sessionName)
+		if err == nil {
+			t.Error("accepted a tampered cookie")
+		}
+		if !tampered.IsNew || len(tampered.Values) != 0 {
+			t.Errorf("got session %q, values %v", tampered.ID, tampered.Values)
+		}
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		if _, err := db.Exec("UPDATE sessions SET expires_on = ? WHERE id = ?", time.Now().UTC().Add(-time.Second), id); err != nil {
+			t.Fatal(err)
+		}
+
+		expired, err := store.New(requestWithCookies(w), sessionName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !expired.IsNew || expired.ID != "" || len(expired.Values) != 0 {
+			t.Errorf("got session %q, new %t, values %v", expired.ID, expired.IsNew, expired.Values)
+		}
+
+		if _, err := db.Exec("UPDATE sessions SET expires_on = ? WHERE id = ?", time.Now().UTC().Add(time.Hour), id); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("deletes", func(t *testing.T) {
+		loaded, err := store.New(requestWithCookies(w), sessionName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loaded.Options.MaxAge = -1
+
+		deleted := httptest.NewRecorder()
+		if err = store.Save(nil, deleted, loaded); err != nil {
+			t.Fatal(err)
+		}
+
+		var count int
+		if err = db.QueryRow("SELECT COUNT(*) FROM sessions WHERE id = ?", id).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Error("the session was kept")
+		}
+
+		if cookie := deleted.Result().Cookies()[0]; cookie.MaxAge >= 0 {
+			t.Errorf("the cookie was kept: %+v", cookie)
+		}
+	})
+}
+
+func TestSessionStoreCleanup(t *testing.T) {
+	db, _ := testdb.Open(t, "sqlite")
+	store := sqlite.NewSessionStore(db, sessionKey)
+
+	now := time.Now().UTC()
+	for _, expires := range []time.Time{now.Add(-time.Hour), now.Add(time.Hour)} {
+		_, err := db.Exec("INSERT INTO sessions (session_data, created_on, modified_on, expires_on) VALUES ('', ?, ?, ?)", now, now, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	go store.Cleanup(10 * time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var ids []int
+
+		rows, err := db.Query("SELECT id FROM sessions ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var id int
+			if err = rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		if len(ids) == 1 && ids[0] == 2 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got sessions %v, want only the unexpired one", ids)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
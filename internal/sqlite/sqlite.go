@@ -0,0 +1,105 @@
+// Package sqlite runs the application on an embedded, pure Go SQLite database, suited for local single-user setups.
+//
+// The models share their SQL with the MySQL backend, with the Dialect covering the few differences and the
+// MySQL functions used by the statements registered as SQLite functions.
+package sqlite
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"p-system.okostadinov.net/internal/models"
+)
+
+var registerFunctions sync.Once
+
+// opens and tests a connection pool to the database file, creating it if needed
+func Open(path string) (*sql.DB, error) {
+	var err error
+	registerFunctions.Do(func() {
+		err = sqlite.RegisterScalarFunction("UTC_TIMESTAMP", 0, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			return time.Now().UTC().Format("2006-01-02 15:04:05"), nil
+		})
+		if err != nil {
+			return
+		}
+		err = sqlite.RegisterScalarFunction("UTC_DATE", 0, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			return time.Now().UTC().Format(models.DateLayout), nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Set("_time_format", "sqlite")
+	// writers take the lock upfront, standing in for the row locks MySQL transactions rely on
+	params.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+type dialect struct{}
+
+// the dialect of SQLite, which lacks row locks and a MySQL compatible full-text search
+var Dialect models.Dialect = dialect{}
+
+// transactions lock the whole database instead, see the _txlock parameter in Open
+func (dialect) LockClause() string {
+	return ""
+}
+
+func (dialect) IsDuplicate(err error) bool {
+	var sqliteError *sqlite.Error
+	if !errors.As(err, &sqliteError) {
+		return false
+	}
+	return sqliteError.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteError.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+// every search term has to appear in one of the searched columns of the patient, in one of its notes or in a medication
+// it has been prescribed, or the query has to prefix the UCN
+func (dialect) SearchCondition(query string) (string, []any) {
+	var (
+		conditions []string
+		args       []any
+	)
+
+	for _, term := range models.SearchTerms(query) {
+		pattern := "%" + models.EscapeLike(term) + "%"
+		conditions = append(conditions, `(first_name LIKE ? ESCAPE '\' OR last_name LIKE ? ESCAPE '\' OR phone_number LIKE ? ESCAPE '\' OR EXISTS(SELECT true FROM patient_notes n WHERE n.patient_id = patients.id AND n.body LIKE ? ESCAPE '\') OR EXISTS(SELECT true FROM prescriptions pr JOIN medications m ON m.id = pr.medication_id WHERE pr.patient_id = patients.id AND (m.name LIKE ? ESCAPE '\' OR m.active_ingredient LIKE ? ESCAPE '\')))`)
+		args = append(args, pattern, pattern, pattern, pattern, pattern, pattern)
+	}
+
+	condition := `ucn LIKE ? ESCAPE '\'`
+	if len(conditions) > 0 {
+		condition = "((" + strings.Join(conditions, " AND ") + ") OR " + condition + ")"
+	}
+
+	return condition, append(args, models.UCNPrefix(query))
+}
+
+// without a relevance score, UCN matches come first and the rest are ordered by the caller's fallback
+func (dialect) SearchOrder(query string) (string, []any) {
+	return `ucn LIKE ? ESCAPE '\' DESC`, []any{models.UCNPrefix(query)}
+}
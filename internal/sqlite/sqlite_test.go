@@ -0,0 +1,55 @@
+package sqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/sqlite"
+)
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// opening the database again registers the MySQL functions only once
+	for i := 0; i < 2; i++ {
+		db, err := sqlite.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		var timestamp, date string
+		if err = db.QueryRow("SELECT UTC_TIMESTAMP(), UTC_DATE()").Scan(&timestamp, &date); err != nil {
+			t.Fatal(err)
+		}
+
+		now, err := time.Parse("2006-01-02 15:04:05", timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(now); d < -time.Minute || d > time.Minute {
+			t.Errorf("UTC_TIMESTAMP() is %s off", d)
+		}
+		if date != now.Format(models.DateLayout) && date != time.Now().UTC().Format(models.DateLayout) {
+			t.Errorf("got UTC_DATE() %s", date)
+		}
+
+		for pragma, want := range map[string]string{"foreign_keys": "1", "busy_timeout": "5000", "journal_mode": "wal"} {
+			var got string
+			if err = db.QueryRow("PRAGMA " + pragma).Scan(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("%s: got %s, want %s", pragma, got, want)
+			}
+		}
+	}
+}
+
+func TestOpenInvalidPath(t *testing.T) {
+	if _, err := sqlite.Open(filepath.Join(t.TempDir(), "missing", "test.db")); err == nil {
+		t.Error("opened a database in a missing directory")
+	}
+}
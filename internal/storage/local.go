@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// keeps the files in a directory on the local filesystem, fanned out into subdirectories named after the first two
+// characters of their key
+type Local struct {
+	root string
+}
+
+// returns a store keeping its files under the root directory, which is created if it does not exist
+func NewLocal(root string) (*Local, error) {
+	err := os.MkdirAll(root, 0o750)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{root: root}, nil
+}
+
+func (s *Local) path(key string) string {
+	return filepath.Join(s.root, key[:2], key)
+}
+
+// writes the contents to a temporary file while hashing them, then moves it into place, so that a file is never seen
+// partially written under its key
+func (s *Local) Put(r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(s.root, ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		tmp.Close()
+		return "", 0, err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	err = os.MkdirAll(filepath.Dir(s.path(key)), 0o750)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// identical contents may already be stored, in which case they are simply replaced
+	err = os.Rename(tmp.Name(), s.path(key))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return key, size, nil
+}
+
+func (s *Local) Open(key string) (io.ReadSeekCloser, error) {
+	if !ValidKey(key) {
+		return nil, ErrNotFound
+	}
+
+	file, err := os.Open(s.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (s *Local) Delete(key string) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+
+	err := os.Remove(s.path(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := Key(strings.NewReader("contents"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, _, err := store.Put(strings.NewReader("contents"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != stored || !ValidKey(key) {
+		t.Errorf("got key %s, stored under %s", key, stored)
+	}
+}
+
+func TestKeyLocks(t *testing.T) {
+	var locks KeyLocks
+
+	unlock := locks.Lock("a")
+
+	// other keys are not held up by the lock
+	done := make(chan struct{})
+	go func() {
+		locks.Lock("b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("locking another key waited")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		defer locks.Lock("a")()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("locked a key which was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("locking the key waited after it was unlocked")
+	}
+
+	var wg sync.WaitGroup
+	held := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer locks.Lock("a")()
+			held++
+		}()
+	}
+	wg.Wait()
+
+	if held != 100 {
+		t.Errorf("held the lock %d times", held)
+	}
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("kept %d locks after they were unlocked", len(locks.locks))
+	}
+}
@@ -0,0 +1,28 @@
+// Package storage keeps the contents of uploaded files, addressed by their SHA-256 digest so that identical files are
+// stored only once.
+package storage
+
+import (
+	"errors"
+	"io"
+	"regexp"
+)
+
+var ErrNotFound = errors.New("storage: file not found")
+
+// stores file contents under the hex encoded SHA-256 digest of the contents, which serves as their key
+type Store interface {
+	// stores the contents read from r, returning their key and size
+	Put(r io.Reader) (string, int64, error)
+	// opens the contents stored under the key, returning ErrNotFound if there are none
+	Open(key string) (io.ReadSeekCloser, error)
+	// removes the contents stored under the key, if any
+	Delete(key string) error
+}
+
+var keyRX = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// reports whether the key is a hex encoded SHA-256 digest, which also keeps keys from escaping the store
+func ValidKey(key string) bool {
+	return keyRX.MatchString(key)
+}
@@ -0,0 +1,121 @@
+// Package testdb opens empty, migrated databases for the tests of the packages depending on the stores, on SQLite
+// always and on MySQL as well once a server is given by the P_SYSTEM_TEST_MYSQL_DSN environment variable.
+package testdb
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"p-system.okostadinov.net/internal/migrations"
+	"p-system.okostadinov.net/internal/models"
+	"p-system.okostadinov.net/internal/sqlite"
+)
+
+// the environment variable holding the DSN of a MySQL server to test against besides SQLite, e.g.
+// 'root:secret@tcp(localhost:3306)/'; its user has to be allowed to create and drop databases
+const MySQLDSNVariable = "P_SYSTEM_TEST_MYSQL_DSN"
+
+// returns the drivers to test against: SQLite, along with MySQL if its DSN is set
+func Drivers() []string {
+	if os.Getenv(MySQLDSNVariable) != "" {
+		return []string{"sqlite", "mysql"}
+	}
+	return []string{"sqlite"}
+}
+
+// opens an empty database of the driver, migrated up to the latest version and removed once the test completes,
+// along with the driver's SQL dialect
+func Open(t testing.TB, driver string) (*sql.DB, models.Dialect) {
+	t.Helper()
+
+	db, dialect := OpenEmpty(t, driver)
+
+	migrator, err := migrations.New(db, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, dialect
+}
+
+// opens an empty database of the driver without any migrations applied, removed once the test completes
+func OpenEmpty(t testing.TB, driver string) (*sql.DB, models.Dialect) {
+	t.Helper()
+
+	switch driver {
+	case "sqlite":
+		return openSQLite(t), sqlite.Dialect
+	case "mysql":
+		dsn := os.Getenv(MySQLDSNVariable)
+		if dsn == "" {
+			t.Skipf("%s is not set", MySQLDSNVariable)
+		}
+		return openMySQL(t, dsn), models.MySQL
+	default:
+		t.Fatalf("unsupported driver %q", driver)
+		return nil, nil
+	}
+}
+
+// opens a database file in a temporary directory, removed along with it
+func openSQLite(t testing.TB) *sql.DB {
+	t.Helper()
+
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// creates a database of its own on the server of the DSN, dropped once the test completes
+func openMySQL(t testing.TB, dsn string) *sql.DB {
+	t.Helper()
+
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ParseTime = true
+	cfg.DBName = ""
+
+	server, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	name := fmt.Sprintf("p_system_test_%d", time.Now().UnixNano())
+
+	_, err = server.Exec("CREATE DATABASE " + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := server.Exec("DROP DATABASE " + name); err != nil {
+			t.Errorf("dropping %s: %v", name, err)
+		}
+	})
+
+	cfg.DBName = name
+
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cleanups run in reverse order, closing the pool before the database is dropped
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
@@ -0,0 +1,147 @@
+package totp
+
+import (
+	"encoding/base32"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// the ASCII secret "12345678901234567890" of the SHA-1 test vectors of RFC 6238, base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// the SHA-1 test vectors of appendix B of RFC 6238, whose eight digit codes end in the six digit ones
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		step int64
+		want string
+	}{
+		{59, 0x1, "287082"},
+		{1111111109, 0x23523ec, "081804"},
+		{1111111111, 0x23523ed, "050471"},
+		{1234567890, 0x273ef07, "005924"},
+		{2000000000, 0x3f940aa, "279037"},
+		{20000000000, 0x27bc86aa, "353130"},
+	}
+
+	for _, tt := range tests {
+		step := Step(time.Unix(tt.unix, 0))
+		if step != tt.step {
+			t.Errorf("%d: got step %x, want %x", tt.unix, step, tt.step)
+		}
+
+		got, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%d: got %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeSecret(t *testing.T) {
+	// authenticator apps show secrets in lowercase groups, which are typed in as shown
+	got, err := Code("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", 1)
+	if err != nil || got != "287082" {
+		t.Errorf("got %s, %v", got, err)
+	}
+
+	for _, secret := range []string{"", "GEZDGNBV1", "not base32!"} {
+		if _, err := Code(secret, 1); !errors.Is(err, ErrSecret) {
+			t.Errorf("%q: got %v, want %v", secret, err, ErrSecret)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// the middle of step 0x23523ec, whose neighbours are the steps of the other vectors
+	now := time.Unix(1111111095, 0)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	current := Step(now)
+
+	tests := []struct {
+		name string
+		code string
+		step int64
+		ok   bool
+	}{
+		{"current step", code(current), current, true},
+		{"one step behind", code(current - 1), current - 1, true},
+		{"one step ahead", code(current + 1), current + 1, true},
+		{"grouped with a space", code(current)[:3] + " " + code(current)[3:], current, true},
+		{"two steps behind", code(current - 2), 0, false},
+		{"two steps ahead", code(current + 2), 0, false},
+		{"empty", "", 0, false},
+		{"too short", code(current)[:5], 0, false},
+		{"too long", code(current) + "0", 0, false},
+		{"letters", "abcdef", 0, false},
+		{"a letter in place of a digit", code(current)[:5] + "x", 0, false},
+		{"eight digits of RFC 6238", "07081804", 0, false},
+		{"signed", "+" + code(current)[1:], 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("got step %x, %t, want %x, %t", step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+
+	if _, ok := Validate("", code(current), now); ok {
+		t.Error("validated against an invalid secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != secretSize {
+		t.Errorf("got %d bytes, want %d", len(key), secretSize)
+	}
+
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == secret {
+		t.Error("generated the same secret twice")
+	}
+}
+
+func TestURL(t *testing.T) {
+	u, err := url.Parse(URL("P-System", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/P-System:alice@example.com" {
+		t.Errorf("got %s", u)
+	}
+
+	params := u.Query()
+	for name, want := range map[string]string{"secret": rfcSecret, "issuer": "P-System", "algorithm": "SHA1", "digits": "6", "period": "30"} {
+		if got := params.Get(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Package ucn decodes the Bulgarian unified civil number (EGN), which encodes the date of birth and sex of its holder
+// and ends with a check digit.
+//
+// The first six digits are the date of birth as YYMMDD, 20 being added to the month for those born in the 1800s and
+// 40 for those born in the 2000s; the ninth digit is even for men and odd for women.
+package ucn
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	SexMale   = "male"
+	SexFemale = "female"
+)
+
+var (
+	ErrFormat    = errors.New("ucn: not 10 digits")
+	ErrBirthDate = errors.New("ucn: invalid date of birth")
+	ErrChecksum  = errors.New("ucn: invalid check digit")
+)
+
+// the weights of the first nine digits in the check digit
+var weights = [9]int{2, 4, 8, 5, 10, 9, 7, 3, 6}
+
+// what a UCN reveals of its holder
+type Info struct {
+	BirthDate time.Time
+	Sex       string
+}
+
+// the age in full years on the given day
+func (i Info) Age(on time.Time) int {
+	age := on.Year() - i.BirthDate.Year()
+	if on.Month() < i.BirthDate.Month() || on.Month() == i.BirthDate.Month() && on.Day() < i.BirthDate.Day() {
+		age--
+	}
+	return age
+}
+
+// decodes the date of birth and sex of the UCN, verifying its date and check digit
+func Parse(s string) (Info, error) {
+	if len(s) != 10 {
+		return Info{}, ErrFormat
+	}
+
+	var digits [10]int
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return Info{}, ErrFormat
+		}
+		digits[i] = int(s[i] - '0')
+	}
+
+	sum := 0
+	for i, weight := range weights {
+		sum += digits[i] * weight
+	}
+	if sum%11%10 != digits[9] {
+		return Info{}, ErrChecksum
+	}
+
+	year := digits[0]*10 + digits[1]
+	month := digits[2]*10 + digits[3]
+	day := digits[4]*10 + digits[5]
+
+	switch {
+	case month >= 1 && month <= 12:
+		year += 1900
+	case month >= 21 && month <= 32:
+		year += 1800
+		month -= 20
+	case month >= 41 && month <= 52:
+		year += 2000
+		month -= 40
+	default:
+		return Info{}, ErrBirthDate
+	}
+
+	birthDate := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	// time.Date normalizes days past the end of the month into the next one
+	if day == 0 || birthDate.Day() != day {
+		return Info{}, ErrBirthDate
+	}
+
+	sex := SexMale
+	if digits[8]%2 == 1 {
+		sex = SexFemale
+	}
+
+	return Info{BirthDate: birthDate, Sex: sex}, nil
+}
+
+// reports whether the UCN is well-formed, encodes a valid date of birth which is not in the future and has a matching
+// check digit
+func Valid(s string) bool {
+	info, err := Parse(s)
+	return err == nil && !info.BirthDate.After(time.Now().UTC())
+}
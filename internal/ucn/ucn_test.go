@@ -0,0 +1,121 @@
+package ucn
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		ucn       string
+		birthDate time.Time
+		sex       string
+		err       error
+	}{
+		{"1900s female", "7501020018", date(1975, time.January, 2), SexFemale, nil},
+		{"1900s male", "7501020023", date(1975, time.January, 2), SexMale, nil},
+		{"1900s end of year", "7512310003", date(1975, time.December, 31), SexMale, nil},
+		{"1800s female", "8032056031", date(1880, time.December, 5), SexFemale, nil},
+		{"1800s male", "8032056047", date(1880, time.December, 5), SexMale, nil},
+		{"1800s end of year", "8832310000", date(1888, time.December, 31), SexMale, nil},
+		{"2000s female", "0441151237", date(2004, time.January, 15), SexFemale, nil},
+		{"2000s male", "0441151242", date(2004, time.January, 15), SexMale, nil},
+		{"2000 is a leap year", "0042290103", date(2000, time.February, 29), SexMale, nil},
+		{"remainder 10 gives check digit 0", "8506150090", date(1985, time.June, 15), SexFemale, nil},
+		{"2000s remainder 10", "0543100090", date(2005, time.March, 10), SexFemale, nil},
+
+		{"too short", "750102001", time.Time{}, "", ErrFormat},
+		{"too long", "75010200180", time.Time{}, "", ErrFormat},
+		{"empty", "", time.Time{}, "", ErrFormat},
+		{"letters", "75010200a8", time.Time{}, "", ErrFormat},
+		{"spaces", " 750102001", time.Time{}, "", ErrFormat},
+
+		{"wrong check digit", "7501020019", time.Time{}, "", ErrChecksum},
+		{"remainder 10 with check digit 1", "8506150091", time.Time{}, "", ErrChecksum},
+		{"transposed digits", "5701020018", time.Time{}, "", ErrChecksum},
+
+		{"month 0", "7500020008", time.Time{}, "", ErrBirthDate},
+		{"month 13", "7513020009", time.Time{}, "", ErrBirthDate},
+		{"month 20", "7520010004", time.Time{}, "", ErrBirthDate},
+		{"1800s month 33", "7533020003", time.Time{}, "", ErrBirthDate},
+		{"month 40", "7540010009", time.Time{}, "", ErrBirthDate},
+		{"2000s month 53", "7553020008", time.Time{}, "", ErrBirthDate},
+		{"day 0", "7501000006", time.Time{}, "", ErrBirthDate},
+		{"April 31", "7504310005", time.Time{}, "", ErrBirthDate},
+		{"1900 is no leap year", "0002290104", time.Time{}, "", ErrBirthDate},
+		{"2000s February 30", "0042300006", time.Time{}, "", ErrBirthDate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Parse(tt.ucn)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if !info.BirthDate.Equal(tt.birthDate) {
+				t.Errorf("got birth date %s, want %s", info.BirthDate.Format(time.DateOnly), tt.birthDate.Format(time.DateOnly))
+			}
+
+			if info.Sex != tt.sex {
+				t.Errorf("got sex %q, want %q", info.Sex, tt.sex)
+			}
+		})
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name string
+		ucn  string
+		want bool
+	}{
+		{"1900s", "7501020018", true},
+		{"1800s", "8032056031", true},
+		{"2000s", "0441151237", true},
+		{"remainder 10", "8506150090", true},
+		{"wrong check digit", "7501020019", false},
+		{"invalid date", "7504310005", false},
+		{"not digits", "abcdefghij", false},
+		// 31 December 2099, well-formed but not born yet
+		{"future birth date", "9952319991", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Valid(tt.ucn); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAge(t *testing.T) {
+	info := Info{BirthDate: date(2004, time.March, 15)}
+
+	tests := []struct {
+		name string
+		on   time.Time
+		want int
+	}{
+		{"day of birth", date(2004, time.March, 15), 0},
+		{"day before birthday", date(2024, time.March, 14), 19},
+		{"birthday", date(2024, time.March, 15), 20},
+		{"month before birthday", date(2024, time.February, 28), 19},
+		{"month after birthday", date(2024, time.April, 1), 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := info.Age(tt.on); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
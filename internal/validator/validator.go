@@ -3,9 +3,11 @@ package validator
 import (
 	"fmt"
 	"reflect"
+	"regexp"
 	"unicode"
 
 	"github.com/go-playground/validator/v10"
+	"p-system.okostadinov.net/internal/ucn"
 )
 
 type FormErrors map[string]string
@@ -17,7 +19,7 @@ type Validator struct {
 
 var validate *validator.Validate
 
-// sets the validator tag reference to gorilla/schema's tag, add a custom password tag validator, and return the validator
+// sets the validator tag reference to gorilla/schema's tag, add the custom password, atc and ucn tag validators, and return the validator
 func NewValidator() *Validator {
 	validate = validator.New(validator.WithRequiredStructEnabled())
 	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
@@ -25,6 +27,8 @@ func NewValidator() *Validator {
 	})
 
 	validate.RegisterValidation("password", passwordValidate)
+	validate.RegisterValidation("atc", atcValidate)
+	validate.RegisterValidation("ucn", ucnValidate)
 
 	return &Validator{Validate: validate, FormErrors: make(FormErrors)}
 }
@@ -49,10 +53,16 @@ func (v *Validator) ValidateForm(form interface{}) bool {
 // associates each validation error with a human comprehensible message
 func (v *Validator) fetchTagErrorMessage(tag, param string) string {
 	switch tag {
-	case "required":
+	case "required", "required_without", "required_if":
 		return "required field"
 	case "numeric":
 		return "invalid format (only numbers allowed)"
+	case "min":
+		return fmt.Sprintf("invalid value (minimum %v)", param)
+	case "max":
+		return fmt.Sprintf("invalid value (maximum %v)", param)
+	case "oneof":
+		return fmt.Sprintf("invalid value (allowed: %v)", param)
 	case "len":
 		return fmt.Sprintf("invalid amount (requires %v)", param)
 	case "alphaunicode":
@@ -65,6 +75,12 @@ func (v *Validator) fetchTagErrorMessage(tag, param string) string {
 		return fmt.Sprintf("field does not equal %s", param)
 	case "email":
 		return "invalid format (e.g. email@example.com)"
+	case "atc":
+		return "invalid format (e.g. A10BA02)"
+	case "ucn":
+		return "invalid UCN (check the date of birth and the last digit)"
+	case "datetime":
+		return fmt.Sprintf("invalid date (format %v)", param)
 	default:
 		return "undefined error"
 	}
@@ -94,3 +110,16 @@ func passwordValidate(fl validator.FieldLevel) bool {
 
 	return hasLetters && hasNumbers
 }
+
+// matches a full ATC code: anatomical group, therapeutic, pharmacological and chemical subgroups, and substance
+var atcRX = regexp.MustCompile(`^[A-Z][0-9]{2}[A-Z]{2}[0-9]{2}$`)
+
+// validates whether a string is a 7 character ATC code, e.g. A10BA02
+func atcValidate(fl validator.FieldLevel) bool {
+	return atcRX.MatchString(fl.Field().String())
+}
+
+// validates whether a string is a UCN (EGN) with a valid date of birth and check digit
+func ucnValidate(fl validator.FieldLevel) bool {
+	return ucn.Valid(fl.Field().String())
+}
@@ -0,0 +1,186 @@
+// Package xlsx writes single sheet Office Open XML spreadsheets, which Excel and other spreadsheet applications open,
+// row by row as they are produced, so that a sheet of any size never has to be held in memory.
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the static parts of the workbook around its only sheet; style 1 marks bold header cells
+var parts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+		`</styleSheet>`},
+}
+
+// writes a workbook with a single sheet to the underlying writer, whose rows are added one at a time; the workbook is
+// complete only once the writer is closed
+type Writer struct {
+	zip  *zip.Writer
+	buf  *bufio.Writer
+	rows int
+	err  error
+}
+
+// starts a workbook whose only sheet is given the name, which Excel limits to 31 characters
+func NewWriter(w io.Writer, sheet string) (*Writer, error) {
+	zw := zip.NewWriter(w)
+
+	workbook := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="` + escape(sheetName(sheet)) + `" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+	for _, part := range append(parts, struct{ name, content string }{"xl/workbook.xml", workbook}) {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	// the sheet goes last, as it remains open for writing until the workbook is closed
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriter(f)
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	return &Writer{zip: zw, buf: buf}, nil
+}
+
+// adds a row of bold cells, e.g. the column headers
+func (w *Writer) WriteHeader(cells []string) error {
+	values := make([]any, len(cells))
+	for i, cell := range cells {
+		values[i] = cell
+	}
+	return w.writeRow(values, 1)
+}
+
+// adds a row of cells; integers and floats become numbers, times dates and anything else text, while nil leaves the
+// cell empty
+func (w *Writer) Write(cells []any) error {
+	return w.writeRow(cells, 0)
+}
+
+func (w *Writer) writeRow(cells []any, style int) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	w.rows++
+	fmt.Fprintf(w.buf, `<row r="%d">`, w.rows)
+
+	for i, cell := range cells {
+		ref := column(i) + strconv.Itoa(w.rows)
+
+		attrs := fmt.Sprintf(`r="%s"`, ref)
+		if style != 0 {
+			attrs += fmt.Sprintf(` s="%d"`, style)
+		}
+
+		switch v := cell.(type) {
+		case nil:
+			continue
+		case int:
+			fmt.Fprintf(w.buf, `<c %s><v>%d</v></c>`, attrs, v)
+		case int64:
+			fmt.Fprintf(w.buf, `<c %s><v>%d</v></c>`, attrs, v)
+		case float64:
+			fmt.Fprintf(w.buf, `<c %s><v>%s</v></c>`, attrs, strconv.FormatFloat(v, 'f', -1, 64))
+		case time.Time:
+			// dates are kept as ISO 8601 text, which needs no number format to be readable
+			fmt.Fprintf(w.buf, `<c %s t="inlineStr"><is><t>%s</t></is></c>`, attrs, v.Format("2006-01-02"))
+		default:
+			fmt.Fprintf(w.buf, `<c %s t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, attrs, escape(fmt.Sprint(v)))
+		}
+	}
+
+	_, w.err = w.buf.WriteString(`</row>`)
+	return w.err
+}
+
+// finishes the sheet and the workbook, without closing the underlying writer
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	w.buf.WriteString(`</sheetData></worksheet>`)
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+
+	return w.zip.Close()
+}
+
+// returns the letters naming the zero-based column, e.g. A for 0 and AA for 26
+func column(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// escapes text for the XML markup, replacing the characters XML cannot hold
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// drops the characters Excel does not allow in sheet names and shortens the name to its limit
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return -1
+		}
+		return r
+	}, name)
+
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	if name == "" {
+		return "Sheet1"
+	}
+	return name
+}
@@ -0,0 +1,106 @@
+-- Brings a database created by the setup script of the releases before schema migrations up to migration 1 and
+-- records it as applied, after which `migrate up` applies the rest. Run it once, with a user allowed to alter the
+-- schema, after backing the database up: sudo mysql -u root -p < ./scripts/upgrade_baseline.sql
+USE p_system;
+
+ALTER TABLE
+    users
+ADD
+    COLUMN role VARCHAR(20) NOT NULL DEFAULT 'doctor'
+AFTER
+    hashed_password;
+
+ALTER TABLE
+    medications
+ADD
+    COLUMN deleted_at DATETIME,
+ADD
+    COLUMN deleted_by INTEGER,
+ADD
+    FOREIGN KEY (deleted_by) REFERENCES users(id);
+
+ALTER TABLE
+    patients
+ADD
+    COLUMN deleted_at DATETIME
+AFTER
+    user_id,
+ADD
+    COLUMN deleted_by INTEGER
+AFTER
+    deleted_at,
+ADD
+    FOREIGN KEY (deleted_by) REFERENCES users(id),
+ADD
+    INDEX patients_idx_ucn (ucn);
+
+ALTER TABLE
+    patients
+ADD
+    FULLTEXT INDEX patients_ft_search (first_name, last_name, phone_number, medication, note);
+
+CREATE TABLE patient_co_owners (
+    patient_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (patient_id, user_id),
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE patient_transfers (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    patient_id INTEGER NOT NULL,
+    from_user_id INTEGER NOT NULL,
+    to_user_id INTEGER NOT NULL,
+    requested_by INTEGER NOT NULL,
+    status VARCHAR(10) NOT NULL DEFAULT 'pending',
+    created DATETIME NOT NULL,
+    resolved DATETIME,
+    resolved_by INTEGER,
+    FOREIGN KEY (patient_id) REFERENCES patients(id) ON DELETE CASCADE,
+    FOREIGN KEY (from_user_id) REFERENCES users(id),
+    FOREIGN KEY (to_user_id) REFERENCES users(id),
+    FOREIGN KEY (requested_by) REFERENCES users(id),
+    FOREIGN KEY (resolved_by) REFERENCES users(id),
+    INDEX patient_transfers_idx_status (status, to_user_id)
+);
+
+CREATE TABLE audit_log (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER,
+    action VARCHAR(10) NOT NULL,
+    entity VARCHAR(30) NOT NULL,
+    entity_id VARCHAR(30) NOT NULL,
+    before_values JSON,
+    after_values JSON,
+    created DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    INDEX audit_log_idx_entity (entity, entity_id)
+);
+
+CREATE TABLE tokens (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    hash CHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+ALTER TABLE
+    tokens
+ADD
+    CONSTRAINT tokens_uc_hash UNIQUE (hash);
+
+CREATE TABLE schema_migrations (
+    version INTEGER NOT NULL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    applied DATETIME NOT NULL
+);
+
+INSERT INTO
+    schema_migrations (version, name, applied)
+VALUES
+    (1, 'initial_schema', UTC_TIMESTAMP());